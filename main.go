@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	harvesternetworkv1beta1 "github.com/harvester/harvester-network-controller/pkg/apis/network.harvesterhci.io/v1beta1"
 	ctlnetwork "github.com/harvester/harvester-network-controller/pkg/generated/controllers/network.harvesterhci.io"
+	ctlkubevirt "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io"
 	"github.com/rancher/lasso/pkg/controller"
 	ctlcore "github.com/rancher/wrangler/pkg/generated/controllers/core"
 	"github.com/rancher/wrangler/pkg/generic"
@@ -16,19 +18,30 @@ import (
 	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 	kubevirtv1 "kubevirt.io/api/core/v1"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
 
 	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/controller/deviceusage"
 	"github.com/harvester/pcidevices/pkg/controller/nodecleanup"
+	"github.com/harvester/pcidevices/pkg/controller/nodemaintenance"
+	"github.com/harvester/pcidevices/pkg/controller/nodepassthrough"
 	"github.com/harvester/pcidevices/pkg/controller/pcidevice"
 	"github.com/harvester/pcidevices/pkg/controller/pcideviceclaim"
+	"github.com/harvester/pcidevices/pkg/controller/permittedhostdevices"
 	"github.com/harvester/pcidevices/pkg/crd"
+	"github.com/harvester/pcidevices/pkg/deviceplugins"
 	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io"
+	"github.com/harvester/pcidevices/pkg/util/podresources"
 	"github.com/harvester/pcidevices/pkg/webhook"
 )
 
@@ -59,6 +72,9 @@ func init() {
 func main() {
 	// set up the kubeconfig and other args
 	var kubeConfig string
+	var claimOpts pcideviceclaim.Options
+	var hostDeviceOpts permittedhostdevices.Options
+	webhookOpts := webhook.DefaultOptions()
 	app := cli.NewApp()
 	app.Name = controllerName
 	app.Version = VERSION
@@ -70,10 +86,106 @@ func main() {
 			Destination: &kubeConfig,
 			Usage:       "Kube config for accessing k8s cluster",
 		},
+		&cli.BoolFlag{
+			Name:        "reset-on-prestart",
+			EnvVars:     []string{"RESET_ON_PRESTART"},
+			Destination: &claimOpts.ResetOnPreStart,
+			Usage:       "Reset PCI devices before the container of a VM starts, in addition to when they are released",
+		},
+		&cli.DurationFlag{
+			Name:        "claim-expiry-warning",
+			EnvVars:     []string{"CLAIM_EXPIRY_WARNING"},
+			Value:       time.Hour,
+			Destination: &claimOpts.ExpiryWarning,
+			Usage:       "How long before a PCIDeviceClaim expires a warning event is emitted",
+		},
+		&cli.IntFlag{
+			Name:        "operation-history-limit",
+			EnvVars:     []string{"OPERATION_HISTORY_LIMIT"},
+			Value:       20,
+			Destination: &claimOpts.OperationHistoryLimit,
+			Usage:       "Number of PCIDeviceOperations kept per PCI device",
+		},
+		&cli.DurationFlag{
+			Name:        "operation-retention",
+			EnvVars:     []string{"OPERATION_RETENTION"},
+			Value:       30 * 24 * time.Hour,
+			Destination: &claimOpts.OperationRetention,
+			Usage:       "How long PCIDeviceOperations are kept",
+		},
+		&cli.StringFlag{
+			Name:        "cdi-spec-dir",
+			EnvVars:     []string{"CDI_SPEC_DIR"},
+			Value:       deviceplugins.CDISpecDir,
+			Destination: &claimOpts.CDISpecDir,
+			Usage:       "Directory the CDI specs of claimed PCI devices are written to, no CDI specs are written if empty",
+		},
+		&cli.StringFlag{
+			Name:        "device-plugin-dir",
+			EnvVars:     []string{"DEVICE_PLUGIN_DIR"},
+			Value:       pluginapi.DevicePluginPath,
+			Destination: &claimOpts.DevicePluginDir,
+			Usage:       "Directory of the kubelet the sockets of the device plugins are created in",
+		},
+		&cli.StringFlag{
+			Name:        "kubelet-socket",
+			EnvVars:     []string{"KUBELET_SOCKET"},
+			Value:       pluginapi.KubeletSocket,
+			Destination: &claimOpts.KubeletSocket,
+			Usage:       "Registration socket of the kubelet the device plugins register with",
+		},
+		&cli.StringFlag{
+			Name:        "kubevirt-namespace",
+			EnvVars:     []string{"KUBEVIRT_NAMESPACE"},
+			Destination: &hostDeviceOpts.KubeVirtNamespace,
+			Usage:       "Namespace of the KubeVirt CR the claimed PCI devices are permitted in, discovered if empty",
+		},
+		&cli.StringFlag{
+			Name:        "kubevirt-name",
+			EnvVars:     []string{"KUBEVIRT_NAME"},
+			Destination: &hostDeviceOpts.KubeVirtName,
+			Usage:       "Name of the KubeVirt CR the claimed PCI devices are permitted in, discovered if empty",
+		},
+		&cli.StringFlag{
+			Name:        "namespace",
+			EnvVars:     []string{"NAMESPACE"},
+			Value:       "harvester-system",
+			Destination: &hostDeviceOpts.LeaderElectionNamespace,
+			Usage:       "Namespace of the controller and its webhook service, where the lock of its leader election is kept",
+		},
+		&cli.StringFlag{
+			Name:        "webhook-service",
+			EnvVars:     []string{"WEBHOOK_SERVICE"},
+			Value:       webhookOpts.ServiceName,
+			Destination: &webhookOpts.ServiceName,
+			Usage:       "Name of the service the API server calls the webhook with",
+		},
+		&cli.IntFlag{
+			Name:        "webhook-port",
+			EnvVars:     []string{"WEBHOOK_PORT"},
+			Value:       webhookOpts.Port,
+			Destination: &webhookOpts.Port,
+			Usage:       "Port the webhook listens on, which the webhook container port of the manifest must match",
+		},
+		&cli.StringFlag{
+			Name:        "webhook-failure-policy",
+			EnvVars:     []string{"WEBHOOK_FAILURE_POLICY"},
+			Value:       webhookOpts.FailurePolicy,
+			Destination: &webhookOpts.FailurePolicy,
+			Usage:       "Failure policy of the mutating webhooks, Ignore or Fail",
+		},
+		&cli.StringFlag{
+			Name:        "webhook-validation-failure-policy",
+			EnvVars:     []string{"WEBHOOK_VALIDATION_FAILURE_POLICY"},
+			Value:       webhookOpts.ValidationFailurePolicy,
+			Destination: &webhookOpts.ValidationFailurePolicy,
+			Usage:       "Failure policy of the validating webhook, which enforces pcidevicepolicies, Ignore or Fail",
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
-		return run(kubeConfig)
+		webhookOpts.Namespace = hostDeviceOpts.LeaderElectionNamespace
+		return run(kubeConfig, claimOpts, hostDeviceOpts, webhookOpts)
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -81,7 +193,7 @@ func main() {
 	}
 }
 
-func run(kubeConfig string) error {
+func run(kubeConfig string, claimOpts pcideviceclaim.Options, hostDeviceOpts permittedhostdevices.Options, webhookOpts webhook.Options) error {
 	ctx := signals.SetupSignalContext()
 
 	var cfg *rest.Config
@@ -120,21 +232,52 @@ func run(kubeConfig string) error {
 	if err != nil {
 		return fmt.Errorf("error building network controllers: %v", err)
 	}
+	kubevirtFactory, err := ctlkubevirt.NewFactoryFromConfigWithOptions(cfg, opts)
+	if err != nil {
+		return fmt.Errorf("error building kubevirt controllers: %v", err)
+	}
 	pdCtl := pciFactory.Devices().V1beta1().PCIDevice()
 	pdcCtl := pciFactory.Devices().V1beta1().PCIDeviceClaim()
+	opCtl := pciFactory.Devices().V1beta1().PCIDeviceOperation()
 	nodeCtl := coreFactory.Core().V1().Node()
+	vmiCtl := kubevirtFactory.Kubevirt().V1().VirtualMachineInstance()
 	nodeName := os.Getenv("NODE_NAME")
 
-	if err := pcideviceclaim.Register(ctx, pdcCtl, pdCtl, nodeName); err != nil {
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes client: %v", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(Scheme, corev1.EventSource{Component: controllerName, Host: nodeName})
+
+	if err := pcideviceclaim.Register(ctx, pdcCtl, pdCtl, opCtl, pciFactory.Devices().V1beta1().PCIDeviceHotplug(), nodeCtl, vmiCtl, recorder, nodeName, claimOpts); err != nil {
 		return fmt.Errorf("error registering pcidevicesclaim controller: %v", err)
 	}
 
+	if err := permittedhostdevices.Register(ctx, pdcCtl, pdCtl, client, hostDeviceOpts); err != nil {
+		return fmt.Errorf("error registering permitted host devices controller: %v", err)
+	}
+
+	// the readiness of the node is checked once the claim controller loaded the vfio drivers
+	if err := nodepassthrough.Register(ctx, pciFactory.Devices().V1beta1().NodePassthrough(), nodeCtl, nodeName); err != nil {
+		return fmt.Errorf("error registering node passthrough controller: %v", err)
+	}
+
+	if err := nodemaintenance.Register(ctx, pdcCtl, pdCtl, nodeCtl, vmiCtl, nodeName); err != nil {
+		return fmt.Errorf("error registering node maintenance controller: %v", err)
+	}
+
+	if err := deviceusage.Register(ctx, pdCtl, pdcCtl, coreFactory.Core().V1().Pod(), podresources.NewLister(podresources.DefaultSocket), nodeName); err != nil {
+		return fmt.Errorf("error registering device usage controller: %v", err)
+	}
+
 	if err := nodecleanup.Register(ctx, pdcCtl, pdCtl, nodeCtl); err != nil {
 		logrus.Fatalf("failed to register node cleanup controller: %v", err)
 	}
 
 	eg, egctx := errgroup.WithContext(ctx)
-	w := webhook.New(egctx, cfg)
+	w := webhook.New(egctx, cfg, webhookOpts)
 
 	eg.Go(func() error {
 		err := w.ListenAndServe()
@@ -145,10 +288,10 @@ func run(kubeConfig string) error {
 	})
 
 	eg.Go(func() error {
-		return pcidevice.Register(egctx, pdCtl, coreFactory, networkFactory)
+		return pcidevice.Register(egctx, pdCtl, pciFactory.Devices().V1beta1().PCITopology(), coreFactory, networkFactory)
 	})
 
-	if err := start.All(ctx, 2, coreFactory, networkFactory, pciFactory); err != nil {
+	if err := start.All(ctx, 2, coreFactory, networkFactory, pciFactory, kubevirtFactory); err != nil {
 		return fmt.Errorf("error starting factories: %v", err)
 	}
 
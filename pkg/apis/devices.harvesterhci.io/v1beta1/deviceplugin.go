@@ -0,0 +1,7 @@
+package v1beta1
+
+const (
+	// DevicePluginsAnnotation is set by the controller on each node, and holds the JSON encoded state
+	// of the device plugins serving the passthrough devices of the node
+	DevicePluginsAnnotation = "devices.harvesterhci.io/device-plugins"
+)
@@ -0,0 +1,38 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MaintenanceAnnotation is set to "true" on a node to block new PCIDeviceClaims on the node
+	MaintenanceAnnotation = "devices.harvesterhci.io/maintenance"
+	// MaintenanceReleaseClaimsAnnotation is set to "true" on a node in maintenance to release
+	// the PCIDeviceClaims of the node once no running VM uses the claimed device
+	MaintenanceReleaseClaimsAnnotation = "devices.harvesterhci.io/maintenance-release-claims"
+	// MaintenanceVMsAnnotation is set by the controller on a node in maintenance, and lists the
+	// VMs in namespace/name form which still use PCIDevices of the node
+	MaintenanceVMsAnnotation = "devices.harvesterhci.io/maintenance-vms"
+	// DrainBlockingVMsAnnotation is set by the controller on nodes, and lists the VMs in namespace/name form
+	// which use PCIDevices of the node. These VMs cannot be live-migrated, and block a drain of the node until
+	// they are shut down
+	DrainBlockingVMsAnnotation = "devices.harvesterhci.io/drain-blocking-vms"
+
+	// PassthroughVMLabel is set to "true" by the webhook on VMs using PCIDevices and on their VMIs, as they
+	// cannot be live-migrated
+	PassthroughVMLabel = "devices.harvesterhci.io/passthrough"
+	// OriginalEvictionStrategyAnnotation records the eviction strategy of a VM before the webhook set it to
+	// External for its PCIDevices, and is empty if the VM used the eviction strategy of the cluster. The strategy
+	// is restored once the VM no longer uses PCIDevices
+	OriginalEvictionStrategyAnnotation = "devices.harvesterhci.io/original-eviction-strategy"
+)
+
+// NodeInMaintenance checks if passthrough devices of the node are in maintenance
+func NodeInMaintenance(node *corev1.Node) bool {
+	return node != nil && node.Annotations[MaintenanceAnnotation] == "true"
+}
+
+// NodeReleasesClaims checks if PCIDeviceClaims of a node in maintenance are to be released
+func NodeReleasesClaims(node *corev1.Node) bool {
+	return NodeInMaintenance(node) && node.Annotations[MaintenanceReleaseClaimsAnnotation] == "true"
+}
@@ -0,0 +1,65 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PassthroughCheckName names a check of the readiness of a node for PCI passthrough
+type PassthroughCheckName string
+
+const (
+	// CheckIOMMUGroups checks that the kernel placed devices into IOMMU groups
+	CheckIOMMUGroups PassthroughCheckName = "IOMMUGroups"
+	// CheckKernelParameters checks that the IOMMU is not disabled on the kernel command line
+	CheckKernelParameters PassthroughCheckName = "KernelParameters"
+	// CheckInterruptRemapping checks that interrupts of assigned devices are remapped, which VFIO
+	// requires to isolate devices unless unsafe interrupts are allowed
+	CheckInterruptRemapping PassthroughCheckName = "InterruptRemapping"
+	// CheckVFIOModules checks that vfio-pci and vfio_iommu_type1 are loaded
+	CheckVFIOModules PassthroughCheckName = "VFIOModules"
+	// CheckNoIOMMUMode checks that VFIO does not run in unsafe no-IOMMU mode
+	CheckNoIOMMUMode PassthroughCheckName = "NoIOMMUMode"
+
+	// PassthroughReadyLabel is set on nodes to "true" if they are ready for PCI passthrough, and
+	// to "false" otherwise
+	PassthroughReadyLabel = "devices.harvesterhci.io/passthrough-ready"
+	// NodePassthroughReady is the condition of nodes reflecting the readiness for PCI passthrough
+	NodePassthroughReady = "PCIPassthroughReady"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// a NodePassthrough reports the readiness of a node for PCI passthrough, and is named after the node
+type NodePassthrough struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodePassthroughSpec   `json:"spec,omitempty"`
+	Status NodePassthroughStatus `json:"status,omitempty"`
+}
+
+type NodePassthroughSpec struct {
+}
+
+type NodePassthroughStatus struct {
+	// Ready is set if all checks passed
+	Ready bool `json:"ready"`
+	// IOMMUGroups is the number of IOMMU groups of the node
+	IOMMUGroups int `json:"iommuGroups"`
+	// KernelParameters are the IOMMU and VFIO related parameters of the kernel command line
+	KernelParameters []string           `json:"kernelParameters,omitempty"`
+	Checks           []PassthroughCheck `json:"checks,omitempty"`
+	LastCheckTime    metav1.Time        `json:"lastCheckTime,omitempty"`
+}
+
+// PassthroughCheck is the result of a check, along with how to fix the node if it failed
+type PassthroughCheck struct {
+	Name    PassthroughCheckName `json:"name"`
+	Passed  bool                 `json:"passed"`
+	Message string               `json:"message,omitempty"`
+	// Remediation is a hint on how to fix a failed check, or how to improve a check which passed with a warning
+	Remediation string `json:"remediation,omitempty"`
+}
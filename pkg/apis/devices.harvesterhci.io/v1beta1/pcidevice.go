@@ -8,11 +8,30 @@ import (
 
 	"github.com/jaypipes/ghw/pkg/pci"
 	"github.com/jaypipes/ghw/pkg/util"
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/genericcondition"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/util/pciaddress"
 )
 
 const (
 	PciDeviceDriver = "harvesterhci.io/pcideviceDriver"
+	// PCIDomainLabel is set on PCIDevices to the PCI domain (segment) of the device, e.g. 0000
+	PCIDomainLabel = "devices.harvesterhci.io/pci-domain"
+
+	// ResetFailedReason is set on the Healthy condition when the device could not be reset on release
+	ResetFailedReason = "ResetFailed"
+	// HealthCheckFailedReason is set on the Healthy condition when the device plugin found the device unhealthy
+	HealthCheckFailedReason = "HealthCheckFailed"
+)
+
+var (
+	// PCIDeviceHealthy reports if the device can be handed to a VM. Unhealthy devices are
+	// advertised as unhealthy by the device plugin
+	PCIDeviceHealthy condition.Cond = "Healthy"
+	// PCIDeviceReleasing reports why a deleted device is not removed yet
+	PCIDeviceReleasing condition.Cond = "Releasing"
 )
 
 // +genclient
@@ -40,6 +59,28 @@ type PCIDeviceStatus struct {
 	ResourceName      string `json:"resourceName"`
 	Description       string `json:"description"`
 	KernelDriverInUse string `json:"kernelDriverInUse,omitempty"`
+	// ResetMethod is the reset method used the last time the device was reset
+	ResetMethod string `json:"resetMethod,omitempty"`
+	// UsedBy is the workload the device is allocated to by the kubelet
+	// +optional
+	UsedBy *DeviceUsage `json:"usedBy,omitempty"`
+	// +optional
+	Conditions []genericcondition.GenericCondition `json:"conditions,omitempty"`
+}
+
+// DeviceUsage identifies the pod, and the VMI running in it, which a device is allocated to
+type DeviceUsage struct {
+	// Pod is the namespace/name of the pod
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	// VirtualMachineInstance is the namespace/name of the VMI, if the pod is a virt-launcher pod
+	VirtualMachineInstance string `json:"virtualMachineInstance,omitempty"`
+}
+
+// PCIDeviceDescription describes dev by its class, vendor and product, e.g.
+// "Ethernet controller: Intel Corporation I350 Gigabit Network Connection"
+func PCIDeviceDescription(dev *pci.Device) string {
+	return description(dev)
 }
 
 func description(dev *pci.Device) string {
@@ -121,8 +162,8 @@ func (status *PCIDeviceStatus) Update(dev *pci.Device, hostname string, iommuGro
 	// Generate the ResourceName field, this is used by KubeVirt to schedule the VM to the node
 	status.ResourceName = resourceName(dev)
 	status.Description = description(dev)
-	group, ok := iommuGroups[dev.Address]
-	if ok {
+	status.IOMMUGroup = ""
+	if group, ok := iommuGroups[dev.Address]; ok {
 		status.IOMMUGroup = strconv.Itoa(group)
 	}
 	status.KernelDriverInUse = dev.Driver
@@ -133,12 +174,34 @@ type PCIDeviceSpec struct {
 }
 
 func PCIDeviceNameForHostname(dev *pci.Device, hostname string) string {
-	addrDNSsafe := strings.ReplaceAll(strings.ReplaceAll(dev.Address, ":", ""), ".", "")
-	return fmt.Sprintf(
-		"%s-%s",
-		hostname,
-		addrDNSsafe,
-	)
+	return PCIDeviceName(hostname, dev.Address)
+}
+
+// PCIDeviceName returns the name of the PCIDevice of the device at address on hostname. Devices in PCI
+// domain 0000 are named after the address without colons and dots, e.g. node1-000004000 for 0000:04:00.0.
+// Devices in other domains, on servers with several PCI segments or behind Intel VMD, are named with the
+// domain separated, as VMD domains are wider than 4 hex digits, e.g. node1-10000-e1000 for 10000:e1:00.0
+func PCIDeviceName(hostname, address string) string {
+	a, err := pciaddress.Parse(address)
+	if err != nil || a.Domain == 0 {
+		return LegacyPCIDeviceName(hostname, address)
+	}
+	return fmt.Sprintf("%s-%s-%02x%02x%x", hostname, a.DomainString(), a.Bus, a.Device, a.Function)
+}
+
+// LegacyPCIDeviceName returns the name of the PCIDevice of the device at address on hostname from before
+// the domain was separated, which PCIDevices of devices in other domains than 0000 may still be named after
+func LegacyPCIDeviceName(hostname, address string) string {
+	return fmt.Sprintf("%s-%s", hostname, strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(address, ":", ""), ".", "")))
+}
+
+// PCIDomain returns the domain of the device at address, e.g. 0000
+func PCIDomain(address string) string {
+	a, err := pciaddress.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return a.DomainString()
 }
 
 func NewPCIDeviceForHostname(dev *pci.Device, hostname string) PCIDevice {
@@ -146,6 +209,9 @@ func NewPCIDeviceForHostname(dev *pci.Device, hostname string) PCIDevice {
 	pciDevice := PCIDevice{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
+			Labels: map[string]string{
+				PCIDomainLabel: PCIDomain(dev.Address),
+			},
 			Annotations: map[string]string{
 				PciDeviceDriver: dev.Driver,
 			},
@@ -47,6 +47,9 @@ func TestNewPCIDeviceForName(t *testing.T) {
 			want: PCIDevice{
 				ObjectMeta: v1.ObjectMeta{
 					Name: "deepgreen-001f6",
+					Labels: map[string]string{
+						PCIDomainLabel: "0000",
+					},
 					Annotations: map[string]string{
 						PciDeviceDriver: "fake",
 					},
@@ -180,3 +183,29 @@ func TestDescriptionForVendorDevice(t *testing.T) {
 		})
 	}
 }
+
+func TestPCIDeviceName(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "0000:04:00.0", want: "node1-000004000"},
+		{address: "00:1f.6", want: "node1-001f6"},
+		{address: "0001:04:00.0", want: "node1-0001-04000"},
+		{address: "10000:e1:00.0", want: "node1-10000-e1000"},
+		{address: "1000:00:04.0", want: "node1-1000-00040"},
+	}
+	names := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			got := PCIDeviceName("node1", tt.address)
+			if got != tt.want {
+				t.Errorf("PCIDeviceName() = %v, want %v", got, tt.want)
+			}
+			if names[got] {
+				t.Errorf("PCIDeviceName() = %v collides with another address", got)
+			}
+			names[got] = true
+		})
+	}
+}
@@ -2,10 +2,63 @@ package v1beta1
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/genericcondition"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var (
+	// PCIDeviceClaimDeviceAvailable reports if the host was found to be using the device before it was
+	// unbound from its driver
+	PCIDeviceClaimDeviceAvailable condition.Cond = "DeviceAvailable"
+	// PCIDeviceClaimNodeAvailable reports if the node of the claim accepts new claims
+	PCIDeviceClaimNodeAvailable condition.Cond = "NodeAvailable"
+	// PCIDeviceClaimReserved reports if the reservation window of the claim has started, and does
+	// not overlap with an earlier claim for the same device
+	PCIDeviceClaimReserved condition.Cond = "Reserved"
+	// PCIDeviceClaimExpiring reports if the claim is about to expire, or expired and waits for
+	// the VMs using the device to stop
+	PCIDeviceClaimExpiring condition.Cond = "Expiring"
+	// PCIDeviceClaimReleasing reports why a deleted claim is not released yet
+	PCIDeviceClaimReleasing condition.Cond = "Releasing"
+	// PCIDeviceClaimHostDevicePermitted reports if the resourceName of the claimed device is permitted
+	// in the KubeVirt CR, which KubeVirt requires to pass the device through to VMs
+	PCIDeviceClaimHostDevicePermitted condition.Cond = "HostDevicePermitted"
+)
+
+const (
+	// DeviceInUseReason is set on the DeviceAvailable condition when the claim is refused as the host is using the device
+	DeviceInUseReason = "DeviceInUse"
+	// ForceUnbindReason is set on the DeviceAvailable condition when the device was unbound despite being in use
+	ForceUnbindReason = "ForceUnbind"
+	// NodeInMaintenanceReason is set on the NodeAvailable condition when the claim is blocked by node maintenance
+	NodeInMaintenanceReason = "NodeInMaintenance"
+	// ReservationPendingReason is set on the Reserved condition until the reservation window starts
+	ReservationPendingReason = "ReservationPending"
+	// ReservationConflictReason is set on the Reserved condition when the reservation window overlaps
+	// with an earlier claim for the same device
+	ReservationConflictReason = "ReservationConflict"
+	// ExpiryWarningReason is set on the Expiring condition when the claim is about to expire
+	ExpiryWarningReason = "ExpiryWarning"
+	// ExpiredReason is set on the Expiring condition when the claim expired, but a VM still uses the device
+	ExpiredReason = "Expired"
+	// PermitFailedReason is set on the HostDevicePermitted condition when the KubeVirt CR could not be updated
+	PermitFailedReason = "PermitFailed"
+	// InUseByVMReason is set on the Releasing condition of deleted claims and devices while VMs still use the device
+	InUseByVMReason = "InUseByVM"
+
+	// ForceReleaseAnnotation is set to "true" on a deleted PCIDeviceClaim or PCIDevice to release the
+	// device although VMs still use it, which crashes the VMs using the device
+	ForceReleaseAnnotation = "devices.harvesterhci.io/force-release"
+)
+
+// ForceRelease checks if a deleted claim or device is released regardless of the VMs using the device
+func ForceRelease(obj metav1.Object) bool {
+	return obj.GetAnnotations()[ForceReleaseAnnotation] == "true"
+}
+
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -24,13 +77,71 @@ type PCIDeviceClaimSpec struct {
 	Address  string `json:"address"`
 	NodeName string `json:"nodeName"`
 	UserName string `json:"userName"`
+	// ForceUnbind skips the checks for host usage of the device, such as mounted filesystems
+	// or configured network interfaces, before unbinding it from its driver
+	ForceUnbind bool `json:"forceUnbind,omitempty"`
+	// ExpiresAt releases the claim at the given time, once no running VM uses the device
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// TTL releases the claim once the given duration passed since the reservation started,
+	// once no running VM uses the device
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+	// ReservedFrom reserves the device from the given time until the claim expires.
+	// Passthrough is only enabled once the reservation starts
+	// +optional
+	ReservedFrom *metav1.Time `json:"reservedFrom,omitempty"`
 }
 
 func (s PCIDeviceClaimSpec) NodeAddr() string {
 	return fmt.Sprintf("%s-%s", s.NodeName, s.Address)
 }
 
+// ReservationStart is the time from which the device is reserved for the claim
+func (pdc *PCIDeviceClaim) ReservationStart() time.Time {
+	if pdc.Spec.ReservedFrom != nil {
+		return pdc.Spec.ReservedFrom.Time
+	}
+	return pdc.CreationTimestamp.Time
+}
+
+// Expiry is the earliest of ExpiresAt and the end of the TTL, or nil if the claim does not expire
+func (pdc *PCIDeviceClaim) Expiry() *time.Time {
+	var expiry *time.Time
+	if pdc.Spec.ExpiresAt != nil {
+		t := pdc.Spec.ExpiresAt.Time
+		expiry = &t
+	}
+	if pdc.Spec.TTL != nil {
+		t := pdc.ReservationStart().Add(pdc.Spec.TTL.Duration)
+		if expiry == nil || t.Before(*expiry) {
+			expiry = &t
+		}
+	}
+	return expiry
+}
+
+// ReservationOverlaps checks if the reservation windows of two claims for the same device overlap
+func (pdc *PCIDeviceClaim) ReservationOverlaps(other *PCIDeviceClaim) bool {
+	if pdc.Spec.NodeAddr() != other.Spec.NodeAddr() {
+		return false
+	}
+	// windows are half open, a reservation may start when the previous one expires
+	if end := pdc.Expiry(); end != nil && !end.After(other.ReservationStart()) {
+		return false
+	}
+	if end := other.Expiry(); end != nil && !end.After(pdc.ReservationStart()) {
+		return false
+	}
+	return true
+}
+
 type PCIDeviceClaimStatus struct {
 	KernelDriverToUnbind string `json:"kernelDriverToUnbind"`
 	PassthroughEnabled   bool   `json:"passthroughEnabled"`
+	// UsedBy is the workload the claimed device is allocated to by the kubelet
+	// +optional
+	UsedBy *DeviceUsage `json:"usedBy,omitempty"`
+	// +optional
+	Conditions []genericcondition.GenericCondition `json:"conditions,omitempty"`
 }
@@ -0,0 +1,75 @@
+package v1beta1
+
+import (
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PCIDeviceHotplugPhase is the phase of a PCIDeviceHotplug
+type PCIDeviceHotplugPhase string
+
+const (
+	// PCIDeviceHotplugPending is the phase until the device is bound, advertised and permitted
+	PCIDeviceHotplugPending PCIDeviceHotplugPhase = "Pending"
+	// PCIDeviceHotplugAttaching is the phase once the device was requested from KubeVirt, until the
+	// VMI reports the device
+	PCIDeviceHotplugAttaching PCIDeviceHotplugPhase = "Attaching"
+	// PCIDeviceHotplugAttached is the phase once the VMI reports the device
+	PCIDeviceHotplugAttached PCIDeviceHotplugPhase = "Attached"
+	// PCIDeviceHotplugDetaching is the phase of a deleted PCIDeviceHotplug until the device is detached
+	PCIDeviceHotplugDetaching PCIDeviceHotplugPhase = "Detaching"
+	// PCIDeviceHotplugFailed is the phase when the device cannot be attached to the VMI
+	PCIDeviceHotplugFailed PCIDeviceHotplugPhase = "Failed"
+)
+
+var (
+	// PCIDeviceHotplugDeviceReady reports if the device is bound to vfio-pci, advertised by its device
+	// plugin and permitted in the KubeVirt CR
+	PCIDeviceHotplugDeviceReady condition.Cond = "DeviceReady"
+	// PCIDeviceHotplugAttachedCondition reports if the device is attached to the VMI
+	PCIDeviceHotplugAttachedCondition condition.Cond = "Attached"
+)
+
+const (
+	// HotplugUnsupportedReason is set on the Attached condition when KubeVirt does not support host device hotplug
+	HotplugUnsupportedReason = "HotplugUnsupported"
+	// VMINotRunningReason is set on the Attached condition when the VMI is not running on the node of the device
+	VMINotRunningReason = "VMINotRunning"
+	// DeviceClaimedReason is set on the DeviceReady condition when the device is claimed for another user
+	DeviceClaimedReason = "DeviceClaimed"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// a PCIDeviceHotplug requests a PCIDevice to be attached to a running VMI. The device is detached
+// once the PCIDeviceHotplug is deleted
+type PCIDeviceHotplug struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PCIDeviceHotplugSpec   `json:"spec,omitempty"`
+	Status PCIDeviceHotplugStatus `json:"status,omitempty"`
+}
+
+type PCIDeviceHotplugSpec struct {
+	// DeviceName is the name of the PCIDevice to attach
+	DeviceName string `json:"deviceName"`
+	// VMINamespace and VMIName identify the running VMI the device is attached to
+	VMINamespace string `json:"vmiNamespace"`
+	VMIName      string `json:"vmiName"`
+}
+
+type PCIDeviceHotplugStatus struct {
+	Phase PCIDeviceHotplugPhase `json:"phase,omitempty"`
+	// ClaimName is the PCIDeviceClaim of the device
+	ClaimName string `json:"claimName,omitempty"`
+	// ClaimCreated is set if the claim was created for the hotplug, and is deleted once the device is detached
+	ClaimCreated bool `json:"claimCreated,omitempty"`
+	// HostDeviceName is the name of the host device attached to the VMI by the hotplug
+	HostDeviceName string                              `json:"hostDeviceName,omitempty"`
+	Conditions     []genericcondition.GenericCondition `json:"conditions,omitempty"`
+}
@@ -0,0 +1,71 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OperationType is a change of the driver or state of a device performed by the controller
+type OperationType string
+
+// OperationResult is the outcome of an operation
+type OperationResult string
+
+const (
+	// OperationBind unbinds a device from its host driver and binds it to vfio-pci
+	OperationBind OperationType = "Bind"
+	// OperationUnbind unbinds a device from vfio-pci
+	OperationUnbind OperationType = "Unbind"
+	// OperationReset resets a device through sysfs
+	OperationReset OperationType = "Reset"
+	// OperationRestoreDriver binds a device to its original host driver
+	OperationRestoreDriver OperationType = "RestoreDriver"
+
+	OperationSucceeded OperationResult = "Succeeded"
+	OperationFailed    OperationResult = "Failed"
+
+	// OperationDeviceLabel is set on PCIDeviceOperations to the name of the PCIDevice
+	OperationDeviceLabel = "devices.harvesterhci.io/device"
+	// RequestedByAnnotation is set by the admission webhook on PCIDeviceClaims to the user
+	// which created the claim
+	RequestedByAnnotation = "devices.harvesterhci.io/requested-by"
+	// RequesterGroupsAnnotation is set by the admission webhook on PCIDeviceClaims to the comma
+	// separated groups of the user which created the claim, which the devices of groups are capped by
+	RequesterGroupsAnnotation = "devices.harvesterhci.io/requester-groups"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// a PCIDeviceOperation records an operation on a PCIDevice performed by the controller, such as binding it
+// to vfio-pci for a PCIDeviceClaim. The number and age of records kept per device is limited
+type PCIDeviceOperation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PCIDeviceOperationSpec `json:"spec,omitempty"`
+}
+
+type PCIDeviceOperationSpec struct {
+	// Type is one of Bind, Unbind, Reset or RestoreDriver
+	Type       OperationType `json:"type"`
+	DeviceName string        `json:"deviceName"`
+	Address    string        `json:"address"`
+	NodeName   string        `json:"nodeName"`
+	// ClaimName is the PCIDeviceClaim the operation was performed for, and is empty for
+	// devices released by the controller at startup
+	ClaimName string `json:"claimName,omitempty"`
+	// UserName is the user which created the claim, as recorded from the admission request
+	UserName       string `json:"userName,omitempty"`
+	PreviousDriver string `json:"previousDriver,omitempty"`
+	NewDriver      string `json:"newDriver,omitempty"`
+	// ResetMethod is the method which reset the device
+	ResetMethod    string          `json:"resetMethod,omitempty"`
+	StartTime      metav1.Time     `json:"startTime"`
+	CompletionTime metav1.Time     `json:"completionTime"`
+	Result         OperationResult `json:"result"`
+	// Message is the error of failed operations
+	Message string `json:"message,omitempty"`
+}
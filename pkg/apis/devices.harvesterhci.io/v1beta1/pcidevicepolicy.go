@@ -0,0 +1,88 @@
+package v1beta1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PolicySubjectUser matches the username of the requester
+	PolicySubjectUser = "User"
+	// PolicySubjectGroup matches any group of the requester
+	PolicySubjectGroup = "Group"
+	// PolicySubjectNamespace matches the namespace of a VM
+	PolicySubjectNamespace = "Namespace"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// a PCIDevicePolicy grants users, groups or namespaces access to PCIDevices, and caps how many devices
+// each of them may hold. Once a PCIDevicePolicy exists, PCIDeviceClaims and VMs using PCIDevices are
+// only admitted if a policy permits them
+type PCIDevicePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PCIDevicePolicySpec `json:"spec,omitempty"`
+}
+
+type PCIDevicePolicySpec struct {
+	// Subjects the policy applies to
+	Subjects []PolicySubject `json:"subjects"`
+	// ResourceNames of the PCIDevices the subjects may use, e.g. nvidia.com/GA102GL_A10
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	// ClassIDs of the PCIDevices the subjects may use. A class id matches devices with a class id
+	// starting with it, e.g. "03" matches all display controllers. All devices are permitted if
+	// neither ResourceNames nor ClassIDs are set
+	ClassIDs []string `json:"classIds,omitempty"`
+	// MaxDevices caps the permitted devices each subject may hold. Users and members of a group are
+	// counted by their PCIDeviceClaims, namespaces by the host devices of their VMs
+	MaxDevices *int32 `json:"maxDevices,omitempty"`
+}
+
+// PolicySubject identifies a user, group or namespace
+type PolicySubject struct {
+	// Kind is one of User, Group or Namespace
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Matches checks if the subject is the user, one of the groups, or the namespace of a request
+func (s PolicySubject) Matches(user string, groups []string, namespace string) bool {
+	switch s.Kind {
+	case PolicySubjectUser:
+		return s.Name == user
+	case PolicySubjectGroup:
+		for _, group := range groups {
+			if s.Name == group {
+				return true
+			}
+		}
+	case PolicySubjectNamespace:
+		return namespace != "" && s.Name == namespace
+	}
+	return false
+}
+
+// Permits checks if the policy grants access to pd
+func (p *PCIDevicePolicy) Permits(pd *PCIDevice) bool {
+	if len(p.Spec.ResourceNames) == 0 && len(p.Spec.ClassIDs) == 0 {
+		return true
+	}
+	for _, resourceName := range p.Spec.ResourceNames {
+		if resourceName == pd.Status.ResourceName {
+			return true
+		}
+	}
+	for _, classID := range p.Spec.ClassIDs {
+		if classID != "" && strings.HasPrefix(pd.Status.ClassId, classID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PCIPortType is the type of a device in the PCIe tree, as reported by its PCI Express capability
+type PCIPortType string
+
+const (
+	PCIPortTypeEndpoint                      PCIPortType = "Endpoint"
+	PCIPortTypeLegacyEndpoint                PCIPortType = "LegacyEndpoint"
+	PCIPortTypeRootComplexIntegratedEndpoint PCIPortType = "RootComplexIntegratedEndpoint"
+	PCIPortTypeRootComplexEventCollector     PCIPortType = "RootComplexEventCollector"
+	PCIPortTypeRootPort                      PCIPortType = "RootPort"
+	PCIPortTypeSwitchUpstreamPort            PCIPortType = "SwitchUpstreamPort"
+	PCIPortTypeSwitchDownstreamPort          PCIPortType = "SwitchDownstreamPort"
+	PCIPortTypePCIeToPCIBridge               PCIPortType = "PCIeToPCIBridge"
+	PCIPortTypePCIToPCIeBridge               PCIPortType = "PCIToPCIeBridge"
+	// PCIPortTypePCIBridge is a conventional PCI bridge without a PCI Express capability
+	PCIPortTypePCIBridge PCIPortType = "PCIBridge"
+	// PCIPortTypePCIDevice is a conventional PCI device without a PCI Express capability
+	PCIPortTypePCIDevice PCIPortType = "PCIDevice"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// a PCITopology records the PCIe tree and the IOMMU groups of a node, and is named after the node
+type PCITopology struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PCITopologySpec   `json:"spec,omitempty"`
+	Status PCITopologyStatus `json:"status,omitempty"`
+}
+
+type PCITopologySpec struct {
+}
+
+type PCITopologyStatus struct {
+	// Devices are the PCI devices of the node including bridges and ports, ordered as in the PCIe tree
+	Devices []PCITopologyDevice `json:"devices,omitempty"`
+	// IOMMUGroups are the IOMMU groups of the node with the devices in each group, devices in the same
+	// group can only be passed through together
+	IOMMUGroups []PCITopologyIOMMUGroup `json:"iommuGroups,omitempty"`
+	// Tree renders the PCIe tree like lspci -tv
+	Tree string `json:"tree,omitempty"`
+}
+
+// PCITopologyDevice is a device in the PCIe tree of a node
+type PCITopologyDevice struct {
+	Address string `json:"address"`
+	// RootBus is the root bus of the root complex the device is below, e.g. pci0000:00
+	RootBus string `json:"rootBus"`
+	// Parent is the address of the port or bridge the device is below, and empty for devices on the root bus
+	Parent      string      `json:"parent,omitempty"`
+	Type        PCIPortType `json:"type"`
+	VendorID    string      `json:"vendorId"`
+	DeviceID    string      `json:"deviceId"`
+	ClassID     string      `json:"classId"`
+	Description string      `json:"description,omitempty"`
+	Driver      string      `json:"driver,omitempty"`
+	IOMMUGroup  string      `json:"iommuGroup,omitempty"`
+	// NUMANode is the NUMA node the device is attached to, or -1 if unknown
+	NUMANode int `json:"numaNode"`
+	// ACS is the Access Control Services capability of ports and bridges
+	ACS *PCIACS `json:"acs,omitempty"`
+	// PCIDevice is the PCIDevice of the device, and empty for bridges and devices used by the host
+	PCIDevice string `json:"pciDevice,omitempty"`
+}
+
+// PCIACS is the Access Control Services capability of a port or bridge. The kernel only places the
+// devices below a port into separate IOMMU groups if the port isolates them with ACS
+type PCIACS struct {
+	// Capabilities are the ACS controls supported, e.g. SourceValidation
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Enabled are the ACS controls enabled
+	Enabled []string `json:"enabled,omitempty"`
+	// Isolated is set if the controls the kernel requires for isolation are enabled
+	Isolated bool `json:"isolated"`
+}
+
+// PCITopologyIOMMUGroup is an IOMMU group with the addresses of its devices
+type PCITopologyIOMMUGroup struct {
+	Group   string   `json:"group"`
+	Devices []string `json:"devices"`
+}
@@ -22,16 +22,164 @@ limitations under the License.
 package v1beta1
 
 import (
+	genericcondition "github.com/rancher/wrangler/pkg/genericcondition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceUsage) DeepCopyInto(out *DeviceUsage) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceUsage.
+func (in *DeviceUsage) DeepCopy() *DeviceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePassthrough) DeepCopyInto(out *NodePassthrough) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePassthrough.
+func (in *NodePassthrough) DeepCopy() *NodePassthrough {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePassthrough)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodePassthrough) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePassthroughList) DeepCopyInto(out *NodePassthroughList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NodePassthrough, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePassthroughList.
+func (in *NodePassthroughList) DeepCopy() *NodePassthroughList {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePassthroughList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodePassthroughList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePassthroughSpec) DeepCopyInto(out *NodePassthroughSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePassthroughSpec.
+func (in *NodePassthroughSpec) DeepCopy() *NodePassthroughSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePassthroughSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePassthroughStatus) DeepCopyInto(out *NodePassthroughStatus) {
+	*out = *in
+	if in.KernelParameters != nil {
+		in, out := &in.KernelParameters, &out.KernelParameters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]PassthroughCheck, len(*in))
+		copy(*out, *in)
+	}
+	in.LastCheckTime.DeepCopyInto(&out.LastCheckTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePassthroughStatus.
+func (in *NodePassthroughStatus) DeepCopy() *NodePassthroughStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePassthroughStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIACS) DeepCopyInto(out *PCIACS) {
+	*out = *in
+	if in.Capabilities != nil {
+		in, out := &in.Capabilities, &out.Capabilities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIACS.
+func (in *PCIACS) DeepCopy() *PCIACS {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIACS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDevice) DeepCopyInto(out *PCIDevice) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -58,8 +206,8 @@ func (in *PCIDeviceClaim) DeepCopyInto(out *PCIDeviceClaim) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -117,6 +265,19 @@ func (in *PCIDeviceClaimList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceClaimSpec) DeepCopyInto(out *PCIDeviceClaimSpec) {
 	*out = *in
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ReservedFrom != nil {
+		in, out := &in.ReservedFrom, &out.ReservedFrom
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -133,6 +294,16 @@ func (in *PCIDeviceClaimSpec) DeepCopy() *PCIDeviceClaimSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceClaimStatus) DeepCopyInto(out *PCIDeviceClaimStatus) {
 	*out = *in
+	if in.UsedBy != nil {
+		in, out := &in.UsedBy, &out.UsedBy
+		*out = new(DeviceUsage)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]genericcondition.GenericCondition, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -146,6 +317,104 @@ func (in *PCIDeviceClaimStatus) DeepCopy() *PCIDeviceClaimStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceHotplug) DeepCopyInto(out *PCIDeviceHotplug) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceHotplug.
+func (in *PCIDeviceHotplug) DeepCopy() *PCIDeviceHotplug {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceHotplug)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDeviceHotplug) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceHotplugList) DeepCopyInto(out *PCIDeviceHotplugList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PCIDeviceHotplug, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceHotplugList.
+func (in *PCIDeviceHotplugList) DeepCopy() *PCIDeviceHotplugList {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceHotplugList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDeviceHotplugList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceHotplugSpec) DeepCopyInto(out *PCIDeviceHotplugSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceHotplugSpec.
+func (in *PCIDeviceHotplugSpec) DeepCopy() *PCIDeviceHotplugSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceHotplugSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceHotplugStatus) DeepCopyInto(out *PCIDeviceHotplugStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]genericcondition.GenericCondition, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceHotplugStatus.
+func (in *PCIDeviceHotplugStatus) DeepCopy() *PCIDeviceHotplugStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceHotplugStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceList) DeepCopyInto(out *PCIDeviceList) {
 	*out = *in
@@ -179,6 +448,180 @@ func (in *PCIDeviceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceOperation) DeepCopyInto(out *PCIDeviceOperation) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceOperation.
+func (in *PCIDeviceOperation) DeepCopy() *PCIDeviceOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDeviceOperation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceOperationList) DeepCopyInto(out *PCIDeviceOperationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PCIDeviceOperation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceOperationList.
+func (in *PCIDeviceOperationList) DeepCopy() *PCIDeviceOperationList {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceOperationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDeviceOperationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceOperationSpec) DeepCopyInto(out *PCIDeviceOperationSpec) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.CompletionTime.DeepCopyInto(&out.CompletionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceOperationSpec.
+func (in *PCIDeviceOperationSpec) DeepCopy() *PCIDeviceOperationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceOperationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDevicePolicy) DeepCopyInto(out *PCIDevicePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDevicePolicy.
+func (in *PCIDevicePolicy) DeepCopy() *PCIDevicePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDevicePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDevicePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDevicePolicyList) DeepCopyInto(out *PCIDevicePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PCIDevicePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDevicePolicyList.
+func (in *PCIDevicePolicyList) DeepCopy() *PCIDevicePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDevicePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCIDevicePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDevicePolicySpec) DeepCopyInto(out *PCIDevicePolicySpec) {
+	*out = *in
+	if in.Subjects != nil {
+		in, out := &in.Subjects, &out.Subjects
+		*out = make([]PolicySubject, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResourceNames != nil {
+		in, out := &in.ResourceNames, &out.ResourceNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClassIDs != nil {
+		in, out := &in.ClassIDs, &out.ClassIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxDevices != nil {
+		in, out := &in.MaxDevices, &out.MaxDevices
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDevicePolicySpec.
+func (in *PCIDevicePolicySpec) DeepCopy() *PCIDevicePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDevicePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceSpec) DeepCopyInto(out *PCIDeviceSpec) {
 	*out = *in
@@ -198,6 +641,16 @@ func (in *PCIDeviceSpec) DeepCopy() *PCIDeviceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceStatus) DeepCopyInto(out *PCIDeviceStatus) {
 	*out = *in
+	if in.UsedBy != nil {
+		in, out := &in.UsedBy, &out.UsedBy
+		*out = new(DeviceUsage)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]genericcondition.GenericCondition, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -210,3 +663,184 @@ func (in *PCIDeviceStatus) DeepCopy() *PCIDeviceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopology) DeepCopyInto(out *PCITopology) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopology.
+func (in *PCITopology) DeepCopy() *PCITopology {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopology)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCITopology) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopologyDevice) DeepCopyInto(out *PCITopologyDevice) {
+	*out = *in
+	if in.ACS != nil {
+		in, out := &in.ACS, &out.ACS
+		*out = new(PCIACS)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopologyDevice.
+func (in *PCITopologyDevice) DeepCopy() *PCITopologyDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopologyDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopologyIOMMUGroup) DeepCopyInto(out *PCITopologyIOMMUGroup) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopologyIOMMUGroup.
+func (in *PCITopologyIOMMUGroup) DeepCopy() *PCITopologyIOMMUGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopologyIOMMUGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopologyList) DeepCopyInto(out *PCITopologyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PCITopology, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopologyList.
+func (in *PCITopologyList) DeepCopy() *PCITopologyList {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopologyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PCITopologyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopologySpec) DeepCopyInto(out *PCITopologySpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopologySpec.
+func (in *PCITopologySpec) DeepCopy() *PCITopologySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopologySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCITopologyStatus) DeepCopyInto(out *PCITopologyStatus) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]PCITopologyDevice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IOMMUGroups != nil {
+		in, out := &in.IOMMUGroups, &out.IOMMUGroups
+		*out = make([]PCITopologyIOMMUGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCITopologyStatus.
+func (in *PCITopologyStatus) DeepCopy() *PCITopologyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PCITopologyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PassthroughCheck) DeepCopyInto(out *PassthroughCheck) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PassthroughCheck.
+func (in *PassthroughCheck) DeepCopy() *PassthroughCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(PassthroughCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicySubject) DeepCopyInto(out *PolicySubject) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicySubject.
+func (in *PolicySubject) DeepCopy() *PolicySubject {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicySubject)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -26,6 +26,23 @@ import (
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
+// NodePassthroughList is a list of NodePassthrough resources
+type NodePassthroughList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []NodePassthrough `json:"items"`
+}
+
+func NewNodePassthrough(namespace, name string, obj NodePassthrough) *NodePassthrough {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("NodePassthrough").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
 // PCIDeviceList is a list of PCIDevice resources
 type PCIDeviceList struct {
 	metav1.TypeMeta `json:",inline"`
@@ -57,3 +74,71 @@ func NewPCIDeviceClaim(namespace, name string, obj PCIDeviceClaim) *PCIDeviceCla
 	obj.Namespace = namespace
 	return &obj
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PCIDeviceHotplugList is a list of PCIDeviceHotplug resources
+type PCIDeviceHotplugList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PCIDeviceHotplug `json:"items"`
+}
+
+func NewPCIDeviceHotplug(namespace, name string, obj PCIDeviceHotplug) *PCIDeviceHotplug {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("PCIDeviceHotplug").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PCIDeviceOperationList is a list of PCIDeviceOperation resources
+type PCIDeviceOperationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PCIDeviceOperation `json:"items"`
+}
+
+func NewPCIDeviceOperation(namespace, name string, obj PCIDeviceOperation) *PCIDeviceOperation {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("PCIDeviceOperation").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PCIDevicePolicyList is a list of PCIDevicePolicy resources
+type PCIDevicePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PCIDevicePolicy `json:"items"`
+}
+
+func NewPCIDevicePolicy(namespace, name string, obj PCIDevicePolicy) *PCIDevicePolicy {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("PCIDevicePolicy").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PCITopologyList is a list of PCITopology resources
+type PCITopologyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PCITopology `json:"items"`
+}
+
+func NewPCITopology(namespace, name string, obj PCITopology) *PCITopology {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("PCITopology").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
@@ -28,8 +28,13 @@ import (
 )
 
 var (
-	PCIDeviceResourceName      = "pcidevices"
-	PCIDeviceClaimResourceName = "pcideviceclaims"
+	NodePassthroughResourceName    = "nodepassthroughs"
+	PCIDeviceResourceName          = "pcidevices"
+	PCIDeviceClaimResourceName     = "pcideviceclaims"
+	PCIDeviceHotplugResourceName   = "pcidevicehotplugs"
+	PCIDeviceOperationResourceName = "pcideviceoperations"
+	PCIDevicePolicyResourceName    = "pcidevicepolicies"
+	PCITopologyResourceName        = "pcitopologies"
 )
 
 // SchemeGroupVersion is group version used to register these objects
@@ -53,10 +58,20 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&NodePassthrough{},
+		&NodePassthroughList{},
 		&PCIDevice{},
 		&PCIDeviceList{},
 		&PCIDeviceClaim{},
 		&PCIDeviceClaimList{},
+		&PCIDeviceHotplug{},
+		&PCIDeviceHotplugList{},
+		&PCIDeviceOperation{},
+		&PCIDeviceOperationList{},
+		&PCIDevicePolicy{},
+		&PCIDevicePolicyList{},
+		&PCITopology{},
+		&PCITopologyList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -0,0 +1,174 @@
+package deviceusage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/podresources"
+)
+
+const pollInterval = 10 * time.Second
+
+// Handler publishes the pods, and the VMIs running in them, which the kubelet allocated the PCIDevices
+// of the node to, in status.usedBy of the PCIDevices and their PCIDeviceClaims
+type Handler struct {
+	nodeName  string
+	lister    podresources.Lister
+	pods      ctlcorev1.PodClient
+	pdClient  v1beta1gen.PCIDeviceClient
+	pdCache   v1beta1gen.PCIDeviceCache
+	pdcClient v1beta1gen.PCIDeviceClaimClient
+	pdcCache  v1beta1gen.PCIDeviceClaimCache
+	// vmiByPod remembers the VMI of each pod holding devices, as the owner of a pod never changes
+	vmiByPod map[string]string
+}
+
+func Register(
+	ctx context.Context,
+	pdClient v1beta1gen.PCIDeviceController,
+	pdcClient v1beta1gen.PCIDeviceClaimController,
+	pods ctlcorev1.PodClient,
+	lister podresources.Lister,
+	nodeName string,
+) error {
+	handler := &Handler{
+		nodeName:  nodeName,
+		lister:    lister,
+		pods:      pods,
+		pdClient:  pdClient,
+		pdCache:   pdClient.Cache(),
+		pdcClient: pdcClient,
+		pdcCache:  pdcClient.Cache(),
+		vmiByPod:  make(map[string]string),
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := handler.sync(ctx); err != nil {
+			logrus.Errorf("error syncing usage of pcidevices: %v", err)
+		}
+	}, pollInterval)
+	return nil
+}
+
+// sync queries the kubelet for the devices allocated to pods, and updates the usage of the devices
+// and claims of the node
+func (h *Handler) sync(ctx context.Context) error {
+	resources, err := h.lister.List(ctx)
+	if err != nil {
+		return err
+	}
+	usage, err := h.deviceUsage(resources)
+	if err != nil {
+		return err
+	}
+
+	pds, err := h.pdCache.List(labels.SelectorFromSet(map[string]string{"nodename": h.nodeName}))
+	if err != nil {
+		return fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	usageByAddress := make(map[string]*v1beta1.DeviceUsage, len(pds))
+	for _, pd := range pds {
+		usedBy := usage[deviceKey(pd.Status.ResourceName, pd.Status.Address)]
+		usageByAddress[pd.Status.Address] = usedBy
+		if reflect.DeepEqual(pd.Status.UsedBy, usedBy) {
+			continue
+		}
+		pdCopy := pd.DeepCopy()
+		pdCopy.Status.UsedBy = usedBy
+		if _, err := h.pdClient.UpdateStatus(pdCopy); err != nil {
+			return fmt.Errorf("error updating usage of pcidevice %s: %v", pd.Name, err)
+		}
+	}
+
+	pdcs, err := h.pdcCache.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+	for _, pdc := range pdcs {
+		if pdc.Spec.NodeName != h.nodeName {
+			continue
+		}
+		usedBy := usageByAddress[pdc.Spec.Address]
+		if reflect.DeepEqual(pdc.Status.UsedBy, usedBy) {
+			continue
+		}
+		pdcCopy := pdc.DeepCopy()
+		pdcCopy.Status.UsedBy = usedBy
+		if _, err := h.pdcClient.UpdateStatus(pdcCopy); err != nil {
+			return fmt.Errorf("error updating usage of pcideviceclaim %s: %v", pdc.Name, err)
+		}
+	}
+	return nil
+}
+
+// deviceUsage maps the resource name and id of each allocated device to the pod and VMI it is allocated to.
+// The device plugins use the PCI address of a device as its id
+func (h *Handler) deviceUsage(resources []*podresources.PodResources) (map[string]*v1beta1.DeviceUsage, error) {
+	usage := make(map[string]*v1beta1.DeviceUsage)
+	vmiByPod := make(map[string]string)
+	for _, pod := range resources {
+		podKey := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+		for _, container := range pod.Containers {
+			for _, devices := range container.Devices {
+				if len(devices.DeviceIds) == 0 {
+					continue
+				}
+				vmi, ok := vmiByPod[podKey]
+				if !ok {
+					var err error
+					if vmi, err = h.vmiForPod(pod.Namespace, pod.Name); err != nil {
+						return nil, err
+					}
+					vmiByPod[podKey] = vmi
+				}
+				for _, id := range devices.DeviceIds {
+					usage[deviceKey(devices.ResourceName, id)] = &v1beta1.DeviceUsage{
+						Pod:                    podKey,
+						Container:              container.Name,
+						VirtualMachineInstance: vmi,
+					}
+				}
+			}
+		}
+	}
+	// forget pods which no longer hold devices
+	h.vmiByPod = vmiByPod
+	return usage, nil
+}
+
+// vmiForPod returns the namespace/name of the VMI owning a virt-launcher pod, or an empty string for other pods
+func (h *Handler) vmiForPod(namespace, name string) (string, error) {
+	podKey := fmt.Sprintf("%s/%s", namespace, name)
+	if vmi, ok := h.vmiByPod[podKey]; ok {
+		return vmi, nil
+	}
+
+	pod, err := h.pods.Get(namespace, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error looking up pod %s: %v", podKey, err)
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == kubevirtv1.VirtualMachineInstanceGroupVersionKind.Kind {
+			return fmt.Sprintf("%s/%s", namespace, owner.Name), nil
+		}
+	}
+	return "", nil
+}
+
+func deviceKey(resourceName, id string) string {
+	return resourceName + "/" + id
+}
@@ -0,0 +1,113 @@
+package deviceusage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+	"github.com/harvester/pcidevices/pkg/util/podresources"
+)
+
+const resourceName = "nvidia.com/GA102GL_A10"
+
+type fakeLister []*podresources.PodResources
+
+func (f fakeLister) List(_ context.Context) ([]*podresources.PodResources, error) {
+	return f, nil
+}
+
+func newDevice(name, address string) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"nodename": "node1"},
+		},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:      address,
+			NodeName:     "node1",
+			ResourceName: resourceName,
+		},
+	}
+}
+
+func Test_SyncUsage(t *testing.T) {
+	assert := require.New(t)
+	used := newDevice("node1-000004000", "0000:04:00.0")
+	free := newDevice("node1-000005000", "0000:05:00.0")
+	free.Status.UsedBy = &v1beta1.DeviceUsage{Pod: "default/stale"}
+	pdc := &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: used.Name,
+		},
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address:  used.Status.Address,
+			NodeName: "node1",
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "virt-launcher-vm1-abcde",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "VirtualMachineInstance",
+					Name: "vm1",
+				},
+			},
+		},
+	}
+	lister := fakeLister{
+		{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Containers: []*podresources.ContainerResources{
+				{
+					Name: "compute",
+					Devices: []*podresources.ContainerDevices{
+						{ResourceName: resourceName, DeviceIds: []string{used.Status.Address}},
+						{ResourceName: "other.com/DEVICE", DeviceIds: []string{free.Status.Address}},
+					},
+				},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(used, free, pdc)
+	k8sclient := k8sfake.NewSimpleClientset(pod)
+	h := &Handler{
+		nodeName:  "node1",
+		lister:    lister,
+		pods:      fakeclients.PodClient(k8sclient.CoreV1().Pods),
+		pdClient:  fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		pdCache:   fakeclients.PCIDevicesCache(client.DevicesV1beta1().PCIDevices),
+		pdcClient: fakeclients.PCIDeviceClaimsClient(client.DevicesV1beta1().PCIDeviceClaims),
+		pdcCache:  fakeclients.PCIDeviceClaimsCache(client.DevicesV1beta1().PCIDeviceClaims),
+		vmiByPod:  make(map[string]string),
+	}
+	assert.NoError(h.sync(context.TODO()))
+
+	expected := &v1beta1.DeviceUsage{
+		Pod:                    "default/virt-launcher-vm1-abcde",
+		Container:              "compute",
+		VirtualMachineInstance: "default/vm1",
+	}
+	pd, err := client.DevicesV1beta1().PCIDevices().Get(context.TODO(), used.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(expected, pd.Status.UsedBy)
+
+	pd, err = client.DevicesV1beta1().PCIDevices().Get(context.TODO(), free.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Nil(pd.Status.UsedBy, "expected usage of other resources to be ignored, and stale usage to be cleared")
+
+	claim, err := client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), pdc.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(expected, claim.Status.UsedBy)
+	assert.Equal(map[string]string{"default/virt-launcher-vm1-abcde": "default/vm1"}, h.vmiByPod)
+}
@@ -12,7 +12,8 @@ import (
 )
 
 const (
-	wranglerFinalizer = "wrangler.cattle.io/PCIDeviceClaimOnRemove"
+	wranglerFinalizer       = "wrangler.cattle.io/PCIDeviceClaimOnRemove"
+	wranglerDeviceFinalizer = "wrangler.cattle.io/PCIDeviceOnRemove"
 )
 
 type Handler struct {
@@ -59,6 +60,15 @@ func (h *Handler) OnRemove(_ string, node *v1.Node) (*v1.Node, error) {
 		return node, err
 	}
 	for _, pd := range pds.Items {
+		// the controller of a removed node is gone, and no longer releases its devices
+		pdCopy := pd.DeepCopy()
+		if containsFinalizer(pdCopy.Finalizers, wranglerDeviceFinalizer) {
+			pdCopy.Finalizers = removeFinalizer(pdCopy.Finalizers, wranglerDeviceFinalizer)
+			if _, err := h.pdClient.Update(pdCopy); err != nil {
+				return node, fmt.Errorf("error removing finalizer: %v", err)
+			}
+		}
+
 		err = h.pdClient.Delete(pd.Name, &metav1.DeleteOptions{})
 		if err != nil {
 			logrus.Errorf("error deleting pd: %s", err)
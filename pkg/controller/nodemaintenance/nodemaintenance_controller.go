@@ -0,0 +1,164 @@
+package nodemaintenance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	ctlkubevirtv1 "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/relatedresource"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/vmusage"
+)
+
+// Handler reconciles the maintenance annotations of the node the controller runs on. It reports the VMs
+// using passthrough devices of the node, which block a drain of the node, and while the node is in
+// maintenance optionally releases claims no longer used by a VM
+type Handler struct {
+	nodeName  string
+	nodes     corecontrollers.NodeClient
+	pdcClient v1beta1gen.PCIDeviceClaimClient
+	pdcCache  v1beta1gen.PCIDeviceClaimCache
+	pdCache   v1beta1gen.PCIDeviceCache
+	vmiCache  ctlkubevirtv1.VirtualMachineInstanceCache
+}
+
+func Register(
+	ctx context.Context,
+	pdcClient v1beta1gen.PCIDeviceClaimController,
+	pdClient v1beta1gen.PCIDeviceController,
+	nodeClient corecontrollers.NodeController,
+	vmiClient ctlkubevirtv1.VirtualMachineInstanceController,
+	nodeName string,
+) error {
+	handler := &Handler{
+		nodeName:  nodeName,
+		nodes:     nodeClient,
+		pdcClient: pdcClient,
+		pdcCache:  pdcClient.Cache(),
+		pdCache:   pdClient.Cache(),
+		vmiCache:  vmiClient.Cache(),
+	}
+	nodeClient.OnChange(ctx, "node-maintenance", handler.OnNodeChange)
+	// VMs stopping, claims being removed and devices being allocated change the VMs holding devices of the node
+	relatedresource.WatchClusterScoped(ctx, "node-maintenance-vmi", handler.nodeForObject, nodeClient, vmiClient, pdcClient, pdClient)
+	return nil
+}
+
+func (h *Handler) nodeForObject(_ string, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	switch o := obj.(type) {
+	case *kubevirtv1.VirtualMachineInstance:
+		// VMIs which are not scheduled yet may be scheduled to the node
+		if o.Status.NodeName == h.nodeName || o.Status.NodeName == "" {
+			return []relatedresource.Key{relatedresource.NewKey("", h.nodeName)}, nil
+		}
+	case *v1beta1.PCIDeviceClaim:
+		if o.Spec.NodeName == h.nodeName {
+			return []relatedresource.Key{relatedresource.NewKey("", h.nodeName)}, nil
+		}
+	case *v1beta1.PCIDevice:
+		if o.Status.NodeName == h.nodeName {
+			return []relatedresource.Key{relatedresource.NewKey("", h.nodeName)}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (h *Handler) OnNodeChange(_ string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil || node.DeletionTimestamp != nil || node.Name != h.nodeName {
+		return node, nil
+	}
+
+	pdcs, err := h.claimsForNode(node.Name)
+	if err != nil {
+		return node, err
+	}
+
+	vmsByClaim, err := vmusage.VMsUsingClaims(h.vmiCache, h.pdCache, pdcs...)
+	if err != nil {
+		return node, err
+	}
+
+	vmSet := make(map[string]bool)
+	for _, vms := range vmsByClaim {
+		for _, vm := range vms {
+			vmSet[vm] = true
+		}
+	}
+	vms := make([]string, 0, len(vmSet))
+	for vm := range vmSet {
+		vms = append(vms, vm)
+	}
+	sort.Strings(vms)
+	// VMs using passthrough devices cannot be live-migrated, and block drains of the node until they are shut down
+	node, err = h.updateVMsAnnotation(node, v1beta1.DrainBlockingVMsAnnotation, vms)
+	if err != nil {
+		return node, err
+	}
+
+	if !v1beta1.NodeInMaintenance(node) {
+		return h.updateVMsAnnotation(node, v1beta1.MaintenanceVMsAnnotation, nil)
+	}
+
+	if v1beta1.NodeReleasesClaims(node) {
+		for _, pdc := range pdcs {
+			if len(vmsByClaim[pdc.Name]) > 0 || pdc.DeletionTimestamp != nil {
+				continue
+			}
+			logrus.Infof("releasing pcideviceclaim %s for maintenance of node %s", pdc.Name, node.Name)
+			if err := h.pdcClient.Delete(pdc.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return node, fmt.Errorf("error releasing pcideviceclaim %s: %v", pdc.Name, err)
+			}
+		}
+	}
+
+	if len(vms) > 0 {
+		logrus.Infof("node %s is in maintenance, pcidevices are still in use by VMs %s", node.Name, strings.Join(vms, ","))
+	}
+	return h.updateVMsAnnotation(node, v1beta1.MaintenanceVMsAnnotation, vms)
+}
+
+func (h *Handler) claimsForNode(nodeName string) ([]*v1beta1.PCIDeviceClaim, error) {
+	pdcs, err := h.pdcCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+	var result []*v1beta1.PCIDeviceClaim
+	for _, pdc := range pdcs {
+		if pdc.Spec.NodeName == nodeName {
+			result = append(result, pdc)
+		}
+	}
+	return result, nil
+}
+
+// updateVMsAnnotation lists vms in the annotation key of node, the annotation is removed if there are no vms
+func (h *Handler) updateVMsAnnotation(node *corev1.Node, key string, vms []string) (*corev1.Node, error) {
+	value := strings.Join(vms, ",")
+	current, ok := node.Annotations[key]
+	if current == value && (ok || value == "") {
+		return node, nil
+	}
+
+	nodeCopy := node.DeepCopy()
+	if value == "" {
+		delete(nodeCopy.Annotations, key)
+	} else {
+		if nodeCopy.Annotations == nil {
+			nodeCopy.Annotations = make(map[string]string)
+		}
+		nodeCopy.Annotations[key] = value
+	}
+	return h.nodes.Update(nodeCopy)
+}
@@ -0,0 +1,184 @@
+package nodemaintenance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+const (
+	nicResourceName = "intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION"
+	gpuResourceName = "nvidia.com/GA102GL_A10"
+)
+
+// newVMI returns a VMI on node1, which requests a host device for each of resourceNames. VMs name their
+// host devices freely, so the names differ from the claims
+func newVMI(name string, phase kubevirtv1.VirtualMachineInstancePhase, resourceNames ...string) *kubevirtv1.VirtualMachineInstance {
+	vmi := &kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Status: kubevirtv1.VirtualMachineInstanceStatus{
+			NodeName: "node1",
+			Phase:    phase,
+		},
+	}
+	for idx, resourceName := range resourceNames {
+		vmi.Spec.Domain.Devices.HostDevices = append(vmi.Spec.Domain.Devices.HostDevices, kubevirtv1.HostDevice{
+			Name:       fmt.Sprintf("%s-hostdevice%d", name, idx),
+			DeviceName: resourceName,
+		})
+	}
+	return vmi
+}
+
+func newClaim(name, node, address string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address:  address,
+			NodeName: node,
+		},
+	}
+}
+
+// newDevice returns the device at address of node, allocated to the VMI vmi if it is set
+func newDevice(node, address, resourceName, vmi string) *v1beta1.PCIDevice {
+	pd := &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: v1beta1.PCIDeviceName(node, address),
+		},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:      address,
+			NodeName:     node,
+			ResourceName: resourceName,
+		},
+	}
+	if vmi != "" {
+		pd.Status.UsedBy = &v1beta1.DeviceUsage{
+			Pod:                    "default/virt-launcher-" + vmi,
+			VirtualMachineInstance: "default/" + vmi,
+		}
+	}
+	return pd
+}
+
+func Test_NodeMaintenance(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		annotations    map[string]string
+		expectVMs      string
+		expectReleased []string
+	}{
+		{
+			name:        "node not in maintenance",
+			annotations: map[string]string{},
+		},
+		{
+			name: "node in maintenance reports vms",
+			annotations: map[string]string{
+				v1beta1.MaintenanceAnnotation: "true",
+			},
+			expectVMs: "default/vm1,default/vm2",
+		},
+		{
+			name: "node in maintenance releases unused claims",
+			annotations: map[string]string{
+				v1beta1.MaintenanceAnnotation:              "true",
+				v1beta1.MaintenanceReleaseClaimsAnnotation: "true",
+			},
+			expectVMs:      "default/vm1,default/vm2",
+			expectReleased: []string{"node1-000004100", "node1-000004103"},
+		},
+		{
+			name: "release requires maintenance",
+			annotations: map[string]string{
+				v1beta1.MaintenanceReleaseClaimsAnnotation: "true",
+			},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "node1",
+					Annotations: v.annotations,
+				},
+			}
+			k8sclient := k8sfake.NewSimpleClientset(node)
+			// vm1 is allocated 04:10.1 as published in usedBy, so it does not hold 04:10.0 of the same
+			// resourceName. vm2 is scheduled and holds the only GPU before the allocation is published.
+			// vm3 stopped and no longer holds 04:10.3
+			client := fake.NewSimpleClientset(
+				newDevice("node1", "0000:04:10.0", nicResourceName, ""),
+				newDevice("node1", "0000:04:10.1", nicResourceName, "vm1"),
+				newDevice("node1", "0000:04:10.2", gpuResourceName, ""),
+				newDevice("node1", "0000:04:10.3", nicResourceName, "vm3"),
+				newDevice("node2", "0000:04:10.0", nicResourceName, ""),
+			)
+			for idx, name := range []string{"node1-000004100", "node1-000004101", "node1-000004102", "node1-000004103"} {
+				pdc := newClaim(name, "node1", fmt.Sprintf("0000:04:10.%d", idx))
+				_, err := client.DevicesV1beta1().PCIDeviceClaims().Create(context.TODO(), pdc, metav1.CreateOptions{})
+				assert.NoError(err)
+			}
+			_, err := client.DevicesV1beta1().PCIDeviceClaims().Create(context.TODO(), newClaim("node2-000004100", "node2", "0000:04:10.0"), metav1.CreateOptions{})
+			assert.NoError(err)
+
+			h := &Handler{
+				nodeName:  "node1",
+				nodes:     fakeclients.NodeClient(k8sclient.CoreV1().Nodes),
+				pdcClient: fakeclients.PCIDeviceClaimsClient(client.DevicesV1beta1().PCIDeviceClaims),
+				pdcCache:  fakeclients.PCIDeviceClaimsCache(client.DevicesV1beta1().PCIDeviceClaims),
+				pdCache:   fakeclients.PCIDevicesCache(client.DevicesV1beta1().PCIDevices),
+				vmiCache: fakeclients.VirtualMachineInstanceCache{
+					newVMI("vm1", kubevirtv1.Running, nicResourceName),
+					newVMI("vm2", kubevirtv1.Scheduling, gpuResourceName),
+					newVMI("vm3", kubevirtv1.Succeeded, nicResourceName),
+				},
+			}
+
+			_, err = h.OnNodeChange(node.Name, node)
+			assert.NoError(err)
+
+			nodeObj, err := k8sclient.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+			assert.NoError(err)
+			assert.Equal(v.expectVMs, nodeObj.Annotations[v1beta1.MaintenanceVMsAnnotation])
+			// vms using devices of the node block drains, also outside of maintenance
+			assert.Equal("default/vm1,default/vm2", nodeObj.Annotations[v1beta1.DrainBlockingVMsAnnotation])
+
+			pdcs, err := client.DevicesV1beta1().PCIDeviceClaims().List(context.TODO(), metav1.ListOptions{})
+			assert.NoError(err)
+			remaining := make(map[string]bool)
+			for _, pdc := range pdcs.Items {
+				remaining[pdc.Name] = true
+			}
+			assert.True(remaining["node2-000004100"], "expected claims of other nodes to not be released")
+			assert.Len(remaining, 5-len(v.expectReleased))
+			for _, name := range v.expectReleased {
+				assert.False(remaining[name], "expected claim %s to be released", name)
+			}
+
+			// the report is removed once the maintenance ends
+			delete(nodeObj.Annotations, v1beta1.MaintenanceAnnotation)
+			nodeObj, err = h.OnNodeChange(nodeObj.Name, nodeObj)
+			assert.NoError(err)
+			assert.NotContains(nodeObj.Annotations, v1beta1.MaintenanceVMsAnnotation)
+			assert.Equal("default/vm1,default/vm2", nodeObj.Annotations[v1beta1.DrainBlockingVMsAnnotation])
+		})
+	}
+}
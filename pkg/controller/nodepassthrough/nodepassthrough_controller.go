@@ -0,0 +1,220 @@
+package nodepassthrough
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/relatedresource"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/iommu"
+)
+
+const (
+	// checkInterval is how often the readiness of the node is checked, as firmware settings and
+	// kernel parameters only change across reboots, while modules can be loaded at any time
+	checkInterval = 5 * time.Minute
+
+	reasonReady    = "PassthroughReady"
+	reasonNotReady = "PassthroughChecksFailed"
+)
+
+// Handler checks the readiness of the node the controller runs on for PCI passthrough. The result is
+// reported in the NodePassthrough named after the node, and published on the node as a label and a condition
+type Handler struct {
+	nodeName string
+	host     iommu.Host
+	nodes    corecontrollers.NodeClient
+	npClient v1beta1gen.NodePassthroughClient
+	npCache  v1beta1gen.NodePassthroughCache
+}
+
+func Register(
+	ctx context.Context,
+	npClient v1beta1gen.NodePassthroughController,
+	nodeClient corecontrollers.NodeController,
+	nodeName string,
+) error {
+	handler := &Handler{
+		nodeName: nodeName,
+		host:     iommu.DefaultHost,
+		nodes:    nodeClient,
+		npClient: npClient,
+		npCache:  npClient.Cache(),
+	}
+	nodeClient.OnChange(ctx, "node-passthrough", handler.OnNodeChange)
+	relatedresource.WatchClusterScoped(ctx, "node-passthrough-status", handler.nodeForStatus, nodeClient, npClient)
+	go wait.Until(func() {
+		if err := handler.checkReadiness(); err != nil {
+			logrus.Errorf("error checking passthrough readiness of node %s: %v", nodeName, err)
+		}
+	}, checkInterval, ctx.Done())
+	return nil
+}
+
+func (h *Handler) nodeForStatus(_ string, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	if np, ok := obj.(*v1beta1.NodePassthrough); ok && np.Name == h.nodeName {
+		return []relatedresource.Key{relatedresource.NewKey("", h.nodeName)}, nil
+	}
+	return nil, nil
+}
+
+// checkReadiness checks the host, and records the result in the NodePassthrough of the node
+func (h *Handler) checkReadiness() error {
+	status := h.host.CheckReadiness()
+	status.LastCheckTime = metav1.Now()
+
+	np, err := h.npClient.Get(h.nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		np, err = h.createNodePassthrough()
+	}
+	if err != nil {
+		return err
+	}
+
+	if np.Status.Ready != status.Ready || np.Status.LastCheckTime.IsZero() {
+		if status.Ready {
+			logrus.Infof("node %s is ready for pci passthrough", h.nodeName)
+		} else {
+			logrus.Warnf("node %s is not ready for pci passthrough: %s", h.nodeName, failedChecks(status))
+		}
+	}
+	npCopy := np.DeepCopy()
+	npCopy.Status = status
+	if _, err := h.npClient.UpdateStatus(npCopy); err != nil {
+		return fmt.Errorf("error updating status of nodepassthrough %s: %v", h.nodeName, err)
+	}
+	return nil
+}
+
+// createNodePassthrough creates the NodePassthrough of the node, which is owned by the node so it
+// is removed along with the node
+func (h *Handler) createNodePassthrough() (*v1beta1.NodePassthrough, error) {
+	node, err := h.nodes.Get(h.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node %s: %v", h.nodeName, err)
+	}
+	np, err := h.npClient.Create(&v1beta1.NodePassthrough{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: h.nodeName,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Node",
+					Name:       node.Name,
+					UID:        node.UID,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating nodepassthrough %s: %v", h.nodeName, err)
+	}
+	return np, nil
+}
+
+// OnNodeChange publishes the readiness of the node recorded in its NodePassthrough as a label, so
+// workloads can be scheduled to ready nodes, and as a condition with the failed checks
+func (h *Handler) OnNodeChange(_ string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil || node.DeletionTimestamp != nil || node.Name != h.nodeName {
+		return node, nil
+	}
+	np, err := h.npCache.Get(node.Name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the node was not checked yet
+			return node, nil
+		}
+		return node, err
+	}
+
+	if conditionChanged(node, np.Status) {
+		nodeCopy := node.DeepCopy()
+		setCondition(nodeCopy, np.Status)
+		node, err = h.nodes.UpdateStatus(nodeCopy)
+		if err != nil {
+			return node, fmt.Errorf("error updating passthrough condition of node %s: %v", h.nodeName, err)
+		}
+	}
+
+	ready := strconv.FormatBool(np.Status.Ready)
+	if node.Labels[v1beta1.PassthroughReadyLabel] != ready {
+		nodeCopy := node.DeepCopy()
+		if nodeCopy.Labels == nil {
+			nodeCopy.Labels = make(map[string]string)
+		}
+		nodeCopy.Labels[v1beta1.PassthroughReadyLabel] = ready
+		return h.nodes.Update(nodeCopy)
+	}
+	return node, nil
+}
+
+func newCondition(status v1beta1.NodePassthroughStatus) corev1.NodeCondition {
+	condition := corev1.NodeCondition{
+		Type:    corev1.NodeConditionType(v1beta1.NodePassthroughReady),
+		Status:  corev1.ConditionTrue,
+		Reason:  reasonReady,
+		Message: "all passthrough checks passed",
+	}
+	if !status.Ready {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = reasonNotReady
+		condition.Message = failedChecks(status)
+	}
+	return condition
+}
+
+func conditionChanged(node *corev1.Node, status v1beta1.NodePassthroughStatus) bool {
+	condition := newCondition(status)
+	for _, c := range node.Status.Conditions {
+		if c.Type == condition.Type {
+			return c.Status != condition.Status || c.Reason != condition.Reason || c.Message != condition.Message
+		}
+	}
+	return true
+}
+
+func setCondition(node *corev1.Node, status v1beta1.NodePassthroughStatus) {
+	condition := newCondition(status)
+	now := metav1.Now()
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	for i, c := range node.Status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		node.Status.Conditions[i] = condition
+		return
+	}
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+}
+
+// failedChecks describes the failed checks of status along with their remediation
+func failedChecks(status v1beta1.NodePassthroughStatus) string {
+	var failed []string
+	for _, check := range status.Checks {
+		if check.Passed {
+			continue
+		}
+		message := fmt.Sprintf("%s: %s", check.Name, check.Message)
+		if check.Remediation != "" {
+			message = fmt.Sprintf("%s (%s)", message, check.Remediation)
+		}
+		failed = append(failed, message)
+	}
+	return strings.Join(failed, "; ")
+}
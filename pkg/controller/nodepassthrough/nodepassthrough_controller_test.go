@@ -0,0 +1,81 @@
+package nodepassthrough
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/iommu"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func Test_NodePassthrough(t *testing.T) {
+	assert := require.New(t)
+	root := t.TempDir()
+	host := iommu.Host{SysPath: filepath.Join(root, "sys"), ProcPath: filepath.Join(root, "proc")}
+	for _, dir := range []string{
+		filepath.Join(host.SysPath, "kernel", "iommu_groups", "0"),
+		filepath.Join(host.SysPath, "module", "vfio_iommu_type1"),
+		host.ProcPath,
+	} {
+		assert.NoError(os.MkdirAll(dir, 0755))
+	}
+	assert.NoError(os.WriteFile(filepath.Join(host.ProcPath, "cmdline"), []byte("intel_iommu=on iommu=pt\n"), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(host.ProcPath, "interrupts"), []byte("  24:  0  IR-PCI-MSI  eth0\n"), 0644))
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			UID:  "node1-uid",
+		},
+	}
+	k8sclient := k8sfake.NewSimpleClientset(node)
+	client := fake.NewSimpleClientset()
+	h := &Handler{
+		nodeName: "node1",
+		host:     host,
+		nodes:    fakeclients.NodeClient(k8sclient.CoreV1().Nodes),
+		npClient: fakeclients.NodePassthroughsClient(client.DevicesV1beta1().NodePassthroughs),
+		npCache:  fakeclients.NodePassthroughsCache(client.DevicesV1beta1().NodePassthroughs),
+	}
+
+	// the node is left alone until it was checked
+	_, err := h.OnNodeChange(node.Name, node)
+	assert.NoError(err)
+
+	// vfio-pci is not loaded
+	assert.NoError(h.checkReadiness())
+	np, err := client.DevicesV1beta1().NodePassthroughs().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(err)
+	assert.False(np.Status.Ready)
+	assert.Equal(1, np.Status.IOMMUGroups)
+	assert.False(np.Status.LastCheckTime.IsZero())
+	assert.Equal([]metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node1", UID: "node1-uid"}}, np.OwnerReferences)
+
+	nodeObj, err := h.OnNodeChange(node.Name, node)
+	assert.NoError(err)
+	assert.Equal("false", nodeObj.Labels[v1beta1.PassthroughReadyLabel])
+	assert.Len(nodeObj.Status.Conditions, 1)
+	condition := nodeObj.Status.Conditions[0]
+	assert.Equal(corev1.NodeConditionType(v1beta1.NodePassthroughReady), condition.Type)
+	assert.Equal(corev1.ConditionFalse, condition.Status)
+	assert.Contains(condition.Message, "VFIOModules: vfio-pci not loaded")
+
+	// loading vfio-pci makes the node ready
+	assert.NoError(os.MkdirAll(filepath.Join(host.SysPath, "bus", "pci", "drivers", "vfio-pci"), 0755))
+	assert.NoError(h.checkReadiness())
+	nodeObj, err = h.OnNodeChange(nodeObj.Name, nodeObj)
+	assert.NoError(err)
+	assert.Equal("true", nodeObj.Labels[v1beta1.PassthroughReadyLabel])
+	assert.Len(nodeObj.Status.Conditions, 1)
+	assert.Equal(corev1.ConditionTrue, nodeObj.Status.Conditions[0].Status)
+	assert.Equal(reasonReady, nodeObj.Status.Conditions[0].Reason)
+}
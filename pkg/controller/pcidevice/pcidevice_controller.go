@@ -9,9 +9,11 @@ import (
 	ctlnetwork "github.com/harvester/harvester-network-controller/pkg/generated/controllers/network.harvesterhci.io"
 	ctlnetworkv1beta1 "github.com/harvester/harvester-network-controller/pkg/generated/controllers/network.harvesterhci.io/v1beta1"
 	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
 	ctlcore "github.com/rancher/wrangler/pkg/generated/controllers/core"
 	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -20,6 +22,7 @@ import (
 	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
 	"github.com/harvester/pcidevices/pkg/iommu"
 	"github.com/harvester/pcidevices/pkg/util/nichelper"
+	"github.com/harvester/pcidevices/pkg/util/pcitopology"
 )
 
 const (
@@ -29,6 +32,8 @@ const (
 
 type Handler struct {
 	client          ctl.PCIDeviceClient
+	topologyClient  ctl.PCITopologyClient
+	topology        *pcitopology.Reader
 	pci             *ghw.PCIInfo
 	nodeCache       ctlcorev1.NodeCache
 	vlanConfigCache ctlnetworkv1beta1.VlanConfigCache
@@ -38,12 +43,15 @@ type Handler struct {
 func Register(
 	ctx context.Context,
 	pd ctl.PCIDeviceClient,
+	topology ctl.PCITopologyClient,
 	coreFactory *ctlcore.Factory,
 	networkFactory *ctlnetwork.Factory) error {
 	logrus.Info("Registering PCI Devices controller")
 
 	handler := &Handler{
 		client:          pd,
+		topologyClient:  topology,
+		topology:        pcitopology.New(),
 		nodeCache:       coreFactory.Core().V1().Node().Cache(),
 		vlanConfigCache: networkFactory.Network().V1beta1().VlanConfig().Cache(),
 	}
@@ -78,47 +86,66 @@ func Register(
 			logrus.Errorf("PCI device reconciliation error: %v", err)
 			return err
 		}
+		if err := handler.reconcileTopology(nodename); err != nil {
+			logrus.Errorf("PCI topology reconciliation error: %v", err)
+		}
 	}
 	return nil
 }
 
 func (h *Handler) reconcilePCIDevices(nodename string) error {
-	// Build up the IOMMU group map
-	iommuGroupPaths, err := iommu.GroupPaths()
+	// Build up the IOMMU group map, devices which cannot be read are reported without their IOMMU group,
+	// and the NodePassthrough of the node reports why the node is not ready for passthrough
+	iommuGroupMap, err := iommu.Groups()
 	if err != nil {
-		return err
+		logrus.Warnf("error reading iommu groups: %v", err)
 	}
-	iommuGroupMap := iommu.GroupMapForPCIDevices(iommuGroupPaths)
 
 	commonLabels := map[string]string{"nodename": nodename} // label
 	var setOfRealPCIAddrs map[string]bool = make(map[string]bool)
 	for _, dev := range h.pci.Devices {
 		if !containsString(h.skipAddresses, dev.Address) {
 			setOfRealPCIAddrs[dev.Address] = true
-			name := v1beta1.PCIDeviceNameForHostname(dev, nodename)
-			// Check if device is stored
-			devCR, err := h.client.Get(name, metav1.GetOptions{})
+			devCR, err := h.getPCIDevice(dev, nodename)
 
 			if err != nil {
 				if apierrors.IsNotFound(err) {
+					name := v1beta1.PCIDeviceNameForHostname(dev, nodename)
 					logrus.Infof("[PCIDeviceController] Device %s does not exist", name)
 
 					// Create the PCIDevice CR if it doesn't exist
 					var pdToCreate v1beta1.PCIDevice = v1beta1.NewPCIDeviceForHostname(dev, nodename)
 					logrus.Infof("Creating PCI Device: %s\n", err)
-					pdToCreate.Labels = commonLabels
+					for k, v := range commonLabels {
+						pdToCreate.Labels[k] = v
+					}
 					devCR, err = h.client.Create(&pdToCreate)
 					if err != nil {
 						logrus.Errorf("[PCIDeviceController] Failed to create PCI Device: %v", err)
 						return err
 					}
 				} else {
-					logrus.Errorf("[PCIDeviceController] error fetching device %s: %v", name, err)
+					logrus.Errorf("[PCIDeviceController] error fetching device %s: %v", dev.Address, err)
 					return err
 				}
 
 			}
 
+			// PCIDevices created before the domain label was added are labelled
+			domain := v1beta1.PCIDomain(dev.Address)
+			if devCR.Labels[v1beta1.PCIDomainLabel] != domain {
+				devCopy := devCR.DeepCopy()
+				if devCopy.Labels == nil {
+					devCopy.Labels = make(map[string]string)
+				}
+				devCopy.Labels[v1beta1.PCIDomainLabel] = domain
+				devCR, err = h.client.Update(devCopy)
+				if err != nil {
+					logrus.Errorf("[PCIDeviceController] Failed to label PCI Device %s: %v", devCopy.Name, err)
+					return err
+				}
+			}
+
 			devCopy := devCR.DeepCopy()
 
 			// during reboot if the device driver has changed back from vfio, then update the CRD
@@ -165,6 +192,97 @@ func (h *Handler) reconcilePCIDevices(nodename string) error {
 	return nil
 }
 
+// reconcileTopology records the PCIe tree and the IOMMU groups of the node in the PCITopology named after
+// the node, along with the PCIDevices of the devices in the tree
+func (h *Handler) reconcileTopology(nodename string) error {
+	devices, iommuGroups, err := h.topology.Read()
+	if err != nil {
+		return fmt.Errorf("error reading pci topology: %v", err)
+	}
+
+	descriptions := make(map[string]string)
+	for _, dev := range h.pci.Devices {
+		descriptions[dev.Address] = v1beta1.PCIDeviceDescription(dev)
+	}
+	selector := labels.SelectorFromValidatedSet(map[string]string{"nodename": nodename})
+	pdList, err := h.client.List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return fmt.Errorf("error listing devices for node %s: %v", nodename, err)
+	}
+	pciDevices := make(map[string]string)
+	for _, pd := range pdList.Items {
+		pciDevices[pd.Status.Address] = pd.Name
+	}
+	for i := range devices {
+		devices[i].Description = descriptions[devices[i].Address]
+		devices[i].PCIDevice = pciDevices[devices[i].Address]
+	}
+	status := v1beta1.PCITopologyStatus{
+		Devices:     devices,
+		IOMMUGroups: iommuGroups,
+		Tree:        pcitopology.Tree(devices),
+	}
+
+	topology, err := h.topologyClient.Get(nodename, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		topology, err = h.createPCITopology(nodename)
+	}
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(topology.Status, status) {
+		return nil
+	}
+	topologyCopy := topology.DeepCopy()
+	topologyCopy.Status = status
+	if _, err := h.topologyClient.UpdateStatus(topologyCopy); err != nil {
+		return fmt.Errorf("error updating status of pcitopology %s: %v", nodename, err)
+	}
+	return nil
+}
+
+// createPCITopology creates the PCITopology of the node, which is owned by the node so it is removed
+// along with the node
+func (h *Handler) createPCITopology(nodename string) (*v1beta1.PCITopology, error) {
+	node, err := h.nodeCache.Get(nodename)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node %s: %v", nodename, err)
+	}
+	topology, err := h.topologyClient.Create(&v1beta1.PCITopology{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: nodename,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Node",
+					Name:       node.Name,
+					UID:        node.UID,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating pcitopology %s: %v", nodename, err)
+	}
+	return topology, nil
+}
+
+// getPCIDevice gets the PCIDevice of dev. Devices in other PCI domains than 0000 may still be named after
+// their address without the domain separated, these PCIDevices are kept so claims on them stay valid
+func (h *Handler) getPCIDevice(dev *pci.Device, nodename string) (*v1beta1.PCIDevice, error) {
+	name := v1beta1.PCIDeviceNameForHostname(dev, nodename)
+	devCR, err := h.client.Get(name, metav1.GetOptions{})
+	legacyName := v1beta1.LegacyPCIDeviceName(nodename, dev.Address)
+	if !apierrors.IsNotFound(err) || legacyName == name {
+		return devCR, err
+	}
+	legacyCR, legacyErr := h.client.Get(legacyName, metav1.GetOptions{})
+	if legacyErr == nil && legacyCR.Status.Address == dev.Address {
+		return legacyCR, nil
+	}
+	return devCR, err
+}
+
 func containsString(elements []string, element string) bool {
 	for _, v := range elements {
 		if v == element {
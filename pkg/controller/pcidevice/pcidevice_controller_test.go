@@ -2,15 +2,23 @@ package pcidevice
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/pcidb"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
 	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
 	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+	"github.com/harvester/pcidevices/pkg/util/pcitopology"
 )
 
 const (
@@ -53,3 +61,117 @@ func Test_identifyPCIBridgeAddresses(t *testing.T) {
 	devs := identifyPCIBridgeDevices(pci)
 	assert.Len(devs, 26, "expected to find 26 devices from the snapshot")
 }
+
+func newDevice(address string) *pci.Device {
+	return &pci.Device{
+		Address:  address,
+		Vendor:   &pcidb.Vendor{ID: "8086", Name: "Intel Corporation"},
+		Product:  &pcidb.Product{ID: "1521", Name: "I350 Gigabit Network Connection"},
+		Class:    &pcidb.Class{ID: "02", Name: "Network controller"},
+		Subclass: &pcidb.Subclass{ID: "00", Name: "Ethernet controller"},
+		Driver:   "igb",
+	}
+}
+
+func Test_reconcilePCIDevicesInOtherDomains(t *testing.T) {
+	assert := require.New(t)
+	// a device in domain 0001 named before the domain was separated
+	legacy := v1beta1.NewPCIDeviceForHostname(newDevice("0001:04:00.0"), "TEST_NODE")
+	legacy.Name = "TEST_NODE-000104000"
+	legacy.Labels = map[string]string{"nodename": "TEST_NODE"}
+	client := fake.NewSimpleClientset(&legacy)
+
+	h := Handler{
+		client: fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		pci: &ghw.PCIInfo{
+			Devices: []*pci.Device{
+				newDevice("0000:04:00.0"),
+				newDevice("0001:04:00.0"),
+				newDevice("10000:e1:00.0"),
+			},
+		},
+	}
+	assert.NoError(h.reconcilePCIDevices("TEST_NODE"))
+
+	pds, err := client.DevicesV1beta1().PCIDevices().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(err)
+	domains := make(map[string]string)
+	for _, pd := range pds.Items {
+		domains[pd.Name] = pd.Labels[v1beta1.PCIDomainLabel]
+		assert.Equal("TEST_NODE", pd.Labels["nodename"])
+	}
+	assert.Equal(map[string]string{
+		"TEST_NODE-000004000":   "0000",
+		"TEST_NODE-000104000":   "0001",
+		"TEST_NODE-10000-e1000": "10000",
+	}, domains, "expected the legacy device to be kept, and new devices in other domains to be named with the domain separated")
+}
+
+// newSysfs creates a sysfs with a device below the root port 0000:00:01.0
+func newSysfs(t *testing.T, address string) string {
+	assert := require.New(t)
+	root := t.TempDir()
+	for _, dev := range []struct {
+		path  string
+		class string
+	}{
+		{path: "pci0000:00/0000:00:01.0", class: "0x060400"},
+		{path: "pci0000:00/0000:00:01.0/" + address, class: "0x020000"},
+	} {
+		devicePath := filepath.Join(root, "devices", dev.path)
+		assert.NoError(os.MkdirAll(devicePath, 0755))
+		assert.NoError(os.WriteFile(filepath.Join(devicePath, "vendor"), []byte("0x8086\n"), 0644))
+		assert.NoError(os.WriteFile(filepath.Join(devicePath, "device"), []byte("0x1521\n"), 0644))
+		assert.NoError(os.WriteFile(filepath.Join(devicePath, "class"), []byte(dev.class+"\n"), 0644))
+		assert.NoError(os.WriteFile(filepath.Join(devicePath, "numa_node"), []byte("1\n"), 0644))
+		assert.NoError(os.MkdirAll(filepath.Join(root, "bus", "pci", "devices"), 0755))
+		assert.NoError(os.Symlink(filepath.Join("..", "..", "..", "devices", dev.path), filepath.Join(root, "bus", "pci", "devices", filepath.Base(dev.path))))
+	}
+	return root
+}
+
+func Test_reconcileTopology(t *testing.T) {
+	assert := require.New(t)
+	pd := v1beta1.NewPCIDeviceForHostname(newDevice("0000:04:00.0"), "TEST_NODE")
+	pd.Labels = map[string]string{"nodename": "TEST_NODE"}
+	client := fake.NewSimpleClientset(&pd)
+	k8sclient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "TEST_NODE", UID: "node-uid"},
+	})
+
+	h := Handler{
+		client:         fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		topologyClient: fakeclients.PCITopologiesClient(client.DevicesV1beta1().PCITopologies),
+		topology:       &pcitopology.Reader{SysfsRoot: newSysfs(t, "0000:04:00.0")},
+		nodeCache:      fakeclients.NodeCache(k8sclient.CoreV1().Nodes),
+		pci: &ghw.PCIInfo{
+			Devices: []*pci.Device{
+				newDevice("0000:04:00.0"),
+			},
+		},
+	}
+	assert.NoError(h.reconcileTopology("TEST_NODE"))
+
+	topology, err := client.DevicesV1beta1().PCITopologies().Get(context.TODO(), "TEST_NODE", metav1.GetOptions{})
+	assert.NoError(err, "expected the pcitopology to be named after the node")
+	assert.Len(topology.OwnerReferences, 1)
+	assert.Equal("node-uid", string(topology.OwnerReferences[0].UID), "expected the pcitopology to be owned by the node")
+	assert.Len(topology.Status.Devices, 2)
+	endpoint := topology.Status.Devices[1]
+	assert.Equal("0000:00:01.0", endpoint.Parent)
+	assert.Equal(1, endpoint.NUMANode)
+	assert.Equal("Ethernet controller: Intel Corporation I350 Gigabit Network Connection", endpoint.Description)
+	assert.Equal(pd.Name, endpoint.PCIDevice)
+	assert.Empty(topology.Status.Devices[0].PCIDevice, "expected the root port to have no pcidevice")
+	assert.Equal(`pci0000:00
+\-0000:00:01.0 PCIBridge [8086:1521] (numa node 1)
+  \-0000:04:00.0 PCIDevice [8086:1521] Ethernet controller: Intel Corporation I350 Gigabit Network Connection (numa node 1, pcidevice TEST_NODE-000004000)
+`, topology.Status.Tree)
+
+	// the topology is only updated if it changed
+	client.ClearActions()
+	assert.NoError(h.reconcileTopology("TEST_NODE"))
+	for _, action := range client.Actions() {
+		assert.NotEqual("update", action.GetVerb(), "expected an unchanged topology not to be updated")
+	}
+}
@@ -0,0 +1,142 @@
+package pcideviceclaim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+const (
+	defaultOperationHistoryLimit = 20
+	defaultOperationRetention    = 30 * 24 * time.Hour
+	operationPruneInterval       = time.Hour
+)
+
+// auditor records the operations performed on the devices of the node as PCIDeviceOperations, and
+// prunes records beyond the history limit of each device or older than the retention. A nil auditor
+// records nothing
+type auditor struct {
+	client       v1beta1gen.PCIDeviceOperationClient
+	nodeName     string
+	historyLimit int
+	retention    time.Duration
+	now          func() time.Time
+}
+
+func newAuditor(client v1beta1gen.PCIDeviceOperationClient, nodeName string, historyLimit int, retention time.Duration) *auditor {
+	if historyLimit <= 0 {
+		historyLimit = defaultOperationHistoryLimit
+	}
+	if retention <= 0 {
+		retention = defaultOperationRetention
+	}
+	return &auditor{
+		client:       client,
+		nodeName:     nodeName,
+		historyLimit: historyLimit,
+		retention:    retention,
+		now:          time.Now,
+	}
+}
+
+// begin starts an operation on pd. pdc is the claim the operation is performed for, if any
+func (a *auditor) begin(opType v1beta1.OperationType, pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) *v1beta1.PCIDeviceOperationSpec {
+	op := &v1beta1.PCIDeviceOperationSpec{
+		Type:       opType,
+		DeviceName: pd.Name,
+		Address:    pd.Status.Address,
+		NodeName:   pd.Status.NodeName,
+	}
+	if a != nil {
+		op.StartTime = metav1.NewTime(a.now())
+	}
+	if pdc != nil {
+		op.ClaimName = pdc.Name
+		op.UserName = pdc.Spec.UserName
+		if requestedBy := pdc.Annotations[v1beta1.RequestedByAnnotation]; requestedBy != "" {
+			op.UserName = requestedBy
+		}
+	}
+	return op
+}
+
+// record completes op with the result of the operation, and records it. Failing to record an operation
+// does not fail the operation, so errors are only logged
+func (a *auditor) record(op *v1beta1.PCIDeviceOperationSpec, opErr error) {
+	if a == nil {
+		return
+	}
+	op.CompletionTime = metav1.NewTime(a.now())
+	op.Result = v1beta1.OperationSucceeded
+	if opErr != nil {
+		op.Result = v1beta1.OperationFailed
+		op.Message = opErr.Error()
+	}
+
+	record := &v1beta1.PCIDeviceOperation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s-%s-%s", op.DeviceName, strings.ToLower(string(op.Type)), utilrand.String(5)),
+			Labels: map[string]string{
+				"nodename":                   op.NodeName,
+				v1beta1.OperationDeviceLabel: op.DeviceName,
+			},
+		},
+		Spec: *op,
+	}
+	if _, err := a.client.Create(record); err != nil {
+		logrus.Errorf("error recording %s operation on pcidevice %s: %v", op.Type, op.DeviceName, err)
+		return
+	}
+
+	if err := a.prune(labels.Set{v1beta1.OperationDeviceLabel: op.DeviceName}); err != nil {
+		logrus.Errorf("error pruning operations of pcidevice %s: %v", op.DeviceName, err)
+	}
+}
+
+// pruneNode removes the expired records of all devices of the node, as records are otherwise only
+// pruned when a device is operated on
+func (a *auditor) pruneNode() {
+	if a == nil {
+		return
+	}
+	if err := a.prune(labels.Set{"nodename": a.nodeName}); err != nil {
+		logrus.Errorf("error pruning pcideviceoperations of node %s: %v", a.nodeName, err)
+	}
+}
+
+// prune removes the records matching set which are older than the retention, or beyond the history
+// limit of their device
+func (a *auditor) prune(set labels.Set) error {
+	list, err := a.client.List(metav1.ListOptions{LabelSelector: labels.SelectorFromSet(set).String()})
+	if err != nil {
+		return fmt.Errorf("error listing pcideviceoperations: %v", err)
+	}
+
+	ops := list.Items
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[j].Spec.CompletionTime.Before(&ops[i].Spec.CompletionTime)
+	})
+	cutoff := a.now().Add(-a.retention)
+	kept := make(map[string]int)
+	for _, op := range ops {
+		device := op.Spec.DeviceName
+		if kept[device] < a.historyLimit && op.Spec.CompletionTime.Time.After(cutoff) {
+			kept[device]++
+			continue
+		}
+		if err := a.client.Delete(op.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting pcideviceoperation %s: %v", op.Name, err)
+		}
+	}
+	return nil
+}
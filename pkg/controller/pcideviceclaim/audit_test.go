@@ -0,0 +1,109 @@
+package pcideviceclaim
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func Test_OperationsAreRecorded(t *testing.T) {
+	assert := require.New(t)
+	client := fake.NewSimpleClientset()
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	pdc.Annotations = map[string]string{v1beta1.RequestedByAnnotation: "alice"}
+	_, err := client.DevicesV1beta1().PCIDevices().Create(context.TODO(), pd, metav1.CreateOptions{})
+	assert.NoError(err)
+	sysfs := newFakeSysfs()
+	sysfs.addDevice(pd.Status.Address, pd.Status.IOMMUGroup, pd.Status.KernelDriverInUse)
+	h := &Handler{
+		pdClient: fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		sysfs:    sysfs,
+		resetter: &fakeResetter{method: "flr"},
+		audit:    newAuditor(fakeclients.PCIDeviceOperationsClient(client.DevicesV1beta1().PCIDeviceOperations), "node1", 0, 0),
+	}
+
+	assert.NoError(h.attemptToEnablePassthrough(pd, pdc))
+	pd.Status.KernelDriverInUse = vfioPCIDriver
+	assert.NoError(h.disablePassthrough(pd, pdc))
+
+	list, err := client.DevicesV1beta1().PCIDeviceOperations().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(err)
+	ops := make(map[v1beta1.OperationType]v1beta1.PCIDeviceOperationSpec)
+	for _, op := range list.Items {
+		assert.Equal(pd.Name, op.Labels[v1beta1.OperationDeviceLabel])
+		assert.Equal(pdc.Name, op.Spec.ClaimName)
+		assert.Equal("alice", op.Spec.UserName, "expected user recorded at admission")
+		assert.Equal(v1beta1.OperationSucceeded, op.Spec.Result)
+		assert.False(op.Spec.CompletionTime.IsZero())
+		ops[op.Spec.Type] = op.Spec
+	}
+	assert.Len(ops, 4, "expected bind, reset, unbind and restore driver operations")
+	assert.Equal("ixgbevf", ops[v1beta1.OperationBind].PreviousDriver)
+	assert.Equal(vfioPCIDriver, ops[v1beta1.OperationBind].NewDriver)
+	assert.Equal("flr", ops[v1beta1.OperationReset].ResetMethod)
+	assert.Equal(vfioPCIDriver, ops[v1beta1.OperationUnbind].PreviousDriver)
+	assert.Equal("ixgbevf", ops[v1beta1.OperationRestoreDriver].NewDriver)
+}
+
+func Test_FailedOperationIsRecorded(t *testing.T) {
+	assert := require.New(t)
+	client := fake.NewSimpleClientset()
+	a := newAuditor(fakeclients.PCIDeviceOperationsClient(client.DevicesV1beta1().PCIDeviceOperations), "node1", 0, 0)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+
+	a.record(a.begin(v1beta1.OperationReset, pd, pdc), fmt.Errorf("device busy"))
+	list, err := client.DevicesV1beta1().PCIDeviceOperations().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(err)
+	assert.Len(list.Items, 1)
+	assert.Equal(v1beta1.OperationFailed, list.Items[0].Spec.Result)
+	assert.Equal("device busy", list.Items[0].Spec.Message)
+	assert.Equal("admin", list.Items[0].Spec.UserName, "expected claim user without admission record")
+}
+
+func Test_OperationsArePruned(t *testing.T) {
+	assert := require.New(t)
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	a := newAuditor(fakeclients.PCIDeviceOperationsClient(client.DevicesV1beta1().PCIDeviceOperations), "node1", 3, 24*time.Hour)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	other, _ := newDeviceAndClaim("node1", 1, "90")
+
+	// records are created an hour apart, the oldest being beyond the retention
+	for i := 30; i >= 0; i -= 10 {
+		a.now = func() time.Time { return now.Add(-time.Duration(i) * time.Hour) }
+		a.record(a.begin(v1beta1.OperationReset, pd, pdc), nil)
+	}
+	a.now = func() time.Time { return now.Add(-30 * time.Hour) }
+	a.record(a.begin(v1beta1.OperationReset, other, nil), nil)
+
+	a.now = time.Now
+	for i := 0; i < 3; i++ {
+		a.record(a.begin(v1beta1.OperationBind, pd, pdc), nil)
+	}
+
+	list, err := client.DevicesV1beta1().PCIDeviceOperations().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{v1beta1.OperationDeviceLabel: pd.Name}).String(),
+	})
+	assert.NoError(err)
+	var types []string
+	for _, op := range list.Items {
+		types = append(types, string(op.Spec.Type))
+	}
+	sort.Strings(types)
+	assert.Equal([]string{"Bind", "Bind", "Bind"}, types, "expected only the newest records within the history limit")
+
+	a.pruneNode()
+	list, err = client.DevicesV1beta1().PCIDeviceOperations().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(err)
+	assert.Len(list.Items, 3, "expected expired records of other devices to be pruned")
+}
@@ -0,0 +1,174 @@
+package pcideviceclaim
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugins"
+)
+
+const sysfsPCIDevicesPath = "/sys/bus/pci/devices"
+
+// rebuildDevicePlugins creates the device plugins of the node from the devices bound to vfio-pci on the
+// host which are claimed by PCIDeviceClaims of the node, and starts the plugins with healthy devices. It
+// runs before claims are reconciled, so a restart of the controller registers each plugin with all its
+// devices, instead of adding devices one claim at a time
+func (h *Handler) rebuildDevicePlugins() error {
+	pds, err := h.pdClient.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("nodename=%s", h.nodeName)})
+	if err != nil {
+		return fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	supported := make(map[string]string)
+	devices := make(map[string]*v1beta1.PCIDevice)
+	for i := range pds.Items {
+		pd := &pds.Items[i]
+		if pd.Status.ResourceName == "" {
+			continue
+		}
+		supported[strings.ToLower(fmt.Sprintf("%s:%s", pd.Status.VendorId, pd.Status.DeviceId))] = pd.Status.ResourceName
+		devices[pd.Status.Address] = pd
+	}
+
+	pdcs, err := h.pdcClient.List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+	claims := make(map[string]*v1beta1.PCIDeviceClaim)
+	for i := range pdcs.Items {
+		pdc := &pdcs.Items[i]
+		if pdc.Spec.NodeName == h.nodeName {
+			claims[pdc.Spec.Address] = pdc
+		}
+	}
+
+	hostDevices, err := deviceplugins.DiscoverPermittedHostPCIDevices(h.pciDevicesPath, supported)
+	if err != nil {
+		return err
+	}
+
+	h.pluginsLock.Lock()
+	defer h.pluginsLock.Unlock()
+	for resourceName, hostDevs := range hostDevices {
+		var claimed []*deviceplugins.PCIDevice
+		for _, dev := range hostDevs {
+			if claims[dev.GetAddress()] != nil {
+				claimed = append(claimed, dev)
+			}
+		}
+		if len(claimed) == 0 {
+			continue
+		}
+
+		dp := deviceplugins.NewPCIDevicePlugin(claimed, resourceName)
+		h.configureDevicePlugin(dp)
+		for _, dev := range claimed {
+			if deviceAllocatable(devices[dev.GetAddress()], claims[dev.GetAddress()]) {
+				dp.MarkPCIDeviceAsHealthy(resourceName, dev.GetAddress())
+			}
+		}
+		h.devicePlugins[resourceName] = dp
+		logrus.Infof("Rebuilt DevicePlugin %s with %d devices, %d healthy", resourceName, len(claimed), dp.GetCount())
+		if dp.GetCount() > 0 {
+			if err := h.startDevicePlugin(dp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deviceAllocatable checks if a device bound to vfio-pci may be allocated to new VMs
+func deviceAllocatable(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) bool {
+	if pd == nil || pdc == nil || pdc.DeletionTimestamp != nil || !pdc.Status.PassthroughEnabled {
+		return false
+	}
+	return !resetFailed(pd)
+}
+
+// resetFailed checks if the device failed to reset when it was last released. Such devices must not be
+// handed to a VM. Devices failing the health checks of the device plugin are reported as unhealthy by
+// the plugin itself, and are healthy again once they pass
+func resetFailed(pd *v1beta1.PCIDevice) bool {
+	return v1beta1.PCIDeviceHealthy.IsFalse(pd) && v1beta1.PCIDeviceHealthy.GetReason(pd) == v1beta1.ResetFailedReason
+}
+
+// configureDevicePlugin enables the optional features of a device plugin before it is started
+func (h *Handler) configureDevicePlugin(dp *deviceplugins.PCIDevicePlugin) {
+	if h.resetOnPreStart {
+		dp.EnableResetOnPreStart(h.resetter)
+	}
+	if h.healthChecker != nil {
+		dp.EnableHealthChecks(h.healthChecker, h.updateDeviceHealth)
+	}
+	if h.cdiSpecDir != "" {
+		dp.EnableCDI(h.cdiSpecDir)
+	}
+}
+
+// updateDeviceHealth mirrors the result of the health checks of the device plugin in the Healthy condition
+// of the device. A failed reset is kept, as it is cleared by the next successful reset
+func (h *Handler) updateDeviceHealth(address string, healthErr error) {
+	pds, err := h.pdClient.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("nodename=%s", h.nodeName)})
+	if err != nil {
+		logrus.Errorf("error listing pcidevices to update health of %s: %v", address, err)
+		return
+	}
+	for _, pd := range pds.Items {
+		if pd.Status.Address != address || resetFailed(&pd) {
+			continue
+		}
+		pdCopy := pd.DeepCopy()
+		if healthErr != nil {
+			v1beta1.PCIDeviceHealthy.SetError(pdCopy, v1beta1.HealthCheckFailedReason, healthErr)
+		} else if v1beta1.PCIDeviceHealthy.GetReason(pdCopy) == v1beta1.HealthCheckFailedReason {
+			v1beta1.PCIDeviceHealthy.SetError(pdCopy, "", nil)
+		}
+		if reflect.DeepEqual(pd.Status, pdCopy.Status) {
+			return
+		}
+		if _, err := h.pdClient.UpdateStatus(pdCopy); err != nil {
+			logrus.Errorf("error updating health of pcidevice %s: %v", pd.Name, err)
+		}
+		return
+	}
+}
+
+// OnNodeChangePublishPlugins records the state of the device plugins of this node in the
+// device-plugins annotation of the node, and removes the annotation once no plugin runs
+func (h *Handler) OnNodeChangePublishPlugins(_ string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil || node.DeletionTimestamp != nil || node.Name != h.nodeName {
+		return node, nil
+	}
+
+	var value string
+	if states := h.plugins.States(); len(states) > 0 {
+		encoded, err := json.Marshal(states)
+		if err != nil {
+			return node, fmt.Errorf("error encoding device plugin states: %v", err)
+		}
+		value = string(encoded)
+	}
+
+	current, ok := node.Annotations[v1beta1.DevicePluginsAnnotation]
+	if current == value && (ok || value == "") {
+		return node, nil
+	}
+
+	nodeCopy := node.DeepCopy()
+	if value == "" {
+		delete(nodeCopy.Annotations, v1beta1.DevicePluginsAnnotation)
+	} else {
+		if nodeCopy.Annotations == nil {
+			nodeCopy.Annotations = make(map[string]string)
+		}
+		nodeCopy.Annotations[v1beta1.DevicePluginsAnnotation] = value
+	}
+	return h.nodes.Update(nodeCopy)
+}
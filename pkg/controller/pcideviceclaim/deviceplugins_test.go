@@ -0,0 +1,141 @@
+package pcideviceclaim
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugins"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// addHostDevice creates a device in a fake /sys/bus/pci/devices
+func addHostDevice(t *testing.T, devicesPath, address, pciID, driver, iommuGroup string) {
+	dir := filepath.Join(devicesPath, address)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "uevent"), []byte(fmt.Sprintf("DRIVER=%s\nPCI_ID=%s\n", driver, pciID)), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "numa_node"), []byte("0\n"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join("../../../bus/pci/drivers", driver), filepath.Join(dir, "driver")))
+	require.NoError(t, os.Symlink(filepath.Join("../../../kernel/iommu_groups", iommuGroup), filepath.Join(dir, "iommu_group")))
+}
+
+func newHostDeviceAndClaim(address, vendorID, deviceID, resourceName string) (*v1beta1.PCIDevice, *v1beta1.PCIDeviceClaim) {
+	name := fmt.Sprintf("node1-%s", address)
+	pd := &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"nodename": "node1"},
+		},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:           address,
+			VendorId:          vendorID,
+			DeviceId:          deviceID,
+			NodeName:          "node1",
+			ResourceName:      resourceName,
+			KernelDriverInUse: vfioPCIDriver,
+		},
+	}
+	pdc := &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address:  address,
+			NodeName: "node1",
+		},
+		Status: v1beta1.PCIDeviceClaimStatus{
+			PassthroughEnabled: true,
+		},
+	}
+	return pd, pdc
+}
+
+func Test_RebuildDevicePlugins(t *testing.T) {
+	assert := require.New(t)
+	devicesPath := t.TempDir()
+	const gpus, nics = "nvidia.com/GA102GL_A10", "intel.com/82599_VF"
+
+	enabled, enabledClaim := newHostDeviceAndClaim("0000:04:00.0", "10DE", "2236", gpus)
+	addHostDevice(t, devicesPath, "0000:04:00.0", "10DE:2236", vfioPCIDriver, "40")
+	deleting, deletingClaim := newHostDeviceAndClaim("0000:05:00.0", "10DE", "2236", gpus)
+	deletingClaim.DeletionTimestamp = &metav1.Time{}
+	deletingClaim.Finalizers = []string{"wrangler.cattle.io/PCIDeviceClaimOnRemove"}
+	addHostDevice(t, devicesPath, "0000:05:00.0", "10DE:2236", vfioPCIDriver, "41")
+	unclaimed, _ := newHostDeviceAndClaim("0000:06:00.0", "10DE", "2236", gpus)
+	addHostDevice(t, devicesPath, "0000:06:00.0", "10DE:2236", vfioPCIDriver, "42")
+	unbound, unboundClaim := newHostDeviceAndClaim("0000:07:00.0", "10DE", "2236", gpus)
+	unbound.Status.KernelDriverInUse = "nvidia"
+	addHostDevice(t, devicesPath, "0000:07:00.0", "10DE:2236", "nvidia", "43")
+	failedReset, failedResetClaim := newHostDeviceAndClaim("0000:08:00.0", "10DE", "2236", gpus)
+	v1beta1.PCIDeviceHealthy.SetError(failedReset, v1beta1.ResetFailedReason, fmt.Errorf("reset failed"))
+	addHostDevice(t, devicesPath, "0000:08:00.0", "10DE:2236", vfioPCIDriver, "44")
+	pending, pendingClaim := newHostDeviceAndClaim("0000:09:10.0", "8086", "10ED", nics)
+	pendingClaim.Status.PassthroughEnabled = false
+	addHostDevice(t, devicesPath, "0000:09:10.0", "8086:10ED", vfioPCIDriver, "45")
+
+	client := fake.NewSimpleClientset(enabled, enabledClaim, deleting, deletingClaim, unclaimed, unbound, unboundClaim,
+		failedReset, failedResetClaim, pending, pendingClaim)
+	h := &Handler{
+		nodeName:       "node1",
+		pdClient:       fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		pdcClient:      newFakeClaimController(client),
+		devicePlugins:  make(map[string]*deviceplugins.PCIDevicePlugin),
+		plugins:        deviceplugins.NewManager(deviceplugins.ManagerOptions{}),
+		pciDevicesPath: devicesPath,
+	}
+	assert.NoError(h.rebuildDevicePlugins())
+	defer h.plugins.Stop(gpus)
+
+	assert.Len(h.devicePlugins, 2, "expected a device plugin for each resource name with claimed devices")
+	dp := h.devicePlugins[gpus]
+	assert.NotNil(dp)
+	var addresses []string
+	for _, dev := range dp.GetPCIDevices() {
+		addresses = append(addresses, dev.GetAddress())
+	}
+	assert.ElementsMatch([]string{"0000:04:00.0", "0000:05:00.0", "0000:08:00.0"}, addresses,
+		"expected only claimed devices bound to vfio-pci in the device plugin")
+	assert.Equal(1, dp.GetCount(), "expected only the device of the enabled claim to be healthy")
+	assert.True(dp.Started(), "expected device plugin with healthy devices to be started")
+
+	dp = h.devicePlugins[nics]
+	assert.NotNil(dp)
+	assert.Len(dp.GetPCIDevices(), 1)
+	assert.Equal(0, dp.GetCount())
+	assert.False(dp.Started(), "expected device plugin without healthy devices to not be started")
+}
+
+func Test_UpdateDeviceHealth(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newHostDeviceAndClaim("0000:04:00.0", "10DE", "2236", "nvidia.com/GA102GL_A10")
+	resetFailedPD, _ := newHostDeviceAndClaim("0000:05:00.0", "10DE", "2236", "nvidia.com/GA102GL_A10")
+	v1beta1.PCIDeviceHealthy.SetError(resetFailedPD, v1beta1.ResetFailedReason, fmt.Errorf("reset failed"))
+	client := fake.NewSimpleClientset(pd, resetFailedPD)
+	h := &Handler{
+		nodeName: "node1",
+		pdClient: fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+	}
+
+	h.updateDeviceHealth(pd.Status.Address, fmt.Errorf("pcie link is down"))
+	h.updateDeviceHealth(resetFailedPD.Status.Address, fmt.Errorf("pcie link is down"))
+	pdObj, err := client.DevicesV1beta1().PCIDevices().Get(context.TODO(), pd.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.True(v1beta1.PCIDeviceHealthy.IsFalse(pdObj))
+	assert.Equal(v1beta1.HealthCheckFailedReason, v1beta1.PCIDeviceHealthy.GetReason(pdObj))
+	assert.Equal("pcie link is down", v1beta1.PCIDeviceHealthy.GetMessage(pdObj))
+	pdObj, err = client.DevicesV1beta1().PCIDevices().Get(context.TODO(), resetFailedPD.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal(v1beta1.ResetFailedReason, v1beta1.PCIDeviceHealthy.GetReason(pdObj), "expected failed reset to be kept")
+
+	h.updateDeviceHealth(pd.Status.Address, nil)
+	pdObj, err = client.DevicesV1beta1().PCIDevices().Get(context.TODO(), pd.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.True(v1beta1.PCIDeviceHealthy.IsTrue(pdObj), "expected device which passed the health checks to be healthy")
+}
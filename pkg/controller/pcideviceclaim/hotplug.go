@@ -0,0 +1,371 @@
+package pcideviceclaim
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/relatedresource"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/vmusage"
+)
+
+// hotplugRetryPeriod is how often a hotplug waiting for its device, or for its device to be detached, is checked
+const hotplugRetryPeriod = 5 * time.Second
+
+// OnHotplugChange attaches the device of a PCIDeviceHotplug to its running VMI. The device is claimed for the
+// requester unless it is claimed already, and attached once it is bound to vfio-pci, advertised by its device
+// plugin and permitted in the KubeVirt CR. Only the node of the device handles the hotplug
+func (h *Handler) OnHotplugChange(_ string, hp *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	if hp == nil || hp.DeletionTimestamp != nil {
+		return hp, nil
+	}
+	pd, err := h.pdCache.Get(hp.Spec.DeviceName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the webhook refuses hotplugs of unknown devices, the device may have been removed since
+			return hp, nil
+		}
+		return hp, fmt.Errorf("error looking up pcidevice %s: %v", hp.Spec.DeviceName, err)
+	}
+	if pd.Status.NodeName != h.nodeName {
+		return hp, nil
+	}
+
+	hpCopy := hp.DeepCopy()
+	requeue, reconcileErr := h.reconcileHotplug(hpCopy, pd)
+	if !reflect.DeepEqual(hp.Status, hpCopy.Status) {
+		updated, err := h.hotplugs.UpdateStatus(hpCopy)
+		if err != nil {
+			return hp, fmt.Errorf("error updating status of pcidevicehotplug %s: %v", hp.Name, err)
+		}
+		hp = updated
+	}
+	if reconcileErr != nil {
+		return hp, reconcileErr
+	}
+	if requeue {
+		h.hotplugs.EnqueueAfter(hp.Name, hotplugRetryPeriod)
+	}
+	return hp, nil
+}
+
+// reconcileHotplug records the progress of the hotplug in its status, and returns whether the hotplug
+// waits for its device to become ready
+func (h *Handler) reconcileHotplug(hp *v1beta1.PCIDeviceHotplug, pd *v1beta1.PCIDevice) (bool, error) {
+	vmi, err := h.vmiCache.Get(hp.Spec.VMINamespace, hp.Spec.VMIName)
+	switch {
+	case apierrors.IsNotFound(err):
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugPending, v1beta1.PCIDeviceHotplugAttachedCondition, v1beta1.VMINotRunningReason,
+			fmt.Sprintf("vmi %s/%s not found", hp.Spec.VMINamespace, hp.Spec.VMIName))
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("error looking up vmi %s/%s: %v", hp.Spec.VMINamespace, hp.Spec.VMIName, err)
+	case vmi.Status.Phase != kubevirtv1.Running:
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugPending, v1beta1.PCIDeviceHotplugAttachedCondition, v1beta1.VMINotRunningReason,
+			fmt.Sprintf("vmi %s/%s is %s", vmi.Namespace, vmi.Name, vmi.Status.Phase))
+		return false, nil
+	case vmi.Status.NodeName != h.nodeName:
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugFailed, v1beta1.PCIDeviceHotplugAttachedCondition, v1beta1.VMINotRunningReason,
+			fmt.Sprintf("vmi %s/%s runs on node %s, the device is on node %s", vmi.Namespace, vmi.Name, vmi.Status.NodeName, h.nodeName))
+		return false, nil
+	}
+
+	attached := vmiHasHostDevice(vmi, pd.Name)
+	switch hp.Status.Phase {
+	case v1beta1.PCIDeviceHotplugAttaching, v1beta1.PCIDeviceHotplugAttached:
+		if attached {
+			hp.Status.Phase = v1beta1.PCIDeviceHotplugAttached
+			setHotplugCondition(hp, v1beta1.PCIDeviceHotplugAttachedCondition, true, "", "")
+			return false, nil
+		}
+		if hp.Status.Phase == v1beta1.PCIDeviceHotplugAttaching {
+			// the VMI is reconciled again once it reports the device
+			return false, nil
+		}
+		// the device was detached from the VMI by hand, and is attached again
+	default:
+		if attached {
+			setHotplugPhase(hp, v1beta1.PCIDeviceHotplugFailed, v1beta1.PCIDeviceHotplugAttachedCondition, "",
+				fmt.Sprintf("vmi %s/%s already has a host device %s", vmi.Namespace, vmi.Name, pd.Name))
+			return false, nil
+		}
+	}
+
+	pdc, message, err := h.hotplugClaim(hp, pd)
+	if err != nil {
+		return false, err
+	}
+	if pdc == nil {
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugPending, v1beta1.PCIDeviceHotplugDeviceReady, v1beta1.DeviceClaimedReason, message)
+		return true, nil
+	}
+	if ready, message := h.hotplugDeviceReady(pdc, pd); !ready {
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugPending, v1beta1.PCIDeviceHotplugDeviceReady, "", message)
+		return true, nil
+	}
+	setHotplugCondition(hp, v1beta1.PCIDeviceHotplugDeviceReady, true, "", "")
+
+	supported, err := h.hotplugger.Supported()
+	if err != nil {
+		return false, err
+	}
+	if !supported {
+		setHotplugPhase(hp, v1beta1.PCIDeviceHotplugFailed, v1beta1.PCIDeviceHotplugAttachedCondition, v1beta1.HotplugUnsupportedReason,
+			"kubevirt does not support host device hotplug")
+		return false, nil
+	}
+	hostDevice := kubevirtv1.HostDevice{
+		Name:       pd.Name,
+		DeviceName: pd.Status.ResourceName,
+	}
+	if err := h.hotplugger.Attach(vmi.Namespace, vmi.Name, hostDevice); err != nil {
+		setHotplugCondition(hp, v1beta1.PCIDeviceHotplugAttachedCondition, false, "", err.Error())
+		return false, err
+	}
+	logrus.Infof("attaching pcidevice %s to vmi %s/%s", pd.Name, vmi.Namespace, vmi.Name)
+	hp.Status.HostDeviceName = hostDevice.Name
+	setHotplugPhase(hp, v1beta1.PCIDeviceHotplugAttaching, v1beta1.PCIDeviceHotplugAttachedCondition, "",
+		fmt.Sprintf("waiting for vmi %s/%s to report the device", vmi.Namespace, vmi.Name))
+	return false, nil
+}
+
+// hotplugClaim returns the claim of the device of hp, which is created for the requester of hp if the device
+// is not claimed yet. No claim is returned if the device is claimed for another user, by another hotplug, or
+// is in use by another pod or VM, e.g. through a claim of the same user
+func (h *Handler) hotplugClaim(hp *v1beta1.PCIDeviceHotplug, pd *v1beta1.PCIDevice) (*v1beta1.PCIDeviceClaim, string, error) {
+	hotplugs, err := h.hotplugCache.List(labels.Everything())
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing pcidevicehotplugs: %v", err)
+	}
+	for _, other := range hotplugs {
+		if other.Name != hp.Name && other.Spec.DeviceName == pd.Name && other.Status.HostDeviceName != "" {
+			return nil, fmt.Sprintf("the device is attached to vmi %s/%s by pcidevicehotplug %s",
+				other.Spec.VMINamespace, other.Spec.VMIName, other.Name), nil
+		}
+	}
+	if message, err := h.hotplugDeviceInUse(hp, pd); message != "" || err != nil {
+		return nil, message, err
+	}
+
+	requestedBy := hp.Annotations[v1beta1.RequestedByAnnotation]
+	pdc, err := h.pdcClient.Cache().Get(pd.Name)
+	if apierrors.IsNotFound(err) {
+		pdc, err = h.pdcClient.Create(newHotplugClaim(pd, requestedBy))
+		if err != nil {
+			return nil, "", fmt.Errorf("error creating pcideviceclaim %s: %v", pd.Name, err)
+		}
+		hp.Status.ClaimName, hp.Status.ClaimCreated = pdc.Name, true
+		return pdc, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error looking up pcideviceclaim %s: %v", pd.Name, err)
+	}
+	if pdc.Spec.UserName != requestedBy {
+		return nil, fmt.Sprintf("the device is claimed for user %s", pdc.Spec.UserName), nil
+	}
+	hp.Status.ClaimName = pdc.Name
+	return pdc, "", nil
+}
+
+// hotplugDeviceInUse describes the pod or VMs other than the VMI of hp which use pd, if any
+func (h *Handler) hotplugDeviceInUse(hp *v1beta1.PCIDeviceHotplug, pd *v1beta1.PCIDevice) (string, error) {
+	if usedBy := pd.Status.UsedBy; usedBy != nil && usedBy.VirtualMachineInstance == "" {
+		return fmt.Sprintf("the device is in use by pod %s", usedBy.Pod), nil
+	}
+	vmsByDevice, err := vmusage.VMsUsingDevices(h.vmiCache, h.pdCache, pd)
+	if err != nil {
+		return "", err
+	}
+	self := fmt.Sprintf("%s/%s", hp.Spec.VMINamespace, hp.Spec.VMIName)
+	var vms []string
+	for _, vm := range vmsByDevice[pd.Name] {
+		if vm != self {
+			vms = append(vms, vm)
+		}
+	}
+	if len(vms) == 0 {
+		return "", nil
+	}
+	sort.Strings(vms)
+	return fmt.Sprintf("the device is in use by vmis %s", strings.Join(vms, ", ")), nil
+}
+
+// newHotplugClaim claims pd for user, the claim is named after the device and owned by it like the claims
+// created for VMs
+func newHotplugClaim(pd *v1beta1.PCIDevice, user string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pd.Name,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: v1beta1.SchemeGroupVersion.String(),
+					Kind:       "PCIDevice",
+					Name:       pd.Name,
+					UID:        pd.UID,
+				},
+			},
+			Annotations: map[string]string{
+				v1beta1.RequestedByAnnotation: user,
+			},
+		},
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address:  pd.Status.Address,
+			NodeName: pd.Status.NodeName,
+			UserName: user,
+		},
+	}
+}
+
+// hotplugDeviceReady checks if the claimed device is bound to vfio-pci, advertised by its device plugin and
+// permitted in the KubeVirt CR, and otherwise describes what the hotplug waits for
+func (h *Handler) hotplugDeviceReady(pdc *v1beta1.PCIDeviceClaim, pd *v1beta1.PCIDevice) (bool, string) {
+	if pdc.DeletionTimestamp != nil {
+		return false, fmt.Sprintf("pcideviceclaim %s is being deleted", pdc.Name)
+	}
+	if !pdc.Status.PassthroughEnabled {
+		return false, "waiting for the device to be bound to vfio-pci"
+	}
+	if !h.deviceAdvertised(pd) {
+		return false, fmt.Sprintf("waiting for the device plugin %s to advertise the device", pd.Status.ResourceName)
+	}
+	if !v1beta1.PCIDeviceClaimHostDevicePermitted.IsTrue(pdc) {
+		return false, fmt.Sprintf("waiting for %s to be permitted in the kubevirt CR", pd.Status.ResourceName)
+	}
+	return true, ""
+}
+
+// deviceAdvertised checks if the device plugin of pd is started and serves pd
+func (h *Handler) deviceAdvertised(pd *v1beta1.PCIDevice) bool {
+	h.pluginsLock.Lock()
+	defer h.pluginsLock.Unlock()
+	dp := h.devicePlugins[pd.Status.ResourceName]
+	if dp == nil || !dp.Started() {
+		return false
+	}
+	for _, dev := range dp.GetPCIDevices() {
+		if dev.GetAddress() == pd.Status.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// OnHotplugRemove detaches the device of a deleted PCIDeviceHotplug from its VMI, and deletes the claim of
+// the device if it was created for the hotplug. Only the node of the device releases the hotplug
+func (h *Handler) OnHotplugRemove(_ string, hp *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	if hp == nil || hp.DeletionTimestamp == nil {
+		return hp, nil
+	}
+	pd, err := h.pdCache.Get(hp.Spec.DeviceName)
+	switch {
+	case apierrors.IsNotFound(err):
+		// claims are owned by their device, and removed along with it
+		return hp, nil
+	case err != nil:
+		return hp, fmt.Errorf("error looking up pcidevice %s: %v", hp.Spec.DeviceName, err)
+	case pd.Status.NodeName != h.nodeName:
+		return hp, generic.ErrSkip
+	}
+
+	if hp.Status.HostDeviceName != "" {
+		vmi, err := h.vmiCache.Get(hp.Spec.VMINamespace, hp.Spec.VMIName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return hp, fmt.Errorf("error looking up vmi %s/%s: %v", hp.Spec.VMINamespace, hp.Spec.VMIName, err)
+		}
+		if err == nil && !vmi.IsFinal() && vmiHasHostDevice(vmi, hp.Status.HostDeviceName) {
+			if hp.Status.Phase != v1beta1.PCIDeviceHotplugDetaching {
+				if err := h.hotplugger.Detach(vmi.Namespace, vmi.Name, hp.Status.HostDeviceName); err != nil {
+					return hp, err
+				}
+				logrus.Infof("detaching pcidevice %s from vmi %s/%s", pd.Name, vmi.Namespace, vmi.Name)
+				hpCopy := hp.DeepCopy()
+				hpCopy.Status.Phase = v1beta1.PCIDeviceHotplugDetaching
+				updated, err := h.hotplugs.UpdateStatus(hpCopy)
+				if err != nil {
+					return hp, fmt.Errorf("error updating status of pcidevicehotplug %s: %v", hp.Name, err)
+				}
+				hp = updated
+			}
+			// the hotplug is released once the VMI no longer reports the device
+			h.hotplugs.EnqueueAfter(hp.Name, hotplugRetryPeriod)
+			return hp, generic.ErrSkip
+		}
+	}
+
+	if hp.Status.ClaimCreated {
+		err := h.pdcClient.Delete(hp.Status.ClaimName, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return hp, fmt.Errorf("error deleting pcideviceclaim %s: %v", hp.Status.ClaimName, err)
+		}
+	}
+	return hp, nil
+}
+
+// hotplugsForVMI reconciles the hotplugs of a VMI when it changes, e.g. once it runs or reports the device
+func (h *Handler) hotplugsForVMI(namespace, name string, obj runtime.Object) ([]relatedresource.Key, error) {
+	return h.hotplugKeys(func(hp *v1beta1.PCIDeviceHotplug) bool {
+		return hp.Spec.VMINamespace == namespace && hp.Spec.VMIName == name
+	})
+}
+
+// hotplugsForClaim reconciles the hotplugs of a device when its claim changes, e.g. once passthrough is enabled
+func (h *Handler) hotplugsForClaim(_, name string, obj runtime.Object) ([]relatedresource.Key, error) {
+	pdc, ok := obj.(*v1beta1.PCIDeviceClaim)
+	if ok && pdc.Spec.NodeName != h.nodeName {
+		return nil, nil
+	}
+	return h.hotplugKeys(func(hp *v1beta1.PCIDeviceHotplug) bool {
+		return hp.Spec.DeviceName == name || hp.Status.ClaimName == name
+	})
+}
+
+func (h *Handler) hotplugKeys(match func(hp *v1beta1.PCIDeviceHotplug) bool) ([]relatedresource.Key, error) {
+	hotplugs, err := h.hotplugCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcidevicehotplugs: %v", err)
+	}
+	var keys []relatedresource.Key
+	for _, hp := range hotplugs {
+		if match(hp) {
+			keys = append(keys, relatedresource.NewKey("", hp.Name))
+		}
+	}
+	return keys, nil
+}
+
+func vmiHasHostDevice(vmi *kubevirtv1.VirtualMachineInstance, name string) bool {
+	for _, dev := range vmi.Spec.Domain.Devices.HostDevices {
+		if dev.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setHotplugPhase sets the phase of hp, and cond to false with reason and message
+func setHotplugPhase(hp *v1beta1.PCIDeviceHotplug, phase v1beta1.PCIDeviceHotplugPhase, cond condition.Cond, reason, message string) {
+	hp.Status.Phase = phase
+	setHotplugCondition(hp, cond, false, reason, message)
+}
+
+func setHotplugCondition(hp *v1beta1.PCIDeviceHotplug, cond condition.Cond, ok bool, reason, message string) {
+	if ok {
+		cond.True(hp)
+	} else {
+		cond.False(hp)
+	}
+	cond.Reason(hp, reason)
+	cond.Message(hp, message)
+}
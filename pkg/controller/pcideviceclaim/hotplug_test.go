@@ -0,0 +1,294 @@
+package pcideviceclaim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugins"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// fakeHotplugController serves hotplugs from a fake clientset and records enqueued hotplugs
+type fakeHotplugController struct {
+	v1beta1gen.PCIDeviceHotplugController
+	client   fakeclients.PCIDeviceHotplugsClient
+	enqueued map[string]time.Duration
+}
+
+func (f *fakeHotplugController) UpdateStatus(hp *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	return f.client.UpdateStatus(hp)
+}
+
+func (f *fakeHotplugController) EnqueueAfter(name string, duration time.Duration) {
+	f.enqueued[name] = duration
+}
+
+// fakeHotplugger attaches host devices by adding them to the spec of the VMI, as KubeVirt does
+type fakeHotplugger struct {
+	supported bool
+	vmi       *kubevirtv1.VirtualMachineInstance
+	detached  []string
+}
+
+func (f *fakeHotplugger) Supported() (bool, error) {
+	return f.supported, nil
+}
+
+func (f *fakeHotplugger) Attach(_, _ string, device kubevirtv1.HostDevice) error {
+	f.vmi.Spec.Domain.Devices.HostDevices = append(f.vmi.Spec.Domain.Devices.HostDevices, device)
+	return nil
+}
+
+func (f *fakeHotplugger) Detach(_, _, name string) error {
+	f.detached = append(f.detached, name)
+	return nil
+}
+
+func newHotplugHandler(client *fake.Clientset, vmi *kubevirtv1.VirtualMachineInstance) (*Handler, *fakeHotplugController, *fakeHotplugger) {
+	hotplugs := &fakeHotplugController{
+		client:   fakeclients.PCIDeviceHotplugsClient(client.DevicesV1beta1().PCIDeviceHotplugs),
+		enqueued: make(map[string]time.Duration),
+	}
+	hotplugger := &fakeHotplugger{supported: true, vmi: vmi}
+	return &Handler{
+		nodeName:      "node1",
+		pdCache:       fakeclients.PCIDevicesCache(client.DevicesV1beta1().PCIDevices),
+		pdcClient:     newFakeClaimController(client),
+		vmiCache:      fakeclients.VirtualMachineInstanceCache{vmi},
+		devicePlugins: make(map[string]*deviceplugins.PCIDevicePlugin),
+		hotplugs:      hotplugs,
+		hotplugCache:  fakeclients.PCIDeviceHotplugsCache(client.DevicesV1beta1().PCIDeviceHotplugs),
+		hotplugger:    hotplugger,
+	}, hotplugs, hotplugger
+}
+
+func newHotplug(pd *v1beta1.PCIDevice, vmi *kubevirtv1.VirtualMachineInstance) *v1beta1.PCIDeviceHotplug {
+	return &v1beta1.PCIDeviceHotplug{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "notebook-gpu",
+			Annotations: map[string]string{
+				v1beta1.RequestedByAnnotation: "alice",
+			},
+		},
+		Spec: v1beta1.PCIDeviceHotplugSpec{
+			DeviceName:   pd.Name,
+			VMINamespace: vmi.Namespace,
+			VMIName:      vmi.Name,
+		},
+	}
+}
+
+// newNodeVMI returns the running VMI notebook on node
+func newNodeVMI(node string) *kubevirtv1.VirtualMachineInstance {
+	vmi := newRunningVMI("default", "notebook")
+	vmi.Status.NodeName = node
+	return vmi
+}
+
+func Test_HotplugAttachesOnceDeviceIsReady(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newDeviceAndClaim("node1", 0, "89")
+	vmi := newNodeVMI("node1")
+	hp := newHotplug(pd, vmi)
+	client := fake.NewSimpleClientset(pd, hp)
+	h, hotplugs, _ := newHotplugHandler(client, vmi)
+
+	// the device is claimed for the requester, and the hotplug waits for passthrough
+	hp, err := h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugPending, hp.Status.Phase)
+	assert.True(hp.Status.ClaimCreated)
+	assert.True(v1beta1.PCIDeviceHotplugDeviceReady.IsFalse(hp))
+	assert.Contains(hotplugs.enqueued, hp.Name)
+	pdc, err := h.pdcClient.Cache().Get(pd.Name)
+	assert.NoError(err)
+	assert.Equal("alice", pdc.Spec.UserName)
+	assert.Equal("alice", pdc.Annotations[v1beta1.RequestedByAnnotation])
+
+	// the device is bound and permitted, but not advertised yet
+	pdc.Status.PassthroughEnabled = true
+	v1beta1.PCIDeviceClaimHostDevicePermitted.True(pdc)
+	_, err = h.pdcClient.UpdateStatus(pdc)
+	assert.NoError(err)
+	hp, err = h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugPending, hp.Status.Phase)
+	assert.Contains(v1beta1.PCIDeviceHotplugDeviceReady.GetMessage(hp), "advertise")
+
+	dp := deviceplugins.Create(pd.Status.ResourceName, pd.Status.Address, []*v1beta1.PCIDevice{pd})
+	dp.SetStarted(make(chan struct{}))
+	h.devicePlugins[pd.Status.ResourceName] = dp
+	hp, err = h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugAttaching, hp.Status.Phase)
+	assert.Equal(pd.Name, hp.Status.HostDeviceName)
+	assert.True(v1beta1.PCIDeviceHotplugDeviceReady.IsTrue(hp))
+
+	// the VMI reports the device
+	hp, err = h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugAttached, hp.Status.Phase)
+	assert.True(v1beta1.PCIDeviceHotplugAttachedCondition.IsTrue(hp))
+	assert.Len(vmi.Spec.Domain.Devices.HostDevices, 1, "expected the device to be attached once")
+}
+
+func Test_HotplugRefusesDeviceOfOtherUser(t *testing.T) {
+	assert := require.New(t)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	vmi := newNodeVMI("node1")
+	hp := newHotplug(pd, vmi)
+	client := fake.NewSimpleClientset(pd, pdc, hp)
+	h, _, _ := newHotplugHandler(client, vmi)
+
+	hp, err := h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugPending, hp.Status.Phase)
+	assert.Equal(v1beta1.DeviceClaimedReason, v1beta1.PCIDeviceHotplugDeviceReady.GetReason(hp))
+	assert.False(hp.Status.ClaimCreated)
+}
+
+func Test_HotplugRefusesDeviceInUse(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		usedBy   *v1beta1.DeviceUsage
+		vmis     []*kubevirtv1.VirtualMachineInstance
+		expected string
+	}{
+		{
+			name: "device allocated to a running vmi",
+			usedBy: &v1beta1.DeviceUsage{
+				Pod:                    "default/virt-launcher-vm1-abcde",
+				VirtualMachineInstance: "default/vm1",
+			},
+			vmis:     []*kubevirtv1.VirtualMachineInstance{newRunningVMI("default", "vm1", "intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION")},
+			expected: "the device is in use by vmis default/vm1",
+		},
+		{
+			name:     "device requested by a vmi before its allocation is published",
+			vmis:     []*kubevirtv1.VirtualMachineInstance{newRunningVMI("default", "vm1", "intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION")},
+			expected: "the device is in use by vmis default/vm1",
+		},
+		{
+			name:     "device allocated to a pod",
+			usedBy:   &v1beta1.DeviceUsage{Pod: "default/dpdk-app", Container: "app"},
+			expected: "the device is in use by pod default/dpdk-app",
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			// the device is claimed for the requester of the hotplug, who uses it in another VM already
+			pd, pdc := newDeviceAndClaim("node1", 0, "89")
+			pd.Status.UsedBy = v.usedBy
+			pdc.Spec.UserName = "alice"
+			pdc.Status.PassthroughEnabled = true
+			vmi := newNodeVMI("node1")
+			hp := newHotplug(pd, vmi)
+			client := fake.NewSimpleClientset(pd, pdc, hp)
+			h, hotplugs, _ := newHotplugHandler(client, vmi)
+			h.vmiCache = append(fakeclients.VirtualMachineInstanceCache{vmi}, v.vmis...)
+
+			hp, err := h.OnHotplugChange(hp.Name, hp)
+			assert.NoError(err)
+			assert.Equal(v1beta1.PCIDeviceHotplugPending, hp.Status.Phase)
+			assert.Equal(v1beta1.DeviceClaimedReason, v1beta1.PCIDeviceHotplugDeviceReady.GetReason(hp))
+			assert.Equal(v.expected, v1beta1.PCIDeviceHotplugDeviceReady.GetMessage(hp))
+			assert.Empty(hp.Status.ClaimName, "expected the claim of the device in use to not be used")
+			assert.Contains(hotplugs.enqueued, hp.Name, "expected the hotplug to wait for the device")
+			assert.Empty(vmi.Spec.Domain.Devices.HostDevices)
+		})
+	}
+}
+
+func Test_HotplugUnsupported(t *testing.T) {
+	assert := require.New(t)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	pdc.Spec.UserName = "alice"
+	pdc.Status.PassthroughEnabled = true
+	v1beta1.PCIDeviceClaimHostDevicePermitted.True(pdc)
+	vmi := newNodeVMI("node1")
+	hp := newHotplug(pd, vmi)
+	client := fake.NewSimpleClientset(pd, pdc, hp)
+	h, _, hotplugger := newHotplugHandler(client, vmi)
+	hotplugger.supported = false
+	dp := deviceplugins.Create(pd.Status.ResourceName, pd.Status.Address, []*v1beta1.PCIDevice{pd})
+	dp.SetStarted(make(chan struct{}))
+	h.devicePlugins[pd.Status.ResourceName] = dp
+
+	hp, err := h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugFailed, hp.Status.Phase)
+	assert.Equal(v1beta1.HotplugUnsupportedReason, v1beta1.PCIDeviceHotplugAttachedCondition.GetReason(hp))
+	assert.Empty(vmi.Spec.Domain.Devices.HostDevices)
+}
+
+func Test_HotplugVMIOnOtherNode(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newDeviceAndClaim("node1", 0, "89")
+	vmi := newNodeVMI("node2")
+	hp := newHotplug(pd, vmi)
+	client := fake.NewSimpleClientset(pd, hp)
+	h, _, _ := newHotplugHandler(client, vmi)
+
+	hp, err := h.OnHotplugChange(hp.Name, hp)
+	assert.NoError(err)
+	assert.Equal(v1beta1.PCIDeviceHotplugFailed, hp.Status.Phase)
+	assert.Equal(v1beta1.VMINotRunningReason, v1beta1.PCIDeviceHotplugAttachedCondition.GetReason(hp))
+	assert.Empty(hp.Status.ClaimName, "expected no claim for a vmi on another node")
+}
+
+func Test_HotplugRemoveDetachesAndDeletesClaim(t *testing.T) {
+	assert := require.New(t)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	vmi := newNodeVMI("node1")
+	vmi.Spec.Domain.Devices.HostDevices = []kubevirtv1.HostDevice{{Name: pd.Name, DeviceName: pd.Status.ResourceName}}
+	hp := newHotplug(pd, vmi)
+	hp.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	hp.Status = v1beta1.PCIDeviceHotplugStatus{
+		Phase:          v1beta1.PCIDeviceHotplugAttached,
+		ClaimName:      pdc.Name,
+		ClaimCreated:   true,
+		HostDeviceName: pd.Name,
+	}
+	client := fake.NewSimpleClientset(pd, pdc, hp)
+	h, hotplugs, hotplugger := newHotplugHandler(client, vmi)
+
+	// the hotplug is kept until the VMI no longer reports the device
+	hp, err := h.OnHotplugRemove(hp.Name, hp)
+	assert.ErrorIs(err, generic.ErrSkip)
+	assert.Equal([]string{pd.Name}, hotplugger.detached)
+	assert.Equal(v1beta1.PCIDeviceHotplugDetaching, hp.Status.Phase)
+	assert.Contains(hotplugs.enqueued, hp.Name)
+
+	_, err = h.OnHotplugRemove(hp.Name, hp)
+	assert.ErrorIs(err, generic.ErrSkip)
+	assert.Len(hotplugger.detached, 1, "expected the device to be detached once")
+
+	vmi.Spec.Domain.Devices.HostDevices = nil
+	_, err = h.OnHotplugRemove(hp.Name, hp)
+	assert.NoError(err)
+	_, err = h.pdcClient.Cache().Get(pdc.Name)
+	assert.Error(err, "expected the claim created for the hotplug to be deleted")
+}
+
+func Test_HotplugRemoveOnOtherNodeIsSkipped(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newDeviceAndClaim("node2", 0, "89")
+	vmi := newNodeVMI("node2")
+	hp := newHotplug(pd, vmi)
+	hp.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	client := fake.NewSimpleClientset(pd, hp)
+	h, _, _ := newHotplugHandler(client, vmi)
+
+	_, err := h.OnHotplugRemove(hp.Name, hp)
+	assert.ErrorIs(err, generic.ErrSkip)
+}
@@ -0,0 +1,162 @@
+package pcideviceclaim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/vmusage"
+)
+
+const (
+	defaultExpiryWarning = time.Hour
+	// expiredRecheckInterval is how often an expired claim checks if the VMs using the device stopped
+	expiredRecheckInterval = time.Minute
+)
+
+// reconcileLease applies the reservation window and expiry of a claim, and returns false if passthrough
+// must not be enabled as the reservation has not started, conflicts with another claim or expired.
+// The outcome is recorded in the Reserved and Expiring conditions of pdc
+func (h *Handler) reconcileLease(pdc *v1beta1.PCIDeviceClaim) (bool, error) {
+	now := time.Now()
+	expiry := pdc.Expiry()
+	if expiry != nil && !now.Before(*expiry) {
+		return false, h.releaseExpiredClaim(pdc, *expiry)
+	}
+
+	if !pdc.Status.PassthroughEnabled {
+		ready, err := h.checkReservation(pdc, now)
+		if !ready || err != nil {
+			return false, err
+		}
+	}
+	v1beta1.PCIDeviceClaimReserved.SetError(pdc, "", nil)
+
+	if expiry == nil {
+		return true, nil
+	}
+
+	warnAt := expiry.Add(-h.expiryWarning)
+	if now.Before(warnAt) {
+		h.pdcClient.EnqueueAfter(pdc.Name, warnAt.Sub(now))
+		return true, nil
+	}
+
+	if !v1beta1.PCIDeviceClaimExpiring.IsTrue(pdc) {
+		msg := fmt.Sprintf("claim expires at %s", expiry.UTC().Format(time.RFC3339))
+		h.recorder.Event(pdc, corev1.EventTypeWarning, v1beta1.ExpiryWarningReason, msg)
+		v1beta1.PCIDeviceClaimExpiring.SetError(pdc, v1beta1.ExpiryWarningReason, nil)
+		v1beta1.PCIDeviceClaimExpiring.Message(pdc, msg)
+	}
+	h.pdcClient.EnqueueAfter(pdc.Name, expiry.Sub(now))
+	return true, nil
+}
+
+// checkReservation checks if the reservation window of a claim without passthrough has started, and no
+// other claim holds the device
+func (h *Handler) checkReservation(pdc *v1beta1.PCIDeviceClaim, now time.Time) (bool, error) {
+	others, err := h.claimsForSameDevice(pdc)
+	if err != nil {
+		return false, err
+	}
+
+	for _, other := range others {
+		// the claim holding the device, or else the claim created first, keeps the reservation
+		if !pdc.ReservationOverlaps(other) || !(other.Status.PassthroughEnabled || claimPrecedes(other, pdc)) {
+			continue
+		}
+		if !v1beta1.PCIDeviceClaimReserved.IsFalse(pdc) || v1beta1.PCIDeviceClaimReserved.GetReason(pdc) != v1beta1.ReservationConflictReason {
+			h.recorder.Eventf(pdc, corev1.EventTypeWarning, v1beta1.ReservationConflictReason,
+				"reservation overlaps with pcideviceclaim %s", other.Name)
+		}
+		v1beta1.PCIDeviceClaimReserved.SetError(pdc, v1beta1.ReservationConflictReason,
+			fmt.Errorf("reservation overlaps with pcideviceclaim %s", other.Name))
+		return false, nil
+	}
+
+	if start := pdc.ReservationStart(); start.After(now) {
+		v1beta1.PCIDeviceClaimReserved.SetError(pdc, v1beta1.ReservationPendingReason,
+			fmt.Errorf("device is reserved from %s", start.UTC().Format(time.RFC3339)))
+		h.pdcClient.EnqueueAfter(pdc.Name, start.Sub(now))
+		return false, nil
+	}
+
+	// a previous claim which expired keeps the device until its VMs stop
+	for _, other := range others {
+		if other.Status.PassthroughEnabled {
+			v1beta1.PCIDeviceClaimReserved.SetError(pdc, v1beta1.ReservationPendingReason,
+				fmt.Errorf("waiting for pcideviceclaim %s to be released", other.Name))
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// releaseExpiredClaim deletes an expired claim once no running VM uses the device
+func (h *Handler) releaseExpiredClaim(pdc *v1beta1.PCIDeviceClaim, expiry time.Time) error {
+	vmsByClaim, err := vmusage.VMsUsingClaims(h.vmiCache, h.pdCache, pdc)
+	if err != nil {
+		return err
+	}
+
+	if vms := vmsByClaim[pdc.Name]; len(vms) > 0 {
+		msg := fmt.Sprintf("claim expired at %s, waiting for VMs %s to stop", expiry.UTC().Format(time.RFC3339), strings.Join(vms, ","))
+		if v1beta1.PCIDeviceClaimExpiring.GetReason(pdc) != v1beta1.ExpiredReason {
+			h.recorder.Event(pdc, corev1.EventTypeWarning, v1beta1.ExpiredReason, msg)
+		}
+		v1beta1.PCIDeviceClaimExpiring.SetError(pdc, v1beta1.ExpiredReason, nil)
+		v1beta1.PCIDeviceClaimExpiring.Message(pdc, msg)
+		h.pdcClient.EnqueueAfter(pdc.Name, expiredRecheckInterval)
+		return nil
+	}
+
+	logrus.Infof("releasing expired pdc %s", pdc.Name)
+	h.recorder.Eventf(pdc, corev1.EventTypeNormal, v1beta1.ExpiredReason, "releasing claim which expired at %s", expiry.UTC().Format(time.RFC3339))
+	if err := h.pdcClient.Delete(pdc.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error releasing expired pcideviceclaim %s: %v", pdc.Name, err)
+	}
+	return nil
+}
+
+// claimsForSameDevice lists the other claims for the device of pdc which are not being deleted
+func (h *Handler) claimsForSameDevice(pdc *v1beta1.PCIDeviceClaim) ([]*v1beta1.PCIDeviceClaim, error) {
+	pdcs, err := h.pdcClient.Cache().List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+
+	var result []*v1beta1.PCIDeviceClaim
+	for _, v := range pdcs {
+		if v.Name != pdc.Name && v.DeletionTimestamp == nil && v.Spec.NodeAddr() == pdc.Spec.NodeAddr() {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// enqueueClaimsForSameDevice reconciles reservations waiting for pdc to be released
+func (h *Handler) enqueueClaimsForSameDevice(pdc *v1beta1.PCIDeviceClaim) error {
+	others, err := h.claimsForSameDevice(pdc)
+	if err != nil {
+		return err
+	}
+	for _, v := range others {
+		h.pdcClient.Enqueue(v.Name)
+	}
+	return nil
+}
+
+// claimPrecedes checks if a was created before b, using the name to order claims created at the same time
+func claimPrecedes(a, b *v1beta1.PCIDeviceClaim) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
@@ -0,0 +1,194 @@
+package pcideviceclaim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// fakeClaimController serves claims from a fake clientset and records enqueued claims
+type fakeClaimController struct {
+	v1beta1gen.PCIDeviceClaimController
+	client   fakeclients.PCIDeviceClaimsClient
+	enqueued map[string]time.Duration
+}
+
+func newFakeClaimController(client *fake.Clientset) *fakeClaimController {
+	return &fakeClaimController{
+		client:   fakeclients.PCIDeviceClaimsClient(client.DevicesV1beta1().PCIDeviceClaims),
+		enqueued: make(map[string]time.Duration),
+	}
+}
+
+func (f *fakeClaimController) Cache() v1beta1gen.PCIDeviceClaimCache {
+	return fakeclients.PCIDeviceClaimsCache(f.client)
+}
+
+func (f *fakeClaimController) Create(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	return f.client.Create(pdc)
+}
+
+func (f *fakeClaimController) Delete(name string, options *metav1.DeleteOptions) error {
+	return f.client.Delete(name, options)
+}
+
+func (f *fakeClaimController) List(opts metav1.ListOptions) (*v1beta1.PCIDeviceClaimList, error) {
+	return f.client.List(opts)
+}
+
+func (f *fakeClaimController) UpdateStatus(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	return f.client.UpdateStatus(pdc)
+}
+
+func (f *fakeClaimController) Enqueue(name string) {
+	f.enqueued[name] = 0
+}
+
+func (f *fakeClaimController) EnqueueAfter(name string, duration time.Duration) {
+	f.enqueued[name] = duration
+}
+
+func newLeaseHandler(client *fake.Clientset, vmis ...*kubevirtv1.VirtualMachineInstance) (*Handler, *fakeClaimController, *record.FakeRecorder) {
+	pdcClient := newFakeClaimController(client)
+	recorder := record.NewFakeRecorder(10)
+	return &Handler{
+		nodeName:      "node1",
+		pdcClient:     pdcClient,
+		pdCache:       fakeclients.PCIDevicesCache(client.DevicesV1beta1().PCIDevices),
+		nodeCache:     fakeclients.NodeCache(k8sfake.NewSimpleClientset(newReadyNode("node1", true), newReadyNode("node2", true)).CoreV1().Nodes),
+		vmiCache:      fakeclients.VirtualMachineInstanceCache(vmis),
+		recorder:      recorder,
+		expiryWarning: time.Hour,
+	}, pdcClient, recorder
+}
+
+func newClaimForDevice(name string, created time.Time) *v1beta1.PCIDeviceClaim {
+	_, pdc := newDeviceAndClaim("node1", 0, "89")
+	pdc.Name = name
+	pdc.CreationTimestamp = metav1.NewTime(created)
+	return pdc
+}
+
+func Test_ReservationConflict(t *testing.T) {
+	assert := require.New(t)
+	now := time.Now().Truncate(time.Second)
+
+	first := newClaimForDevice("first", now.Add(-time.Minute))
+	first.Spec.TTL = &metav1.Duration{Duration: 2 * time.Hour}
+	second := newClaimForDevice("second", now)
+	second.Spec.ReservedFrom = &metav1.Time{Time: now.Add(time.Hour)}
+	client := fake.NewSimpleClientset(first, second)
+	h, _, recorder := newLeaseHandler(client)
+
+	ready, err := h.reconcileLease(first)
+	assert.NoError(err)
+	assert.True(ready, "expected first claim to hold the reservation")
+	assert.True(v1beta1.PCIDeviceClaimReserved.IsTrue(first))
+
+	ready, err = h.reconcileLease(second)
+	assert.NoError(err)
+	assert.False(ready, "expected overlapping reservation to be refused")
+	assert.True(v1beta1.PCIDeviceClaimReserved.IsFalse(second))
+	assert.Equal(v1beta1.ReservationConflictReason, v1beta1.PCIDeviceClaimReserved.GetReason(second))
+	assert.Len(recorder.Events, 1, "expected conflict event")
+
+	// the event is only emitted when the conflict is first detected
+	_, err = h.reconcileLease(second)
+	assert.NoError(err)
+	assert.Len(recorder.Events, 1)
+}
+
+func Test_PendingReservation(t *testing.T) {
+	assert := require.New(t)
+	now := time.Now().Truncate(time.Second)
+
+	current := newClaimForDevice("current", now.Add(-time.Hour))
+	current.Spec.ExpiresAt = &metav1.Time{Time: now.Add(time.Hour)}
+	current.Status.PassthroughEnabled = true
+	next := newClaimForDevice("next", now)
+	next.Spec.ReservedFrom = &metav1.Time{Time: now.Add(time.Hour)}
+	client := fake.NewSimpleClientset(current, next)
+	h, pdcClient, _ := newLeaseHandler(client)
+
+	ready, err := h.reconcileLease(next)
+	assert.NoError(err)
+	assert.False(ready, "expected reservation to wait for its window")
+	assert.Equal(v1beta1.ReservationPendingReason, v1beta1.PCIDeviceClaimReserved.GetReason(next))
+	assert.Contains(pdcClient.enqueued, "next", "expected claim to be reconciled when the window starts")
+
+	// the window started, but the previous claim has not been released yet
+	next.Spec.ReservedFrom = &metav1.Time{Time: now.Add(-time.Minute)}
+	current.Spec.ExpiresAt = &metav1.Time{Time: now.Add(-time.Minute)}
+	_, err = client.DevicesV1beta1().PCIDeviceClaims().Update(context.TODO(), current, metav1.UpdateOptions{})
+	assert.NoError(err)
+	ready, err = h.reconcileLease(next)
+	assert.NoError(err)
+	assert.False(ready, "expected reservation to wait for the previous claim")
+	assert.Equal(v1beta1.ReservationPendingReason, v1beta1.PCIDeviceClaimReserved.GetReason(next))
+
+	assert.NoError(client.DevicesV1beta1().PCIDeviceClaims().Delete(context.TODO(), "current", metav1.DeleteOptions{}))
+	ready, err = h.reconcileLease(next)
+	assert.NoError(err)
+	assert.True(ready, "expected reservation to start once the device is released")
+	assert.True(v1beta1.PCIDeviceClaimReserved.IsTrue(next))
+}
+
+func Test_ExpiryWarning(t *testing.T) {
+	assert := require.New(t)
+	pdc := newClaimForDevice("claim", time.Now())
+	pdc.Spec.TTL = &metav1.Duration{Duration: 30 * time.Minute}
+	client := fake.NewSimpleClientset(pdc)
+	h, pdcClient, recorder := newLeaseHandler(client)
+
+	ready, err := h.reconcileLease(pdc)
+	assert.NoError(err)
+	assert.True(ready)
+	assert.True(v1beta1.PCIDeviceClaimExpiring.IsTrue(pdc))
+	assert.Equal(v1beta1.ExpiryWarningReason, v1beta1.PCIDeviceClaimExpiring.GetReason(pdc))
+	assert.Len(recorder.Events, 1, "expected expiry warning event")
+	assert.Contains(pdcClient.enqueued, "claim", "expected claim to be reconciled when it expires")
+
+	_, err = h.reconcileLease(pdc)
+	assert.NoError(err)
+	assert.Len(recorder.Events, 1, "expected a single expiry warning event")
+}
+
+func Test_ExpiredClaimReleasedOnceUnused(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newDeviceAndClaim("node1", 0, "89")
+	pdc := newClaimForDevice("claim", time.Now().Add(-2*time.Hour))
+	pdc.Spec.TTL = &metav1.Duration{Duration: time.Hour}
+	pdc.Status.PassthroughEnabled = true
+	client := fake.NewSimpleClientset(pd, pdc)
+
+	// the VM names its host device differently than the claim and the device
+	vmi := newRunningVMI("default", "vm1", pd.Status.ResourceName)
+	h, pdcClient, _ := newLeaseHandler(client, vmi)
+
+	ready, err := h.reconcileLease(pdc)
+	assert.NoError(err)
+	assert.False(ready)
+	assert.Equal(v1beta1.ExpiredReason, v1beta1.PCIDeviceClaimExpiring.GetReason(pdc))
+	assert.Contains(v1beta1.PCIDeviceClaimExpiring.GetMessage(pdc), "default/vm1")
+	assert.Equal(expiredRecheckInterval, pdcClient.enqueued["claim"])
+	_, err = client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), "claim", metav1.GetOptions{})
+	assert.NoError(err, "expected claim in use by a running VM to be kept")
+
+	vmi.Status.Phase = kubevirtv1.Succeeded
+	_, err = h.reconcileLease(pdc)
+	assert.NoError(err)
+	_, err = client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), "claim", metav1.GetOptions{})
+	assert.True(apierrors.IsNotFound(err), "expected expired claim to be released")
+}
@@ -4,68 +4,153 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
+	ctlkubevirtv1 "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/pkg/relatedresource"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/u-root/u-root/pkg/kmodule"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	kubevirtv1 "kubevirt.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"kubevirt.io/client-go/kubecli"
 
 	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
 	"github.com/harvester/pcidevices/pkg/deviceplugins"
 	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/executor"
+	"github.com/harvester/pcidevices/pkg/util/hotplug"
+	"github.com/harvester/pcidevices/pkg/util/inuse"
+	"github.com/harvester/pcidevices/pkg/util/pcireset"
 )
 
 const pdcFinalizer = "harvesterhci.io/pcidevicecleanup"
 
 const (
-	reconcilePeriod   = time.Minute * 20
-	vfioPCIDriver     = "vfio-pci"
-	DefaultNS         = "harvester-system"
-	KubevirtCR        = "kubevirt"
-	vfioPCIDriverPath = "/sys/bus/pci/drivers/vfio-pci"
+	reconcilePeriod = time.Minute * 20
+	vfioPCIDriver   = "vfio-pci"
 )
 
 type Controller struct {
 	PCIDeviceClaims v1beta1gen.PCIDeviceClaimController
 }
 
+// Options configures the PCIDeviceClaim controller
+type Options struct {
+	// ResetOnPreStart resets devices in PreStartContainer of the device plugins, in addition
+	// to the reset performed when a claim is released
+	ResetOnPreStart bool
+	// ExpiryWarning is how long before a claim expires a warning event is emitted
+	ExpiryWarning time.Duration
+	// OperationHistoryLimit is the number of PCIDeviceOperations kept per device
+	OperationHistoryLimit int
+	// OperationRetention is how long PCIDeviceOperations are kept
+	OperationRetention time.Duration
+	// CDISpecDir is where the device plugins write the CDI specs of the claimed devices, no CDI specs
+	// are written if it is empty
+	CDISpecDir string
+	// DevicePluginDir is where the sockets of the device plugins are created, and KubeletSocket is the
+	// registration socket of the kubelet. The kubelet defaults are used if they are empty
+	DevicePluginDir string
+	KubeletSocket   string
+}
+
 type Handler struct {
-	pdcClient     v1beta1gen.PCIDeviceClaimController
-	pdClient      v1beta1gen.PCIDeviceClient
-	virtClient    kubecli.KubevirtClient
-	nodeName      string
+	pdcClient v1beta1gen.PCIDeviceClaimController
+	pdClient  v1beta1gen.PCIDeviceClient
+	pdCache   v1beta1gen.PCIDeviceCache
+	nodeCache ctlcorev1.NodeCache
+	nodes     ctlcorev1.NodeClient
+	nodeName  string
+	// executor serializes sysfs mutations per device and iommu group, as wrangler
+	// runs handlers for different claims concurrently
+	executor *executor.Executor
+	sysfs    pciDriverSysfs
+	// usageChecker detects host usage of a device before it is unbound from its driver
+	usageChecker deviceUsageChecker
+	// resetter resets devices when they are released, and before container start if resetOnPreStart is set
+	resetter        deviceplugins.DeviceResetter
+	resetOnPreStart bool
+	// vmiCache is used to keep expired claims until the VMs using them stop
+	vmiCache      ctlkubevirtv1.VirtualMachineInstanceCache
+	recorder      record.EventRecorder
+	expiryWarning time.Duration
+	// audit records the operations on devices as PCIDeviceOperations
+	audit *auditor
+	// pluginsLock guards devicePlugins, which is shared by the OnChange and OnRemove handlers
+	pluginsLock   sync.Mutex
 	devicePlugins map[string]*deviceplugins.PCIDevicePlugin
+	// plugins runs the device plugins, and registers them again when the kubelet restarts
+	plugins *deviceplugins.Manager
+	// pciDevicesPath is where the PCI devices of the host are discovered when the device plugins are rebuilt
+	pciDevicesPath string
+	// healthChecker probes the devices served by the device plugins, it is nil if devices are not probed
+	healthChecker deviceplugins.DeviceHealthChecker
+	// cdiSpecDir is where the device plugins write CDI specs, it is empty if no CDI specs are written
+	cdiSpecDir string
+	// hotplugs attach claimed devices to running VMIs through hotplugger
+	hotplugs     v1beta1gen.PCIDeviceHotplugController
+	hotplugCache v1beta1gen.PCIDeviceHotplugCache
+	hotplugger   hotplug.Client
 }
 
 func Register(
 	ctx context.Context,
 	pdcClient v1beta1gen.PCIDeviceClaimController,
 	pdClient v1beta1gen.PCIDeviceController,
+	opClient v1beta1gen.PCIDeviceOperationClient,
+	hotplugClient v1beta1gen.PCIDeviceHotplugController,
+	nodeClient ctlcorev1.NodeController,
+	vmiClient ctlkubevirtv1.VirtualMachineInstanceController,
+	recorder record.EventRecorder,
 	nodeName string,
+	opts Options,
 ) error {
 	logrus.Info("Registering PCI Device Claims controller")
-	clientConfig := kubecli.DefaultClientConfig(&pflag.FlagSet{})
-	virtClient, err := kubecli.GetKubevirtClientFromClientConfig(clientConfig)
+	expiryWarning := opts.ExpiryWarning
+	if expiryWarning <= 0 {
+		expiryWarning = defaultExpiryWarning
+	}
+
+	virtClient, err := kubecli.GetKubevirtClientFromClientConfig(kubecli.DefaultClientConfig(&pflag.FlagSet{}))
 	if err != nil {
-		msg := fmt.Sprintf("cannot obtain KubeVirt client: %v", err)
-		return errors.New(msg)
+		return fmt.Errorf("cannot obtain KubeVirt client: %v", err)
 	}
 
 	handler := &Handler{
-		pdcClient:     pdcClient,
-		pdClient:      pdClient,
-		nodeName:      nodeName,
-		virtClient:    virtClient,
-		devicePlugins: make(map[string]*deviceplugins.PCIDevicePlugin),
+		pdcClient:       pdcClient,
+		pdClient:        pdClient,
+		pdCache:         pdClient.Cache(),
+		nodeCache:       nodeClient.Cache(),
+		nodes:           nodeClient,
+		nodeName:        nodeName,
+		executor:        executor.New(),
+		sysfs:           newHostSysfs(),
+		usageChecker:    inuse.NewChecker(),
+		resetter:        pcireset.New(),
+		resetOnPreStart: opts.ResetOnPreStart,
+		vmiCache:        vmiClient.Cache(),
+		recorder:        recorder,
+		expiryWarning:   expiryWarning,
+		audit:           newAuditor(opClient, nodeName, opts.OperationHistoryLimit, opts.OperationRetention),
+		devicePlugins:   make(map[string]*deviceplugins.PCIDevicePlugin),
+		plugins: deviceplugins.NewManager(deviceplugins.ManagerOptions{
+			SocketDir:     opts.DevicePluginDir,
+			KubeletSocket: opts.KubeletSocket,
+		}),
+		pciDevicesPath: sysfsPCIDevicesPath,
+		healthChecker:  deviceplugins.NewHealthChecker(sysfsPCIDevicesPath),
+		cdiSpecDir:     opts.CDISpecDir,
+		hotplugs:       hotplugClient,
+		hotplugCache:   hotplugClient.Cache(),
+		hotplugger:     hotplug.New(virtClient.Discovery(), virtClient.RestClient()),
 	}
 
 	pdcClient.OnRemove(ctx, "PCIDeviceClaimOnRemove", handler.OnRemove)
@@ -73,10 +158,37 @@ func Register(
 	// Watch to check for updates to pcidevices. This can happen on reboot as devices are set to reflect the correct
 	// driver in use by said device. This helps ensure that associated claim is reconcilled to trigger a rebind if needed
 	relatedresource.WatchClusterScoped(ctx, "PCIDeviceToClaimReconcile", handler.OnDeviceChange, pdcClient, pdClient)
-	err = handler.unbindOrphanedPCIDevices()
-	if err != nil {
+	// Claims blocked by node maintenance are reconciled once the maintenance ends
+	relatedresource.WatchClusterScoped(ctx, "NodeToClaimReconcile", handler.OnNodeChange, pdcClient, nodeClient)
+	// The state of the device plugins is published on the node whenever it changes
+	handler.plugins.OnStateChange(func() {
+		nodeClient.Enqueue(nodeName)
+	})
+	nodeClient.OnChange(ctx, "DevicePluginStates", handler.OnNodeChangePublishPlugins)
+	go func() {
+		if err := handler.plugins.Run(ctx); err != nil {
+			logrus.Errorf("error watching kubelet for device plugin restarts: %v", err)
+		}
+	}()
+	// Deleted claims and devices are released once the VMs using the device stop
+	pdClient.OnRemove(ctx, "PCIDeviceOnRemove", handler.OnDeviceRemove)
+	relatedresource.WatchClusterScoped(ctx, "VMIToClaimRelease", handler.OnVMIChangeReleaseClaims, pdcClient, vmiClient)
+	relatedresource.WatchClusterScoped(ctx, "VMIToDeviceRelease", handler.OnVMIChangeReleaseDevices, pdClient, vmiClient)
+	// Deleted claims and devices of unavailable nodes are released by the other nodes
+	relatedresource.WatchClusterScoped(ctx, "NodeToClaimRelease", handler.OnNodeChangeReleaseClaims, pdcClient, nodeClient)
+	relatedresource.WatchClusterScoped(ctx, "NodeToDeviceRelease", handler.OnNodeChangeReleaseDevices, pdClient, nodeClient)
+	// Devices are hot-plugged once they are ready, and the VMI is running on the node of the device
+	hotplugClient.OnChange(ctx, "PCIDeviceHotplugAttach", handler.OnHotplugChange)
+	hotplugClient.OnRemove(ctx, "PCIDeviceHotplugDetach", handler.OnHotplugRemove)
+	relatedresource.WatchClusterScoped(ctx, "VMIToHotplug", handler.hotplugsForVMI, hotplugClient, vmiClient)
+	relatedresource.WatchClusterScoped(ctx, "ClaimToHotplug", handler.hotplugsForClaim, hotplugClient, pdcClient)
+	if err := handler.unbindOrphanedPCIDevices(); err != nil {
 		return err
 	}
+	if err := handler.rebuildDevicePlugins(); err != nil {
+		return fmt.Errorf("error rebuilding device plugins: %v", err)
+	}
+	go wait.Until(handler.audit.pruneNode, operationPruneInterval, ctx.Done())
 	// Load VFIO drivers when controller starts instead of repeatedly in the reconcile loop
 	loadVfioDrivers()
 	return nil
@@ -88,12 +200,31 @@ func (h *Handler) OnRemove(name string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.P
 		return pdc, nil
 	}
 
-	// need to requeue object to ensure correct node picks up and cleanup/rebind is executed
+	// only the node of the claim removes the finalizer, once the device is rebound to its original driver.
+	// Claims of unavailable nodes are released by the other nodes, once no running VM uses the device
 	if pdc.Spec.NodeName != h.nodeName {
-		h.pdcClient.Enqueue(name)
+		return h.releaseClaimOfUnavailableNode(pdc)
+	}
+
+	// reservations waiting for the device are reconciled once it is released
+	if err := h.enqueueClaimsForSameDevice(pdc); err != nil {
+		return pdc, err
+	}
+
+	// claims which never enabled passthrough, e.g. pending reservations, must not
+	// unbind a device held by another claim
+	if !pdc.Status.PassthroughEnabled {
 		return pdc, nil
 	}
 
+	blocked, err := h.releaseBlocked(pdc)
+	if err != nil {
+		return pdc, err
+	}
+	if blocked {
+		return pdc, fmt.Errorf("pcideviceclaim %s is still in use by running vms, not unbinding device from vfio-pci", pdc.Name)
+	}
+
 	// Get PCIDevice for the PCIDeviceClaim
 	pd, err := h.getPCIDeviceForClaim(pdc)
 	if err != nil {
@@ -102,11 +233,22 @@ func (h *Handler) OnRemove(name string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.P
 	}
 
 	// Disable PCI Passthrough by unbinding from the vfio-pci device driver
-	err = h.disablePassthrough(pd)
+	err = h.runDeviceOperation(pd, func() error {
+		return h.disablePassthrough(pd, pdc)
+	})
 	if err != nil {
 		return pdc, err
 	}
 
+	return pdc, h.removeDeviceFromPlugin(pd, pdc)
+}
+
+// removeDeviceFromPlugin marks the device as unhealthy in the DevicePlugin for its resourceName,
+// and shuts down the DevicePlugin once no healthy devices remain
+func (h *Handler) removeDeviceFromPlugin(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	h.pluginsLock.Lock()
+	defer h.pluginsLock.Unlock()
+
 	// Find the DevicePlugin
 	resourceName := pd.Status.ResourceName
 	dp := deviceplugins.Find(
@@ -114,22 +256,28 @@ func (h *Handler) OnRemove(name string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.P
 		h.devicePlugins,
 	)
 
-	if dp != nil {
-		err = dp.RemoveDevice(pd, pdc)
-		if err != nil {
-			return pdc, err
-		}
-		// Check if that was the last device, and then shut down the dp
-		time.Sleep(5 * time.Second)
-		if dp.GetCount() == 0 {
-			err := dp.Stop()
-			if err != nil {
-				return pdc, err
-			}
-			delete(h.devicePlugins, resourceName)
+	if dp == nil {
+		return nil
+	}
+
+	if err := dp.RemoveDevice(pd, pdc); err != nil {
+		return err
+	}
+
+	// Check if that was the last device, and then shut down the dp
+	if dp.GetCount() == 0 {
+		if err := h.plugins.Stop(resourceName); err != nil {
+			return err
 		}
+		delete(h.devicePlugins, resourceName)
 	}
-	return pdc, nil
+	return nil
+}
+
+// runDeviceOperation runs op once no other operation is in progress for the device or its iommu group.
+// All sysfs mutations for a device must go through runDeviceOperation
+func (h *Handler) runDeviceOperation(pd *v1beta1.PCIDevice, op func() error) error {
+	return h.executor.Run(executor.DeviceKeys(pd.Status.Address, pd.Status.IOMMUGroup), op)
 }
 
 func loadVfioDrivers() {
@@ -141,8 +289,8 @@ func loadVfioDrivers() {
 	}
 }
 
-func bindDeviceToVFIOPCIDriver(pd *v1beta1.PCIDevice) error {
-	if deviceBoundToDriver(vfioPCIDriverPath, pd.Status.Address) {
+func (h *Handler) bindDeviceToVFIOPCIDriver(pd *v1beta1.PCIDevice) error {
+	if h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
 		return nil
 	}
 
@@ -151,30 +299,20 @@ func bindDeviceToVFIOPCIDriver(pd *v1beta1.PCIDevice) error {
 	var id string = fmt.Sprintf("%s %s", vendorId, deviceId)
 	logrus.Infof("Binding device %s [%s] to vfio-pci", pd.Name, id)
 
-	newIdFile, err := os.OpenFile("/sys/bus/pci/drivers/vfio-pci/new_id", os.O_WRONLY, 0200)
-	if err != nil {
-		return fmt.Errorf("error opening new_id file: %v", err)
-	}
-	defer newIdFile.Close()
-	_, err = newIdFile.WriteString(id)
-	if err != nil && !os.IsExist(err) {
+	if err := h.sysfs.addNewID(vfioPCIDriver, id); err != nil {
 		return fmt.Errorf("error writing to new_id file: %v", err)
 	}
 
-	logrus.Infof("Binding device %s vfio-pci", pd.Status.Address)
-	file, err := os.OpenFile("/sys/bus/pci/drivers/vfio-pci/bind", os.O_WRONLY, 0200)
-	if err != nil {
-		return fmt.Errorf("error opening bind file: %s", err)
-	}
-	_, err = file.WriteString(pd.Status.Address)
-	if err != nil {
-		file.Close()
-		return fmt.Errorf("error writing to bind file: %s", err)
+	// writing to new_id may already have bound the device
+	if !h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
+		logrus.Infof("Binding device %s vfio-pci", pd.Status.Address)
+		if err := h.sysfs.bind(vfioPCIDriver, pd.Status.Address); err != nil {
+			return fmt.Errorf("error writing to bind file: %s", err)
+		}
 	}
-	file.Close()
 
-	if !deviceBoundToDriver(vfioPCIDriverPath, pd.Status.Address) {
-		return fmt.Errorf("no device %s found at /sys/bus/pci/drivers/vfio-pci", pd.Status.Address)
+	if !h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
+		return fmt.Errorf("no device %s found at %s/%s", pd.Status.Address, sysfsPCIDriversPath, vfioPCIDriver)
 	}
 	return nil
 }
@@ -182,8 +320,8 @@ func bindDeviceToVFIOPCIDriver(pd *v1beta1.PCIDevice) error {
 // Enabling passthrough for a PCI Device requires two steps:
 // 1. Bind the device to the vfio-pci driver in the host
 // 2. Add device to DevicePlugin so KubeVirt will recognize it
-func (h Handler) enablePassthrough(pd *v1beta1.PCIDevice) error {
-	err := bindDeviceToVFIOPCIDriver(pd)
+func (h *Handler) enablePassthrough(pd *v1beta1.PCIDevice) error {
+	err := h.bindDeviceToVFIOPCIDriver(pd)
 	if err != nil {
 		return err
 	}
@@ -193,36 +331,86 @@ func (h Handler) enablePassthrough(pd *v1beta1.PCIDevice) error {
 	return err
 }
 
-// disablePassthrough will unbind and bind device to the original driver
-func (h *Handler) disablePassthrough(pd *v1beta1.PCIDevice) error {
-	err := unbindDeviceFromDriver(pd.Status.Address, vfioPCIDriver)
-	if err != nil {
+// disablePassthrough will reset the device, and unbind and bind device to the original driver. pdc is the
+// claim being released, if any
+func (h *Handler) disablePassthrough(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	// the device is reset while still bound to vfio-pci, so state left by the VM
+	// is not visible to the host driver or the next claim
+	if h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
+		var err error
+		pd, err = h.resetDevice(pd, pdc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := h.unbindDeviceFromVFIO(pd, pdc); err != nil {
 		return fmt.Errorf("failed unbinding driver: (%s)", err)
 	}
 
-	return h.bindDeviceToOriginalDriver(pd)
+	return h.bindDeviceToOriginalDriver(pd, pdc)
+}
+
+// unbindDeviceFromVFIO unbinds the device from vfio-pci, and records the operation if it was bound
+func (h *Handler) unbindDeviceFromVFIO(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	if !h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
+		return nil
+	}
+	op := h.audit.begin(v1beta1.OperationUnbind, pd, pdc)
+	op.PreviousDriver = vfioPCIDriver
+	err := h.unbindDeviceFromDriver(pd.Status.Address, vfioPCIDriver)
+	h.audit.record(op, err)
+	return err
+}
+
+// resetDevice resets the device and records the reset method in the device status. Devices which
+// fail to reset are marked unhealthy, and only an error updating the status is returned
+func (h *Handler) resetDevice(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDevice, error) {
+	op := h.audit.begin(v1beta1.OperationReset, pd, pdc)
+	method, resetErr := h.resetter.Reset(pd.Status.Address)
+	op.ResetMethod = method
+	h.audit.record(op, resetErr)
+
+	current, err := h.pdClient.Get(pd.Name, metav1.GetOptions{})
+	if err != nil {
+		return pd, fmt.Errorf("error fetching pcidevice %s: %v", pd.Name, err)
+	}
+	pdCopy := current.DeepCopy()
+
+	switch {
+	case resetErr == nil || errors.Is(resetErr, pcireset.ErrResetNotSupported):
+		if resetErr != nil {
+			logrus.Warnf("pcidevice %s does not support reset, state may be visible to the next user: %v", pd.Name, resetErr)
+		} else {
+			logrus.Infof("reset pcidevice %s using reset method %s", pd.Name, method)
+		}
+		pdCopy.Status.ResetMethod = method
+		if v1beta1.PCIDeviceHealthy.GetReason(pdCopy) == v1beta1.ResetFailedReason {
+			v1beta1.PCIDeviceHealthy.SetError(pdCopy, "", nil)
+		}
+	default:
+		logrus.Errorf("error resetting pcidevice %s, marking it unhealthy: %v", pd.Name, resetErr)
+		v1beta1.PCIDeviceHealthy.SetError(pdCopy, v1beta1.ResetFailedReason, resetErr)
+	}
+
+	if reflect.DeepEqual(current.Status, pdCopy.Status) {
+		return current, nil
+	}
+	return h.pdClient.UpdateStatus(pdCopy)
 }
 
 // This function unbinds the device with PCI Address addr from the given driver
 // NOTE: this function assumes that addr is on THIS NODE, only call for PCI addrs on this node
-func unbindDeviceFromDriver(addr string, driver string) error {
-	driverPath := fmt.Sprintf("/sys/bus/pci/drivers/%s", driver)
+func (h *Handler) unbindDeviceFromDriver(addr string, driver string) error {
 	// Check if device at addr is already bound to driver
-	if !deviceBoundToDriver(driverPath, addr) {
+	if !h.sysfs.boundToDriver(driver, addr) {
 		return nil
 	}
-	path := fmt.Sprintf("%s/unbind", driverPath)
-	file, err := os.OpenFile(path, os.O_WRONLY, 0200)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	_, err = file.WriteString(addr)
-	if err != nil {
+	if err := h.sysfs.unbind(driver, addr); err != nil {
 		return err
 	}
 
-	if deviceBoundToDriver(driverPath, addr) {
+	if h.sysfs.boundToDriver(driver, addr) {
 		return fmt.Errorf("device still bound to driver, will check again")
 	}
 	return nil
@@ -267,117 +455,192 @@ func (h *Handler) reconcilePCIDeviceClaims(name string, pdc *v1beta1.PCIDeviceCl
 	}
 
 	pdcCopy := pdc.DeepCopy()
-	// Get the PCIDevice object for the PCIDeviceClaim
-	pd, err := h.getPCIDeviceForClaim(pdc)
-	if pd == nil {
+
+	ready, err := h.reconcileLease(pdcCopy)
+	if err != nil {
 		return pdc, err
 	}
 
-	// Find the DevicePlugin
-	resourceName := pd.Status.ResourceName
-	dp := deviceplugins.Find(
-		resourceName,
-		h.devicePlugins,
-	)
+	blocked := !ready
+	if ready {
+		blocked, err = h.checkNodeMaintenance(pdcCopy)
+		if err != nil {
+			return pdc, err
+		}
+	}
+	if blocked {
+		if reflect.DeepEqual(pdc.Status, pdcCopy.Status) {
+			return pdc, nil
+		}
+		return h.pdcClient.UpdateStatus(pdcCopy)
+	}
 
-	if err := h.permitHostDeviceInKubeVirt(pd); err != nil {
-		return pdc, fmt.Errorf("error updating kubevirt CR: %v", err)
+	// Get the PCIDevice object for the PCIDeviceClaim
+	pd, err := h.getPCIDeviceForClaim(pdc)
+	if pd == nil {
+		return pdc, err
 	}
 
 	// Enable PCI Passthrough on the device by binding it to vfio-pci driver
-	err = h.attemptToEnablePassthrough(pd, pdc)
+	err = h.runDeviceOperation(pd, func() error {
+		if err := h.checkDeviceUsage(pd, pdcCopy); err != nil {
+			return err
+		}
+		if err := h.attemptToEnablePassthrough(pd, pdc); err != nil {
+			return err
+		}
+		// retry the reset of devices which failed to reset when they were last released
+		if v1beta1.PCIDeviceHealthy.GetReason(pd) == v1beta1.ResetFailedReason {
+			pd, err = h.resetDevice(pd, pdc)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
+		var inUseErr *inuse.Error
+		if errors.As(err, &inUseErr) && !reflect.DeepEqual(pdc.Status, pdcCopy.Status) {
+			if _, updateErr := h.pdcClient.UpdateStatus(pdcCopy); updateErr != nil {
+				return pdc, fmt.Errorf("error updating status for pcideviceclaim %s: %v", pdc.Name, updateErr)
+			}
+		}
 		return pdc, err
 	}
 
-	if dp == nil {
-		pds := []*v1beta1.PCIDevice{pd}
-		dp, err = h.createDevicePlugin(pds, pdc)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// Add the Device to the DevicePlugin
-		dp.AddDevice(pd, pdc)
+	if err := h.addDeviceToPlugin(pd, pdc); err != nil {
+		return nil, err
 	}
 
 	if !pdcCopy.Status.PassthroughEnabled {
 		pdcCopy.Status.PassthroughEnabled = true
 		pdcCopy.Status.KernelDriverToUnbind = pd.Status.KernelDriverInUse
+	}
+
+	if !reflect.DeepEqual(pdc.Status, pdcCopy.Status) {
 		return h.pdcClient.UpdateStatus(pdcCopy)
 	}
 
 	return pdc, nil
 }
 
-func (h *Handler) createDevicePlugin(
-	pds []*v1beta1.PCIDevice,
-	pdc *v1beta1.PCIDeviceClaim,
-) (*deviceplugins.PCIDevicePlugin, error) {
-	resourceName := pds[0].Status.ResourceName
-	logrus.Infof("Creating DevicePlugin: %s", resourceName)
-	dp := deviceplugins.Create(resourceName, pdc.Spec.Address, pds)
-	h.devicePlugins[resourceName] = dp
-	// Start the DevicePlugin
-	if pdc.Status.PassthroughEnabled && !dp.Started() {
-		err := h.startDevicePlugin(dp)
-		if err != nil {
-			return nil, err
-		}
+// checkNodeMaintenance blocks new claims while the node is in maintenance. Claims with passthrough
+// enabled are not affected, and are released by the node maintenance controller if requested
+func (h *Handler) checkNodeMaintenance(pdc *v1beta1.PCIDeviceClaim) (bool, error) {
+	if pdc.Status.PassthroughEnabled {
+		return false, nil
 	}
-	return dp, nil
+
+	node, err := h.nodeCache.Get(h.nodeName)
+	if err != nil {
+		return false, fmt.Errorf("error fetching node %s: %v", h.nodeName, err)
+	}
+
+	if v1beta1.NodeInMaintenance(node) {
+		logrus.Infof("node %s is in maintenance, blocking pdc %s", h.nodeName, pdc.Name)
+		v1beta1.PCIDeviceClaimNodeAvailable.SetError(pdc, v1beta1.NodeInMaintenanceReason,
+			fmt.Errorf("node %s is in maintenance, new claims are blocked", h.nodeName))
+		return true, nil
+	}
+
+	v1beta1.PCIDeviceClaimNodeAvailable.SetError(pdc, "", nil)
+	return false, nil
 }
 
-func (h *Handler) permitHostDeviceInKubeVirt(pd *v1beta1.PCIDevice) error {
-	logrus.Infof("Adding %s to KubeVirt list of permitted devices", pd.Name)
-	kv, err := h.virtClient.KubeVirt(DefaultNS).Get(KubevirtCR, &v1.GetOptions{})
+// OnNodeChange enqueues the claims of this node which are blocked by node maintenance
+func (h *Handler) OnNodeChange(_ string, name string, _ runtime.Object) ([]relatedresource.Key, error) {
+	if name != h.nodeName {
+		return nil, nil
+	}
+
+	pdcs, err := h.pdcClient.Cache().List(labels.Everything())
 	if err != nil {
-		msg := fmt.Sprintf("cannot obtain KubeVirt CR: %v", err)
-		return errors.New(msg)
+		return nil, fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+
+	var rr []relatedresource.Key
+	for _, pdc := range pdcs {
+		if pdc.Spec.NodeName == h.nodeName && v1beta1.PCIDeviceClaimNodeAvailable.IsFalse(pdc) {
+			rr = append(rr, relatedresource.NewKey("", pdc.Name))
+		}
+	}
+	return rr, nil
+}
+
+// checkDeviceUsage refuses to unbind a device from its host driver while the host is using it, unless
+// the claim sets ForceUnbind. The outcome is recorded in the DeviceAvailable condition of pdc
+func (h *Handler) checkDeviceUsage(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	// devices already bound to vfio-pci are not usable by the host
+	if h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
+		return nil
 	}
 
-	kvCopy := reconcileKubevirtCR(kv, pd)
-	if !reflect.DeepEqual(kv.Spec.Configuration.PermittedHostDevices, kvCopy.Spec.Configuration.PermittedHostDevices) {
-		_, err := h.virtClient.KubeVirt(DefaultNS).Update(kvCopy)
+	err := h.usageChecker.Check(pd.Status.Address)
+	var inUseErr *inuse.Error
+	if err != nil && !errors.As(err, &inUseErr) {
 		return err
 	}
 
+	switch {
+	case inUseErr == nil:
+		v1beta1.PCIDeviceClaimDeviceAvailable.SetError(pdc, "", nil)
+	case pdc.Spec.ForceUnbind:
+		logrus.Warnf("force unbinding device for pdc %s: %v", pdc.Name, inUseErr)
+		v1beta1.PCIDeviceClaimDeviceAvailable.SetError(pdc, v1beta1.ForceUnbindReason, nil)
+		v1beta1.PCIDeviceClaimDeviceAvailable.Message(pdc, inUseErr.Error())
+	default:
+		v1beta1.PCIDeviceClaimDeviceAvailable.SetError(pdc, v1beta1.DeviceInUseReason, inUseErr)
+		return inUseErr
+	}
 	return nil
 }
 
-func reconcileKubevirtCR(kvObj *kubevirtv1.KubeVirt, pd *v1beta1.PCIDevice) *kubevirtv1.KubeVirt {
-	kv := kvObj.DeepCopy()
-	if kv.Spec.Configuration.PermittedHostDevices == nil {
-		kv.Spec.Configuration.PermittedHostDevices = &kubevirtv1.PermittedHostDevices{
-			PciHostDevices: []kubevirtv1.PciHostDevice{},
+// addDeviceToPlugin adds the device to the DevicePlugin for its resourceName, creating the DevicePlugin if needed
+func (h *Handler) addDeviceToPlugin(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	h.pluginsLock.Lock()
+	defer h.pluginsLock.Unlock()
+
+	// Find the DevicePlugin
+	dp := deviceplugins.Find(
+		pd.Status.ResourceName,
+		h.devicePlugins,
+	)
+
+	if dp == nil {
+		var err error
+		pds := []*v1beta1.PCIDevice{pd}
+		dp, err = h.createDevicePlugin(pds, pdc)
+		if err != nil {
+			return err
 		}
+	} else if err := dp.AddDevice(pd, pdc); err != nil {
+		// Add the Device to the DevicePlugin
+		return err
 	}
-	permittedPCIDevices := kv.Spec.Configuration.PermittedHostDevices.PciHostDevices
-	resourceName := pd.Status.ResourceName
-	// check if device is currently permitted
-	var devPermitted bool = false
-	for i, permittedPCIDev := range permittedPCIDevices {
-		if permittedPCIDev.ResourceName == resourceName {
-			if permittedPCIDev.ExternalResourceProvider {
-				devPermitted = true
-			}
-			// remove device so it can be re-added
-			permittedPCIDevices = append(permittedPCIDevices[:i], permittedPCIDevices[i+1:]...)
-			break
-		}
+
+	// devices which failed to reset must not be handed to a VM
+	if resetFailed(pd) {
+		dp.MarkPCIDeviceAsUnhealthy(pd.Status.Address)
 	}
+	return nil
+}
 
-	if !devPermitted {
-		vendorId := pd.Status.VendorId
-		deviceId := pd.Status.DeviceId
-		devToPermit := kubevirtv1.PciHostDevice{
-			PCIVendorSelector:        fmt.Sprintf("%s:%s", vendorId, deviceId),
-			ResourceName:             resourceName,
-			ExternalResourceProvider: true,
+// createDevicePlugin must be called with pluginsLock held
+func (h *Handler) createDevicePlugin(
+	pds []*v1beta1.PCIDevice,
+	pdc *v1beta1.PCIDeviceClaim,
+) (*deviceplugins.PCIDevicePlugin, error) {
+	resourceName := pds[0].Status.ResourceName
+	logrus.Infof("Creating DevicePlugin: %s", resourceName)
+	dp := deviceplugins.Create(resourceName, pdc.Spec.Address, pds)
+	h.configureDevicePlugin(dp)
+	h.devicePlugins[resourceName] = dp
+	// Start the DevicePlugin
+	if pdc.Status.PassthroughEnabled && !dp.Started() {
+		err := h.startDevicePlugin(dp)
+		if err != nil {
+			return nil, err
 		}
-		kv.Spec.Configuration.PermittedHostDevices.PciHostDevices = append(permittedPCIDevices, devToPermit)
 	}
-	return kv
+	return dp, nil
 }
 
 func (h *Handler) getPCIDeviceForClaim(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDevice, error) {
@@ -401,40 +664,18 @@ func (h *Handler) startDevicePlugin(
 	if dp.Started() {
 		return nil
 	}
-	// Start the plugin
-	stop := make(chan struct{})
-	go func() {
-		err := dp.Start(stop)
-		if err != nil {
-			logrus.Errorf("error starting %s device plugin: %s", dp.GetDeviceName(), err)
-		}
-		// TODO: test if deleting this stops the DevicePlugin
-		<-stop
-	}()
-	dp.SetStarted(stop)
+	h.plugins.Start(dp)
 	return nil
 }
 
 func (h *Handler) attemptToEnablePassthrough(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
-	if !deviceBoundToDriver(vfioPCIDriverPath, pd.Status.Address) {
+	if !h.sysfs.boundToDriver(vfioPCIDriver, pd.Status.Address) {
 		logrus.Infof("Enabling passthrough for PDC: %s", pdc.Name)
-		// Only unbind from driver is a driver is currently in use
-		if strings.TrimSpace(pd.Status.KernelDriverInUse) != "" {
-			err := unbindDeviceFromDriver(pd.Status.Address, pd.Status.KernelDriverInUse)
-			if err != nil {
-				return err
-			}
-		}
-
-		originalDriver, ok := pd.Annotations[v1beta1.PciDeviceDriver]
-		if ok {
-			err := unbindDeviceFromDriver(pd.Status.Address, originalDriver)
-			if err != nil {
-				return err
-			}
-		}
-		// Enable PCI Passthrough by binding the device to the vfio-pci driver
-		err := h.enablePassthrough(pd)
+		op := h.audit.begin(v1beta1.OperationBind, pd, pdc)
+		op.PreviousDriver = pd.Status.KernelDriverInUse
+		op.NewDriver = vfioPCIDriver
+		err := h.bindDeviceForPassthrough(pd)
+		h.audit.record(op, err)
 		if err != nil {
 			return err
 		}
@@ -445,6 +686,27 @@ func (h *Handler) attemptToEnablePassthrough(pd *v1beta1.PCIDevice, pdc *v1beta1
 
 }
 
+// bindDeviceForPassthrough unbinds the device from its host driver, and binds it to vfio-pci
+func (h *Handler) bindDeviceForPassthrough(pd *v1beta1.PCIDevice) error {
+	// Only unbind from driver is a driver is currently in use
+	if strings.TrimSpace(pd.Status.KernelDriverInUse) != "" {
+		err := h.unbindDeviceFromDriver(pd.Status.Address, pd.Status.KernelDriverInUse)
+		if err != nil {
+			return err
+		}
+	}
+
+	originalDriver, ok := pd.Annotations[v1beta1.PciDeviceDriver]
+	if ok {
+		err := h.unbindDeviceFromDriver(pd.Status.Address, originalDriver)
+		if err != nil {
+			return err
+		}
+	}
+	// Enable PCI Passthrough by binding the device to the vfio-pci driver
+	return h.enablePassthrough(pd)
+}
+
 func (h *Handler) unbindOrphanedPCIDevices() error {
 	pdcs, err := h.pdcClient.List(metav1.ListOptions{})
 	if err != nil {
@@ -458,8 +720,11 @@ func (h *Handler) unbindOrphanedPCIDevices() error {
 	if err != nil {
 		return err
 	}
-	for _, pd := range orphanedPCIDevices.Items {
-		unbindDeviceFromDriver(pd.Status.Address, vfioPCIDriver)
+	for i := range orphanedPCIDevices.Items {
+		pd := &orphanedPCIDevices.Items[i]
+		_ = h.runDeviceOperation(pd, func() error {
+			return h.unbindDeviceFromVFIO(pd, nil)
+		})
 	}
 	return nil
 }
@@ -488,7 +753,7 @@ func (h *Handler) OnDeviceChange(_ string, _ string, obj runtime.Object) ([]rela
 	return nil, nil
 }
 
-func (h *Handler) bindDeviceToOriginalDriver(pd *v1beta1.PCIDevice) error {
+func (h *Handler) bindDeviceToOriginalDriver(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
 	address := pd.Status.Address
 	orgDriver, ok := pd.Annotations[v1beta1.PciDeviceDriver]
 
@@ -503,18 +768,14 @@ func (h *Handler) bindDeviceToOriginalDriver(pd *v1beta1.PCIDevice) error {
 	}
 
 	logrus.Debugf("Binding device %s [%s] to %s", pd.Name, address, orgDriver)
-	file, err := os.OpenFile(fmt.Sprintf("/sys/bus/pci/drivers/%s/bind", orgDriver), os.O_WRONLY, 0200)
-	if err != nil {
-		logrus.Errorf("Error opening bind file: %s", err)
-		return err
-	}
-	_, err = file.WriteString(address)
+	op := h.audit.begin(v1beta1.OperationRestoreDriver, pd, pdc)
+	op.NewDriver = orgDriver
+	err := h.sysfs.bind(orgDriver, address)
+	h.audit.record(op, err)
 	if err != nil {
 		logrus.Errorf("Error writing to bind file: %s", err)
-		file.Close()
 		return err
 	}
-	file.Close()
 	pdCopy := pd.DeepCopy()
 
 	// update to reflect the original driver
@@ -522,11 +783,3 @@ func (h *Handler) bindDeviceToOriginalDriver(pd *v1beta1.PCIDevice) error {
 	_, err = h.pdClient.UpdateStatus(pdCopy)
 	return err
 }
-
-func deviceBoundToDriver(driverPath string, pciAddress string) bool {
-	_, err := os.Stat(fmt.Sprintf("%s/%s", driverPath, pciAddress))
-	if err != nil {
-		return false
-	}
-	return true
-}
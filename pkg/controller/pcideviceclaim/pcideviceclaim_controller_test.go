@@ -1,13 +1,23 @@
 package pcideviceclaim
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
-	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
 	"github.com/stretchr/testify/require"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	kubevirtv1 "kubevirt.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+	"github.com/harvester/pcidevices/pkg/util/inuse"
+	"github.com/harvester/pcidevices/pkg/util/pcireset"
 )
 
 func TestHandler_getOrphanedPCIDevices(t *testing.T) {
@@ -17,7 +27,7 @@ func TestHandler_getOrphanedPCIDevices(t *testing.T) {
 		pds      *v1beta1.PCIDeviceList
 	}
 	orphanpd := v1beta1.PCIDevice{
-		ObjectMeta: v1.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "testnode1-00003f062",
 			UID:  "450a6607-b836-46fe-9ced-c23cb2cfdef0",
 		},
@@ -28,7 +38,7 @@ func TestHandler_getOrphanedPCIDevices(t *testing.T) {
 		},
 	}
 	pd := v1beta1.PCIDevice{
-		ObjectMeta: v1.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "testnode1-00003f063",
 		},
 		Status: v1beta1.PCIDeviceStatus{
@@ -38,10 +48,10 @@ func TestHandler_getOrphanedPCIDevices(t *testing.T) {
 		},
 	}
 	pdc := v1beta1.PCIDeviceClaim{
-		ObjectMeta: v1.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "testnode1-00003f063",
-			OwnerReferences: []v1.OwnerReference{
-				v1.OwnerReference{
+			OwnerReferences: []metav1.OwnerReference{
+				metav1.OwnerReference{
 					Kind: "PCIDevice",
 					Name: "testnode1-00003f063",
 					UID:  pd.GetObjectMeta().GetUID(),
@@ -105,62 +115,217 @@ func TestHandler_getOrphanedPCIDevices(t *testing.T) {
 	}
 }
 
-var (
-	pd = &v1beta1.PCIDevice{
-		ObjectMeta: v1.ObjectMeta{
-			Name: "testnode1-00003f063",
+// fakeUsageChecker reports the configured usages for every device
+type fakeUsageChecker struct {
+	usages []string
+	err    error
+}
+
+func (f fakeUsageChecker) Check(address string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if len(f.usages) == 0 {
+		return nil
+	}
+	return &inuse.Error{Address: address, Usages: f.usages}
+}
+
+func Test_CheckDeviceUsage(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		checker         fakeUsageChecker
+		forceUnbind     bool
+		boundToVFIO     bool
+		expectError     bool
+		expectStatus    string
+		expectReason    string
+		expectCondition bool
+	}{
+		{
+			name:            "device not in use",
+			checker:         fakeUsageChecker{},
+			expectStatus:    "True",
+			expectCondition: true,
 		},
-		Status: v1beta1.PCIDeviceStatus{
-			Address:           "0000:3f:06.3",
-			KernelDriverInUse: "vfio-pci",
-			NodeName:          "testnode1",
-			ResourceName:      "intel.com/82571EB_82571GB_GIGABIT_ETHERNET_CONTROLLER_COPPER",
-			VendorId:          "8086",
-			DeviceId:          "10bc",
+		{
+			name:            "device in use is refused",
+			checker:         fakeUsageChecker{usages: []string{"network interface eth1 is up"}},
+			expectError:     true,
+			expectStatus:    "False",
+			expectReason:    v1beta1.DeviceInUseReason,
+			expectCondition: true,
+		},
+		{
+			name:            "device in use is unbound with force flag",
+			checker:         fakeUsageChecker{usages: []string{"block device nvme0n1 is mounted at /"}},
+			forceUnbind:     true,
+			expectStatus:    "True",
+			expectReason:    v1beta1.ForceUnbindReason,
+			expectCondition: true,
+		},
+		{
+			name:        "checker failure is not reported as usage",
+			checker:     fakeUsageChecker{err: errors.New("permission denied")},
+			expectError: true,
+		},
+		{
+			name:        "device already bound to vfio-pci is not checked",
+			checker:     fakeUsageChecker{usages: []string{"device is the boot VGA device"}},
+			boundToVFIO: true,
 		},
 	}
 
-	kubevirtCR = &kubevirtv1.KubeVirt{
-		ObjectMeta: v1.ObjectMeta{
-			Name:      "kubevirt",
-			Namespace: "harvester-system",
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			pd, pdc := newDeviceAndClaim("node1", 0, "89")
+			pdc.Spec.ForceUnbind = v.forceUnbind
+			sysfs := newFakeSysfs()
+			driver := pd.Status.KernelDriverInUse
+			if v.boundToVFIO {
+				driver = vfioPCIDriver
+			}
+			sysfs.addDevice(pd.Status.Address, pd.Status.IOMMUGroup, driver)
+			h := &Handler{
+				sysfs:        sysfs,
+				usageChecker: v.checker,
+			}
+
+			err := h.checkDeviceUsage(pd, pdc)
+			if v.expectError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			if !v.expectCondition {
+				assert.Empty(pdc.Status.Conditions, "expected no condition to be set")
+				return
+			}
+			assert.Equal(v.expectStatus, v1beta1.PCIDeviceClaimDeviceAvailable.GetStatus(pdc))
+			assert.Equal(v.expectReason, v1beta1.PCIDeviceClaimDeviceAvailable.GetReason(pdc))
+			if len(v.checker.usages) > 0 {
+				assert.Contains(v1beta1.PCIDeviceClaimDeviceAvailable.GetMessage(pdc), v.checker.usages[0])
+			}
+		})
+	}
+}
+
+// fakeResetter records the devices it resets, and fails resets if err is set
+type fakeResetter struct {
+	lock   sync.Mutex
+	method string
+	err    error
+	resets []string
+}
+
+func (f *fakeResetter) Reset(address string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.resets = append(f.resets, address)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.method, nil
+}
+
+func Test_ResetOnRelease(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		resetter      *fakeResetter
+		expectMethod  string
+		expectHealthy string
+	}{
+		{
+			name:          "device is reset",
+			resetter:      &fakeResetter{method: "flr"},
+			expectMethod:  "flr",
+			expectHealthy: "",
 		},
-		Spec: kubevirtv1.KubeVirtSpec{
-			Configuration: kubevirtv1.KubeVirtConfiguration{},
+		{
+			name:          "device without reset support is released",
+			resetter:      &fakeResetter{err: fmt.Errorf("error resetting device: %w", pcireset.ErrResetNotSupported)},
+			expectHealthy: "",
 		},
+		{
+			name:          "device failing reset is unhealthy",
+			resetter:      &fakeResetter{err: errors.New("device busy")},
+			expectHealthy: "False",
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			client := fake.NewSimpleClientset()
+			pd, _ := newDeviceAndClaim("node1", 0, "89")
+			pd.Status.KernelDriverInUse = vfioPCIDriver
+			_, err := client.DevicesV1beta1().PCIDevices().Create(context.TODO(), pd, metav1.CreateOptions{})
+			assert.NoError(err)
+			sysfs := newFakeSysfs()
+			sysfs.addDevice(pd.Status.Address, pd.Status.IOMMUGroup, vfioPCIDriver)
+			h := &Handler{
+				pdClient: fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+				sysfs:    sysfs,
+				resetter: v.resetter,
+			}
+
+			assert.NoError(h.disablePassthrough(pd, nil))
+			assert.Equal([]string{pd.Status.Address}, v.resetter.resets, "expected device to be reset once")
+			assert.Equal("ixgbevf", sysfs.driver(pd.Status.Address), "expected device to be bound to original driver")
+
+			pdObj, err := client.DevicesV1beta1().PCIDevices().Get(context.TODO(), pd.Name, metav1.GetOptions{})
+			assert.NoError(err)
+			assert.Equal(v.expectMethod, pdObj.Status.ResetMethod)
+			assert.Equal(v.expectHealthy, v1beta1.PCIDeviceHealthy.GetStatus(pdObj))
+			assert.Equal("ixgbevf", pdObj.Status.KernelDriverInUse, "expected status update after reset to be preserved")
+
+			// a successful reset on the next attempt clears the failure
+			v.resetter.err = nil
+			v.resetter.method = "bus"
+			pdObj, err = h.resetDevice(pdObj, nil)
+			assert.NoError(err)
+			assert.Equal("bus", pdObj.Status.ResetMethod)
+			assert.NotEqual("False", v1beta1.PCIDeviceHealthy.GetStatus(pdObj), "expected device to no longer be unhealthy")
+		})
 	}
+}
 
-	permittedHostDevices = &kubevirtv1.PermittedHostDevices{
-		PciHostDevices: []kubevirtv1.PciHostDevice{
-			{
-				PCIVendorSelector: "8086:10bc",
-				ResourceName:      "intel.com/82571EB_82571GB_GIGABIT_ETHERNET_CONTROLLER_COPPER",
+func Test_NodeMaintenanceBlocksNewClaims(t *testing.T) {
+	assert := require.New(t)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				v1beta1.MaintenanceAnnotation: "true",
 			},
 		},
 	}
-)
+	k8sclient := k8sfake.NewSimpleClientset(node)
+	h := &Handler{
+		nodeName:  "node1",
+		nodeCache: fakeclients.NodeCache(k8sclient.CoreV1().Nodes),
+	}
 
-func Test_permitHostDeviceInKubevirtWithNoDevices(t *testing.T) {
-	assert := require.New(t)
-	kvCopy := reconcileKubevirtCR(kubevirtCR, pd)
-	assert.Len(kvCopy.Spec.Configuration.PermittedHostDevices.PciHostDevices, 1, "expected to find one device added")
-}
+	_, pdc := newDeviceAndClaim("node1", 0, "89")
+	blocked, err := h.checkNodeMaintenance(pdc)
+	assert.NoError(err)
+	assert.True(blocked, "expected new claim to be blocked")
+	assert.True(v1beta1.PCIDeviceClaimNodeAvailable.IsFalse(pdc))
+	assert.Equal(v1beta1.NodeInMaintenanceReason, v1beta1.PCIDeviceClaimNodeAvailable.GetReason(pdc))
 
-func Test_permitHostDeviceInKubevirtWithoutExternalResourceDevices(t *testing.T) {
-	assert := require.New(t)
-	kubevirtCR.Spec.Configuration.PermittedHostDevices = permittedHostDevices
-	kvCopy := reconcileKubevirtCR(kubevirtCR, pd)
-	assert.False(reflect.DeepEqual(kvCopy.Spec.Configuration.PermittedHostDevices, kubevirtCR.Spec.Configuration.PermittedHostDevices), "expected to find changes in the kubevirt CR")
-	assert.Len(kvCopy.Spec.Configuration.PermittedHostDevices.PciHostDevices, 1, "expected to find one device added")
-	assert.True(kvCopy.Spec.Configuration.PermittedHostDevices.PciHostDevices[0].ExternalResourceProvider, "expected external resource provider to be updated")
-}
+	_, enabled := newDeviceAndClaim("node1", 1, "89")
+	enabled.Status.PassthroughEnabled = true
+	blocked, err = h.checkNodeMaintenance(enabled)
+	assert.NoError(err)
+	assert.False(blocked, "expected claim with passthrough enabled to not be blocked")
 
-func Test_permitHostDeviceInKubevirtWithExternalResourceDevices(t *testing.T) {
-	assert := require.New(t)
-	permittedHostDevices.PciHostDevices[0].ExternalResourceProvider = true
-	kubevirtCR.Spec.Configuration.PermittedHostDevices = permittedHostDevices
-	kvCopy := reconcileKubevirtCR(kubevirtCR, pd)
-	assert.True(reflect.DeepEqual(kvCopy.Spec.Configuration.PermittedHostDevices, kubevirtCR.Spec.Configuration.PermittedHostDevices), "expected to find no changes in the kubevirt CR")
-	assert.Len(kvCopy.Spec.Configuration.PermittedHostDevices.PciHostDevices, 1, "expected to find one device added")
-	assert.True(kvCopy.Spec.Configuration.PermittedHostDevices.PciHostDevices[0].ExternalResourceProvider, "expected external resource provider to be updated")
+	node.Annotations = nil
+	_, err = k8sclient.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+	assert.NoError(err)
+	blocked, err = h.checkNodeMaintenance(pdc)
+	assert.NoError(err)
+	assert.False(blocked, "expected claim to be unblocked once maintenance ends")
+	assert.True(v1beta1.PCIDeviceClaimNodeAvailable.IsTrue(pdc))
 }
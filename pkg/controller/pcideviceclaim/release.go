@@ -0,0 +1,244 @@
+package pcideviceclaim
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/relatedresource"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/util/vmusage"
+)
+
+// releaseBlocked checks if a deleted claim must keep the device bound to vfio-pci, as unbinding a device
+// while a VM has it open crashes the guest. The VMs blocking the release are recorded in the Releasing
+// condition, unless the claim is force released
+func (h *Handler) releaseBlocked(pdc *v1beta1.PCIDeviceClaim) (bool, error) {
+	vmsByClaim, err := vmusage.VMsUsingClaims(h.vmiCache, h.pdCache, pdc)
+	if err != nil {
+		return false, err
+	}
+	vmis := vmsByClaim[pdc.Name]
+	sort.Strings(vmis)
+	if len(vmis) == 0 {
+		return false, nil
+	}
+
+	if v1beta1.ForceRelease(pdc) {
+		logrus.Warnf("force releasing pcideviceclaim %s, which is still in use by vmis %s", pdc.Name, strings.Join(vmis, ","))
+		h.recorder.Eventf(pdc, corev1.EventTypeWarning, "ForceReleased", "Device released while in use by VMs %s", strings.Join(vmis, ", "))
+		return false, nil
+	}
+
+	pdcCopy := pdc.DeepCopy()
+	v1beta1.PCIDeviceClaimReleasing.True(pdcCopy)
+	v1beta1.PCIDeviceClaimReleasing.Reason(pdcCopy, v1beta1.InUseByVMReason)
+	v1beta1.PCIDeviceClaimReleasing.Message(pdcCopy, fmt.Sprintf("waiting for VMs %s to stop", strings.Join(vmis, ", ")))
+	if !reflect.DeepEqual(pdc.Status, pdcCopy.Status) {
+		h.recorder.Eventf(pdc, corev1.EventTypeWarning, "ReleaseBlocked", "Device is not released while in use by VMs %s", strings.Join(vmis, ", "))
+		if _, err := h.pdcClient.UpdateStatus(pdcCopy); err != nil {
+			return true, fmt.Errorf("error updating status of pcideviceclaim %s: %v", pdc.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// releaseClaimOfUnavailableNode removes the finalizer of a deleted claim of another node which is
+// unavailable, as that node cannot unbind the device. Claims of available nodes are skipped
+func (h *Handler) releaseClaimOfUnavailableNode(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	unavailable, err := h.nodeUnavailable(pdc.Spec.NodeName)
+	if err != nil || !unavailable {
+		return pdc, skipUnlessError(err)
+	}
+
+	if err := h.enqueueClaimsForSameDevice(pdc); err != nil {
+		return pdc, err
+	}
+
+	if pdc.Status.PassthroughEnabled {
+		blocked, err := h.releaseBlocked(pdc)
+		if err != nil {
+			return pdc, err
+		}
+		if blocked {
+			return pdc, fmt.Errorf("pcideviceclaim %s of unavailable node %s is still in use by running vms", pdc.Name, pdc.Spec.NodeName)
+		}
+	}
+
+	h.recordUnavailableNodeRelease(pdc, "pcideviceclaim "+pdc.Name, pdc.Spec.NodeName)
+	return pdc, nil
+}
+
+// OnDeviceRemove keeps deleted PCIDevices until the VMs using the device stop, as deleting a device
+// cascades to its claims, which then unbind the device
+func (h *Handler) OnDeviceRemove(_ string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	if pd == nil || pd.DeletionTimestamp == nil {
+		return pd, nil
+	}
+
+	// only the node of the device removes the finalizer, unless the node is unavailable
+	if pd.Status.NodeName != h.nodeName {
+		unavailable, err := h.nodeUnavailable(pd.Status.NodeName)
+		if err != nil || !unavailable {
+			return pd, skipUnlessError(err)
+		}
+	}
+
+	vmsByDevice, err := vmusage.VMsUsingDevices(h.vmiCache, h.pdCache, pd)
+	if err != nil {
+		return pd, err
+	}
+	vmis := vmsByDevice[pd.Name]
+	sort.Strings(vmis)
+	if len(vmis) == 0 {
+		h.recordUnavailableNodeRelease(pd, "pcidevice "+pd.Name, pd.Status.NodeName)
+		return pd, nil
+	}
+
+	if v1beta1.ForceRelease(pd) {
+		logrus.Warnf("force removing pcidevice %s, which is still in use by vmis %s", pd.Name, strings.Join(vmis, ","))
+		h.recorder.Eventf(pd, corev1.EventTypeWarning, "ForceReleased", "Device removed while in use by VMs %s", strings.Join(vmis, ", "))
+		h.recordUnavailableNodeRelease(pd, "pcidevice "+pd.Name, pd.Status.NodeName)
+		return pd, nil
+	}
+
+	pdCopy := pd.DeepCopy()
+	v1beta1.PCIDeviceReleasing.True(pdCopy)
+	v1beta1.PCIDeviceReleasing.Reason(pdCopy, v1beta1.InUseByVMReason)
+	v1beta1.PCIDeviceReleasing.Message(pdCopy, fmt.Sprintf("waiting for VMs %s to stop", strings.Join(vmis, ", ")))
+	if !reflect.DeepEqual(pd.Status, pdCopy.Status) {
+		h.recorder.Eventf(pd, corev1.EventTypeWarning, "ReleaseBlocked", "Device is not removed while in use by VMs %s", strings.Join(vmis, ", "))
+		if _, err := h.pdClient.UpdateStatus(pdCopy); err != nil {
+			return pd, fmt.Errorf("error updating status of pcidevice %s: %v", pd.Name, err)
+		}
+	}
+	return pd, fmt.Errorf("pcidevice %s is still in use by vmis %s", pd.Name, strings.Join(vmis, ","))
+}
+
+// nodeUnavailable checks if a node is gone, or its kubelet is not Ready. The claims and devices of such
+// a node are released by the other nodes, as the node itself cannot remove their finalizers
+func (h *Handler) nodeUnavailable(nodeName string) (bool, error) {
+	node, err := h.nodeCache.Get(nodeName)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error fetching node %s: %v", nodeName, err)
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status != corev1.ConditionTrue, nil
+		}
+	}
+	return true, nil
+}
+
+// releasedByThisNode checks if this node removes the finalizers of the claims and devices of nodeName
+func (h *Handler) releasedByThisNode(nodeName string) (bool, error) {
+	if nodeName == h.nodeName {
+		return true, nil
+	}
+	return h.nodeUnavailable(nodeName)
+}
+
+// recordUnavailableNodeRelease records the release of a claim or device on behalf of its unavailable node.
+// The device stays bound to vfio-pci until the controller of its node starts again, and unbinds the
+// devices which are no longer claimed
+func (h *Handler) recordUnavailableNodeRelease(obj runtime.Object, description, nodeName string) {
+	if nodeName == h.nodeName {
+		return
+	}
+	logrus.Warnf("releasing %s of unavailable node %s", description, nodeName)
+	h.recorder.Eventf(obj, corev1.EventTypeWarning, "NodeUnavailable", "Released by node %s, as node %s is unavailable", h.nodeName, nodeName)
+}
+
+// skipUnlessError keeps the finalizer for another node to remove, unless checking the node failed
+func skipUnlessError(err error) error {
+	if err != nil {
+		return err
+	}
+	return generic.ErrSkip
+}
+
+// OnVMIChangeReleaseClaims reconciles the deleted claims released by this node, which may wait for the VMI to stop
+func (h *Handler) OnVMIChangeReleaseClaims(_ string, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	if _, ok := obj.(*kubevirtv1.VirtualMachineInstance); !ok {
+		return nil, nil
+	}
+	return h.deletedClaimsReleasedByThisNode(func(string) bool { return true })
+}
+
+// OnVMIChangeReleaseDevices reconciles the deleted devices released by this node, which may wait for the VMI to stop
+func (h *Handler) OnVMIChangeReleaseDevices(_ string, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	if _, ok := obj.(*kubevirtv1.VirtualMachineInstance); !ok {
+		return nil, nil
+	}
+	return h.deletedDevicesReleasedByThisNode(func(string) bool { return true })
+}
+
+// OnNodeChangeReleaseClaims reconciles the deleted claims of another node once it becomes unavailable
+func (h *Handler) OnNodeChangeReleaseClaims(_ string, name string, _ runtime.Object) ([]relatedresource.Key, error) {
+	if name == h.nodeName {
+		return nil, nil
+	}
+	return h.deletedClaimsReleasedByThisNode(func(nodeName string) bool { return nodeName == name })
+}
+
+// OnNodeChangeReleaseDevices reconciles the deleted devices of another node once it becomes unavailable
+func (h *Handler) OnNodeChangeReleaseDevices(_ string, name string, _ runtime.Object) ([]relatedresource.Key, error) {
+	if name == h.nodeName {
+		return nil, nil
+	}
+	return h.deletedDevicesReleasedByThisNode(func(nodeName string) bool { return nodeName == name })
+}
+
+func (h *Handler) deletedClaimsReleasedByThisNode(matchNode func(string) bool) ([]relatedresource.Key, error) {
+	pdcs, err := h.pdcClient.Cache().List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+	var rr []relatedresource.Key
+	for _, pdc := range pdcs {
+		if pdc.DeletionTimestamp == nil || !matchNode(pdc.Spec.NodeName) {
+			continue
+		}
+		released, err := h.releasedByThisNode(pdc.Spec.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		if released {
+			rr = append(rr, relatedresource.NewKey("", pdc.Name))
+		}
+	}
+	return rr, nil
+}
+
+func (h *Handler) deletedDevicesReleasedByThisNode(matchNode func(string) bool) ([]relatedresource.Key, error) {
+	pds, err := h.pdCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	var rr []relatedresource.Key
+	for _, pd := range pds {
+		if pd.DeletionTimestamp == nil || !matchNode(pd.Status.NodeName) {
+			continue
+		}
+		released, err := h.releasedByThisNode(pd.Status.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		if released {
+			rr = append(rr, relatedresource.NewKey("", pd.Name))
+		}
+	}
+	return rr, nil
+}
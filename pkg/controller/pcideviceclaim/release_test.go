@@ -0,0 +1,215 @@
+package pcideviceclaim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// newRunningVMI returns a VMI running on node1, which requests a host device for each of resourceNames
+func newRunningVMI(namespace, name string, resourceNames ...string) *kubevirtv1.VirtualMachineInstance {
+	vmi := &kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Status: kubevirtv1.VirtualMachineInstanceStatus{
+			NodeName: "node1",
+			Phase:    kubevirtv1.Running,
+		},
+	}
+	for idx, resourceName := range resourceNames {
+		vmi.Spec.Domain.Devices.HostDevices = append(vmi.Spec.Domain.Devices.HostDevices, kubevirtv1.HostDevice{
+			Name:       fmt.Sprintf("hostdevice%d", idx),
+			DeviceName: resourceName,
+		})
+	}
+	return vmi
+}
+
+// newReadyNode returns a node with the Ready condition set to ready
+func newReadyNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{
+					Type:   corev1.NodeReady,
+					Status: status,
+				},
+			},
+		},
+	}
+}
+
+func newDeletedClaim() *v1beta1.PCIDeviceClaim {
+	_, pdc := newDeviceAndClaim("node1", 0, "89")
+	pdc.Status.PassthroughEnabled = true
+	now := metav1.Now()
+	pdc.DeletionTimestamp = &now
+	return pdc
+}
+
+func Test_RemoveRefusedWhileVMIRunning(t *testing.T) {
+	assert := require.New(t)
+	vmi := newRunningVMI("default", "vm1")
+	pdc := newDeletedClaim()
+	pdc.Status.UsedBy = &v1beta1.DeviceUsage{
+		Pod:                    "default/virt-launcher-vm1-abcde",
+		Container:              "compute",
+		VirtualMachineInstance: "default/vm1",
+	}
+	client := fake.NewSimpleClientset(pdc)
+	h, _, recorder := newLeaseHandler(client, vmi)
+
+	_, err := h.OnRemove(pdc.Name, pdc)
+	assert.Error(err, "expected removal to be refused while the vmi is running")
+	updated, err := client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), pdc.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.True(v1beta1.PCIDeviceClaimReleasing.IsTrue(updated))
+	assert.Equal(v1beta1.InUseByVMReason, v1beta1.PCIDeviceClaimReleasing.GetReason(updated))
+	assert.Equal("waiting for VMs default/vm1 to stop", v1beta1.PCIDeviceClaimReleasing.GetMessage(updated))
+	assert.Len(recorder.Events, 1, "expected release blocked event")
+
+	vmi.Status.Phase = kubevirtv1.Succeeded
+	blocked, err := h.releaseBlocked(pdc)
+	assert.NoError(err)
+	assert.False(blocked, "expected stopped vmi to not hold the device")
+}
+
+func Test_RemoveRefusedWhileRequestedByVMI(t *testing.T) {
+	assert := require.New(t)
+	pd, _ := newDeviceAndClaim("node1", 0, "89")
+	pdc := newDeletedClaim()
+	client := fake.NewSimpleClientset(pd, pdc)
+	otherNode := newRunningVMI("default", "vm3", pd.Status.ResourceName)
+	otherNode.Status.NodeName = "node2"
+	h, _, _ := newLeaseHandler(client, newRunningVMI("default", "vm1", pd.Status.ResourceName), newRunningVMI("default", "vm2"), otherNode)
+
+	blocked, err := h.releaseBlocked(pdc)
+	assert.NoError(err)
+	assert.True(blocked, "expected claim requested by a running vmi on the node to not be released")
+	updated, err := client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), pdc.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.Equal("waiting for VMs default/vm1 to stop", v1beta1.PCIDeviceClaimReleasing.GetMessage(updated),
+		"expected vmis on other nodes or without the resourceName to not hold the device")
+
+	pdc.Annotations = map[string]string{v1beta1.ForceReleaseAnnotation: "true"}
+	blocked, err = h.releaseBlocked(pdc)
+	assert.NoError(err)
+	assert.False(blocked, "expected force released claim to be released")
+}
+
+func Test_RemoveOnOtherNodeIsSkipped(t *testing.T) {
+	assert := require.New(t)
+	pdc := newDeletedClaim()
+	pdc.Spec.NodeName = "node2"
+	h, _, _ := newLeaseHandler(fake.NewSimpleClientset(pdc))
+
+	_, err := h.OnRemove(pdc.Name, pdc)
+	assert.ErrorIs(err, generic.ErrSkip, "expected other nodes to keep the finalizer")
+}
+
+func Test_DeviceRemoveRefusedWhileInUse(t *testing.T) {
+	assert := require.New(t)
+	pd, pdc := newDeviceAndClaim("node1", 0, "89")
+	now := metav1.Now()
+	pd.DeletionTimestamp = &now
+	client := fake.NewSimpleClientset(pd, pdc)
+	h, _, _ := newLeaseHandler(client, newRunningVMI("default", "vm1", pd.Status.ResourceName))
+	h.pdClient = fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices)
+
+	_, err := h.OnDeviceRemove(pd.Name, pd)
+	assert.Error(err, "expected removal of device in use to be refused")
+	updated, err := client.DevicesV1beta1().PCIDevices().Get(context.TODO(), pd.Name, metav1.GetOptions{})
+	assert.NoError(err)
+	assert.True(v1beta1.PCIDeviceReleasing.IsTrue(updated))
+	assert.Equal(v1beta1.InUseByVMReason, v1beta1.PCIDeviceReleasing.GetReason(updated))
+
+	pd.Annotations[v1beta1.ForceReleaseAnnotation] = "true"
+	_, err = h.OnDeviceRemove(pd.Name, pd)
+	assert.NoError(err, "expected force released device to be removed")
+
+	pd.Status.NodeName = "node2"
+	_, err = h.OnDeviceRemove(pd.Name, pd)
+	assert.ErrorIs(err, generic.ErrSkip, "expected other nodes to keep the finalizer")
+}
+
+func Test_RemoveOnUnavailableNode(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		nodes []runtime.Object
+	}{
+		{
+			name:  "node not ready",
+			nodes: []runtime.Object{newReadyNode("node1", true), newReadyNode("node2", false)},
+		},
+		{
+			name:  "node gone",
+			nodes: []runtime.Object{newReadyNode("node1", true)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+			pd, pdc := newDeviceAndClaim("node2", 0, "89")
+			now := metav1.Now()
+			pd.DeletionTimestamp = &now
+			pdc.DeletionTimestamp = &now
+			pdc.Status.PassthroughEnabled = true
+			client := fake.NewSimpleClientset(pd, pdc)
+			vmi := newRunningVMI("default", "vm1", pd.Status.ResourceName)
+			vmi.Status.NodeName = "node2"
+			h, _, recorder := newLeaseHandler(client, vmi)
+			h.pdClient = fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices)
+			h.nodeCache = fakeclients.NodeCache(k8sfake.NewSimpleClientset(tc.nodes...).CoreV1().Nodes)
+
+			keys, err := h.OnNodeChangeReleaseClaims("", "node2", nil)
+			assert.NoError(err)
+			assert.Len(keys, 1, "expected deleted claim of unavailable node to be enqueued")
+			keys, err = h.OnNodeChangeReleaseDevices("", "node2", nil)
+			assert.NoError(err)
+			assert.Len(keys, 1, "expected deleted device of unavailable node to be enqueued")
+
+			_, err = h.OnRemove(pdc.Name, pdc)
+			assert.Error(err, "expected release to wait for the vmi using the device")
+			assert.NotErrorIs(err, generic.ErrSkip)
+			_, err = h.OnDeviceRemove(pd.Name, pd)
+			assert.Error(err, "expected removal to wait for the vmi using the device")
+			assert.NotErrorIs(err, generic.ErrSkip)
+
+			h.vmiCache = fakeclients.VirtualMachineInstanceCache(nil)
+			_, err = h.OnRemove(pdc.Name, pdc)
+			assert.NoError(err, "expected claim of unavailable node to be released by another node")
+			_, err = h.OnDeviceRemove(pd.Name, pd)
+			assert.NoError(err, "expected device of unavailable node to be removed by another node")
+			var released int
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, "NodeUnavailable") {
+					released++
+				}
+			}
+			assert.Equal(2, released, "expected the release of the claim and device to be recorded")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package pcideviceclaim
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const sysfsPCIDriversPath = "/sys/bus/pci/drivers"
+
+// pciDriverSysfs wraps the sysfs files used to move a PCI device between kernel drivers.
+// It allows the claim controller to be exercised against a fake sysfs in tests
+type pciDriverSysfs interface {
+	// boundToDriver checks if the device at address is currently bound to driver
+	boundToDriver(driver string, address string) bool
+	// bind writes address to the bind file of driver
+	bind(driver string, address string) error
+	// unbind writes address to the unbind file of driver
+	unbind(driver string, address string) error
+	// addNewID writes a "vendor device" id to the new_id file of driver
+	addNewID(driver string, id string) error
+}
+
+// deviceUsageChecker reports host usage of a device as an *inuse.Error
+type deviceUsageChecker interface {
+	Check(address string) error
+}
+
+// hostSysfs is the pciDriverSysfs backed by the sysfs of the node
+type hostSysfs struct {
+	driversPath string
+}
+
+func newHostSysfs() *hostSysfs {
+	return &hostSysfs{
+		driversPath: sysfsPCIDriversPath,
+	}
+}
+
+func (s *hostSysfs) boundToDriver(driver string, address string) bool {
+	_, err := os.Stat(filepath.Join(s.driversPath, driver, address))
+	return err == nil
+}
+
+func (s *hostSysfs) bind(driver string, address string) error {
+	return writeSysfsFile(filepath.Join(s.driversPath, driver, "bind"), address)
+}
+
+func (s *hostSysfs) unbind(driver string, address string) error {
+	return writeSysfsFile(filepath.Join(s.driversPath, driver, "unbind"), address)
+}
+
+func (s *hostSysfs) addNewID(driver string, id string) error {
+	err := writeSysfsFile(filepath.Join(s.driversPath, driver, "new_id"), id)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func writeSysfsFile(path string, value string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0200)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(value)
+	if err != nil {
+		return fmt.Errorf("error writing to %s: %w", path, err)
+	}
+	return nil
+}
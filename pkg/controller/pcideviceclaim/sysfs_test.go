@@ -0,0 +1,222 @@
+package pcideviceclaim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugins"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/executor"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// fakeSysfs emulates the bind/unbind behaviour of the kernel, and records if writes for
+// devices in the same iommu group ever overlap
+type fakeSysfs struct {
+	lock     sync.Mutex
+	bound    map[string]string // pci address -> driver
+	groups   map[string]string // pci address -> iommu group
+	inFlight map[string]bool   // iommu group -> write in progress
+	overlaps int
+	writes   int
+}
+
+func newFakeSysfs() *fakeSysfs {
+	return &fakeSysfs{
+		bound:    make(map[string]string),
+		groups:   make(map[string]string),
+		inFlight: make(map[string]bool),
+	}
+}
+
+func (f *fakeSysfs) addDevice(address, group, driver string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.groups[address] = group
+	if driver != "" {
+		f.bound[address] = driver
+	}
+}
+
+func (f *fakeSysfs) driver(address string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.bound[address]
+}
+
+// write simulates a slow sysfs write, giving concurrent writers a chance to interleave
+func (f *fakeSysfs) write(address string, apply func()) {
+	f.lock.Lock()
+	group := f.groups[address]
+	if f.inFlight[group] {
+		f.overlaps++
+	}
+	f.inFlight[group] = true
+	f.writes++
+	f.lock.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+
+	f.lock.Lock()
+	apply()
+	f.inFlight[group] = false
+	f.lock.Unlock()
+}
+
+func (f *fakeSysfs) boundToDriver(driver string, address string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.bound[address] == driver
+}
+
+func (f *fakeSysfs) bind(driver string, address string) error {
+	if f.driver(address) != "" {
+		return fmt.Errorf("device %s is busy", address)
+	}
+	f.write(address, func() {
+		f.bound[address] = driver
+	})
+	return nil
+}
+
+func (f *fakeSysfs) unbind(driver string, address string) error {
+	if f.driver(address) != driver {
+		return fmt.Errorf("device %s is not bound to %s", address, driver)
+	}
+	f.write(address, func() {
+		delete(f.bound, address)
+	})
+	return nil
+}
+
+func (f *fakeSysfs) addNewID(_ string, _ string) error {
+	return nil
+}
+
+func newDeviceAndClaim(node string, function int, group string) (*v1beta1.PCIDevice, *v1beta1.PCIDeviceClaim) {
+	address := fmt.Sprintf("0000:04:10.%d", function)
+	name := fmt.Sprintf("%s-00000410%d", node, function)
+	pd := &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				v1beta1.PciDeviceDriver: "ixgbevf",
+			},
+		},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:           address,
+			VendorId:          "8086",
+			DeviceId:          "10ed",
+			IOMMUGroup:        group,
+			NodeName:          node,
+			ResourceName:      "intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION",
+			KernelDriverInUse: "ixgbevf",
+		},
+	}
+	pdc := &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address:  address,
+			NodeName: node,
+			UserName: "admin",
+		},
+	}
+	return pd, pdc
+}
+
+func Test_ConcurrentPassthroughIsSerializedPerIOMMUGroup(t *testing.T) {
+	assert := require.New(t)
+	client := fake.NewSimpleClientset()
+	sysfs := newFakeSysfs()
+	h := &Handler{
+		pdClient:      fakeclients.PCIDevicesClient(client.DevicesV1beta1().PCIDevices),
+		nodeName:      "node1",
+		executor:      executor.New(),
+		sysfs:         sysfs,
+		resetter:      &fakeResetter{method: "flr"},
+		devicePlugins: make(map[string]*deviceplugins.PCIDevicePlugin),
+	}
+
+	var pds []*v1beta1.PCIDevice
+	var pdcs []*v1beta1.PCIDeviceClaim
+	for i := 0; i < 8; i++ {
+		pd, pdc := newDeviceAndClaim("node1", i, "89")
+		_, err := client.DevicesV1beta1().PCIDevices().Create(context.TODO(), pd, metav1.CreateOptions{})
+		assert.NoError(err, "expected no error during pcidevice creation")
+		sysfs.addDevice(pd.Status.Address, pd.Status.IOMMUGroup, pd.Status.KernelDriverInUse)
+		pds = append(pds, pd)
+		pdcs = append(pdcs, pdc)
+	}
+
+	var wg sync.WaitGroup
+	for i := range pds {
+		wg.Add(1)
+		go func(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) {
+			defer wg.Done()
+			err := h.runDeviceOperation(pd, func() error {
+				return h.attemptToEnablePassthrough(pd, pdc)
+			})
+			assert.NoError(err, "expected no error enabling passthrough")
+		}(pds[i], pdcs[i])
+	}
+	wg.Wait()
+
+	for _, pd := range pds {
+		assert.Equal(vfioPCIDriver, sysfs.driver(pd.Status.Address), "expected device to be bound to vfio-pci")
+	}
+
+	for i := range pds {
+		wg.Add(1)
+		go func(pd *v1beta1.PCIDevice) {
+			defer wg.Done()
+			err := h.runDeviceOperation(pd, func() error {
+				return h.disablePassthrough(pd, nil)
+			})
+			assert.NoError(err, "expected no error disabling passthrough")
+		}(pds[i])
+	}
+	wg.Wait()
+
+	for _, pd := range pds {
+		assert.Equal("ixgbevf", sysfs.driver(pd.Status.Address), "expected device to be bound to original driver")
+	}
+	assert.Greater(sysfs.writes, 0, "expected writes to the fake sysfs")
+	assert.Equal(0, sysfs.overlaps, "expected no overlapping writes within an iommu group")
+}
+
+func Test_ConcurrentDevicePluginUpdates(t *testing.T) {
+	assert := require.New(t)
+	h := &Handler{
+		nodeName:      "node1",
+		executor:      executor.New(),
+		sysfs:         newFakeSysfs(),
+		devicePlugins: make(map[string]*deviceplugins.PCIDevicePlugin),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pd, pdc := newDeviceAndClaim("node1", i, fmt.Sprintf("%d", i))
+			assert.NoError(h.addDeviceToPlugin(pd, pdc), "expected no error adding device to plugin")
+		}(i)
+	}
+	wg.Wait()
+
+	h.pluginsLock.Lock()
+	assert.Len(h.devicePlugins, 1, "expected a single device plugin for the resource name")
+	for _, dp := range h.devicePlugins {
+		assert.Equal(8, dp.GetCount(), "expected all devices to be healthy")
+	}
+	h.pluginsLock.Unlock()
+}
@@ -0,0 +1,500 @@
+package permittedhostdevices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/retry"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+const (
+	// OwnedAnnotation on the KubeVirt CR lists the resourceNames of the permitted PCI host devices added for
+	// PCIDeviceClaims, separated by commas. Entries which are not listed were added by other tools
+	OwnedAnnotation = "devices.harvesterhci.io/permitted-host-devices"
+
+	// resyncPeriod is how often the KubeVirt CR is reconciled in addition to when it or the claims change
+	resyncPeriod = 5 * time.Minute
+	// retryPeriod is how long the reconciler waits before retrying a failed reconcile, or looking for the
+	// KubeVirt CR again if it is not found
+	retryPeriod = 10 * time.Second
+	// leaderLockName is the name of the lock of the leader election, as a single controller of the
+	// cluster reconciles the KubeVirt CR
+	leaderLockName = "pcidevices-permitted-host-devices"
+	// leaseDuration, renewDeadline and leaderRetryPeriod time the leader election as wrangler does
+	leaseDuration     = 45 * time.Second
+	renewDeadline     = 30 * time.Second
+	leaderRetryPeriod = 2 * time.Second
+)
+
+// Options configures the reconciler of the permitted host devices
+type Options struct {
+	// KubeVirtNamespace and KubeVirtName identify the KubeVirt CR. If either is empty, the KubeVirt CR is
+	// discovered among those matching the other
+	KubeVirtNamespace string
+	KubeVirtName      string
+	// LeaderElectionNamespace is where the lock of the leader election is kept
+	LeaderElectionNamespace string
+}
+
+// kubeVirtClient gets and patches the KubeVirt CR
+type kubeVirtClient interface {
+	Get(name string, options *metav1.GetOptions) (*kubevirtv1.KubeVirt, error)
+	Patch(name string, pt types.PatchType, data []byte, patchOptions *metav1.PatchOptions, subresources ...string) (*kubevirtv1.KubeVirt, error)
+}
+
+// kubeVirtLister lists the KubeVirt CRs of a namespace, or of all namespaces
+type kubeVirtLister interface {
+	List(opts *metav1.ListOptions) (*kubevirtv1.KubeVirtList, error)
+}
+
+// Handler permits the resourceNames of claimed devices in the KubeVirt CR for an external resource provider,
+// which are the device plugins of the claims, and removes the entries once no claim needs them. The desired
+// entries are computed from all PCIDeviceClaims of the cluster, and entries removed by hand are restored. The
+// result is recorded in the HostDevicePermitted condition of the claims
+type Handler struct {
+	pdcClient v1beta1gen.PCIDeviceClaimClient
+	pdcCache  v1beta1gen.PCIDeviceClaimCache
+	pdCache   v1beta1gen.PCIDeviceCache
+	kubevirt  kubeVirtClient
+	// namespace and name identify the KubeVirt CR once it is discovered
+	namespace string
+	name      string
+	// trigger requests a reconcile, it is only consumed by the leader
+	trigger chan struct{}
+	// synced reports if the caches of the claims and devices are synced, as the desired entries computed from
+	// caches which are not synced yet would remove the entries of the claims not listed yet
+	synced []cache.InformerSynced
+}
+
+func Register(
+	ctx context.Context,
+	pdcClient v1beta1gen.PCIDeviceClaimController,
+	pdClient v1beta1gen.PCIDeviceController,
+	client kubernetes.Interface,
+	opts Options,
+) error {
+	clientConfig := kubecli.DefaultClientConfig(&pflag.FlagSet{})
+	virtClient, err := kubecli.GetKubevirtClientFromClientConfig(clientConfig)
+	if err != nil {
+		return fmt.Errorf("cannot obtain KubeVirt client: %v", err)
+	}
+
+	handler := &Handler{
+		pdcClient: pdcClient,
+		pdcCache:  pdcClient.Cache(),
+		pdCache:   pdClient.Cache(),
+		trigger:   make(chan struct{}, 1),
+		synced:    []cache.InformerSynced{pdcClient.Informer().HasSynced, pdClient.Informer().HasSynced},
+	}
+	pdcClient.OnChange(ctx, "permitted-host-devices", handler.OnClaimChange)
+	pdClient.OnChange(ctx, "permitted-host-devices", handler.OnDeviceChange)
+	lock, err := newLeaderLock(client, opts.LeaderElectionNamespace)
+	if err != nil {
+		return err
+	}
+	go handler.runWhileLeading(ctx, lock, func(ctx context.Context) {
+		handler.run(ctx, virtClient, opts)
+	})
+	return nil
+}
+
+// newLeaderLock returns the lock of the leader election, of the same kind as the locks of wrangler
+func newLeaderLock(client kubernetes.Interface, namespace string) (resourcelock.Interface, error) {
+	if namespace == "" {
+		namespace = metav1.NamespaceSystem
+	}
+	id, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error getting hostname for leader election: %v", err)
+	}
+	lock, err := resourcelock.New(resourcelock.ConfigMapsLeasesResourceLock, namespace, leaderLockName,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return nil, fmt.Errorf("error creating leader lock %s/%s: %v", namespace, leaderLockName, err)
+	}
+	return lock, nil
+}
+
+// runWhileLeading runs reconcile while the controller is the leader, until ctx is done. Losing the leadership
+// only stops reconcile, which runs again once the leadership is won again, as the process also serves the
+// device plugins and claims of its node
+func (h *Handler) runWhileLeading(ctx context.Context, lock resourcelock.Interface, reconcile func(ctx context.Context)) {
+	for {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			LeaseDuration: leaseDuration,
+			RenewDeadline: renewDeadline,
+			RetryPeriod:   leaderRetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				// the context of reconcile is cancelled once the leadership is lost
+				OnStartedLeading: reconcile,
+				OnStoppedLeading: func() {
+					logrus.Infof("Lost leadership of %s", leaderLockName)
+				},
+			},
+			ReleaseOnCancel: true,
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderRetryPeriod):
+		}
+	}
+}
+
+// run reconciles the KubeVirt CR while the controller is the leader
+func (h *Handler) run(ctx context.Context, virtClient kubecli.KubevirtClient, opts Options) {
+	err := wait.PollImmediateUntil(retryPeriod, func() (bool, error) {
+		namespace, name, err := discoverKubeVirt(virtClient.KubeVirt(opts.KubeVirtNamespace), opts.KubeVirtName)
+		if err != nil {
+			logrus.Warnf("error discovering the kubevirt CR: %v", err)
+			return false, nil
+		}
+		h.namespace, h.name = namespace, name
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), h.synced...) {
+		return
+	}
+	logrus.Infof("Reconciling the permitted host devices of kubevirt CR %s/%s", h.namespace, h.name)
+	h.kubevirt = virtClient.KubeVirt(h.namespace)
+	h.watchKubeVirt(ctx, virtClient.RestClient())
+
+	h.enqueue()
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.trigger:
+		}
+		if err := h.reconcile(); err != nil {
+			logrus.Errorf("error reconciling permitted host devices: %v", err)
+			time.AfterFunc(retryPeriod, h.enqueue)
+		}
+	}
+}
+
+// discoverKubeVirt returns the namespace and name of the only KubeVirt CR listed by kubevirts which is
+// named name, or of any name if name is empty
+func discoverKubeVirt(kubevirts kubeVirtLister, name string) (string, string, error) {
+	list, err := kubevirts.List(&metav1.ListOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("error listing kubevirt CRs: %v", err)
+	}
+	var found []string
+	var kv kubevirtv1.KubeVirt
+	for _, item := range list.Items {
+		if name == "" || item.Name == name {
+			kv = item
+			found = append(found, item.Namespace+"/"+item.Name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", "", fmt.Errorf("no kubevirt CR found")
+	case 1:
+		return kv.Namespace, kv.Name, nil
+	default:
+		return "", "", fmt.Errorf("found several kubevirt CRs %s, the kubevirt CR has to be configured", strings.Join(found, ", "))
+	}
+}
+
+// watchKubeVirt reconciles the KubeVirt CR whenever its permitted host devices are changed, e.g. by hand or
+// by the KubeVirt operator
+func (h *Handler) watchKubeVirt(ctx context.Context, restClient *rest.RESTClient) {
+	lw := cache.NewListWatchFromClient(restClient, "kubevirts", h.namespace, fields.OneTermEqualSelector("metadata.name", h.name))
+	informer := cache.NewSharedInformer(lw, &kubevirtv1.KubeVirt{}, resyncPeriod)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) {
+			h.enqueue()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldKV, oldOK := oldObj.(*kubevirtv1.KubeVirt)
+			newKV, newOK := newObj.(*kubevirtv1.KubeVirt)
+			if !oldOK || !newOK ||
+				!equality.Semantic.DeepEqual(oldKV.Spec.Configuration.PermittedHostDevices, newKV.Spec.Configuration.PermittedHostDevices) ||
+				oldKV.Annotations[OwnedAnnotation] != newKV.Annotations[OwnedAnnotation] {
+				h.enqueue()
+			}
+		},
+	})
+	go informer.Run(ctx.Done())
+}
+
+// enqueue requests a reconcile, requests are merged while a reconcile is pending
+func (h *Handler) enqueue() {
+	select {
+	case h.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// OnClaimChange reconciles the KubeVirt CR when a claim is created, changed or removed
+func (h *Handler) OnClaimChange(_ string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	h.enqueue()
+	return pdc, nil
+}
+
+// OnDeviceChange reconciles the KubeVirt CR when a device is changed or removed along with its hardware
+func (h *Handler) OnDeviceChange(_ string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	h.enqueue()
+	return pd, nil
+}
+
+// reconcile permits the claimed devices in the KubeVirt CR and removes the entries which are no longer needed,
+// retrying if the KubeVirt CR is changed concurrently, and records the result on the claims
+func (h *Handler) reconcile() error {
+	claims, desired, err := h.desiredHostDevices()
+	if err != nil {
+		return err
+	}
+	pds, err := h.pdCache.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	known := make(map[string]bool)
+	for _, pd := range pds {
+		known[pd.Status.ResourceName] = true
+	}
+
+	permitErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return h.patchKubeVirt(desired, known)
+	})
+	if permitErr != nil {
+		permitErr = fmt.Errorf("error updating permitted host devices of kubevirt CR %s/%s: %v", h.namespace, h.name, permitErr)
+	}
+	if err := h.syncClaims(claims, permitErr); err != nil {
+		return err
+	}
+	return permitErr
+}
+
+func (h *Handler) patchKubeVirt(desired map[string]kubevirtv1.PciHostDevice, known map[string]bool) error {
+	kv, err := h.kubevirt.Get(h.name, &metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	kvCopy := reconcilePermittedHostDevices(kv, desired, known)
+	if equality.Semantic.DeepEqual(kv, kvCopy) {
+		return nil
+	}
+	patch, err := permittedHostDevicesPatch(kvCopy)
+	if err != nil {
+		return err
+	}
+	_, err = h.kubevirt.Patch(h.name, types.MergePatchType, patch, &metav1.PatchOptions{})
+	return err
+}
+
+// desiredHostDevices returns the resourceName of the device of each claim, and the permitted host device of
+// each claimed resourceName. Claims which are deleted still need their device until it is released
+func (h *Handler) desiredHostDevices() (map[string]string, map[string]kubevirtv1.PciHostDevice, error) {
+	pdcs, err := h.pdcCache.List(labels.Everything())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing pcideviceclaims: %v", err)
+	}
+	claims := make(map[string]string)
+	desired := make(map[string]kubevirtv1.PciHostDevice)
+	for _, pdc := range pdcs {
+		if len(pdc.OwnerReferences) == 0 {
+			continue
+		}
+		pd, err := h.pdCache.Get(pdc.OwnerReferences[0].Name)
+		if err != nil || pd.Status.ResourceName == "" {
+			// the device of the claim is gone along with its hardware
+			continue
+		}
+		resourceName := pd.Status.ResourceName
+		claims[pdc.Name] = resourceName
+		selector := fmt.Sprintf("%s:%s", pd.Status.VendorId, pd.Status.DeviceId)
+		// devices of a resourceName share their vendor and device id, the lowest is picked otherwise so
+		// the entry does not change between reconciles
+		if dev, ok := desired[resourceName]; ok && dev.PCIVendorSelector < selector {
+			continue
+		}
+		desired[resourceName] = kubevirtv1.PciHostDevice{
+			PCIVendorSelector:        selector,
+			ResourceName:             resourceName,
+			ExternalResourceProvider: true,
+		}
+	}
+	return claims, desired, nil
+}
+
+// syncClaims records in the HostDevicePermitted condition of the claims whether their resourceName is
+// permitted in the KubeVirt CR
+func (h *Handler) syncClaims(claims map[string]string, permitErr error) error {
+	var errs []error
+	for name, resourceName := range claims {
+		pdc, err := h.pdcCache.Get(name)
+		if err != nil {
+			continue
+		}
+		pdcCopy := pdc.DeepCopy()
+		if permitErr != nil {
+			v1beta1.PCIDeviceClaimHostDevicePermitted.SetError(pdcCopy, v1beta1.PermitFailedReason, permitErr)
+		} else {
+			v1beta1.PCIDeviceClaimHostDevicePermitted.True(pdcCopy)
+			v1beta1.PCIDeviceClaimHostDevicePermitted.Reason(pdcCopy, "")
+			v1beta1.PCIDeviceClaimHostDevicePermitted.Message(pdcCopy,
+				fmt.Sprintf("%s is permitted in kubevirt CR %s/%s", resourceName, h.namespace, h.name))
+		}
+		if reflect.DeepEqual(pdc.Status, pdcCopy.Status) {
+			continue
+		}
+		if _, err := h.pdcClient.UpdateStatus(pdcCopy); err != nil {
+			errs = append(errs, fmt.Errorf("error updating status for pcideviceclaim %s: %v", name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// reconcilePermittedHostDevices permits the desired PCI host devices in kvObj, and removes the entries owned
+// by the controller which are not desired. The entries the controller added are owned, as listed in the
+// OwnedAnnotation. Entries added by releases before the annotation was introduced are adopted once, while the
+// annotation is missing, if they are permitted for an external resource provider under the resourceName of a
+// known PCIDevice. Once annotated, an existing entry of a desired resourceName for an external resource
+// provider already permits the devices, and is left to the tool which added it. An entry of a desired
+// resourceName for KubeVirt's own device plugin is replaced, and entries of other tools are left untouched
+func reconcilePermittedHostDevices(kvObj *kubevirtv1.KubeVirt, desired map[string]kubevirtv1.PciHostDevice, known map[string]bool) *kubevirtv1.KubeVirt {
+	kv := kvObj.DeepCopy()
+	value, annotated := kv.Annotations[OwnedAnnotation]
+	owned := ParseOwned(value)
+	var existing []kubevirtv1.PciHostDevice
+	if kv.Spec.Configuration.PermittedHostDevices != nil {
+		existing = kv.Spec.Configuration.PermittedHostDevices.PciHostDevices
+	}
+
+	// remaining holds the desired resourceNames which are permitted, ours those of the entries added by the controller
+	remaining := make(map[string]bool)
+	ours := make(map[string]bool)
+	var pciHostDevices []kubevirtv1.PciHostDevice
+	for _, dev := range existing {
+		isOwned := dev.ExternalResourceProvider && (owned[dev.ResourceName] || (!annotated && known[dev.ResourceName]))
+		want, isDesired := desired[dev.ResourceName]
+		switch {
+		case isDesired && remaining[dev.ResourceName]:
+			// duplicate entries of a desired resourceName are dropped
+			continue
+		case isDesired && (isOwned || !dev.ExternalResourceProvider):
+			dev = want
+			ours[dev.ResourceName] = true
+		case isDesired:
+			// entries of other tools already permit the devices, and stay theirs
+		case isOwned:
+			logrus.Infof("Removing %s from KubeVirt list of permitted devices", dev.ResourceName)
+			continue
+		}
+		if isDesired {
+			remaining[dev.ResourceName] = true
+		}
+		pciHostDevices = append(pciHostDevices, dev)
+	}
+
+	// entries which are missing, e.g. as they were removed by hand, are added
+	var missing []string
+	for resourceName := range desired {
+		if !remaining[resourceName] {
+			missing = append(missing, resourceName)
+		}
+	}
+	sort.Strings(missing)
+	for _, resourceName := range missing {
+		logrus.Infof("Adding %s to KubeVirt list of permitted devices", resourceName)
+		pciHostDevices = append(pciHostDevices, desired[resourceName])
+		ours[resourceName] = true
+	}
+
+	if !reflect.DeepEqual(pciHostDevices, existing) && (len(pciHostDevices) > 0 || len(existing) > 0) {
+		if kv.Spec.Configuration.PermittedHostDevices == nil {
+			kv.Spec.Configuration.PermittedHostDevices = &kubevirtv1.PermittedHostDevices{}
+		}
+		kv.Spec.Configuration.PermittedHostDevices.PciHostDevices = pciHostDevices
+	}
+	SetOwned(kv, ours)
+	return kv
+}
+
+// permittedHostDevicesPatch returns a merge patch of the PCI host devices and the OwnedAnnotation of kv. The
+// patch carries the resourceVersion of kv, so it fails with a conflict if the KubeVirt CR changed since it was read
+func permittedHostDevicesPatch(kv *kubevirtv1.KubeVirt) ([]byte, error) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": kv.ResourceVersion,
+			"annotations": map[string]string{
+				OwnedAnnotation: kv.Annotations[OwnedAnnotation],
+			},
+		},
+	}
+	if kv.Spec.Configuration.PermittedHostDevices != nil {
+		// a merge patch replaces lists, an empty list has to be sent to remove all entries
+		pciHostDevices := kv.Spec.Configuration.PermittedHostDevices.PciHostDevices
+		if pciHostDevices == nil {
+			pciHostDevices = []kubevirtv1.PciHostDevice{}
+		}
+		patch["spec"] = map[string]interface{}{
+			"configuration": map[string]interface{}{
+				"permittedHostDevices": map[string]interface{}{
+					"pciHostDevices": pciHostDevices,
+				},
+			},
+		}
+	}
+	return json.Marshal(patch)
+}
+
+// ParseOwned returns the resourceNames listed in the OwnedAnnotation
+func ParseOwned(value string) map[string]bool {
+	owned := make(map[string]bool)
+	for _, resourceName := range strings.Split(value, ",") {
+		if resourceName != "" {
+			owned[resourceName] = true
+		}
+	}
+	return owned
+}
+
+// SetOwned records the resourceNames of owned in the OwnedAnnotation of kv. The annotation is kept when no
+// entries are owned, so entries added by other tools are not adopted again
+func SetOwned(kv *kubevirtv1.KubeVirt, owned map[string]bool) {
+	resourceNames := make([]string, 0, len(owned))
+	for resourceName := range owned {
+		resourceNames = append(resourceNames, resourceName)
+	}
+	sort.Strings(resourceNames)
+	if kv.Annotations == nil {
+		kv.Annotations = make(map[string]string)
+	}
+	kv.Annotations[OwnedAnnotation] = strings.Join(resourceNames, ",")
+}
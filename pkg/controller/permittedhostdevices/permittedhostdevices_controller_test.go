@@ -0,0 +1,271 @@
+package permittedhostdevices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func newPCIDevice(name, resourceName, vendorID, deviceID string) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1beta1.PCIDeviceStatus{
+			ResourceName: resourceName,
+			VendorId:     vendorID,
+			DeviceId:     deviceID,
+		},
+	}
+}
+
+func newClaim(name string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "PCIDevice", Name: name},
+			},
+		},
+	}
+}
+
+func newKubeVirt(annotations map[string]string, pciHostDevices ...kubevirtv1.PciHostDevice) *kubevirtv1.KubeVirt {
+	return &kubevirtv1.KubeVirt{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "kubevirt",
+			Namespace:       "harvester-system",
+			ResourceVersion: "1",
+			Annotations:     annotations,
+		},
+		Spec: kubevirtv1.KubeVirtSpec{
+			Configuration: kubevirtv1.KubeVirtConfiguration{
+				PermittedHostDevices: &kubevirtv1.PermittedHostDevices{
+					PciHostDevices: pciHostDevices,
+				},
+			},
+		},
+	}
+}
+
+func permitted(kv *kubevirtv1.KubeVirt) []string {
+	var resourceNames []string
+	for _, dev := range kv.Spec.Configuration.PermittedHostDevices.PciHostDevices {
+		resourceNames = append(resourceNames, dev.ResourceName)
+	}
+	return resourceNames
+}
+
+var (
+	teslaT4   = kubevirtv1.PciHostDevice{PCIVendorSelector: "10de:1eb8", ResourceName: "nvidia.com/TU104GL_TESLA_T4", ExternalResourceProvider: true}
+	a100      = kubevirtv1.PciHostDevice{PCIVendorSelector: "10de:20b0", ResourceName: "nvidia.com/GA100_A100", ExternalResourceProvider: true}
+	i350      = kubevirtv1.PciHostDevice{PCIVendorSelector: "8086:1521", ResourceName: "intel.com/I350_GIGABIT_NETWORK_CONNECTION", ExternalResourceProvider: true}
+	a10       = kubevirtv1.PciHostDevice{PCIVendorSelector: "10de:2236", ResourceName: "nvidia.com/GA102GL_A10", ExternalResourceProvider: true}
+	virtioNet = kubevirtv1.PciHostDevice{PCIVendorSelector: "1af4:1041", ResourceName: "devices.kubevirt.io/virtio-net"}
+)
+
+func Test_Reconcile(t *testing.T) {
+	assert := require.New(t)
+	kvClient := &fakeclients.KubeVirtClient{
+		KubeVirt: newKubeVirt(map[string]string{
+			OwnedAnnotation: "intel.com/I350_GIGABIT_NETWORK_CONNECTION,nvidia.com/TU104GL_TESLA_T4",
+		},
+			// claimed
+			teslaT4,
+			// the hardware was removed from the cluster
+			i350,
+			// added by other tools
+			a10,
+			virtioNet,
+		),
+		// the KubeVirt operator changes the KubeVirt CR while it is patched
+		Conflicts: 1,
+	}
+	client := fake.NewSimpleClientset(
+		newPCIDevice("node1-000001000", "nvidia.com/TU104GL_TESLA_T4", "10de", "1eb8"),
+		newClaim("node1-000001000"),
+		// claimed, but not permitted yet
+		newPCIDevice("node2-000001000", "nvidia.com/GA100_A100", "10de", "20b0"),
+		newClaim("node2-000001000"),
+		// the device of the claim is gone
+		newClaim("node2-000004000"),
+	)
+	h := &Handler{
+		pdcClient: fakeclients.PCIDeviceClaimsClient(client.DevicesV1beta1().PCIDeviceClaims),
+		pdcCache:  fakeclients.PCIDeviceClaimsCache(client.DevicesV1beta1().PCIDeviceClaims),
+		pdCache:   fakeclients.PCIDevicesCache(client.DevicesV1beta1().PCIDevices),
+		kubevirt:  kvClient,
+		namespace: "harvester-system",
+		name:      "kubevirt",
+	}
+
+	assert.NoError(h.reconcile(), "expected the patch to be retried after a conflict")
+	assert.Equal([]string{"nvidia.com/TU104GL_TESLA_T4", "nvidia.com/GA102GL_A10", "devices.kubevirt.io/virtio-net", "nvidia.com/GA100_A100"},
+		permitted(kvClient.KubeVirt), "expected the claimed device to be added, and only the entry of the removed hardware to be collected")
+	assert.Equal(a100, kvClient.KubeVirt.Spec.Configuration.PermittedHostDevices.PciHostDevices[3])
+	assert.Equal("nvidia.com/GA100_A100,nvidia.com/TU104GL_TESLA_T4", kvClient.KubeVirt.Annotations[OwnedAnnotation])
+	for _, name := range []string{"node1-000001000", "node2-000001000"} {
+		pdc, err := client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(err)
+		assert.True(v1beta1.PCIDeviceClaimHostDevicePermitted.IsTrue(pdc), name)
+	}
+	assert.Equal("nvidia.com/GA100_A100 is permitted in kubevirt CR harvester-system/kubevirt",
+		v1beta1.PCIDeviceClaimHostDevicePermitted.GetMessage(mustGetClaim(t, client, "node2-000001000")))
+
+	// nothing is updated while nothing changed
+	client.ClearActions()
+	assert.NoError(h.reconcile())
+	assert.Equal(1, kvClient.Patches)
+	for _, action := range client.Actions() {
+		assert.NotEqual("update", action.GetVerb(), "expected the claims not to be updated")
+	}
+
+	// an entry removed by hand is restored
+	kvClient.KubeVirt.Spec.Configuration.PermittedHostDevices.PciHostDevices = []kubevirtv1.PciHostDevice{a10, virtioNet, a100}
+	assert.NoError(h.reconcile())
+	assert.Equal([]string{"nvidia.com/GA102GL_A10", "devices.kubevirt.io/virtio-net", "nvidia.com/GA100_A100", "nvidia.com/TU104GL_TESLA_T4"},
+		permitted(kvClient.KubeVirt))
+
+	// the entry is collected once the claim is gone
+	assert.NoError(client.Tracker().Delete(v1beta1.SchemeGroupVersion.WithResource("pcideviceclaims"), "", "node1-000001000"))
+	assert.NoError(h.reconcile())
+	assert.Equal([]string{"nvidia.com/GA102GL_A10", "devices.kubevirt.io/virtio-net", "nvidia.com/GA100_A100"}, permitted(kvClient.KubeVirt))
+	assert.Equal("nvidia.com/GA100_A100", kvClient.KubeVirt.Annotations[OwnedAnnotation])
+
+	// the claims report when the KubeVirt CR cannot be patched
+	kvClient.KubeVirt.Spec.Configuration.PermittedHostDevices.PciHostDevices = nil
+	kvClient.Conflicts = 100
+	assert.Error(h.reconcile())
+	pdc := mustGetClaim(t, client, "node2-000001000")
+	assert.True(v1beta1.PCIDeviceClaimHostDevicePermitted.IsFalse(pdc))
+	assert.Equal(v1beta1.PermitFailedReason, v1beta1.PCIDeviceClaimHostDevicePermitted.GetReason(pdc))
+}
+
+func mustGetClaim(t *testing.T, client *fake.Clientset, name string) *v1beta1.PCIDeviceClaim {
+	pdc, err := client.DevicesV1beta1().PCIDeviceClaims().Get(context.TODO(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return pdc
+}
+
+func Test_ReconcilePermittedHostDevices(t *testing.T) {
+	assert := require.New(t)
+	desired := map[string]kubevirtv1.PciHostDevice{teslaT4.ResourceName: teslaT4}
+
+	// an entry of a claimed resourceName for KubeVirt's own device plugin is replaced
+	ownPlugin := teslaT4
+	ownPlugin.ExternalResourceProvider = false
+	kv := newKubeVirt(nil, ownPlugin, a10)
+	reconciled := reconcilePermittedHostDevices(kv, desired, nil)
+	assert.Equal([]kubevirtv1.PciHostDevice{teslaT4, a10}, reconciled.Spec.Configuration.PermittedHostDevices.PciHostDevices)
+	assert.Equal("nvidia.com/TU104GL_TESLA_T4", reconciled.Annotations[OwnedAnnotation])
+	assert.False(kv.Spec.Configuration.PermittedHostDevices.PciHostDevices[0].ExternalResourceProvider,
+		"expected the KubeVirt CR not to be modified")
+
+	// a KubeVirt CR without permitted host devices is left as is if nothing is claimed
+	kv = newKubeVirt(map[string]string{OwnedAnnotation: ""})
+	kv.Spec.Configuration.PermittedHostDevices = nil
+	assert.Equal(kv, reconcilePermittedHostDevices(kv, nil, nil))
+	assert.Equal([]string{"nvidia.com/TU104GL_TESLA_T4"}, permitted(reconcilePermittedHostDevices(kv, desired, nil)))
+}
+
+func Test_ReconcileDoesNotAdoptEntries(t *testing.T) {
+	assert := require.New(t)
+	desired := map[string]kubevirtv1.PciHostDevice{teslaT4.ResourceName: teslaT4, i350.ResourceName: i350}
+
+	// existing entries for an external resource provider permit the claimed devices, but are not owned unless
+	// they are entries of known PCIDevices added before the annotation was introduced
+	for _, kv := range []*kubevirtv1.KubeVirt{newKubeVirt(nil, teslaT4, a10), newKubeVirt(map[string]string{OwnedAnnotation: ""}, teslaT4, a10)} {
+		reconciled := reconcilePermittedHostDevices(kv, desired, nil)
+		assert.Equal([]string{"nvidia.com/TU104GL_TESLA_T4", "nvidia.com/GA102GL_A10", "intel.com/I350_GIGABIT_NETWORK_CONNECTION"}, permitted(reconciled))
+		assert.Equal("intel.com/I350_GIGABIT_NETWORK_CONNECTION", reconciled.Annotations[OwnedAnnotation],
+			"expected only the added entry to be owned")
+
+		// entries which are not owned are kept once no claim needs them
+		reconciled = reconcilePermittedHostDevices(reconciled, nil, nil)
+		assert.Equal([]string{"nvidia.com/TU104GL_TESLA_T4", "nvidia.com/GA102GL_A10"}, permitted(reconciled))
+		assert.Equal("", reconciled.Annotations[OwnedAnnotation])
+	}
+}
+
+func Test_ReconcileAdoptsLegacyEntries(t *testing.T) {
+	assert := require.New(t)
+	kv := newKubeVirt(nil, teslaT4, i350, a10)
+
+	// entries added before the annotation was introduced are adopted if they are known PCIDevices, and
+	// collected if no claim needs them
+	reconciled := reconcilePermittedHostDevices(kv,
+		map[string]kubevirtv1.PciHostDevice{teslaT4.ResourceName: teslaT4},
+		map[string]bool{teslaT4.ResourceName: true, i350.ResourceName: true})
+	assert.Equal([]string{"nvidia.com/TU104GL_TESLA_T4", "nvidia.com/GA102GL_A10"}, permitted(reconciled))
+	assert.Equal("nvidia.com/TU104GL_TESLA_T4", reconciled.Annotations[OwnedAnnotation])
+
+	// once annotated, entries are not adopted again
+	reconciled = reconcilePermittedHostDevices(reconciled, nil, map[string]bool{a10.ResourceName: true})
+	assert.Equal([]string{"nvidia.com/GA102GL_A10"}, permitted(reconciled))
+	assert.Equal("", reconciled.Annotations[OwnedAnnotation])
+}
+
+func Test_DiscoverKubeVirt(t *testing.T) {
+	assert := require.New(t)
+	kvClient := &fakeclients.KubeVirtClient{}
+	_, _, err := discoverKubeVirt(kvClient, "")
+	assert.Error(err, "expected an error if KubeVirt is not installed")
+
+	kvClient.KubeVirt = newKubeVirt(nil)
+	namespace, name, err := discoverKubeVirt(kvClient, "")
+	assert.NoError(err)
+	assert.Equal("harvester-system", namespace)
+	assert.Equal("kubevirt", name)
+
+	_, _, err = discoverKubeVirt(kvClient, "other")
+	assert.Error(err, "expected the configured name to be looked for")
+}
+
+// Test_PermitHostDeviceInKubeVirt covers the cases of the tests of permitHostDeviceInKubeVirt, which permitted
+// the devices of claims in the KubeVirt CR before this controller
+func Test_PermitHostDeviceInKubeVirt(t *testing.T) {
+	pd := newPCIDevice("testnode1-00003f062", "intel.com/82571EB_82571GB_GIGABIT_ETHERNET_CONTROLLER_COPPER", "8086", "10bc")
+	entry := kubevirtv1.PciHostDevice{
+		PCIVendorSelector: "8086:10bc",
+		ResourceName:      pd.Status.ResourceName,
+	}
+	externalEntry := entry
+	externalEntry.ExternalResourceProvider = true
+	desired := map[string]kubevirtv1.PciHostDevice{pd.Status.ResourceName: externalEntry}
+
+	var testCases = []struct {
+		name     string
+		kv       *kubevirtv1.KubeVirt
+		expected []kubevirtv1.PciHostDevice
+	}{
+		{
+			name:     "no devices",
+			kv:       newKubeVirt(nil),
+			expected: []kubevirtv1.PciHostDevice{externalEntry},
+		},
+		{
+			name:     "without external resource devices",
+			kv:       newKubeVirt(nil, entry),
+			expected: []kubevirtv1.PciHostDevice{externalEntry},
+		},
+		{
+			name:     "with external resource devices",
+			kv:       newKubeVirt(nil, externalEntry),
+			expected: []kubevirtv1.PciHostDevice{externalEntry},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			reconciled := reconcilePermittedHostDevices(v.kv, desired, map[string]bool{pd.Status.ResourceName: true})
+			assert.Equal(v.expected, reconciled.Spec.Configuration.PermittedHostDevices.PciHostDevices)
+			assert.Equal(pd.Status.ResourceName, reconciled.Annotations[OwnedAnnotation])
+		})
+	}
+}
@@ -76,6 +76,13 @@ func Objects(v1beta1 bool) (result []runtime.Object, err error) {
 
 func List() []crd.CRD {
 	return []crd.CRD{
+		newCRD(&devices.NodePassthrough{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			return c.
+				WithColumn("Ready", ".status.ready").
+				WithColumn("IOMMU Groups", ".status.iommuGroups").
+				WithColumn("Last Check", ".status.lastCheckTime")
+		}),
 		newCRD(&devices.PCIDevice{}, func(c crd.CRD) crd.CRD {
 			c.NonNamespace = true
 			return c.
@@ -95,6 +102,37 @@ func List() []crd.CRD {
 				WithColumn("Kernel Driver Το Unbind", ".status.kernelDriverToUnbind").
 				WithColumn("Passthrough Enabled", ".status.passthroughEnabled")
 		}),
+		newCRD(&devices.PCIDeviceHotplug{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			return c.
+				WithColumn("Device", ".spec.deviceName").
+				WithColumn("VMI Namespace", ".spec.vmiNamespace").
+				WithColumn("VMI", ".spec.vmiName").
+				WithColumn("Phase", ".status.phase")
+		}),
+		newCRD(&devices.PCIDeviceOperation{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			c.Status = false
+			return c.
+				WithColumn("Type", ".spec.type").
+				WithColumn("Device", ".spec.deviceName").
+				WithColumn("Claim", ".spec.claimName").
+				WithColumn("User Name", ".spec.userName").
+				WithColumn("Result", ".spec.result").
+				WithColumn("Completed", ".spec.completionTime")
+		}),
+		newCRD(&devices.PCIDevicePolicy{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			c.Status = false
+			return c.
+				WithColumn("Resource Names", ".spec.resourceNames").
+				WithColumn("Class IDs", ".spec.classIds").
+				WithColumn("Max Devices", ".spec.maxDevices")
+		}),
+		newCRD(&devices.PCITopology{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			return c
+		}),
 	}
 }
 
@@ -0,0 +1,230 @@
+package deviceplugins
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+// The device plugin API vendored from KubeVirt predates GetPreferredAllocation and CDI devices. The messages below carry
+// the parts of k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1 which are missing, with the same wire format,
+// and devicePluginServiceDesc serves them next to the methods of the vendored API
+
+const (
+	devicePluginService = "v1beta1.DevicePlugin"
+	registrationService = "v1beta1.Registration"
+)
+
+// DevicePluginOptions extends pluginapi.DevicePluginOptions with GetPreferredAllocationAvailable
+type DevicePluginOptions struct {
+	PreStartRequired                bool `protobuf:"varint,1,opt,name=pre_start_required,json=preStartRequired,proto3" json:"pre_start_required,omitempty"`
+	GetPreferredAllocationAvailable bool `protobuf:"varint,2,opt,name=get_preferred_allocation_available,json=getPreferredAllocationAvailable,proto3" json:"get_preferred_allocation_available,omitempty"`
+}
+
+func (m *DevicePluginOptions) Reset()         { *m = DevicePluginOptions{} }
+func (m *DevicePluginOptions) String() string { return fmt.Sprintf("%+v", *m) }
+func (*DevicePluginOptions) ProtoMessage()    {}
+
+// RegisterRequest extends pluginapi.RegisterRequest with the Options of the plugin, which the kubelet reads
+// on registration to decide if it calls GetPreferredAllocation and PreStartContainer
+type RegisterRequest struct {
+	Version      string               `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Endpoint     string               `protobuf:"bytes,2,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	ResourceName string               `protobuf:"bytes,3,opt,name=resource_name,json=resourceName,proto3" json:"resource_name,omitempty"`
+	Options      *DevicePluginOptions `protobuf:"bytes,4,opt,name=options,proto3" json:"options,omitempty"`
+}
+
+func (m *RegisterRequest) Reset()         { *m = RegisterRequest{} }
+func (m *RegisterRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*RegisterRequest) ProtoMessage()    {}
+
+// PreferredAllocationRequest is passed via a call to GetPreferredAllocation for each container
+type PreferredAllocationRequest struct {
+	ContainerRequests []*ContainerPreferredAllocationRequest `protobuf:"bytes,1,rep,name=container_requests,json=containerRequests,proto3" json:"container_requests,omitempty"`
+}
+
+func (m *PreferredAllocationRequest) Reset()         { *m = PreferredAllocationRequest{} }
+func (m *PreferredAllocationRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*PreferredAllocationRequest) ProtoMessage()    {}
+
+// ContainerPreferredAllocationRequest lists the devices available to a container, the devices which must
+// be part of the allocation, and the number of devices to allocate
+type ContainerPreferredAllocationRequest struct {
+	AvailableDeviceIDs   []string `protobuf:"bytes,1,rep,name=available_deviceIDs,json=availableDeviceIDs,proto3" json:"available_deviceIDs,omitempty"`
+	MustIncludeDeviceIDs []string `protobuf:"bytes,2,rep,name=must_include_deviceIDs,json=mustIncludeDeviceIDs,proto3" json:"must_include_deviceIDs,omitempty"`
+	AllocationSize       int32    `protobuf:"varint,3,opt,name=allocation_size,json=allocationSize,proto3" json:"allocation_size,omitempty"`
+}
+
+func (m *ContainerPreferredAllocationRequest) Reset()         { *m = ContainerPreferredAllocationRequest{} }
+func (m *ContainerPreferredAllocationRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ContainerPreferredAllocationRequest) ProtoMessage()    {}
+
+// PreferredAllocationResponse returns the preferred allocation for each container request
+type PreferredAllocationResponse struct {
+	ContainerResponses []*ContainerPreferredAllocationResponse `protobuf:"bytes,1,rep,name=container_responses,json=containerResponses,proto3" json:"container_responses,omitempty"`
+}
+
+func (m *PreferredAllocationResponse) Reset()         { *m = PreferredAllocationResponse{} }
+func (m *PreferredAllocationResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*PreferredAllocationResponse) ProtoMessage()    {}
+
+// ContainerPreferredAllocationResponse lists the preferred devices of a container
+type ContainerPreferredAllocationResponse struct {
+	DeviceIDs []string `protobuf:"bytes,1,rep,name=deviceIDs,proto3" json:"deviceIDs,omitempty"`
+}
+
+func (m *ContainerPreferredAllocationResponse) Reset()         { *m = ContainerPreferredAllocationResponse{} }
+func (m *ContainerPreferredAllocationResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ContainerPreferredAllocationResponse) ProtoMessage()    {}
+
+// AllocateResponse extends pluginapi.AllocateResponse with the CDI devices of each container
+type AllocateResponse struct {
+	ContainerResponses []*ContainerAllocateResponse `protobuf:"bytes,1,rep,name=container_responses,json=containerResponses,proto3" json:"container_responses,omitempty"`
+}
+
+func (m *AllocateResponse) Reset()         { *m = AllocateResponse{} }
+func (m *AllocateResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*AllocateResponse) ProtoMessage()    {}
+
+// ContainerAllocateResponse extends pluginapi.ContainerAllocateResponse with CDIDevices
+type ContainerAllocateResponse struct {
+	Envs        map[string]string       `protobuf:"bytes,1,rep,name=envs,proto3" json:"envs,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Mounts      []*pluginapi.Mount      `protobuf:"bytes,2,rep,name=mounts,proto3" json:"mounts,omitempty"`
+	Devices     []*pluginapi.DeviceSpec `protobuf:"bytes,3,rep,name=devices,proto3" json:"devices,omitempty"`
+	Annotations map[string]string       `protobuf:"bytes,4,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	CDIDevices  []*CDIDevice            `protobuf:"bytes,5,rep,name=cdi_devices,json=cdiDevices,proto3" json:"cdi_devices,omitempty"`
+}
+
+func (m *ContainerAllocateResponse) Reset()         { *m = ContainerAllocateResponse{} }
+func (m *ContainerAllocateResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ContainerAllocateResponse) ProtoMessage()    {}
+
+// CDIDevice is the fully qualified name of a device in a CDI spec, e.g. vendor.com/class=name
+type CDIDevice struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *CDIDevice) Reset()         { *m = CDIDevice{} }
+func (m *CDIDevice) String() string { return fmt.Sprintf("%+v", *m) }
+func (*CDIDevice) ProtoMessage()    {}
+
+// devicePluginServer is the device plugin service including GetPreferredAllocation, and the CDI devices
+// which are added to the response of Allocate
+type devicePluginServer interface {
+	pluginapi.DevicePluginServer
+	GetPreferredAllocation(context.Context, *PreferredAllocationRequest) (*PreferredAllocationResponse, error)
+	cdiDevices(deviceIDs []string) []*CDIDevice
+}
+
+var devicePluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: devicePluginService,
+	HandlerType: (*devicePluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetDevicePluginOptions", Handler: getDevicePluginOptionsHandler},
+		{MethodName: "GetPreferredAllocation", Handler: getPreferredAllocationHandler},
+		{MethodName: "Allocate", Handler: allocateHandler},
+		{MethodName: "PreStartContainer", Handler: preStartContainerHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ListAndWatch", Handler: listAndWatchHandler, ServerStreams: true},
+	},
+}
+
+// registerWithKubelet calls Register of the registration service of the kubelet with req
+func registerWithKubelet(ctx context.Context, conn *grpc.ClientConn, req *RegisterRequest) error {
+	return conn.Invoke(ctx, fmt.Sprintf("/%s/Register", registrationService), req, new(pluginapi.Empty))
+}
+
+// registerDevicePluginServer registers dpi as the device plugin service of server
+func registerDevicePluginServer(server *grpc.Server, dpi devicePluginServer) {
+	server.RegisterService(&devicePluginServiceDesc, dpi)
+}
+
+// unaryHandler decodes the request into in, and calls method through the interceptor of the server
+func unaryHandler(ctx context.Context, method string, in interface{}, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor, srv interface{},
+	call func(ctx context.Context, req interface{}) (interface{}, error)) (interface{}, error) {
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return call(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: fmt.Sprintf("/%s/%s", devicePluginService, method),
+	}
+	return interceptor(ctx, in, info, call)
+}
+
+func getDevicePluginOptionsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unaryHandler(ctx, "GetDevicePluginOptions", new(pluginapi.Empty), dec, interceptor, srv, func(ctx context.Context, req interface{}) (interface{}, error) {
+		options, err := srv.(devicePluginServer).GetDevicePluginOptions(ctx, req.(*pluginapi.Empty))
+		if err != nil {
+			return nil, err
+		}
+		return &DevicePluginOptions{
+			PreStartRequired:                options.PreStartRequired,
+			GetPreferredAllocationAvailable: true,
+		}, nil
+	})
+}
+
+func getPreferredAllocationHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unaryHandler(ctx, "GetPreferredAllocation", new(PreferredAllocationRequest), dec, interceptor, srv, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(devicePluginServer).GetPreferredAllocation(ctx, req.(*PreferredAllocationRequest))
+	})
+}
+
+func allocateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unaryHandler(ctx, "Allocate", new(pluginapi.AllocateRequest), dec, interceptor, srv, func(ctx context.Context, req interface{}) (interface{}, error) {
+		server := srv.(devicePluginServer)
+		request := req.(*pluginapi.AllocateRequest)
+		allocation, err := server.Allocate(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		resp := &AllocateResponse{}
+		for i, container := range allocation.ContainerResponses {
+			containerResp := &ContainerAllocateResponse{
+				Envs:        container.Envs,
+				Mounts:      container.Mounts,
+				Devices:     container.Devices,
+				Annotations: container.Annotations,
+			}
+			if i < len(request.ContainerRequests) {
+				containerResp.CDIDevices = server.cdiDevices(request.ContainerRequests[i].DevicesIDs)
+			}
+			// the CDI devices inject the device nodes, which the runtime would otherwise add twice. The
+			// environment is kept, as KubeVirt looks up the devices of a resourceName by its variable
+			if len(containerResp.CDIDevices) > 0 {
+				containerResp.Devices = nil
+			}
+			resp.ContainerResponses = append(resp.ContainerResponses, containerResp)
+		}
+		return resp, nil
+	})
+}
+
+func preStartContainerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unaryHandler(ctx, "PreStartContainer", new(pluginapi.PreStartContainerRequest), dec, interceptor, srv, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(devicePluginServer).PreStartContainer(ctx, req.(*pluginapi.PreStartContainerRequest))
+	})
+}
+
+func listAndWatchHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(pluginapi.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(devicePluginServer).ListAndWatch(m, &listAndWatchServer{stream})
+}
+
+type listAndWatchServer struct {
+	grpc.ServerStream
+}
+
+func (s *listAndWatchServer) Send(m *pluginapi.ListAndWatchResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
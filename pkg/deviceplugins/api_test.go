@@ -0,0 +1,178 @@
+package deviceplugins
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+// The expected encodings below follow the field numbers of the messages in api.proto of
+// k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1, which is not a dependency of this module
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessageField(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func Test_APIWireFormat(t *testing.T) {
+	// message DevicePluginOptions { bool pre_start_required = 1; bool get_preferred_allocation_available = 2; }
+	options := appendVarintField(appendVarintField(nil, 1, 1), 2, 1)
+	// message RegisterRequest { string version = 1; string endpoint = 2; string resource_name = 3; DevicePluginOptions options = 4; }
+	register := appendStringField(nil, 1, "v1beta1")
+	register = appendStringField(register, 2, "fake.com_device.sock")
+	register = appendStringField(register, 3, "fake.com/device")
+	register = appendMessageField(register, 4, options)
+	// message ContainerPreferredAllocationRequest { repeated string available_deviceIDs = 1;
+	// repeated string must_include_deviceIDs = 2; int32 allocation_size = 3; }
+	containerPreferredRequest := appendStringField(nil, 1, "0000:04:00.0")
+	containerPreferredRequest = appendStringField(containerPreferredRequest, 1, "0000:81:00.0")
+	containerPreferredRequest = appendStringField(containerPreferredRequest, 2, "0000:81:00.0")
+	containerPreferredRequest = appendVarintField(containerPreferredRequest, 3, 2)
+	// message ContainerPreferredAllocationResponse { repeated string deviceIDs = 1; }
+	containerPreferredResponse := appendStringField(appendStringField(nil, 1, "0000:04:00.0"), 1, "0000:81:00.0")
+	// message DeviceSpec { string container_path = 1; string host_path = 2; string permissions = 3; }
+	deviceSpec := appendStringField(appendStringField(appendStringField(nil, 1, "/dev/vfio/40"), 2, "/dev/vfio/40"), 3, "mrw")
+	// message ContainerAllocateResponse { ... repeated DeviceSpec devices = 3; ... repeated CDIDevice cdi_devices = 5; }
+	// message CDIDevice { string name = 1; }
+	containerAllocateResponse := appendMessageField(nil, 3, deviceSpec)
+	containerAllocateResponse = appendMessageField(containerAllocateResponse, 5, appendStringField(nil, 1, "devices.harvesterhci.io/pci=0000:04:00.0"))
+
+	var testCases = []struct {
+		name     string
+		msg      proto.Message
+		expected []byte
+		decoded  proto.Message
+	}{
+		{
+			name:     "DevicePluginOptions",
+			msg:      &DevicePluginOptions{PreStartRequired: true, GetPreferredAllocationAvailable: true},
+			expected: options,
+			decoded:  &DevicePluginOptions{},
+		},
+		{
+			name: "RegisterRequest",
+			msg: &RegisterRequest{
+				Version:      "v1beta1",
+				Endpoint:     "fake.com_device.sock",
+				ResourceName: "fake.com/device",
+				Options:      &DevicePluginOptions{PreStartRequired: true, GetPreferredAllocationAvailable: true},
+			},
+			expected: register,
+			decoded:  &RegisterRequest{},
+		},
+		{
+			name: "PreferredAllocationRequest",
+			msg: &PreferredAllocationRequest{
+				ContainerRequests: []*ContainerPreferredAllocationRequest{
+					{
+						AvailableDeviceIDs:   []string{"0000:04:00.0", "0000:81:00.0"},
+						MustIncludeDeviceIDs: []string{"0000:81:00.0"},
+						AllocationSize:       2,
+					},
+				},
+			},
+			// message PreferredAllocationRequest { repeated ContainerPreferredAllocationRequest container_requests = 1; }
+			expected: appendMessageField(nil, 1, containerPreferredRequest),
+			decoded:  &PreferredAllocationRequest{},
+		},
+		{
+			name: "PreferredAllocationResponse",
+			msg: &PreferredAllocationResponse{
+				ContainerResponses: []*ContainerPreferredAllocationResponse{
+					{DeviceIDs: []string{"0000:04:00.0", "0000:81:00.0"}},
+				},
+			},
+			// message PreferredAllocationResponse { repeated ContainerPreferredAllocationResponse container_responses = 1; }
+			expected: appendMessageField(nil, 1, containerPreferredResponse),
+			decoded:  &PreferredAllocationResponse{},
+		},
+		{
+			name: "AllocateResponse",
+			msg: &AllocateResponse{
+				ContainerResponses: []*ContainerAllocateResponse{
+					{
+						Devices:    []*pluginapi.DeviceSpec{{ContainerPath: "/dev/vfio/40", HostPath: "/dev/vfio/40", Permissions: "mrw"}},
+						CDIDevices: []*CDIDevice{{Name: "devices.harvesterhci.io/pci=0000:04:00.0"}},
+					},
+				},
+			},
+			// message AllocateResponse { repeated ContainerAllocateResponse container_responses = 1; }
+			expected: appendMessageField(nil, 1, containerAllocateResponse),
+			decoded:  &AllocateResponse{},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			encoded, err := proto.Marshal(v.msg)
+			assert.NoError(err)
+			assert.Equal(v.expected, encoded)
+			assert.NoError(proto.Unmarshal(encoded, v.decoded))
+			assert.Equal(v.msg, v.decoded)
+		})
+	}
+}
+
+// Test_APIRoundTripsVendoredAPI checks the messages extending the vendored API share its fields
+func Test_APIRoundTripsVendoredAPI(t *testing.T) {
+	assert := require.New(t)
+	vendored := &pluginapi.AllocateResponse{
+		ContainerResponses: []*pluginapi.ContainerAllocateResponse{
+			{
+				Envs:        map[string]string{"PCI_RESOURCE_FAKE_COM_DEVICE": "0000:04:00.0"},
+				Mounts:      []*pluginapi.Mount{{ContainerPath: "/dev/shm", HostPath: "/dev/shm"}},
+				Devices:     []*pluginapi.DeviceSpec{{ContainerPath: "/dev/vfio/40", HostPath: "/dev/vfio/40", Permissions: "mrw"}},
+				Annotations: map[string]string{"fake.com/device": "0000:04:00.0"},
+			},
+		},
+	}
+	encoded, err := proto.Marshal(vendored)
+	assert.NoError(err)
+	extended := &AllocateResponse{}
+	assert.NoError(proto.Unmarshal(encoded, extended))
+	assert.Equal(&AllocateResponse{
+		ContainerResponses: []*ContainerAllocateResponse{
+			{
+				Envs:        vendored.ContainerResponses[0].Envs,
+				Mounts:      vendored.ContainerResponses[0].Mounts,
+				Devices:     vendored.ContainerResponses[0].Devices,
+				Annotations: vendored.ContainerResponses[0].Annotations,
+			},
+		},
+	}, extended)
+
+	extended.ContainerResponses[0].CDIDevices = []*CDIDevice{{Name: "devices.harvesterhci.io/pci=0000:04:00.0"}}
+	encoded, err = proto.Marshal(extended)
+	assert.NoError(err)
+	decoded := &pluginapi.AllocateResponse{}
+	assert.NoError(proto.Unmarshal(encoded, decoded))
+	assert.Equal(vendored.ContainerResponses[0].Envs, decoded.ContainerResponses[0].Envs)
+	assert.Equal(vendored.ContainerResponses[0].Devices, decoded.ContainerResponses[0].Devices)
+
+	options, err := proto.Marshal(&DevicePluginOptions{PreStartRequired: true, GetPreferredAllocationAvailable: true})
+	assert.NoError(err)
+	vendoredOptions := &pluginapi.DevicePluginOptions{}
+	assert.NoError(proto.Unmarshal(options, vendoredOptions))
+	assert.True(vendoredOptions.PreStartRequired)
+
+	register, err := proto.Marshal(&RegisterRequest{Version: "v1beta1", Endpoint: "fake.com_device.sock", ResourceName: "fake.com/device"})
+	assert.NoError(err)
+	vendoredRegister := &pluginapi.RegisterRequest{}
+	assert.NoError(proto.Unmarshal(register, vendoredRegister))
+	assert.Equal("fake.com/device", vendoredRegister.ResourceName)
+	assert.Equal("fake.com_device.sock", vendoredRegister.Endpoint)
+}
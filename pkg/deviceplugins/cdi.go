@@ -0,0 +1,177 @@
+package deviceplugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"kubevirt.io/kubevirt/pkg/util"
+)
+
+// The Container Device Interface (CDI) lets container runtimes inject devices described by spec files, which
+// are picked up from the CDI spec directories of the host. The device plugins write a spec with a device per
+// claimed PCI device, and return the names of the devices in the AllocateResponse, so containerd and CRI-O
+// inject the devices consistently, and containers which are not started by the kubelet can use them as well
+
+const (
+	cdiVersion = "0.5.0"
+	// CDIKind is the vendor and class of the devices in the CDI specs, the fully qualified name of the
+	// device of a PCI device is devices.harvesterhci.io/pci=<pci address>
+	CDIKind = "devices.harvesterhci.io/pci"
+	// CDISpecDir is the CDI spec directory for specs generated at runtime
+	CDISpecDir = "/var/run/cdi"
+)
+
+// addressEnvVarSuffix turns a PCI address into a suffix for environment variable names
+var addressEnvVarSuffix = strings.NewReplacer(":", "_", ".", "_")
+
+type cdiSpec struct {
+	Version        string            `json:"cdiVersion"`
+	Kind           string            `json:"kind"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits,omitempty"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+// cdiContainerEdits are the edits applied to a container using a device. VFIO devices only need their
+// device nodes, so the mounts and hooks of the CDI spec are not used
+type cdiContainerEdits struct {
+	Env         []string         `json:"env,omitempty"`
+	DeviceNodes []*cdiDeviceNode `json:"deviceNodes,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `json:"path"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// CDIDeviceName returns the fully qualified CDI name of the device with pciAddress
+func CDIDeviceName(pciAddress string) string {
+	return fmt.Sprintf("%s=%s", CDIKind, pciAddress)
+}
+
+// cdiSpecPath returns the path of the CDI spec of a resourceName. CDI spec file names have to be unique
+// across vendors, so they are prefixed with the vendor of CDIKind
+func cdiSpecPath(dir, resourceName string) string {
+	vendor := strings.Split(CDIKind, "/")[0]
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", vendor, strings.ReplaceAll(resourceName, "/", "_")))
+}
+
+// newCDISpec describes the devices of a resourceName. Each device adds the device node of its IOMMU group,
+// and an environment variable with the addresses of the devices in its IOMMU group, which is suffixed by the
+// address of the device, as a container may use several devices of the resourceName
+func newCDISpec(resourceName string, pcidevs []*PCIDevice) *cdiSpec {
+	resourceNameEnvVar := util.ResourceNameToEnvVar(PCIResourcePrefix, resourceName)
+	groups := make(map[string][]string)
+	for _, dev := range pcidevs {
+		groups[dev.iommuGroup] = append(groups[dev.iommuGroup], dev.pciAddress)
+	}
+
+	spec := &cdiSpec{
+		Version: cdiVersion,
+		Kind:    CDIKind,
+		ContainerEdits: cdiContainerEdits{
+			DeviceNodes: []*cdiDeviceNode{{Path: vfioMount, Permissions: "rwm"}},
+		},
+		Devices: []cdiDevice{},
+	}
+	for _, dev := range pcidevs {
+		group := append([]string{}, groups[dev.iommuGroup]...)
+		sort.Strings(group)
+		spec.Devices = append(spec.Devices, cdiDevice{
+			Name: dev.pciAddress,
+			ContainerEdits: cdiContainerEdits{
+				Env: []string{fmt.Sprintf("%s_%s=%s", resourceNameEnvVar, addressEnvVarSuffix.Replace(dev.pciAddress), strings.Join(group, ","))},
+				DeviceNodes: []*cdiDeviceNode{
+					{Path: filepath.Join(vfioDevicePath, dev.iommuGroup), Permissions: "rwm"},
+				},
+			},
+		})
+	}
+	sort.Slice(spec.Devices, func(i, j int) bool {
+		return spec.Devices[i].Name < spec.Devices[j].Name
+	})
+	return spec
+}
+
+// writeCDISpec writes the CDI spec of the devices of the plugin. The spec is replaced atomically, as
+// container runtimes watch the CDI spec directories. Allocations only return CDI devices once the spec
+// describing them was written
+func (dpi *PCIDevicePlugin) writeCDISpec() {
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
+	if dpi.cdiDir == "" {
+		return
+	}
+	dpi.cdiWritten = false
+
+	out, err := json.MarshalIndent(newCDISpec(dpi.resourceName, dpi.pcidevs), "", "  ")
+	if err != nil {
+		logrus.Errorf("error generating cdi spec of %s: %v", dpi.resourceName, err)
+		return
+	}
+	if err := os.MkdirAll(dpi.cdiDir, 0755); err != nil {
+		logrus.Errorf("error creating cdi spec directory %s: %v", dpi.cdiDir, err)
+		return
+	}
+	specPath := cdiSpecPath(dpi.cdiDir, dpi.resourceName)
+	tmp, err := os.CreateTemp(dpi.cdiDir, ".tmp-*")
+	if err != nil {
+		logrus.Errorf("error writing cdi spec %s: %v", specPath, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(out)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), specPath)
+	}
+	if err != nil {
+		logrus.Errorf("error writing cdi spec %s: %v", specPath, err)
+		return
+	}
+	dpi.cdiWritten = true
+}
+
+// removeCDISpec removes the CDI spec of the plugin, once the plugin is no longer served
+func (dpi *PCIDevicePlugin) removeCDISpec() error {
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
+	if dpi.cdiDir == "" {
+		return nil
+	}
+	dpi.cdiWritten = false
+	if err := os.Remove(cdiSpecPath(dpi.cdiDir, dpi.resourceName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing cdi spec of %s: %v", dpi.resourceName, err)
+	}
+	return nil
+}
+
+// cdiDevices returns the CDI devices of the allocated devices, or none if the CDI spec was not written
+func (dpi *PCIDevicePlugin) cdiDevices(deviceIDs []string) []*CDIDevice {
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
+	if !dpi.cdiWritten {
+		return nil
+	}
+	var devices []*CDIDevice
+	for _, id := range deviceIDs {
+		if _, exists := dpi.iommuToPCIMap[id]; exists {
+			devices = append(devices, &CDIDevice{Name: CDIDeviceName(id)})
+		}
+	}
+	return devices
+}
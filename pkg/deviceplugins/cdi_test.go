@@ -0,0 +1,90 @@
+package deviceplugins
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+func Test_CDISpec(t *testing.T) {
+	assert := require.New(t)
+	dp := newTopologyPlugin()
+	dp.EnableCDI(t.TempDir())
+	dp.writeCDISpec()
+
+	specPath := filepath.Join(dp.cdiDir, "devices.harvesterhci.io-fake.com_device.json")
+	content, err := os.ReadFile(specPath)
+	assert.NoError(err)
+	spec := &cdiSpec{}
+	assert.NoError(json.Unmarshal(content, spec))
+	assert.Equal(CDIKind, spec.Kind)
+	assert.Equal([]*cdiDeviceNode{{Path: "/dev/vfio/vfio", Permissions: "rwm"}}, spec.ContainerEdits.DeviceNodes)
+	assert.Len(spec.Devices, 7)
+
+	devices := make(map[string]cdiDevice)
+	for _, dev := range spec.Devices {
+		devices[dev.Name] = dev
+	}
+	assert.Equal([]*cdiDeviceNode{{Path: "/dev/vfio/40", Permissions: "rwm"}}, devices["0000:04:00.0"].ContainerEdits.DeviceNodes)
+	assert.Equal([]string{"PCI_RESOURCE_FAKE_COM_DEVICE_0000_04_00_0=0000:04:00.0"}, devices["0000:04:00.0"].ContainerEdits.Env)
+	assert.Equal([]string{"PCI_RESOURCE_FAKE_COM_DEVICE_0000_83_00_1=0000:83:00.0,0000:83:00.1"}, devices["0000:83:00.1"].ContainerEdits.Env,
+		"expected the devices of the iommu group in the environment")
+
+	assert.NoError(dp.removeCDISpec())
+	_, err = os.Stat(specPath)
+	assert.True(os.IsNotExist(err), "expected the cdi spec to be removed")
+	assert.Empty(dp.cdiDevices([]string{"0000:04:00.0"}), "expected no cdi devices without a cdi spec")
+}
+
+// Test_AllocateReturnsCDIDevices calls Allocate over gRPC, as the kubelet does
+func Test_AllocateReturnsCDIDevices(t *testing.T) {
+	assert := require.New(t)
+	dp := newTopologyPlugin()
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	lis, err := net.Listen("unix", socket)
+	assert.NoError(err)
+	server := grpc.NewServer()
+	registerDevicePluginServer(server, dp)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(err)
+	defer conn.Close()
+
+	req := &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0000:04:00.0", "0000:81:00.0"}},
+		},
+	}
+	resp := &AllocateResponse{}
+	assert.NoError(conn.Invoke(context.Background(), "/v1beta1.DevicePlugin/Allocate", req, resp))
+	assert.Len(resp.ContainerResponses, 1)
+	assert.Empty(resp.ContainerResponses[0].CDIDevices, "expected no cdi devices when cdi is not enabled")
+	assert.Len(resp.ContainerResponses[0].Devices, 4, "expected the device specs without cdi")
+
+	dp.EnableCDI(t.TempDir())
+	dp.writeCDISpec()
+	resp = &AllocateResponse{}
+	assert.NoError(conn.Invoke(context.Background(), "/v1beta1.DevicePlugin/Allocate", req, resp))
+	assert.Len(resp.ContainerResponses, 1)
+	assert.Equal([]*CDIDevice{
+		{Name: "devices.harvesterhci.io/pci=0000:04:00.0"},
+		{Name: "devices.harvesterhci.io/pci=0000:81:00.0"},
+	}, resp.ContainerResponses[0].CDIDevices)
+	assert.Contains(resp.ContainerResponses[0].Envs["PCI_RESOURCE_FAKE_COM_DEVICE"], "0000:81:00.0")
+	assert.Empty(resp.ContainerResponses[0].Devices, "expected the cdi devices to replace the device specs")
+
+	// clients predating cdi devices ignore them
+	allocation, err := pluginapi.NewDevicePluginClient(conn).Allocate(context.Background(), req)
+	assert.NoError(err)
+	assert.Contains(allocation.ContainerResponses[0].Envs["PCI_RESOURCE_FAKE_COM_DEVICE"], "0000:81:00.0")
+}
@@ -41,6 +41,12 @@ type DeviceHandler interface {
 	GetDeviceDriver(basepath string, pciAddress string) (string, error)
 	GetDeviceNumaNode(basepath string, pciAddress string) (numaNode int)
 	GetDevicePCIID(basepath string, pciAddress string) (string, error)
+	GetDevicePCIPath(basepath string, pciAddress string) ([]string, error)
+}
+
+// DeviceResetter resets a PCI device, and returns the reset method used
+type DeviceResetter interface {
+	Reset(pciAddress string) (string, error)
 }
 
 type DeviceUtilsHandler struct{}
@@ -110,6 +116,23 @@ func (h *DeviceUtilsHandler) GetDevicePCIID(basepath string, pciAddress string)
 	return "", fmt.Errorf("no pci_id is found")
 }
 
+// GetDevicePCIPath gets the PCI addresses of the bridges and ports from the root complex down to the device,
+// including the device itself. Devices under the same PCIe switch share the path up to the switch
+// e.g. /sys/bus/pci/devices/0000:04:00.0 -> ../../../devices/pci0000:00/0000:00:01.0/0000:02:00.0/0000:03:08.0/0000:04:00.0
+func (h *DeviceUtilsHandler) GetDevicePCIPath(basepath string, pciAddress string) ([]string, error) {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(basepath, pciAddress))
+	if err != nil {
+		return nil, err
+	}
+	var path []string
+	for _, element := range strings.Split(devicePath, string(filepath.Separator)) {
+		if strings.HasPrefix(element, "pci") || strings.Count(element, ":") == 2 {
+			path = append(path, element)
+		}
+	}
+	return path, nil
+}
+
 func initHandler() {
 	if Handler == nil {
 		Handler = &DeviceUtilsHandler{}
@@ -174,8 +197,3 @@ func formatVFIODeviceSpecs(devID string) []*v1beta1.DeviceSpec {
 	})
 	return devSpecs
 }
-
-type deviceHealth struct {
-	DevId  string
-	Health string
-}
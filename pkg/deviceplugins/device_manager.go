@@ -37,6 +37,8 @@ import (
 	"kubevirt.io/client-go/log"
 	"kubevirt.io/kubevirt/pkg/util"
 	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+
+	"github.com/harvester/pcidevices/pkg/util/pcireset"
 )
 
 const (
@@ -45,6 +47,8 @@ const (
 	pciBasePath       = "/sys/bus/pci/devices"
 	connectionTimeout = 120 * time.Second // Google gRPC default timeout
 	PCIResourcePrefix = "PCI_RESOURCE"
+	// PCIDevicePrefix is the prefix of the environment variable of the allocated devices read by userspace drivers
+	PCIDevicePrefix = "PCIDEVICE"
 )
 
 type PCIDevice struct {
@@ -53,24 +57,52 @@ type PCIDevice struct {
 	pciAddress string
 	iommuGroup string
 	numaNode   int
+	// pciPath is the path of the device in the PCI hierarchy, as returned by GetDevicePCIPath
+	pciPath []string
 }
 
 type PCIDevicePlugin struct {
-	pcidevs       []*PCIDevice
+	pcidevs      []*PCIDevice
+	server       *grpc.Server
+	socketPath   string
+	stop         <-chan struct{}
+	devicePath   string
+	resourceName string
+	done         chan struct{}
+	deviceRoot   string
+	initialized  bool
+	deregistered chan struct{}
+	starter      *DeviceStarter
+	// kubeletSocket is the kubelet registration socket the plugin registers with
+	kubeletSocket string
+	// restart is signalled when the kubelet restarted, and the plugin has to serve and register again
+	restart chan struct{}
+	// onRegistered is called whenever the plugin registered with the kubelet
+	onRegistered func()
+	// resetter resets devices in PreStartContainer, it is nil if devices are not reset before use
+	resetter DeviceResetter
+	// healthChecker probes the devices while the kubelet watches them, it is nil if only the presence
+	// of the vfio group device is checked
+	healthChecker DeviceHealthChecker
+	// onHealthChange is called with the result of the first probe of a device, and whenever it changes
+	onHealthChange func(pciAddress string, err error)
+	probeInterval  time.Duration
+	// probeErrors holds why devices failed their last probe. The health reported to the kubelet is the
+	// health set by the claim controller, unless the device failed its probe
+	probeErrors map[string]error
+	probed      map[string]bool
+	// cdiDir is where the CDI spec of the devices is written, it is empty if no CDI spec is written.
+	// cdiWritten is set once the spec describes the current devices
+	cdiDir     string
+	cdiWritten bool
+	// lock guards pcidevs, devs and iommuToPCIMap, which are updated by the claim controller
+	// and health checks while being read by the kubelet facing gRPC handlers, as well
+	// as the lifecycle fields above which are set by Start and read by Stop
+	lock          *sync.Mutex
 	devs          []*pluginapi.Device
-	server        *grpc.Server
-	socketPath    string
-	stop          <-chan struct{}
-	health        chan deviceHealth
-	devicePath    string
-	resourceName  string
-	done          chan struct{}
-	deviceRoot    string
 	iommuToPCIMap map[string]string
-	initialized   bool
-	lock          *sync.Mutex
-	deregistered  chan struct{}
-	starter       *DeviceStarter
+	// updated is signalled whenever the health of a device changes
+	updated chan struct{}
 }
 
 type DeviceStarter struct {
@@ -80,11 +112,15 @@ type DeviceStarter struct {
 }
 
 func (dp *PCIDevicePlugin) GetPCIDevices() []*PCIDevice {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
 	return dp.pcidevs
 }
 
 // Not adding more data to the struct, it's big enough already
 func (dp *PCIDevicePlugin) GetCount() int {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
 	var count int
 	for _, dev := range dp.devs {
 		if dev.Health == pluginapi.Healthy {
@@ -98,6 +134,10 @@ func (d *PCIDevice) GetID() string {
 	return d.pciID
 }
 
+func (d *PCIDevice) GetAddress() string {
+	return d.pciAddress
+}
+
 func NewPCIDevicePlugin(pciDevices []*PCIDevice, resourceName string) *PCIDevicePlugin {
 	serverSock := SocketPath(strings.Replace(resourceName, "/", "-", -1))
 	iommuToPCIMap := make(map[string]string)
@@ -113,7 +153,12 @@ func NewPCIDevicePlugin(pciDevices []*PCIDevice, resourceName string) *PCIDevice
 		devicePath:    vfioDevicePath,
 		deviceRoot:    util.HostRootMount,
 		iommuToPCIMap: iommuToPCIMap,
-		health:        make(chan deviceHealth),
+		updated:       make(chan struct{}, 1),
+		kubeletSocket: pluginapi.KubeletSocket,
+		probeInterval: healthProbeInterval,
+		probeErrors:   make(map[string]error),
+		probed:        make(map[string]bool),
+		restart:       make(chan struct{}, 1),
 		initialized:   false,
 		lock:          &sync.Mutex{},
 		starter: &DeviceStarter{
@@ -149,26 +194,38 @@ var defaultBackoffTime = []time.Duration{1 * time.Second, 2 * time.Second, 5 * t
 
 // Set Started is used after a call to Start. It's purpose is to set the private starter properly
 func (dpi *PCIDevicePlugin) SetStarted(stop chan struct{}) {
+	dpi.lock.Lock()
 	c := dpi.starter
 	c.stopChan = stop
 	c.started = true
+	dpi.lock.Unlock()
 	logrus.Infof("Started DevicePlugin: %s", dpi.resourceName)
 }
 
 func (dpi *PCIDevicePlugin) Started() bool {
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
 	return dpi.starter.started
 }
 
 func (dpi *PCIDevicePlugin) Stop() error {
-	return dpi.stopDevicePlugin()
+	if err := dpi.stopDevicePlugin(); err != nil {
+		return err
+	}
+	return dpi.removeCDISpec()
 }
 
-// Start starts the device plugin
+// Start starts the device plugin, and serves it until stop is closed, the gRPC server fails, or the
+// kubelet restarted. Serving the plugin again after it returned is up to the caller
 func (dpi *PCIDevicePlugin) Start(stop <-chan struct{}) (err error) {
 	logger := log.DefaultLogger()
+	server := grpc.NewServer([]grpc.ServerOption{}...)
+	dpi.lock.Lock()
 	dpi.stop = stop
 	dpi.done = make(chan struct{})
 	dpi.deregistered = make(chan struct{})
+	dpi.server = server
+	dpi.lock.Unlock()
 
 	err = dpi.cleanup()
 	if err != nil {
@@ -180,15 +237,14 @@ func (dpi *PCIDevicePlugin) Start(stop <-chan struct{}) (err error) {
 		return fmt.Errorf("error creating GRPC server socket: %v", err)
 	}
 
-	dpi.server = grpc.NewServer([]grpc.ServerOption{}...)
 	defer dpi.stopDevicePlugin()
 
-	pluginapi.RegisterDevicePluginServer(dpi.server, dpi)
+	registerDevicePluginServer(server, dpi)
 
 	errChan := make(chan error, 1)
 
 	go func() {
-		errChan <- dpi.server.Serve(sock)
+		errChan <- server.Serve(sock)
 	}()
 
 	err = waitForGRPCServer(dpi.socketPath, connectionTimeout)
@@ -196,6 +252,15 @@ func (dpi *PCIDevicePlugin) Start(stop <-chan struct{}) (err error) {
 		return fmt.Errorf("error starting the GRPC server: %v", err)
 	}
 
+	// the devices are described before the kubelet allocates any of them
+	dpi.writeCDISpec()
+
+	// a restart of the kubelet before the registration is already handled by registering now
+	select {
+	case <-dpi.restart:
+	default:
+	}
+
 	err = dpi.register()
 	if err != nil {
 		return fmt.Errorf("error registering with device plugin manager: %v", err)
@@ -203,39 +268,58 @@ func (dpi *PCIDevicePlugin) Start(stop <-chan struct{}) (err error) {
 
 	dpi.setInitialized(true)
 	logger.Infof("Initialized DevicePlugin: %s", dpi.resourceName)
+	dpi.lock.Lock()
 	dpi.starter.started = true
-	err = <-errChan
+	onRegistered := dpi.onRegistered
+	dpi.lock.Unlock()
+	if onRegistered != nil {
+		onRegistered()
+	}
 
+	select {
+	case err = <-errChan:
+	case <-stop:
+	case <-dpi.restart:
+		logger.Infof("kubelet restarted, restarting DevicePlugin: %s", dpi.resourceName)
+	}
 	return err
 }
 
+// signalRestart makes Start return, so the plugin is served and registered again. The kubelet
+// forgets all plugins when it restarts, and removes their sockets
+func (dpi *PCIDevicePlugin) signalRestart() {
+	select {
+	case dpi.restart <- struct{}{}:
+	default:
+	}
+}
+
 func (dpi *PCIDevicePlugin) ListAndWatch(_ *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
+	dpi.lock.Lock()
+	stop, done, deregistered := dpi.stop, dpi.done, dpi.deregistered
+	dpi.lock.Unlock()
 
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- dpi.healthCheck()
+		errChan <- dpi.healthCheck(stop)
 	}()
 
 	emptyList := []*pluginapi.Device{}
-	s.Send(&pluginapi.ListAndWatchResponse{Devices: dpi.devs})
+	s.Send(&pluginapi.ListAndWatchResponse{Devices: dpi.listDevices()})
 
-	done := false
+	finished := false
 	for {
 		select {
-		case devHealth := <-dpi.health:
-			for _, dev := range dpi.devs {
-				if devHealth.DevId == dev.ID {
-					dev.Health = devHealth.Health
-				}
-			}
-			s.Send(&pluginapi.ListAndWatchResponse{Devices: dpi.devs})
-			logrus.Debugf("Sending ListAndWatchResponse for device with dpi.devs = %v", dpi.devs)
-		case <-dpi.stop:
-			done = true
-		case <-dpi.done:
-			done = true
+		case <-dpi.updated:
+			devs := dpi.listDevices()
+			s.Send(&pluginapi.ListAndWatchResponse{Devices: devs})
+			logrus.Debugf("Sending ListAndWatchResponse for device with dpi.devs = %v", devs)
+		case <-stop:
+			finished = true
+		case <-done:
+			finished = true
 		}
-		if done {
+		if finished {
 			break
 		}
 	}
@@ -244,49 +328,159 @@ func (dpi *PCIDevicePlugin) ListAndWatch(_ *pluginapi.Empty, s pluginapi.DeviceP
 	if err := s.Send(&pluginapi.ListAndWatchResponse{Devices: emptyList}); err != nil {
 		log.DefaultLogger().Reason(err).Infof("%s device plugin failed to deregister: %s", dpi.resourceName, err)
 	}
-	close(dpi.deregistered)
+	close(deregistered)
 	return <-errChan
 }
 
+// listDevices returns a copy of the devices, safe to hand over to the gRPC stream. Devices which failed
+// their last probe are listed as unhealthy
+func (dpi *PCIDevicePlugin) listDevices() []*pluginapi.Device {
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
+	devs := make([]*pluginapi.Device, 0, len(dpi.devs))
+	for _, dev := range dpi.devs {
+		devCopy := *dev
+		if dpi.probeErrors[dev.ID] != nil {
+			devCopy.Health = pluginapi.Unhealthy
+		}
+		devs = append(devs, &devCopy)
+	}
+	return devs
+}
+
+// probeDevices probes all devices of the plugin
+func (dpi *PCIDevicePlugin) probeDevices() {
+	dpi.lock.Lock()
+	ids := make([]string, 0, len(dpi.devs))
+	for _, dev := range dpi.devs {
+		ids = append(ids, dev.ID)
+	}
+	dpi.lock.Unlock()
+	for _, id := range ids {
+		dpi.probeDevice(id)
+	}
+}
+
+// probeDevice checks that the vfio group device of the device exists, and probes the device with the
+// health checker. A change of the result is sent to the kubelet and passed to onHealthChange
+func (dpi *PCIDevicePlugin) probeDevice(devID string) {
+	dpi.lock.Lock()
+	iommuGroup := dpi.iommuToPCIMap[devID]
+	checker, onHealthChange := dpi.healthChecker, dpi.onHealthChange
+	dpi.lock.Unlock()
+
+	var probeErr error
+	vfioDevice := filepath.Join(dpi.deviceRoot, dpi.devicePath, iommuGroup)
+	if _, err := os.Stat(vfioDevice); err != nil {
+		probeErr = fmt.Errorf("vfio device %s is missing", filepath.Join(dpi.devicePath, iommuGroup))
+	} else if checker != nil {
+		probeErr = checker.Check(devID)
+	}
+
+	dpi.lock.Lock()
+	previous, probed := dpi.probeErrors[devID], dpi.probed[devID]
+	changed := (previous == nil) != (probeErr == nil) || (previous != nil && previous.Error() != probeErr.Error())
+	if probeErr != nil {
+		dpi.probeErrors[devID] = probeErr
+	} else {
+		delete(dpi.probeErrors, devID)
+	}
+	dpi.probed[devID] = true
+	dpi.lock.Unlock()
+
+	if changed {
+		if probeErr != nil {
+			logrus.Warnf("device %s of %s is unhealthy: %v", devID, dpi.resourceName, probeErr)
+		} else {
+			logrus.Infof("device %s of %s is healthy again", devID, dpi.resourceName)
+		}
+		select {
+		case dpi.updated <- struct{}{}:
+		default:
+		}
+	}
+	if (changed || !probed) && onHealthChange != nil {
+		onHealthChange(devID, probeErr)
+	}
+}
+
+// setDeviceHealth updates the health of the device with devID and notifies ListAndWatch of the change
+func (dpi *PCIDevicePlugin) setDeviceHealth(devID string, health string) {
+	dpi.lock.Lock()
+	changed := false
+	for _, dev := range dpi.devs {
+		if dev.ID == devID && dev.Health != health {
+			dev.Health = health
+			changed = true
+		}
+	}
+	dpi.lock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	// updated is buffered, if a notification is already pending the next
+	// ListAndWatch response will include this change as well
+	select {
+	case dpi.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Allocate returns the vfio devices of the IOMMU groups of the allocated devices for each container. The
+// addresses of the devices and their IOMMU group siblings are passed in PCI_RESOURCE_<resourceName>, which
+// is read by KubeVirt, and in PCIDEVICE_<resourceName>, which is where userspace drivers like DPDK look for
+// the devices of a resource
 func (dpi *PCIDevicePlugin) Allocate(_ context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
 	logrus.Debugf("Allocate request %s", r.String())
 	resourceNameEnvVar := util.ResourceNameToEnvVar(PCIResourcePrefix, dpi.resourceName)
-	allocatedDevices := []string{}
+	userspaceEnvVar := util.ResourceNameToEnvVar(PCIDevicePrefix, dpi.resourceName)
 	resp := new(pluginapi.AllocateResponse)
-	containerResponse := new(pluginapi.ContainerAllocateResponse)
 
+	dpi.lock.Lock()
+	defer dpi.lock.Unlock()
 	for _, request := range r.ContainerRequests {
+		allocatedDevices := []string{}
 		deviceSpecs := make([]*pluginapi.DeviceSpec, 0)
 		for _, devID := range request.DevicesIDs {
 			// translate device's iommu group to its pci address
 			logrus.Debugf("looking up deviceID %s in map %v", devID, dpi.iommuToPCIMap)
-			iommuGroup, exist := dpi.iommuToPCIMap[devID] // not finding device ids
-			if exist {
-				// if device exists, check if there other devices
-				// in the same iommuGroup, and append these too
-				allocatedDevices = append(allocatedDevices, devID)
-				for devPCIAddress, ig := range dpi.iommuToPCIMap {
-					if ig == iommuGroup {
-						allocatedDevices = append(allocatedDevices, devPCIAddress)
-					}
+			iommuGroup, exist := dpi.iommuToPCIMap[devID]
+			if !exist {
+				continue // skip devices which are not handled by this plugin
+			}
+			// if device exists, check if there other devices
+			// in the same iommuGroup, and append these too
+			allocatedDevices = appendUnique(allocatedDevices, devID)
+			for devPCIAddress, ig := range dpi.iommuToPCIMap {
+				if ig == iommuGroup {
+					allocatedDevices = appendUnique(allocatedDevices, devPCIAddress)
 				}
-				deviceSpecs = append(deviceSpecs, formatVFIODeviceSpecs(iommuGroup)...)
-			} else {
-				continue // break execution of loop as we are not handling this device
 			}
+			deviceSpecs = append(deviceSpecs, formatVFIODeviceSpecs(iommuGroup)...)
 		}
-		containerResponse.Devices = deviceSpecs
-		envVar := make(map[string]string)
-		envVar[resourceNameEnvVar] = strings.Join(allocatedDevices, ",")
-
-		containerResponse.Envs = envVar
-		resp.ContainerResponses = append(resp.ContainerResponses, containerResponse)
+		addresses := strings.Join(allocatedDevices, ",")
+		resp.ContainerResponses = append(resp.ContainerResponses, &pluginapi.ContainerAllocateResponse{
+			Devices: deviceSpecs,
+			Envs: map[string]string{
+				resourceNameEnvVar: addresses,
+				userspaceEnvVar:    addresses,
+			},
+		})
 	}
 	logrus.Debugf("Allocate response %v", resp)
 	return resp, nil
 }
 
-func (dpi *PCIDevicePlugin) healthCheck() error {
+func appendUnique(ids []string, id string) []string {
+	if contains(ids, id) {
+		return ids
+	}
+	return append(ids, id)
+}
+
+func (dpi *PCIDevicePlugin) healthCheck(stop <-chan struct{}) error {
 	logger := log.DefaultLogger()
 	monitoredDevices := make(map[string]string)
 	watcher, err := fsnotify.NewWatcher()
@@ -313,6 +507,7 @@ func (dpi *PCIDevicePlugin) healthCheck() error {
 	}
 
 	// probe all devices
+	dpi.lock.Lock()
 	for _, dev := range dpi.devs {
 		// get iommuGroup from PCI Addr
 		for pciAddr, iommuGroup := range dpi.iommuToPCIMap {
@@ -320,12 +515,17 @@ func (dpi *PCIDevicePlugin) healthCheck() error {
 				vfioDevice := filepath.Join(devicePath, iommuGroup)
 				err = watcher.Add(vfioDevice)
 				if err != nil {
+					dpi.lock.Unlock()
 					return fmt.Errorf("failed to add the device %s to the watcher: %v", vfioDevice, err)
 				}
 				monitoredDevices[dev.ID] = vfioDevice
 			}
 		}
 	}
+	dpi.lock.Unlock()
+	dpi.probeDevices()
+	ticker := time.NewTicker(dpi.probeInterval)
+	defer ticker.Stop()
 
 	dirName = filepath.Dir(dpi.socketPath)
 	err = watcher.Add(dirName)
@@ -345,26 +545,19 @@ func (dpi *PCIDevicePlugin) healthCheck() error {
 
 	for {
 		select {
-		case <-dpi.stop:
+		case <-stop:
 			return nil
+		case <-ticker.C:
+			dpi.probeDevices()
 		case err := <-watcher.Errors:
 			logger.Reason(err).Errorf("error watching devices and device plugin directory")
 		case event := <-watcher.Events:
 			logger.V(4).Infof("health Event: %v", event)
 			if monDevId, exist := monitoredDevices[event.Name]; exist {
-				// Health in this case is if the device path actually exists
-				if event.Op == fsnotify.Create {
-					logger.Infof("monitored device %s appeared", dpi.resourceName)
-					dpi.health <- deviceHealth{
-						DevId:  monDevId,
-						Health: pluginapi.Healthy,
-					}
-				} else if (event.Op == fsnotify.Remove) || (event.Op == fsnotify.Rename) {
-					logger.Infof("monitored device %s disappeared", dpi.resourceName)
-					dpi.health <- deviceHealth{
-						DevId:  monDevId,
-						Health: pluginapi.Unhealthy,
-					}
+				// the device is probed right away when its vfio group device appears or disappears
+				if event.Op == fsnotify.Create || event.Op == fsnotify.Remove || event.Op == fsnotify.Rename {
+					logger.Infof("monitored device %s of %s changed: %v", monDevId, dpi.resourceName, event.Op)
+					dpi.probeDevice(monDevId)
 				}
 			} else if event.Name == dpi.socketPath && event.Op == fsnotify.Remove {
 				logger.Infof("device socket file for device %s was removed, kubelet probably restarted.", dpi.resourceName)
@@ -390,45 +583,52 @@ func (dpi *PCIDevicePlugin) Restart() string {
 
 // Stop stops the gRPC server
 func (dpi *PCIDevicePlugin) stopDevicePlugin() error {
-	defer func() {
-		if !IsChanClosed(dpi.done) {
-			close(dpi.done)
-		}
-	}()
+	dpi.lock.Lock()
+	done, deregistered, server := dpi.done, dpi.deregistered, dpi.server
+	if done != nil && !IsChanClosed(done) {
+		close(done)
+	}
+	dpi.lock.Unlock()
 
 	// Give the device plugin one second to properly deregister
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 	select {
-	case <-dpi.deregistered:
+	case <-deregistered:
 	case <-ticker.C:
 	}
 
-	dpi.server.Stop()
+	if server != nil {
+		server.Stop()
+	}
 	dpi.setInitialized(false)
 	return dpi.cleanup()
 }
 
 // Register the device plugin for the given resourceName with Kubelet.
 func (dpi *PCIDevicePlugin) register() error {
-	conn, err := gRPCConnect(pluginapi.KubeletSocket, connectionTimeout)
+	conn, err := gRPCConnect(dpi.kubeletSocket, connectionTimeout)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	client := pluginapi.NewRegistrationClient(conn)
-	reqt := &pluginapi.RegisterRequest{
+	options, err := dpi.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		return err
+	}
+	reqt := &RegisterRequest{
 		Version:      pluginapi.Version,
 		Endpoint:     path.Base(dpi.socketPath),
 		ResourceName: dpi.resourceName,
+		// the kubelet only calls GetPreferredAllocation and PreStartContainer if the options of the
+		// registration enable them
+		Options: &DevicePluginOptions{
+			PreStartRequired:                options.PreStartRequired,
+			GetPreferredAllocationAvailable: true,
+		},
 	}
-
-	_, err = client.Register(context.Background(), reqt)
-	if err != nil {
-		return err
-	}
-	return nil
+	return registerWithKubelet(context.Background(), conn, reqt)
 }
 
 func (dpi *PCIDevicePlugin) cleanup() error {
@@ -441,55 +641,73 @@ func (dpi *PCIDevicePlugin) cleanup() error {
 
 func (dpi *PCIDevicePlugin) GetDevicePluginOptions(_ context.Context, _ *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
 	options := &pluginapi.DevicePluginOptions{
-		PreStartRequired: false,
+		PreStartRequired: dpi.resetter != nil,
 	}
 	return options, nil
 }
 
-func (dpi *PCIDevicePlugin) PreStartContainer(_ context.Context, _ *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+// PreStartContainer resets the allocated devices when reset on PreStartContainer is enabled, so no state
+// from a previous VM is visible. Devices which fail to reset are marked unhealthy
+func (dpi *PCIDevicePlugin) PreStartContainer(_ context.Context, req *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
 	res := &pluginapi.PreStartContainerResponse{}
+	if dpi.resetter == nil {
+		return res, nil
+	}
+
+	for _, devID := range req.DevicesIDs {
+		method, err := dpi.resetter.Reset(devID)
+		if err != nil && !errors.Is(err, pcireset.ErrResetNotSupported) {
+			logrus.Errorf("error resetting device %s before container start, marking it unhealthy: %v", devID, err)
+			dpi.MarkPCIDeviceAsUnhealthy(devID)
+			return nil, err
+		}
+		logrus.Infof("reset device %s before container start using reset method %s", devID, method)
+	}
 	return res, nil
 }
 
-func discoverPermittedHostPCIDevices(supportedPCIDeviceMap map[string]string) map[string][]*PCIDevice {
+// DiscoverPermittedHostPCIDevices finds the devices in basePath which are bound to vfio-pci, and whose
+// vendor:device id is in supportedPCIDeviceMap. The devices are grouped by the resourceName they are mapped to
+func DiscoverPermittedHostPCIDevices(basePath string, supportedPCIDeviceMap map[string]string) (map[string][]*PCIDevice, error) {
 	initHandler()
 
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("error listing pci devices in %s: %v", basePath, err)
+	}
+
 	pciDevicesMap := make(map[string][]*PCIDevice)
-	err := filepath.Walk(pciBasePath, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
+	for _, entry := range entries {
+		address := entry.Name()
+		pciID, err := Handler.GetDevicePCIID(basePath, address)
+		if err != nil {
+			log.DefaultLogger().Reason(err).Errorf("failed get vendor:device ID for device: %s", address)
+			continue
 		}
-		pciID, err := Handler.GetDevicePCIID(pciBasePath, info.Name())
+		resourceName, supported := supportedPCIDeviceMap[pciID]
+		if !supported {
+			continue
+		}
+		// check device driver
+		driver, err := Handler.GetDeviceDriver(basePath, address)
+		if err != nil || driver != "vfio-pci" {
+			continue
+		}
+		iommuGroup, err := Handler.GetDeviceIOMMUGroup(basePath, address)
 		if err != nil {
-			log.DefaultLogger().Reason(err).Errorf("failed get vendor:device ID for device: %s", info.Name())
-			return nil
+			continue
 		}
-		if resourceName, supported := supportedPCIDeviceMap[pciID]; supported {
-			// check device driver
-			driver, err := Handler.GetDeviceDriver(pciBasePath, info.Name())
-			if err != nil || driver != "vfio-pci" {
-				return nil
-			}
-
-			pcidev := &PCIDevice{
-				pciID:      pciID,
-				pciAddress: info.Name(),
-			}
-			iommuGroup, err := Handler.GetDeviceIOMMUGroup(pciBasePath, info.Name())
-			if err != nil {
-				return nil
-			}
-			pcidev.iommuGroup = iommuGroup
-			pcidev.driver = driver
-			pcidev.numaNode = Handler.GetDeviceNumaNode(pciBasePath, info.Name())
-			pciDevicesMap[resourceName] = append(pciDevicesMap[resourceName], pcidev)
+		pcidev := &PCIDevice{
+			pciID:      address,
+			driver:     driver,
+			pciAddress: address,
+			iommuGroup: iommuGroup,
+			numaNode:   Handler.GetDeviceNumaNode(basePath, address),
 		}
-		return nil
-	})
-	if err != nil {
-		log.DefaultLogger().Reason(err).Errorf("failed to discover host devices")
+		pcidev.pciPath, _ = Handler.GetDevicePCIPath(basePath, address)
+		pciDevicesMap[resourceName] = append(pciDevicesMap[resourceName], pcidev)
 	}
-	return pciDevicesMap
+	return pciDevicesMap, nil
 }
 
 func (dpi *PCIDevicePlugin) GetInitialized() bool {
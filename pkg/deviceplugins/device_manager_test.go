@@ -0,0 +1,208 @@
+package deviceplugins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+// fakeListAndWatchServer records the responses sent by ListAndWatch
+type fakeListAndWatchServer struct {
+	grpc.ServerStream
+	lock      sync.Mutex
+	responses [][]*pluginapi.Device
+}
+
+func (f *fakeListAndWatchServer) Send(resp *pluginapi.ListAndWatchResponse) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.responses = append(f.responses, resp.Devices)
+	return nil
+}
+
+func (f *fakeListAndWatchServer) last() []*pluginapi.Device {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.responses) == 0 {
+		return nil
+	}
+	return f.responses[len(f.responses)-1]
+}
+
+func newTestDevice(function int) (*v1beta1.PCIDevice, *v1beta1.PCIDeviceClaim) {
+	address := fmt.Sprintf("0000:04:10.%d", function)
+	pd := &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("node1-00000410%d", function),
+		},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:      address,
+			IOMMUGroup:   fmt.Sprintf("%d", 80+function),
+			ResourceName: "fake.com/device",
+		},
+	}
+	pdc := &v1beta1.PCIDeviceClaim{
+		Spec: v1beta1.PCIDeviceClaimSpec{
+			Address: address,
+		},
+	}
+	return pd, pdc
+}
+
+// newTestPlugin creates a plugin whose health check and socket live in a temporary directory
+func newTestPlugin(t *testing.T) *PCIDevicePlugin {
+	dir := t.TempDir()
+	assert := require.New(t)
+	assert.NoError(os.MkdirAll(filepath.Join(dir, vfioDevicePath), 0755))
+	// vfio group devices for the iommu groups used by newTestDevice
+	for i := 0; i < 8; i++ {
+		assert.NoError(os.WriteFile(filepath.Join(dir, vfioDevicePath, fmt.Sprintf("%d", 80+i)), nil, 0600))
+	}
+	dp := NewPCIDevicePlugin(nil, "fake.com/device")
+	dp.deviceRoot = dir
+	dp.socketPath = filepath.Join(dir, "kubevirt-fake.sock")
+	assert.NoError(os.WriteFile(dp.socketPath, nil, 0600))
+	dp.stop = make(chan struct{})
+	dp.done = make(chan struct{})
+	dp.deregistered = make(chan struct{})
+	return dp
+}
+
+func Test_ConcurrentDeviceUpdatesDuringListAndWatch(t *testing.T) {
+	assert := require.New(t)
+	dp := newTestPlugin(t)
+	stream := &fakeListAndWatchServer{}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- dp.ListAndWatch(&pluginapi.Empty{}, stream)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pd, pdc := newTestDevice(i)
+			assert.NoError(dp.AddDevice(pd, pdc))
+			_, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+					{DevicesIDs: []string{pd.Status.Address}},
+				},
+			})
+			assert.NoError(err)
+			if i%2 == 0 {
+				assert.NoError(dp.RemoveDevice(pd, pdc))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(4, dp.GetCount(), "expected only devices which were not removed to be healthy")
+
+	assert.Eventually(func() bool {
+		var healthy int
+		for _, dev := range stream.last() {
+			if dev.Health == pluginapi.Healthy {
+				healthy++
+			}
+		}
+		return healthy == 4
+	}, 5*time.Second, 10*time.Millisecond, "expected ListAndWatch to report the final device health")
+
+	assert.NoError(dp.Stop())
+	assert.NoError(<-errChan)
+	assert.Len(stream.last(), 0, "expected empty device list to be sent on stop")
+}
+
+func Test_AddDeviceIsIdempotent(t *testing.T) {
+	assert := require.New(t)
+	dp := NewPCIDevicePlugin(nil, "fake.com/device")
+	pd, pdc := newTestDevice(0)
+	assert.NoError(dp.AddDevice(pd, pdc))
+	assert.NoError(dp.RemoveDevice(pd, pdc))
+	assert.Equal(0, dp.GetCount(), "expected removed device to be unhealthy")
+	assert.NoError(dp.AddDevice(pd, pdc))
+	assert.Equal(1, dp.GetCount(), "expected re-added device to be healthy")
+	assert.Len(dp.listDevices(), 1, "expected device to be listed once")
+}
+
+// fakeResetter fails resets for the addresses in failing
+type fakeResetter struct {
+	failing map[string]bool
+	resets  []string
+}
+
+func (f *fakeResetter) Reset(address string) (string, error) {
+	f.resets = append(f.resets, address)
+	if f.failing[address] {
+		return "", fmt.Errorf("error resetting device %s", address)
+	}
+	return "flr", nil
+}
+
+func Test_ResetOnPreStart(t *testing.T) {
+	assert := require.New(t)
+	dp := NewPCIDevicePlugin(nil, "fake.com/device")
+	options, err := dp.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	assert.NoError(err)
+	assert.False(options.PreStartRequired, "expected PreStartContainer to not be required by default")
+
+	pd0, pdc0 := newTestDevice(0)
+	pd1, pdc1 := newTestDevice(1)
+	assert.NoError(dp.AddDevice(pd0, pdc0))
+	assert.NoError(dp.AddDevice(pd1, pdc1))
+
+	resetter := &fakeResetter{failing: map[string]bool{pd1.Status.Address: true}}
+	dp.EnableResetOnPreStart(resetter)
+	options, err = dp.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	assert.NoError(err)
+	assert.True(options.PreStartRequired, "expected PreStartContainer to be required when reset is enabled")
+
+	_, err = dp.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{
+		DevicesIDs: []string{pd0.Status.Address},
+	})
+	assert.NoError(err)
+	assert.Equal(2, dp.GetCount(), "expected devices to remain healthy after reset")
+
+	_, err = dp.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{
+		DevicesIDs: []string{pd1.Status.Address},
+	})
+	assert.Error(err, "expected failed reset to fail the container start")
+	assert.Equal(1, dp.GetCount(), "expected device which failed reset to be unhealthy")
+	assert.Equal([]string{pd0.Status.Address, pd1.Status.Address}, resetter.resets)
+}
+
+func Test_AllocatePerContainer(t *testing.T) {
+	assert := require.New(t)
+	dp := newTopologyPlugin()
+	resp, err := dp.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0000:04:00.0"}},
+			{DevicesIDs: []string{"0000:83:00.0"}},
+		},
+	})
+	assert.NoError(err)
+	assert.Len(resp.ContainerResponses, 2)
+
+	first := resp.ContainerResponses[0]
+	assert.Equal("0000:04:00.0", first.Envs["PCI_RESOURCE_FAKE_COM_DEVICE"])
+	assert.Equal("0000:04:00.0", first.Envs["PCIDEVICE_FAKE_COM_DEVICE"], "expected the devices for userspace drivers")
+	assert.Equal([]string{"/dev/vfio/vfio", "/dev/vfio/40"}, []string{first.Devices[0].HostPath, first.Devices[1].HostPath})
+
+	second := resp.ContainerResponses[1]
+	assert.ElementsMatch([]string{"0000:83:00.0", "0000:83:00.1"}, strings.Split(second.Envs["PCIDEVICE_FAKE_COM_DEVICE"], ","),
+		"expected the devices of the iommu group once")
+	assert.Equal("0000:83:00.0", strings.Split(second.Envs["PCI_RESOURCE_FAKE_COM_DEVICE"], ",")[0])
+	assert.Len(second.Devices, 2, "expected the devices of the second container only")
+}
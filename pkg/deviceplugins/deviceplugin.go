@@ -8,21 +8,35 @@ import (
 )
 
 func (dp *PCIDevicePlugin) MarkPCIDeviceAsHealthy(resourceName string, pciAddress string) {
-	go func() {
-		dp.health <- deviceHealth{
-			DevId:  pciAddress,
-			Health: pluginapi.Healthy,
-		}
-	}()
+	dp.setDeviceHealth(pciAddress, pluginapi.Healthy)
 }
 
 func (dp *PCIDevicePlugin) MarkPCIDeviceAsUnhealthy(pciAddress string) {
-	go func() {
-		dp.health <- deviceHealth{
-			DevId:  pciAddress,
-			Health: pluginapi.Unhealthy,
-		}
-	}()
+	dp.setDeviceHealth(pciAddress, pluginapi.Unhealthy)
+}
+
+// EnableResetOnPreStart makes the kubelet call PreStartContainer, which resets the devices allocated to a
+// container using resetter. It must be called before the device plugin is started
+func (dp *PCIDevicePlugin) EnableResetOnPreStart(resetter DeviceResetter) {
+	dp.resetter = resetter
+}
+
+// EnableHealthChecks probes the devices with checker while the kubelet watches them, and calls onHealthChange
+// with the result of the first probe of a device and whenever it changes. It must be called before the
+// device plugin is started
+func (dp *PCIDevicePlugin) EnableHealthChecks(checker DeviceHealthChecker, onHealthChange func(pciAddress string, err error)) {
+	dp.lock.Lock()
+	dp.healthChecker = checker
+	dp.onHealthChange = onHealthChange
+	dp.lock.Unlock()
+}
+
+// EnableCDI writes a CDI spec of the devices to cdiDir, and returns their CDI devices when they are
+// allocated. It must be called before the device plugin is started
+func (dp *PCIDevicePlugin) EnableCDI(cdiDir string) {
+	dp.lock.Lock()
+	dp.cdiDir = cdiDir
+	dp.lock.Unlock()
 }
 
 // Looks for a PCIDevicePlugin with that resourceName, and returns it, or an error if it doesn't exist
@@ -37,6 +51,24 @@ func Find(
 	return dp
 }
 
+// newPCIDevice describes pd for the device plugin, along with its NUMA node and position in the PCI
+// hierarchy, which are read from the host as they are not part of the PCIDevice
+func newPCIDevice(pd *v1beta1.PCIDevice) *PCIDevice {
+	initHandler()
+	pciPath, err := Handler.GetDevicePCIPath(pciBasePath, pd.Status.Address)
+	if err != nil {
+		logrus.Warnf("error reading pci path of device %s, it is not considered for preferred allocations: %v", pd.Status.Address, err)
+	}
+	return &PCIDevice{
+		pciID:      pd.Status.Address,
+		driver:     pd.Status.KernelDriverInUse,
+		pciAddress: pd.Status.Address, // this redundancy is here to distinguish between the ID and the PCI Address. They have the same value but mean different things
+		iommuGroup: pd.Status.IOMMUGroup,
+		numaNode:   Handler.GetDeviceNumaNode(pciBasePath, pd.Status.Address),
+		pciPath:    pciPath,
+	}
+}
+
 // Creates a new PCIDevicePlugin with that resourceName, and returns it
 func Create(
 	resourceName string,
@@ -46,12 +78,7 @@ func Create(
 	// Check if there are any PCIDevicePlugins with that resourceName
 	pcidevs := []*PCIDevice{}
 	for _, pd := range pdsWithSameResourceName {
-		pcidevs = append(pcidevs, &PCIDevice{
-			pciID:      pd.Status.Address,
-			driver:     pd.Status.KernelDriverInUse,
-			pciAddress: pd.Status.Address, // this redundancy is here to distinguish between the ID and the PCI Address. They have the same value but mean different things
-			iommuGroup: pd.Status.IOMMUGroup,
-		})
+		pcidevs = append(pcidevs, newPCIDevice(pd))
 	}
 	// Create the DevicePlugin
 	dp := NewPCIDevicePlugin(pcidevs, resourceName)
@@ -61,7 +88,16 @@ func Create(
 
 // This function adds the PCIDevice to the device plugin, or creates the device plugin if it doesn't exist
 func (dp *PCIDevicePlugin) AddDevice(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDeviceClaim) error {
+	dp.lock.Lock()
 	_, exists := dp.iommuToPCIMap[pd.Status.Address]
+	// a device which was released is reset, and starts afresh when it is claimed again
+	if exists && dp.healthChecker != nil {
+		for _, dev := range dp.devs {
+			if dev.ID == pd.Status.Address && dev.Health == pluginapi.Unhealthy {
+				dp.healthChecker.Forget(pd.Status.Address)
+			}
+		}
+	}
 
 	// made AddDevice idempotent to make reconciles easier
 	// if device address doesnt exist in iommuGroupMap then it needs to be added
@@ -69,17 +105,19 @@ func (dp *PCIDevicePlugin) AddDevice(pd *v1beta1.PCIDevice, pdc *v1beta1.PCIDevi
 	if !exists {
 		resourceName := pd.Status.ResourceName
 		logrus.Infof("Adding new claimed %s to device plugin", resourceName)
-		pcidevs := []*PCIDevice{{
-			pciID:      pd.Status.Address,
-			driver:     pd.Status.KernelDriverInUse,
-			pciAddress: pd.Status.Address,
-			iommuGroup: pd.Status.IOMMUGroup,
-		}}
+		pcidevs := []*PCIDevice{newPCIDevice(pd)}
 		devs := constructDPIdevices(pcidevs, dp.iommuToPCIMap)
 		dp.devs = append(dp.devs, devs...)
-		dp.MarkPCIDeviceAsHealthy(resourceName, pdc.Spec.Address)
+		dp.pcidevs = append(dp.pcidevs, pcidevs...)
+	}
+	dp.lock.Unlock()
+	if !exists {
+		dp.writeCDISpec()
 	}
 
+	// a device removed earlier is still listed in devs as unhealthy, so
+	// the health is refreshed even if the device was already known
+	dp.MarkPCIDeviceAsHealthy(pd.Status.ResourceName, pdc.Spec.Address)
 	return nil
 }
 
@@ -0,0 +1,331 @@
+// Package fakekubelet is an in-process kubelet for testing device plugins. It serves the registration
+// service in a device plugin directory, and connects to registered plugins and watches their devices
+// like the kubelet does, so the lifecycle of a plugin can be tested without a node
+package fakekubelet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+// KubeletSocketName is the name of the registration socket in the device plugin directory
+const KubeletSocketName = "kubelet.sock"
+
+// Kubelet serves the registration service on the kubelet socket of a device plugin directory
+type Kubelet struct {
+	dir    string
+	lock   sync.Mutex
+	server *grpc.Server
+	// plugins holds the plugins registered since the kubelet was started, by resourceName
+	plugins map[string]*Plugin
+	// registered is signalled whenever a plugin registers
+	registered chan struct{}
+}
+
+// New returns a Kubelet for the device plugin directory dir. It has to be started with Start
+func New(dir string) *Kubelet {
+	return &Kubelet{
+		dir:        dir,
+		plugins:    make(map[string]*Plugin),
+		registered: make(chan struct{}, 1),
+	}
+}
+
+// Dir returns the device plugin directory
+func (k *Kubelet) Dir() string {
+	return k.dir
+}
+
+// SocketPath returns the path of the registration socket
+func (k *Kubelet) SocketPath() string {
+	return filepath.Join(k.dir, KubeletSocketName)
+}
+
+// Start serves the registration service until Stop is called
+func (k *Kubelet) Start() error {
+	lis, err := net.Listen("unix", k.SocketPath())
+	if err != nil {
+		return fmt.Errorf("error listening on kubelet socket: %v", err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&registrationServiceDesc, &registrationServer{kubelet: k})
+	k.lock.Lock()
+	k.server = server
+	k.lock.Unlock()
+	go server.Serve(lis)
+	return nil
+}
+
+// Stop stops serving the registration service, and disconnects from the registered plugins
+func (k *Kubelet) Stop() {
+	k.lock.Lock()
+	server, plugins := k.server, k.plugins
+	k.server = nil
+	k.plugins = make(map[string]*Plugin)
+	k.lock.Unlock()
+
+	if server != nil {
+		server.Stop()
+	}
+	for _, p := range plugins {
+		p.close()
+	}
+	os.Remove(k.SocketPath())
+}
+
+// Restart stops the kubelet, and starts it again. Like the kubelet, it forgets all plugins and removes
+// their sockets when it starts, so plugins have to register again
+func (k *Kubelet) Restart() error {
+	k.Stop()
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(k.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return k.Start()
+}
+
+// Plugin returns the plugin registered for resourceName, or nil if it is not registered
+func (k *Kubelet) Plugin(resourceName string) *Plugin {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.plugins[resourceName]
+}
+
+// WaitForPlugin waits until a plugin for resourceName registered, and returns it
+func (k *Kubelet) WaitForPlugin(resourceName string, timeout time.Duration) (*Plugin, error) {
+	deadline := time.After(timeout)
+	for {
+		if p := k.Plugin(resourceName); p != nil {
+			return p, nil
+		}
+		select {
+		case <-k.registered:
+		case <-deadline:
+			return nil, fmt.Errorf("timed out waiting for %s to register", resourceName)
+		}
+	}
+}
+
+func (k *Kubelet) register(req *RegisterRequest) error {
+	if req.Version != pluginapi.Version {
+		return fmt.Errorf("unsupported device plugin api version %s", req.Version)
+	}
+	p, err := connect(filepath.Join(k.dir, req.Endpoint), req)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	previous := k.plugins[req.ResourceName]
+	k.plugins[req.ResourceName] = p
+	k.lock.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	select {
+	case k.registered <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// RegisterRequest is the registration of a plugin including its options, which the vendored API lacks
+type RegisterRequest struct {
+	Version      string           `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Endpoint     string           `protobuf:"bytes,2,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	ResourceName string           `protobuf:"bytes,3,opt,name=resource_name,json=resourceName,proto3" json:"resource_name,omitempty"`
+	Options      *RegisterOptions `protobuf:"bytes,4,opt,name=options,proto3" json:"options,omitempty"`
+}
+
+func (m *RegisterRequest) Reset()         { *m = RegisterRequest{} }
+func (m *RegisterRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*RegisterRequest) ProtoMessage()    {}
+
+// RegisterOptions are the options a plugin registers with
+type RegisterOptions struct {
+	PreStartRequired                bool `protobuf:"varint,1,opt,name=pre_start_required,json=preStartRequired,proto3" json:"pre_start_required,omitempty"`
+	GetPreferredAllocationAvailable bool `protobuf:"varint,2,opt,name=get_preferred_allocation_available,json=getPreferredAllocationAvailable,proto3" json:"get_preferred_allocation_available,omitempty"`
+}
+
+func (m *RegisterOptions) Reset()         { *m = RegisterOptions{} }
+func (m *RegisterOptions) String() string { return fmt.Sprintf("%+v", *m) }
+func (*RegisterOptions) ProtoMessage()    {}
+
+var registrationServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v1beta1.Registration",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Register", Handler: registerHandler},
+	},
+}
+
+func registerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(RegisterRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if err := srv.(*registrationServer).kubelet.register(req); err != nil {
+		return nil, err
+	}
+	return &pluginapi.Empty{}, nil
+}
+
+type registrationServer struct {
+	kubelet *Kubelet
+}
+
+// Plugin is the connection of the kubelet to a registered device plugin
+type Plugin struct {
+	ResourceName string
+	Endpoint     string
+	// RegisterOptions are the options the plugin registered with
+	RegisterOptions RegisterOptions
+	conn            *grpc.ClientConn
+	client          pluginapi.DevicePluginClient
+	cancel          context.CancelFunc
+	lock            sync.Mutex
+	devices         []*pluginapi.Device
+	// updated is signalled whenever the plugin sends its devices
+	updated chan struct{}
+	// done is closed once the plugin stopped sending its devices
+	done chan struct{}
+}
+
+// connect dials a registered plugin, and watches its devices like the kubelet does after registration
+func connect(socket string, req *RegisterRequest) (*Plugin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to device plugin %s: %v", req.ResourceName, err)
+	}
+
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	p := &Plugin{
+		ResourceName: req.ResourceName,
+		Endpoint:     req.Endpoint,
+		conn:         conn,
+		client:       pluginapi.NewDevicePluginClient(conn),
+		cancel:       watchCancel,
+		updated:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	if req.Options != nil {
+		p.RegisterOptions = *req.Options
+	}
+	stream, err := p.client.ListAndWatch(watchCtx, &pluginapi.Empty{})
+	if err != nil {
+		p.close()
+		return nil, fmt.Errorf("error watching devices of device plugin %s: %v", req.ResourceName, err)
+	}
+	go p.watch(stream)
+	return p, nil
+}
+
+func (p *Plugin) watch(stream pluginapi.DevicePlugin_ListAndWatchClient) {
+	defer close(p.done)
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return
+		}
+		p.lock.Lock()
+		p.devices = resp.Devices
+		p.lock.Unlock()
+		select {
+		case p.updated <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Plugin) close() {
+	p.cancel()
+	p.conn.Close()
+}
+
+// Conn returns the connection to the plugin, e.g. to call methods missing from the vendored API
+func (p *Plugin) Conn() *grpc.ClientConn {
+	return p.conn
+}
+
+// Devices returns the devices the plugin sent last
+func (p *Plugin) Devices() []*pluginapi.Device {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.devices
+}
+
+// Health returns the health of the devices the plugin sent last, by device ID
+func (p *Plugin) Health() map[string]string {
+	health := make(map[string]string)
+	for _, dev := range p.Devices() {
+		health[dev.ID] = dev.Health
+	}
+	return health
+}
+
+// WaitForDevices waits until the devices sent by the plugin satisfy condition
+func (p *Plugin) WaitForDevices(condition func(devices []*pluginapi.Device) bool, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		if condition(p.Devices()) {
+			return nil
+		}
+		select {
+		case <-p.updated:
+		case <-p.done:
+			if condition(p.Devices()) {
+				return nil
+			}
+			return fmt.Errorf("device plugin %s stopped sending devices", p.ResourceName)
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for the devices of %s", p.ResourceName)
+		}
+	}
+}
+
+// Done is closed once the plugin stopped sending its devices
+func (p *Plugin) Done() <-chan struct{} {
+	return p.done
+}
+
+// Options returns the options of the plugin
+func (p *Plugin) Options(ctx context.Context) (*pluginapi.DevicePluginOptions, error) {
+	return p.client.GetDevicePluginOptions(ctx, &pluginapi.Empty{})
+}
+
+// Allocate allocates deviceIDs to a single container, as the kubelet does when a container is created
+func (p *Plugin) Allocate(ctx context.Context, deviceIDs ...string) (*pluginapi.ContainerAllocateResponse, error) {
+	resp, err := p.client.Allocate(ctx, &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: deviceIDs}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.ContainerResponses) != 1 {
+		return nil, fmt.Errorf("expected 1 container response, got %d", len(resp.ContainerResponses))
+	}
+	return resp.ContainerResponses[0], nil
+}
+
+// PreStartContainer calls PreStartContainer for deviceIDs, as the kubelet does before a container
+// starts if the plugin requires it
+func (p *Plugin) PreStartContainer(ctx context.Context, deviceIDs ...string) error {
+	_, err := p.client.PreStartContainer(ctx, &pluginapi.PreStartContainerRequest{DevicesIDs: deviceIDs})
+	return err
+}
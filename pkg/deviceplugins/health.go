@@ -0,0 +1,169 @@
+package deviceplugins
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthProbeInterval is how often the devices of a plugin are probed while the kubelet watches them
+	healthProbeInterval = 10 * time.Second
+	vfioPCIDriver       = "vfio-pci"
+)
+
+// DeviceHealthChecker probes the state of a device bound to vfio-pci, and returns why it is unhealthy
+type DeviceHealthChecker interface {
+	Check(pciAddress string) error
+	// Forget drops what was learned about the device, e.g. after it was reset and claimed again
+	Forget(pciAddress string)
+}
+
+// linkAndAERState is the state of a device when it was first checked, later states are compared against
+type linkAndAERState struct {
+	linkWidth      int
+	fatalErrors    int
+	nonFatalErrors int
+}
+
+// sysfsHealthChecker checks the devices in the PCI devices directory of sysfs. A device is unhealthy if it
+// is no longer present on the bus, no longer bound to vfio-pci, its PCIe link is down or narrower than when
+// it was first checked, its fatal or non-fatal AER counters increased since it was first checked, or its
+// config space reads as all 0xFF, which is what reads from a device which fell off the bus return
+type sysfsHealthChecker struct {
+	basePath string
+	lock     sync.Mutex
+	initial  map[string]*linkAndAERState
+}
+
+// NewHealthChecker returns a DeviceHealthChecker for the devices in basePath, e.g. /sys/bus/pci/devices
+func NewHealthChecker(basePath string) DeviceHealthChecker {
+	return &sysfsHealthChecker{
+		basePath: basePath,
+		initial:  make(map[string]*linkAndAERState),
+	}
+}
+
+func (c *sysfsHealthChecker) Check(pciAddress string) error {
+	devicePath := filepath.Join(c.basePath, pciAddress)
+	if _, err := os.Stat(devicePath); err != nil {
+		return fmt.Errorf("device is not present on the bus: %v", err)
+	}
+
+	driverPath, err := os.Readlink(filepath.Join(devicePath, "driver"))
+	if err != nil {
+		return fmt.Errorf("device is not bound to %s", vfioPCIDriver)
+	}
+	if driver := filepath.Base(driverPath); driver != vfioPCIDriver {
+		return fmt.Errorf("device is bound to %s instead of %s", driver, vfioPCIDriver)
+	}
+
+	if err := checkConfigSpace(devicePath); err != nil {
+		return err
+	}
+
+	state, err := readLinkAndAERState(devicePath)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	initial, ok := c.initial[pciAddress]
+	if !ok {
+		initial = state
+		c.initial[pciAddress] = state
+	}
+	c.lock.Unlock()
+
+	switch {
+	case state.linkWidth == 0 && initial.linkWidth > 0:
+		return fmt.Errorf("pcie link is down")
+	case state.linkWidth < initial.linkWidth:
+		return fmt.Errorf("pcie link width dropped from x%d to x%d", initial.linkWidth, state.linkWidth)
+	case state.fatalErrors > initial.fatalErrors:
+		return fmt.Errorf("%d fatal aer errors were reported", state.fatalErrors-initial.fatalErrors)
+	case state.nonFatalErrors > initial.nonFatalErrors:
+		return fmt.Errorf("%d non-fatal aer errors were reported", state.nonFatalErrors-initial.nonFatalErrors)
+	}
+	return nil
+}
+
+func (c *sysfsHealthChecker) Forget(pciAddress string) {
+	c.lock.Lock()
+	delete(c.initial, pciAddress)
+	c.lock.Unlock()
+}
+
+// checkConfigSpace reads the vendor and device id from the config space of the device
+func checkConfigSpace(devicePath string) error {
+	file, err := os.Open(filepath.Join(devicePath, "config"))
+	if err != nil {
+		return fmt.Errorf("error opening config space: %v", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	if _, err := file.Read(header); err != nil {
+		return fmt.Errorf("error reading config space: %v", err)
+	}
+	if bytes.Equal(header, []byte{0xff, 0xff, 0xff, 0xff}) {
+		return fmt.Errorf("config space is not readable")
+	}
+	return nil
+}
+
+// readLinkAndAERState reads the link width and AER counters of a device. Devices without a PCIe link or
+// AER support lack the files, and are reported with a zero width or zero errors
+func readLinkAndAERState(devicePath string) (*linkAndAERState, error) {
+	state := &linkAndAERState{}
+	width, err := os.ReadFile(filepath.Join(devicePath, "current_link_width"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("error reading link width: %v", err)
+	default:
+		// the width is reported as 0 when the link is down
+		state.linkWidth, _ = strconv.Atoi(strings.TrimSpace(string(width)))
+	}
+	// the speed of a link which is down is unknown
+	speed, err := os.ReadFile(filepath.Join(devicePath, "current_link_speed"))
+	if err == nil && strings.HasPrefix(string(speed), "Unknown") {
+		state.linkWidth = 0
+	}
+
+	if state.fatalErrors, err = readAERTotal(filepath.Join(devicePath, "aer_dev_fatal"), "TOTAL_ERR_FATAL"); err != nil {
+		return nil, err
+	}
+	if state.nonFatalErrors, err = readAERTotal(filepath.Join(devicePath, "aer_dev_nonfatal"), "TOTAL_ERR_NONFATAL"); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// readAERTotal reads the total of an AER counter file, which lists a "<error> <count>" line per error
+func readAERTotal(path string, total string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading aer counters: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == total {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,220 @@
+package deviceplugins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+const testAddress = "0000:04:00.0"
+
+// newSysfsDevice creates a healthy device bound to vfio-pci in a fake /sys/bus/pci/devices
+func newSysfsDevice(t *testing.T) (string, string) {
+	basePath := t.TempDir()
+	devicePath := filepath.Join(basePath, testAddress)
+	require.NoError(t, os.MkdirAll(devicePath, 0755))
+	require.NoError(t, os.Symlink("../../../bus/pci/drivers/vfio-pci", filepath.Join(devicePath, "driver")))
+	writeSysfsFile(t, devicePath, "config", string([]byte{0xde, 0x10, 0x36, 0x22}))
+	writeSysfsFile(t, devicePath, "current_link_width", "16\n")
+	writeSysfsFile(t, devicePath, "current_link_speed", "16.0 GT/s PCIe\n")
+	writeAERCounters(t, devicePath, 0, 0)
+	return basePath, devicePath
+}
+
+func writeSysfsFile(t *testing.T, devicePath, name, value string) {
+	require.NoError(t, os.WriteFile(filepath.Join(devicePath, name), []byte(value), 0644))
+}
+
+func writeAERCounters(t *testing.T, devicePath string, fatal, nonFatal int) {
+	writeSysfsFile(t, devicePath, "aer_dev_fatal", fmt.Sprintf("Undefined 0\nDLP %d\nSDES 0\nTOTAL_ERR_FATAL %d\n", fatal, fatal))
+	writeSysfsFile(t, devicePath, "aer_dev_nonfatal", fmt.Sprintf("Undefined 0\nCmpltTO %d\nTOTAL_ERR_NONFATAL %d\n", nonFatal, nonFatal))
+}
+
+func Test_HealthChecker(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		mutate   func(t *testing.T, devicePath string)
+		expected string
+	}{
+		{
+			name:   "healthy device",
+			mutate: func(t *testing.T, devicePath string) {},
+		},
+		{
+			name: "device removed from the bus",
+			mutate: func(t *testing.T, devicePath string) {
+				require.NoError(t, os.RemoveAll(devicePath))
+			},
+			expected: "device is not present on the bus",
+		},
+		{
+			name: "device bound to another driver",
+			mutate: func(t *testing.T, devicePath string) {
+				require.NoError(t, os.Remove(filepath.Join(devicePath, "driver")))
+				require.NoError(t, os.Symlink("../../../bus/pci/drivers/nvidia", filepath.Join(devicePath, "driver")))
+			},
+			expected: "device is bound to nvidia instead of vfio-pci",
+		},
+		{
+			name: "config space not readable",
+			mutate: func(t *testing.T, devicePath string) {
+				writeSysfsFile(t, devicePath, "config", string([]byte{0xff, 0xff, 0xff, 0xff}))
+			},
+			expected: "config space is not readable",
+		},
+		{
+			name: "link down",
+			mutate: func(t *testing.T, devicePath string) {
+				writeSysfsFile(t, devicePath, "current_link_speed", "Unknown\n")
+			},
+			expected: "pcie link is down",
+		},
+		{
+			name: "link width dropped",
+			mutate: func(t *testing.T, devicePath string) {
+				writeSysfsFile(t, devicePath, "current_link_width", "4\n")
+			},
+			expected: "pcie link width dropped from x16 to x4",
+		},
+		{
+			name: "idle link at a lower speed",
+			mutate: func(t *testing.T, devicePath string) {
+				writeSysfsFile(t, devicePath, "current_link_speed", "2.5 GT/s PCIe\n")
+				writeSysfsFile(t, devicePath, "max_link_speed", "16.0 GT/s PCIe\n")
+			},
+		},
+		{
+			name: "link narrower than the maximum of the device",
+			mutate: func(t *testing.T, devicePath string) {
+				writeSysfsFile(t, devicePath, "max_link_width", "32\n")
+			},
+		},
+		{
+			name: "fatal aer errors",
+			mutate: func(t *testing.T, devicePath string) {
+				writeAERCounters(t, devicePath, 2, 0)
+			},
+			expected: "2 fatal aer errors were reported",
+		},
+		{
+			name: "non-fatal aer errors",
+			mutate: func(t *testing.T, devicePath string) {
+				writeAERCounters(t, devicePath, 0, 1)
+			},
+			expected: "1 non-fatal aer errors were reported",
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			basePath, devicePath := newSysfsDevice(t)
+			checker := NewHealthChecker(basePath)
+			require.NoError(t, checker.Check(testAddress), "expected device to be healthy initially")
+			v.mutate(t, devicePath)
+			err := checker.Check(testAddress)
+			if v.expected == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, v.expected)
+			}
+		})
+	}
+}
+
+func Test_HealthCheckerForget(t *testing.T) {
+	assert := require.New(t)
+	basePath, devicePath := newSysfsDevice(t)
+	checker := NewHealthChecker(basePath)
+	assert.NoError(checker.Check(testAddress))
+	writeAERCounters(t, devicePath, 1, 0)
+	assert.Error(checker.Check(testAddress))
+	assert.Error(checker.Check(testAddress), "expected aer errors to keep the device unhealthy")
+	checker.Forget(testAddress)
+	assert.NoError(checker.Check(testAddress), "expected a forgotten device to start afresh")
+}
+
+// fakeHealthChecker fails the addresses in failing
+type fakeHealthChecker struct {
+	lock    sync.Mutex
+	failing map[string]error
+}
+
+func (f *fakeHealthChecker) Check(address string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.failing[address]
+}
+
+func (f *fakeHealthChecker) Forget(_ string) {}
+
+func (f *fakeHealthChecker) fail(address string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failing[address] = err
+}
+
+func Test_UnhealthyDevicesAreReportedToKubelet(t *testing.T) {
+	assert := require.New(t)
+	dp := newTestPlugin(t)
+	dp.probeInterval = 10 * time.Millisecond
+	checker := &fakeHealthChecker{failing: make(map[string]error)}
+	var lock sync.Mutex
+	changes := make(map[string]error)
+	dp.EnableHealthChecks(checker, func(address string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes[address] = err
+	})
+	pd0, pdc0 := newTestDevice(0)
+	pd1, pdc1 := newTestDevice(1)
+	assert.NoError(dp.AddDevice(pd0, pdc0))
+	assert.NoError(dp.AddDevice(pd1, pdc1))
+
+	stream := &fakeListAndWatchServer{}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- dp.ListAndWatch(&pluginapi.Empty{}, stream)
+	}()
+
+	health := func() map[string]string {
+		result := make(map[string]string)
+		for _, dev := range stream.last() {
+			result[dev.ID] = dev.Health
+		}
+		return result
+	}
+	assert.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(changes) == 2
+	}, 5*time.Second, 10*time.Millisecond, "expected the first probe of each device to be reported")
+
+	checker.fail(pd1.Status.Address, fmt.Errorf("pcie link is down"))
+	assert.Eventually(func() bool {
+		return health()[pd1.Status.Address] == pluginapi.Unhealthy && health()[pd0.Status.Address] == pluginapi.Healthy
+	}, 5*time.Second, 10*time.Millisecond, "expected the failing device to be reported unhealthy")
+	assert.Equal(2, dp.GetCount(), "expected failed probes to not change the claimed devices")
+	lock.Lock()
+	assert.EqualError(changes[pd1.Status.Address], "pcie link is down")
+	lock.Unlock()
+
+	// a missing vfio group device is unhealthy as well
+	assert.NoError(os.Remove(filepath.Join(dp.deviceRoot, vfioDevicePath, pd0.Status.IOMMUGroup)))
+	checker.fail(pd1.Status.Address, nil)
+	assert.Eventually(func() bool {
+		return health()[pd1.Status.Address] == pluginapi.Healthy && health()[pd0.Status.Address] == pluginapi.Unhealthy
+	}, 5*time.Second, 10*time.Millisecond, "expected recovered device to be reported healthy again")
+	lock.Lock()
+	assert.NoError(changes[pd1.Status.Address])
+	assert.ErrorContains(changes[pd0.Status.Address], "vfio device")
+	lock.Unlock()
+
+	assert.NoError(dp.Stop())
+	assert.NoError(<-errChan)
+}
@@ -0,0 +1,244 @@
+package deviceplugins
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+// PluginState is the lifecycle state of a device plugin run by a Manager
+type PluginState string
+
+const (
+	// PluginStarting plugins are starting their gRPC server and registering with the kubelet
+	PluginStarting PluginState = "Starting"
+	// PluginRegistered plugins are registered with the kubelet, and serve their devices
+	PluginRegistered PluginState = "Registered"
+	// PluginBackoff plugins failed to start or register, or were stopped by a kubelet restart, and
+	// wait before starting again
+	PluginBackoff PluginState = "Backoff"
+)
+
+// PluginStatus describes the state of a device plugin
+type PluginStatus struct {
+	ResourceName string      `json:"resourceName"`
+	State        PluginState `json:"state"`
+	// Restarts counts how often the plugin was started again after it was first registered
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+type managedPlugin struct {
+	dp     *PCIDevicePlugin
+	stop   chan struct{}
+	status PluginStatus
+	// registered is set once the plugin registered for the first time
+	registered bool
+}
+
+// ManagerOptions configures where the device plugins are served and registered
+type ManagerOptions struct {
+	// SocketDir is the directory the sockets of the device plugins are created in. The kubelet connects
+	// to the plugins in its device plugin directory, so it has to be that directory as seen by the plugins
+	SocketDir string
+	// KubeletSocket is the registration socket of the kubelet
+	KubeletSocket string
+}
+
+// Manager runs device plugins, and serves and registers them again with backoff when they fail or
+// the kubelet restarts
+type Manager struct {
+	socketDir     string
+	kubeletSocket string
+	lock          sync.Mutex
+	plugins       map[string]*managedPlugin
+	// onStateChange is called whenever the state of a plugin changes
+	onStateChange func()
+}
+
+// NewManager returns a Manager for opts, the kubelet device plugin directory and registration socket are
+// used if they are not set
+func NewManager(opts ManagerOptions) *Manager {
+	m := &Manager{
+		socketDir:     opts.SocketDir,
+		kubeletSocket: opts.KubeletSocket,
+		plugins:       make(map[string]*managedPlugin),
+	}
+	if m.socketDir == "" {
+		m.socketDir = pluginapi.DevicePluginPath
+	}
+	if m.kubeletSocket == "" {
+		m.kubeletSocket = pluginapi.KubeletSocket
+	}
+	return m
+}
+
+// OnStateChange sets a callback for changes of the plugin states. It must be called before Run
+func (m *Manager) OnStateChange(f func()) {
+	m.onStateChange = f
+}
+
+// Run watches the kubelet registration socket until ctx is done, and restarts all plugins when the
+// kubelet creates the socket again after it restarted
+func (m *Manager) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating fsnotify watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(m.kubeletSocket)); err != nil {
+		return fmt.Errorf("error watching kubelet socket %s: %v", m.kubeletSocket, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			logrus.Errorf("error watching kubelet socket %s: %v", m.kubeletSocket, err)
+		case event := <-watcher.Events:
+			if event.Name == m.kubeletSocket && event.Op&fsnotify.Create == fsnotify.Create {
+				logrus.Infof("kubelet socket %s was created, restarting device plugins", m.kubeletSocket)
+				m.restartAll()
+			}
+		}
+	}
+}
+
+func (m *Manager) restartAll() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, p := range m.plugins {
+		p.dp.signalRestart()
+	}
+}
+
+// Start runs dp until it is stopped with Stop. A plugin for the same resourceName must not be running
+func (m *Manager) Start(dp *PCIDevicePlugin) {
+	stop := make(chan struct{})
+	p := &managedPlugin{
+		dp:   dp,
+		stop: stop,
+		status: PluginStatus{
+			ResourceName: dp.GetDeviceName(),
+			State:        PluginStarting,
+		},
+	}
+
+	dp.lock.Lock()
+	dp.socketPath = filepath.Join(m.socketDir, filepath.Base(dp.socketPath))
+	dp.kubeletSocket = m.kubeletSocket
+	dp.onRegistered = func() {
+		m.updateStatus(p, func(status *PluginStatus) {
+			if p.registered {
+				status.Restarts++
+			}
+			p.registered = true
+			status.State = PluginRegistered
+			status.LastError = ""
+		})
+	}
+	dp.lock.Unlock()
+
+	m.lock.Lock()
+	m.plugins[dp.GetDeviceName()] = p
+	m.lock.Unlock()
+
+	go m.run(p)
+	dp.SetStarted(stop)
+	m.notify()
+}
+
+// run serves the plugin until it is stopped, and serves it again using the backoff of the plugin
+// whenever it returns
+func (m *Manager) run(p *managedPlugin) {
+	backoff := p.dp.starter.backoff
+	retries := 0
+	for {
+		err := p.dp.Start(p.stop)
+		if err != nil {
+			logrus.Errorf("error starting %s device plugin: %v", p.dp.GetDeviceName(), err)
+			if retries < len(backoff)-1 {
+				retries++
+			}
+		} else {
+			retries = 0
+		}
+
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		m.updateStatus(p, func(status *PluginStatus) {
+			status.State = PluginBackoff
+			if err != nil {
+				status.LastError = err.Error()
+			}
+		})
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff[retries]):
+		}
+		m.updateStatus(p, func(status *PluginStatus) {
+			status.State = PluginStarting
+		})
+	}
+}
+
+// Stop stops the plugin for resourceName and waits for it to shut down
+func (m *Manager) Stop(resourceName string) error {
+	m.lock.Lock()
+	p, ok := m.plugins[resourceName]
+	delete(m.plugins, resourceName)
+	m.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	close(p.stop)
+	err := p.dp.Stop()
+	m.notify()
+	return err
+}
+
+// States returns the status of the running plugins, ordered by resourceName
+func (m *Manager) States() []PluginStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]PluginStatus, 0, len(m.plugins))
+	for _, p := range m.plugins {
+		states = append(states, p.status)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ResourceName < states[j].ResourceName
+	})
+	return states
+}
+
+func (m *Manager) updateStatus(p *managedPlugin, update func(status *PluginStatus)) {
+	m.lock.Lock()
+	before := p.status
+	update(&p.status)
+	changed := before != p.status
+	m.lock.Unlock()
+	if changed {
+		m.notify()
+	}
+}
+
+func (m *Manager) notify() {
+	if m.onStateChange != nil {
+		m.onStateChange()
+	}
+}
@@ -0,0 +1,170 @@
+package deviceplugins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+
+	"github.com/harvester/pcidevices/pkg/deviceplugins/fakekubelet"
+)
+
+// newTestManager runs a Manager registering with a fake kubelet serving in a temporary directory
+func newTestManager(t *testing.T) (*Manager, *fakekubelet.Kubelet) {
+	kubelet := fakekubelet.New(t.TempDir())
+	require.NoError(t, kubelet.Start())
+	t.Cleanup(kubelet.Stop)
+
+	m := NewManager(ManagerOptions{
+		SocketDir:     kubelet.Dir(),
+		KubeletSocket: kubelet.SocketPath(),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- m.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-runErr:
+			require.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("expected the manager to stop once its context is cancelled")
+		}
+	})
+	// give the manager time to watch the kubelet socket, failing early if it could not
+	select {
+	case err := <-runErr:
+		// the cleanup does not wait for the manager which already stopped
+		runErr <- nil
+		t.Fatalf("expected the manager to keep running, got: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return m, kubelet
+}
+
+func Test_ManagerRegistersAgainAfterKubeletRestart(t *testing.T) {
+	assert := require.New(t)
+	m, kubelet := newTestManager(t)
+	changes := make(chan struct{}, 100)
+	m.OnStateChange(func() {
+		changes <- struct{}{}
+	})
+	dp := newTestPlugin(t)
+	dp.starter.backoff = []time.Duration{10 * time.Millisecond, 50 * time.Millisecond}
+
+	m.Start(dp)
+	assert.True(dp.Started())
+	_, err := kubelet.WaitForPlugin("fake.com/device", 10*time.Second)
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		states := m.States()
+		return len(states) == 1 && states[0].State == PluginRegistered
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the kubelet removes the plugin sockets and creates its socket again when it restarts
+	assert.NoError(kubelet.Restart())
+	_, err = kubelet.WaitForPlugin("fake.com/device", 10*time.Second)
+	assert.NoError(err, "expected the plugin to register again")
+	assert.Eventually(func() bool {
+		states := m.States()
+		return len(states) == 1 && states[0].State == PluginRegistered && states[0].Restarts == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = os.Stat(dp.socketPath)
+	assert.NoError(err, "expected the plugin socket to be created again")
+	assert.NotEmpty(changes, "expected state changes to be notified")
+
+	assert.NoError(m.Stop("fake.com/device"))
+	assert.Empty(m.States(), "expected stopped plugin to be removed")
+	_, err = os.Stat(dp.socketPath)
+	assert.True(os.IsNotExist(err), "expected the plugin socket to be removed")
+}
+
+// Test_DevicePluginLifecycle registers a plugin, lists and allocates its devices, changes their health,
+// and restarts the kubelet, as seen by the kubelet
+func Test_DevicePluginLifecycle(t *testing.T) {
+	assert := require.New(t)
+	m, kubelet := newTestManager(t)
+	dp := newTestPlugin(t)
+	dp.starter.backoff = []time.Duration{10 * time.Millisecond, 50 * time.Millisecond}
+	dp.probeInterval = 10 * time.Millisecond
+	checker := &fakeHealthChecker{failing: make(map[string]error)}
+	dp.EnableHealthChecks(checker, func(string, error) {})
+	pd0, pdc0 := newTestDevice(0)
+	pd1, pdc1 := newTestDevice(1)
+	assert.NoError(dp.AddDevice(pd0, pdc0))
+	assert.NoError(dp.AddDevice(pd1, pdc1))
+
+	m.Start(dp)
+	plugin, err := kubelet.WaitForPlugin("fake.com/device", 10*time.Second)
+	assert.NoError(err)
+	assert.NoError(plugin.WaitForDevices(func(devices []*pluginapi.Device) bool {
+		return len(devices) == 2 && devices[0].Health == pluginapi.Healthy && devices[1].Health == pluginapi.Healthy
+	}, 10*time.Second), "expected the claimed devices to be listed as healthy")
+
+	options, err := plugin.Options(context.Background())
+	assert.NoError(err)
+	assert.False(options.PreStartRequired)
+	assert.Equal(fakekubelet.RegisterOptions{GetPreferredAllocationAvailable: true}, plugin.RegisterOptions,
+		"expected the plugin to register with GetPreferredAllocation enabled")
+	allocation, err := plugin.Allocate(context.Background(), pd0.Status.Address)
+	assert.NoError(err)
+	assert.Equal(pd0.Status.Address, allocation.Envs["PCI_RESOURCE_FAKE_COM_DEVICE"])
+	assert.Equal(fmt.Sprintf("/dev/vfio/%s", pd0.Status.IOMMUGroup), allocation.Devices[1].HostPath)
+
+	// a device failing its probe, and a device whose claim was removed are unhealthy
+	checker.fail(pd1.Status.Address, fmt.Errorf("pcie link is down"))
+	assert.NoError(plugin.WaitForDevices(func([]*pluginapi.Device) bool {
+		return plugin.Health()[pd1.Status.Address] == pluginapi.Unhealthy
+	}, 10*time.Second), "expected the failing device to be unhealthy")
+	assert.NoError(dp.RemoveDevice(pd0, pdc0))
+	assert.NoError(plugin.WaitForDevices(func([]*pluginapi.Device) bool {
+		return plugin.Health()[pd0.Status.Address] == pluginapi.Unhealthy
+	}, 10*time.Second), "expected the removed device to be unhealthy")
+	checker.fail(pd1.Status.Address, nil)
+	assert.NoError(plugin.WaitForDevices(func([]*pluginapi.Device) bool {
+		return plugin.Health()[pd1.Status.Address] == pluginapi.Healthy
+	}, 10*time.Second), "expected the recovered device to be healthy again")
+
+	// after a restart, the kubelet gets the devices again from the plugin registering again
+	assert.NoError(kubelet.Restart())
+	<-plugin.Done()
+	plugin, err = kubelet.WaitForPlugin("fake.com/device", 10*time.Second)
+	assert.NoError(err)
+	assert.NoError(plugin.WaitForDevices(func([]*pluginapi.Device) bool {
+		health := plugin.Health()
+		return health[pd0.Status.Address] == pluginapi.Unhealthy && health[pd1.Status.Address] == pluginapi.Healthy
+	}, 10*time.Second), "expected the devices to be listed again")
+
+	assert.NoError(m.Stop("fake.com/device"))
+	select {
+	case <-plugin.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the stopped plugin to stop sending devices")
+	}
+}
+
+// Test_RegistrationOptions checks the kubelet is told on registration to call PreStartContainer when
+// devices are reset before use
+func Test_RegistrationOptions(t *testing.T) {
+	assert := require.New(t)
+	m, kubelet := newTestManager(t)
+	dp := newTestPlugin(t)
+	dp.EnableResetOnPreStart(&fakeResetter{})
+	pd0, pdc0 := newTestDevice(0)
+	assert.NoError(dp.AddDevice(pd0, pdc0))
+
+	m.Start(dp)
+	plugin, err := kubelet.WaitForPlugin("fake.com/device", 10*time.Second)
+	assert.NoError(err)
+	assert.Equal(fakekubelet.RegisterOptions{
+		PreStartRequired:                true,
+		GetPreferredAllocationAvailable: true,
+	}, plugin.RegisterOptions)
+	assert.NoError(m.Stop("fake.com/device"))
+}
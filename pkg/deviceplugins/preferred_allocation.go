@@ -0,0 +1,136 @@
+package deviceplugins
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// numaDistance is added for devices on different NUMA nodes, and outweighs any distance in the PCI
+	// hierarchy, so devices are split across sockets only if a single NUMA node cannot satisfy a request
+	numaDistance = 1000
+	// unknownDistance is used for devices whose position in the PCI hierarchy is unknown
+	unknownDistance = 100
+)
+
+// GetPreferredAllocation prefers devices which are close to each other: devices of the same IOMMU group,
+// then devices of the same NUMA node, then devices sharing the most bridges, e.g. under the same PCIe switch
+func (dpi *PCIDevicePlugin) GetPreferredAllocation(_ context.Context, r *PreferredAllocationRequest) (*PreferredAllocationResponse, error) {
+	logrus.Debugf("GetPreferredAllocation request %s", r.String())
+	dpi.lock.Lock()
+	devices := make(map[string]*PCIDevice, len(dpi.pcidevs))
+	for _, dev := range dpi.pcidevs {
+		devices[dev.pciID] = dev
+	}
+	dpi.lock.Unlock()
+
+	resp := &PreferredAllocationResponse{}
+	for _, req := range r.ContainerRequests {
+		resp.ContainerResponses = append(resp.ContainerResponses, &ContainerPreferredAllocationResponse{
+			DeviceIDs: preferredDevices(devices, req.AvailableDeviceIDs, req.MustIncludeDeviceIDs, int(req.AllocationSize)),
+		})
+	}
+	logrus.Debugf("GetPreferredAllocation response %v", resp)
+	return resp, nil
+}
+
+// preferredDevices picks size devices from available, including mustInclude. Starting from mustInclude,
+// or from each available device if there is none, the device closest to the devices picked so far is
+// added until size devices are picked. The set of devices with the lowest total distance is returned
+func preferredDevices(devices map[string]*PCIDevice, available, mustInclude []string, size int) []string {
+	if size <= len(mustInclude) {
+		return mustInclude
+	}
+	candidates := make([]string, 0, len(available))
+	for _, id := range available {
+		if !contains(mustInclude, id) {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Strings(candidates)
+	if len(mustInclude)+len(candidates) <= size {
+		return append(append([]string{}, mustInclude...), candidates...)
+	}
+
+	if len(mustInclude) > 0 {
+		picked, _ := pickClosest(devices, mustInclude, candidates, size)
+		return picked
+	}
+
+	var best []string
+	bestCost := -1
+	for i, start := range candidates {
+		rest := append(append([]string{}, candidates[:i]...), candidates[i+1:]...)
+		picked, cost := pickClosest(devices, []string{start}, rest, size)
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = picked, cost
+		}
+	}
+	return best
+}
+
+// pickClosest adds the candidates closest to picked until size devices are picked, and returns them
+// along with the sum of the distances between all picked devices
+func pickClosest(devices map[string]*PCIDevice, picked, candidates []string, size int) ([]string, int) {
+	picked = append([]string{}, picked...)
+	candidates = append([]string{}, candidates...)
+	for len(picked) < size && len(candidates) > 0 {
+		closest, closestCost := 0, -1
+		for i, candidate := range candidates {
+			cost := 0
+			for _, id := range picked {
+				cost += distance(devices[id], devices[candidate])
+			}
+			// candidates are sorted, so ties are broken by the lowest address
+			if closestCost < 0 || cost < closestCost {
+				closest, closestCost = i, cost
+			}
+		}
+		picked = append(picked, candidates[closest])
+		candidates = append(candidates[:closest], candidates[closest+1:]...)
+	}
+
+	total := 0
+	for i := range picked {
+		for j := i + 1; j < len(picked); j++ {
+			total += distance(devices[picked[i]], devices[picked[j]])
+		}
+	}
+	return picked, total
+}
+
+// distance of two devices. Devices of the same IOMMU group are always allocated together, and have no
+// distance. Otherwise the distance is the number of hops between the devices in the PCI hierarchy, plus
+// numaDistance if the devices are on different NUMA nodes
+func distance(a, b *PCIDevice) int {
+	if a == nil || b == nil {
+		return numaDistance + unknownDistance
+	}
+	if a.iommuGroup != "" && a.iommuGroup == b.iommuGroup {
+		return 0
+	}
+
+	d := unknownDistance
+	if len(a.pciPath) > 0 && len(b.pciPath) > 0 {
+		common := 0
+		for common < len(a.pciPath) && common < len(b.pciPath) && a.pciPath[common] == b.pciPath[common] {
+			common++
+		}
+		d = len(a.pciPath) + len(b.pciPath) - 2*common
+	}
+	if a.numaNode != b.numaNode || a.numaNode < 0 {
+		d += numaDistance
+	}
+	return d
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
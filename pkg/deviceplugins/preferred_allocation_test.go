@@ -0,0 +1,178 @@
+package deviceplugins
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "kubevirt.io/kubevirt/pkg/virt-handler/device-manager/deviceplugin/v1beta1"
+)
+
+func newTopologyDevice(address, iommuGroup string, numaNode int, bridges ...string) *PCIDevice {
+	return &PCIDevice{
+		pciID:      address,
+		pciAddress: address,
+		iommuGroup: iommuGroup,
+		numaNode:   numaNode,
+		pciPath:    append(append([]string{"pci0000:00"}, bridges...), address),
+	}
+}
+
+// newTopologyPlugin serves two GPUs under a PCIe switch and one on a root port of NUMA node 0, two GPUs
+// on NUMA node 1, and a GPU sharing its IOMMU group with its audio function
+func newTopologyPlugin() *PCIDevicePlugin {
+	return NewPCIDevicePlugin([]*PCIDevice{
+		newTopologyDevice("0000:04:00.0", "40", 0, "0000:00:01.0", "0000:02:00.0", "0000:03:08.0"),
+		newTopologyDevice("0000:05:00.0", "41", 0, "0000:00:01.0", "0000:02:00.0", "0000:03:10.0"),
+		newTopologyDevice("0000:06:00.0", "42", 0, "0000:00:02.0"),
+		newTopologyDevice("0000:81:00.0", "80", 1, "0000:80:01.0"),
+		newTopologyDevice("0000:82:00.0", "81", 1, "0000:80:02.0"),
+		newTopologyDevice("0000:83:00.0", "90", 1, "0000:80:03.0"),
+		newTopologyDevice("0000:83:00.1", "90", 1, "0000:80:03.0"),
+	}, "fake.com/device")
+}
+
+func Test_GetPreferredAllocation(t *testing.T) {
+	dp := newTopologyPlugin()
+	all := []string{"0000:81:00.0", "0000:04:00.0", "0000:82:00.0", "0000:06:00.0", "0000:05:00.0"}
+	var testCases = []struct {
+		name        string
+		available   []string
+		mustInclude []string
+		size        int32
+		expected    []string
+	}{
+		{
+			name:      "devices under the same switch",
+			available: all,
+			size:      2,
+			expected:  []string{"0000:04:00.0", "0000:05:00.0"},
+		},
+		{
+			name:      "devices of the same numa node",
+			available: all,
+			size:      3,
+			expected:  []string{"0000:04:00.0", "0000:05:00.0", "0000:06:00.0"},
+		},
+		{
+			name:        "devices close to the devices which must be included",
+			available:   all,
+			mustInclude: []string{"0000:81:00.0"},
+			size:        2,
+			expected:    []string{"0000:81:00.0", "0000:82:00.0"},
+		},
+		{
+			name:      "numa node with enough free devices",
+			available: []string{"0000:04:00.0", "0000:81:00.0", "0000:82:00.0"},
+			size:      2,
+			expected:  []string{"0000:81:00.0", "0000:82:00.0"},
+		},
+		{
+			name:        "iommu group siblings",
+			available:   append([]string{"0000:83:00.0", "0000:83:00.1"}, all...),
+			mustInclude: []string{"0000:83:00.1"},
+			size:        2,
+			expected:    []string{"0000:83:00.1", "0000:83:00.0"},
+		},
+		{
+			name:      "all available devices",
+			available: []string{"0000:81:00.0", "0000:04:00.0"},
+			size:      2,
+			expected:  []string{"0000:04:00.0", "0000:81:00.0"},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			resp, err := dp.GetPreferredAllocation(context.Background(), &PreferredAllocationRequest{
+				ContainerRequests: []*ContainerPreferredAllocationRequest{
+					{
+						AvailableDeviceIDs:   v.available,
+						MustIncludeDeviceIDs: v.mustInclude,
+						AllocationSize:       v.size,
+					},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, resp.ContainerResponses, 1)
+			require.Equal(t, v.expected, resp.ContainerResponses[0].DeviceIDs)
+		})
+	}
+}
+
+// Test_GetPreferredAllocationAcrossSegments uses devices of a server with two PCI segments, which
+// reuse the same bus numbers below their root complexes
+func Test_GetPreferredAllocationAcrossSegments(t *testing.T) {
+	assert := require.New(t)
+	segmentDevice := func(address, iommuGroup string, root string, bridges ...string) *PCIDevice {
+		dev := newTopologyDevice(address, iommuGroup, 0, bridges...)
+		dev.pciPath[0] = root
+		return dev
+	}
+	dp := NewPCIDevicePlugin([]*PCIDevice{
+		segmentDevice("0000:17:00.0", "20", "pci0000:16", "0000:16:01.0"),
+		segmentDevice("0001:17:00.0", "120", "pci0001:16", "0001:16:01.0"),
+		segmentDevice("0001:18:00.0", "121", "pci0001:16", "0001:16:01.0"),
+	}, "fake.com/device")
+
+	resp, err := dp.GetPreferredAllocation(context.Background(), &PreferredAllocationRequest{
+		ContainerRequests: []*ContainerPreferredAllocationRequest{
+			{
+				AvailableDeviceIDs:   []string{"0000:17:00.0", "0001:17:00.0", "0001:18:00.0"},
+				MustIncludeDeviceIDs: []string{"0001:17:00.0"},
+				AllocationSize:       2,
+			},
+		},
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"0001:17:00.0", "0001:18:00.0"}, resp.ContainerResponses[0].DeviceIDs,
+		"expected the device below the same root port of the segment")
+}
+
+// Test_DevicePluginService calls the plugin over gRPC, as the kubelet does
+func Test_DevicePluginService(t *testing.T) {
+	assert := require.New(t)
+	dp := newTopologyPlugin()
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	lis, err := net.Listen("unix", socket)
+	assert.NoError(err)
+	server := grpc.NewServer()
+	registerDevicePluginServer(server, dp)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(err)
+	defer conn.Close()
+
+	options := &DevicePluginOptions{}
+	assert.NoError(conn.Invoke(context.Background(), "/v1beta1.DevicePlugin/GetDevicePluginOptions", &pluginapi.Empty{}, options))
+	assert.True(options.GetPreferredAllocationAvailable, "expected preferred allocation to be advertised")
+
+	resp := &PreferredAllocationResponse{}
+	assert.NoError(conn.Invoke(context.Background(), "/v1beta1.DevicePlugin/GetPreferredAllocation", &PreferredAllocationRequest{
+		ContainerRequests: []*ContainerPreferredAllocationRequest{
+			{
+				AvailableDeviceIDs: []string{"0000:04:00.0", "0000:06:00.0", "0000:05:00.0"},
+				AllocationSize:     2,
+			},
+		},
+	}, resp))
+	assert.Len(resp.ContainerResponses, 1)
+	assert.Equal([]string{"0000:04:00.0", "0000:05:00.0"}, resp.ContainerResponses[0].DeviceIDs)
+
+	allocation, err := pluginapi.NewDevicePluginClient(conn).Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0000:04:00.0"}},
+		},
+	})
+	assert.NoError(err)
+	assert.Len(allocation.ContainerResponses, 1)
+	assert.Contains(allocation.ContainerResponses[0].Envs["PCI_RESOURCE_FAKE_COM_DEVICE"], "0000:04:00.0")
+	assert.Len(allocation.ContainerResponses[0].Devices, 2, "expected the vfio container and group devices")
+	assert.Equal("/dev/vfio/40", allocation.ContainerResponses[0].Devices[1].HostPath)
+}
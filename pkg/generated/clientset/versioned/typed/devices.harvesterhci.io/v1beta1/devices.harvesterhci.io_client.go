@@ -28,8 +28,13 @@ import (
 
 type DevicesV1beta1Interface interface {
 	RESTClient() rest.Interface
+	NodePassthroughsGetter
 	PCIDevicesGetter
 	PCIDeviceClaimsGetter
+	PCIDeviceHotplugsGetter
+	PCIDeviceOperationsGetter
+	PCIDevicePoliciesGetter
+	PCITopologiesGetter
 }
 
 // DevicesV1beta1Client is used to interact with features provided by the devices.harvesterhci.io group.
@@ -37,6 +42,10 @@ type DevicesV1beta1Client struct {
 	restClient rest.Interface
 }
 
+func (c *DevicesV1beta1Client) NodePassthroughs() NodePassthroughInterface {
+	return newNodePassthroughs(c)
+}
+
 func (c *DevicesV1beta1Client) PCIDevices() PCIDeviceInterface {
 	return newPCIDevices(c)
 }
@@ -45,6 +54,22 @@ func (c *DevicesV1beta1Client) PCIDeviceClaims() PCIDeviceClaimInterface {
 	return newPCIDeviceClaims(c)
 }
 
+func (c *DevicesV1beta1Client) PCIDeviceHotplugs() PCIDeviceHotplugInterface {
+	return newPCIDeviceHotplugs(c)
+}
+
+func (c *DevicesV1beta1Client) PCIDeviceOperations() PCIDeviceOperationInterface {
+	return newPCIDeviceOperations(c)
+}
+
+func (c *DevicesV1beta1Client) PCIDevicePolicies() PCIDevicePolicyInterface {
+	return newPCIDevicePolicies(c)
+}
+
+func (c *DevicesV1beta1Client) PCITopologies() PCITopologyInterface {
+	return newPCITopologies(c)
+}
+
 // NewForConfig creates a new DevicesV1beta1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -28,6 +28,10 @@ type FakeDevicesV1beta1 struct {
 	*testing.Fake
 }
 
+func (c *FakeDevicesV1beta1) NodePassthroughs() v1beta1.NodePassthroughInterface {
+	return &FakeNodePassthroughs{c}
+}
+
 func (c *FakeDevicesV1beta1) PCIDevices() v1beta1.PCIDeviceInterface {
 	return &FakePCIDevices{c}
 }
@@ -36,6 +40,22 @@ func (c *FakeDevicesV1beta1) PCIDeviceClaims() v1beta1.PCIDeviceClaimInterface {
 	return &FakePCIDeviceClaims{c}
 }
 
+func (c *FakeDevicesV1beta1) PCIDeviceHotplugs() v1beta1.PCIDeviceHotplugInterface {
+	return &FakePCIDeviceHotplugs{c}
+}
+
+func (c *FakeDevicesV1beta1) PCIDeviceOperations() v1beta1.PCIDeviceOperationInterface {
+	return &FakePCIDeviceOperations{c}
+}
+
+func (c *FakeDevicesV1beta1) PCIDevicePolicies() v1beta1.PCIDevicePolicyInterface {
+	return &FakePCIDevicePolicies{c}
+}
+
+func (c *FakeDevicesV1beta1) PCITopologies() v1beta1.PCITopologyInterface {
+	return &FakePCITopologies{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeDevicesV1beta1) RESTClient() rest.Interface {
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeNodePassthroughs implements NodePassthroughInterface
+type FakeNodePassthroughs struct {
+	Fake *FakeDevicesV1beta1
+}
+
+var nodepassthroughsResource = schema.GroupVersionResource{Group: "devices.harvesterhci.io", Version: "v1beta1", Resource: "nodepassthroughs"}
+
+var nodepassthroughsKind = schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "NodePassthrough"}
+
+// Get takes name of the nodePassthrough, and returns the corresponding nodePassthrough object, and an error if there is any.
+func (c *FakeNodePassthroughs) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.NodePassthrough, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(nodepassthroughsResource, name), &v1beta1.NodePassthrough{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.NodePassthrough), err
+}
+
+// List takes label and field selectors, and returns the list of NodePassthroughs that match those selectors.
+func (c *FakeNodePassthroughs) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.NodePassthroughList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(nodepassthroughsResource, nodepassthroughsKind, opts), &v1beta1.NodePassthroughList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1beta1.NodePassthroughList{ListMeta: obj.(*v1beta1.NodePassthroughList).ListMeta}
+	for _, item := range obj.(*v1beta1.NodePassthroughList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested nodePassthroughs.
+func (c *FakeNodePassthroughs) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(nodepassthroughsResource, opts))
+}
+
+// Create takes the representation of a nodePassthrough and creates it.  Returns the server's representation of the nodePassthrough, and an error, if there is any.
+func (c *FakeNodePassthroughs) Create(ctx context.Context, nodePassthrough *v1beta1.NodePassthrough, opts v1.CreateOptions) (result *v1beta1.NodePassthrough, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(nodepassthroughsResource, nodePassthrough), &v1beta1.NodePassthrough{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.NodePassthrough), err
+}
+
+// Update takes the representation of a nodePassthrough and updates it. Returns the server's representation of the nodePassthrough, and an error, if there is any.
+func (c *FakeNodePassthroughs) Update(ctx context.Context, nodePassthrough *v1beta1.NodePassthrough, opts v1.UpdateOptions) (result *v1beta1.NodePassthrough, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(nodepassthroughsResource, nodePassthrough), &v1beta1.NodePassthrough{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.NodePassthrough), err
+}
+
+// Delete takes name of the nodePassthrough and deletes it. Returns an error if one occurs.
+func (c *FakeNodePassthroughs) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteActionWithOptions(nodepassthroughsResource, name, opts), &v1beta1.NodePassthrough{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeNodePassthroughs) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(nodepassthroughsResource, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1beta1.NodePassthroughList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched nodePassthrough.
+func (c *FakeNodePassthroughs) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.NodePassthrough, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(nodepassthroughsResource, name, pt, data, subresources...), &v1beta1.NodePassthrough{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.NodePassthrough), err
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakePCITopologies implements PCITopologyInterface
+type FakePCITopologies struct {
+	Fake *FakeDevicesV1beta1
+}
+
+var pcitopologiesResource = schema.GroupVersionResource{Group: "devices.harvesterhci.io", Version: "v1beta1", Resource: "pcitopologies"}
+
+var pcitopologiesKind = schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCITopology"}
+
+// Get takes name of the pCITopology, and returns the corresponding pCITopology object, and an error if there is any.
+func (c *FakePCITopologies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PCITopology, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(pcitopologiesResource, name), &v1beta1.PCITopology{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.PCITopology), err
+}
+
+// List takes label and field selectors, and returns the list of PCITopologies that match those selectors.
+func (c *FakePCITopologies) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PCITopologyList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(pcitopologiesResource, pcitopologiesKind, opts), &v1beta1.PCITopologyList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1beta1.PCITopologyList{ListMeta: obj.(*v1beta1.PCITopologyList).ListMeta}
+	for _, item := range obj.(*v1beta1.PCITopologyList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested pCITopologies.
+func (c *FakePCITopologies) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(pcitopologiesResource, opts))
+}
+
+// Create takes the representation of a pCITopology and creates it.  Returns the server's representation of the pCITopology, and an error, if there is any.
+func (c *FakePCITopologies) Create(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.CreateOptions) (result *v1beta1.PCITopology, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(pcitopologiesResource, pCITopology), &v1beta1.PCITopology{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.PCITopology), err
+}
+
+// Update takes the representation of a pCITopology and updates it. Returns the server's representation of the pCITopology, and an error, if there is any.
+func (c *FakePCITopologies) Update(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.UpdateOptions) (result *v1beta1.PCITopology, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(pcitopologiesResource, pCITopology), &v1beta1.PCITopology{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.PCITopology), err
+}
+
+// Delete takes name of the pCITopology and deletes it. Returns an error if one occurs.
+func (c *FakePCITopologies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteActionWithOptions(pcitopologiesResource, name, opts), &v1beta1.PCITopology{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakePCITopologies) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(pcitopologiesResource, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1beta1.PCITopologyList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched pCITopology.
+func (c *FakePCITopologies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCITopology, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(pcitopologiesResource, name, pt, data, subresources...), &v1beta1.PCITopology{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.PCITopology), err
+}
@@ -18,6 +18,16 @@ limitations under the License.
 
 package v1beta1
 
+type NodePassthroughExpansion interface{}
+
 type PCIDeviceExpansion interface{}
 
 type PCIDeviceClaimExpansion interface{}
+
+type PCIDeviceHotplugExpansion interface{}
+
+type PCIDeviceOperationExpansion interface{}
+
+type PCIDevicePolicyExpansion interface{}
+
+type PCITopologyExpansion interface{}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	scheme "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// PCIDeviceOperationsGetter has a method to return a PCIDeviceOperationInterface.
+// A group's client should implement this interface.
+type PCIDeviceOperationsGetter interface {
+	PCIDeviceOperations() PCIDeviceOperationInterface
+}
+
+// PCIDeviceOperationInterface has methods to work with PCIDeviceOperation resources.
+type PCIDeviceOperationInterface interface {
+	Create(ctx context.Context, pCIDeviceOperation *v1beta1.PCIDeviceOperation, opts v1.CreateOptions) (*v1beta1.PCIDeviceOperation, error)
+	Update(ctx context.Context, pCIDeviceOperation *v1beta1.PCIDeviceOperation, opts v1.UpdateOptions) (*v1beta1.PCIDeviceOperation, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1beta1.PCIDeviceOperation, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1beta1.PCIDeviceOperationList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCIDeviceOperation, err error)
+	PCIDeviceOperationExpansion
+}
+
+// pCIDeviceOperations implements PCIDeviceOperationInterface
+type pCIDeviceOperations struct {
+	client rest.Interface
+}
+
+// newPCIDeviceOperations returns a PCIDeviceOperations
+func newPCIDeviceOperations(c *DevicesV1beta1Client) *pCIDeviceOperations {
+	return &pCIDeviceOperations{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the pCIDeviceOperation, and returns the corresponding pCIDeviceOperation object, and an error if there is any.
+func (c *pCIDeviceOperations) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PCIDeviceOperation, err error) {
+	result = &v1beta1.PCIDeviceOperation{}
+	err = c.client.Get().
+		Resource("pcideviceoperations").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of PCIDeviceOperations that match those selectors.
+func (c *pCIDeviceOperations) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PCIDeviceOperationList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1beta1.PCIDeviceOperationList{}
+	err = c.client.Get().
+		Resource("pcideviceoperations").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested pCIDeviceOperations.
+func (c *pCIDeviceOperations) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Resource("pcideviceoperations").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a pCIDeviceOperation and creates it.  Returns the server's representation of the pCIDeviceOperation, and an error, if there is any.
+func (c *pCIDeviceOperations) Create(ctx context.Context, pCIDeviceOperation *v1beta1.PCIDeviceOperation, opts v1.CreateOptions) (result *v1beta1.PCIDeviceOperation, err error) {
+	result = &v1beta1.PCIDeviceOperation{}
+	err = c.client.Post().
+		Resource("pcideviceoperations").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCIDeviceOperation).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a pCIDeviceOperation and updates it. Returns the server's representation of the pCIDeviceOperation, and an error, if there is any.
+func (c *pCIDeviceOperations) Update(ctx context.Context, pCIDeviceOperation *v1beta1.PCIDeviceOperation, opts v1.UpdateOptions) (result *v1beta1.PCIDeviceOperation, err error) {
+	result = &v1beta1.PCIDeviceOperation{}
+	err = c.client.Put().
+		Resource("pcideviceoperations").
+		Name(pCIDeviceOperation.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCIDeviceOperation).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the pCIDeviceOperation and deletes it. Returns an error if one occurs.
+func (c *pCIDeviceOperations) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("pcideviceoperations").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *pCIDeviceOperations) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Resource("pcideviceoperations").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched pCIDeviceOperation.
+func (c *pCIDeviceOperations) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCIDeviceOperation, err error) {
+	result = &v1beta1.PCIDeviceOperation{}
+	err = c.client.Patch(pt).
+		Resource("pcideviceoperations").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	scheme "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// PCIDevicePoliciesGetter has a method to return a PCIDevicePolicyInterface.
+// A group's client should implement this interface.
+type PCIDevicePoliciesGetter interface {
+	PCIDevicePolicies() PCIDevicePolicyInterface
+}
+
+// PCIDevicePolicyInterface has methods to work with PCIDevicePolicy resources.
+type PCIDevicePolicyInterface interface {
+	Create(ctx context.Context, pCIDevicePolicy *v1beta1.PCIDevicePolicy, opts v1.CreateOptions) (*v1beta1.PCIDevicePolicy, error)
+	Update(ctx context.Context, pCIDevicePolicy *v1beta1.PCIDevicePolicy, opts v1.UpdateOptions) (*v1beta1.PCIDevicePolicy, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1beta1.PCIDevicePolicy, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1beta1.PCIDevicePolicyList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCIDevicePolicy, err error)
+	PCIDevicePolicyExpansion
+}
+
+// pCIDevicePolicies implements PCIDevicePolicyInterface
+type pCIDevicePolicies struct {
+	client rest.Interface
+}
+
+// newPCIDevicePolicies returns a PCIDevicePolicies
+func newPCIDevicePolicies(c *DevicesV1beta1Client) *pCIDevicePolicies {
+	return &pCIDevicePolicies{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the pCIDevicePolicy, and returns the corresponding pCIDevicePolicy object, and an error if there is any.
+func (c *pCIDevicePolicies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PCIDevicePolicy, err error) {
+	result = &v1beta1.PCIDevicePolicy{}
+	err = c.client.Get().
+		Resource("pcidevicepolicies").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of PCIDevicePolicies that match those selectors.
+func (c *pCIDevicePolicies) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PCIDevicePolicyList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1beta1.PCIDevicePolicyList{}
+	err = c.client.Get().
+		Resource("pcidevicepolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested pCIDevicePolicies.
+func (c *pCIDevicePolicies) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Resource("pcidevicepolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a pCIDevicePolicy and creates it.  Returns the server's representation of the pCIDevicePolicy, and an error, if there is any.
+func (c *pCIDevicePolicies) Create(ctx context.Context, pCIDevicePolicy *v1beta1.PCIDevicePolicy, opts v1.CreateOptions) (result *v1beta1.PCIDevicePolicy, err error) {
+	result = &v1beta1.PCIDevicePolicy{}
+	err = c.client.Post().
+		Resource("pcidevicepolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCIDevicePolicy).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a pCIDevicePolicy and updates it. Returns the server's representation of the pCIDevicePolicy, and an error, if there is any.
+func (c *pCIDevicePolicies) Update(ctx context.Context, pCIDevicePolicy *v1beta1.PCIDevicePolicy, opts v1.UpdateOptions) (result *v1beta1.PCIDevicePolicy, err error) {
+	result = &v1beta1.PCIDevicePolicy{}
+	err = c.client.Put().
+		Resource("pcidevicepolicies").
+		Name(pCIDevicePolicy.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCIDevicePolicy).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the pCIDevicePolicy and deletes it. Returns an error if one occurs.
+func (c *pCIDevicePolicies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("pcidevicepolicies").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *pCIDevicePolicies) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Resource("pcidevicepolicies").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched pCIDevicePolicy.
+func (c *pCIDevicePolicies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCIDevicePolicy, err error) {
+	result = &v1beta1.PCIDevicePolicy{}
+	err = c.client.Patch(pt).
+		Resource("pcidevicepolicies").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	scheme "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// PCITopologiesGetter has a method to return a PCITopologyInterface.
+// A group's client should implement this interface.
+type PCITopologiesGetter interface {
+	PCITopologies() PCITopologyInterface
+}
+
+// PCITopologyInterface has methods to work with PCITopology resources.
+type PCITopologyInterface interface {
+	Create(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.CreateOptions) (*v1beta1.PCITopology, error)
+	Update(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.UpdateOptions) (*v1beta1.PCITopology, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1beta1.PCITopology, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1beta1.PCITopologyList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCITopology, err error)
+	PCITopologyExpansion
+}
+
+// pCITopologies implements PCITopologyInterface
+type pCITopologies struct {
+	client rest.Interface
+}
+
+// newPCITopologies returns a PCITopologies
+func newPCITopologies(c *DevicesV1beta1Client) *pCITopologies {
+	return &pCITopologies{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the pCITopology, and returns the corresponding pCITopology object, and an error if there is any.
+func (c *pCITopologies) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PCITopology, err error) {
+	result = &v1beta1.PCITopology{}
+	err = c.client.Get().
+		Resource("pcitopologies").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of PCITopologies that match those selectors.
+func (c *pCITopologies) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PCITopologyList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1beta1.PCITopologyList{}
+	err = c.client.Get().
+		Resource("pcitopologies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested pCITopologies.
+func (c *pCITopologies) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Resource("pcitopologies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a pCITopology and creates it.  Returns the server's representation of the pCITopology, and an error, if there is any.
+func (c *pCITopologies) Create(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.CreateOptions) (result *v1beta1.PCITopology, err error) {
+	result = &v1beta1.PCITopology{}
+	err = c.client.Post().
+		Resource("pcitopologies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCITopology).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a pCITopology and updates it. Returns the server's representation of the pCITopology, and an error, if there is any.
+func (c *pCITopologies) Update(ctx context.Context, pCITopology *v1beta1.PCITopology, opts v1.UpdateOptions) (result *v1beta1.PCITopology, err error) {
+	result = &v1beta1.PCITopology{}
+	err = c.client.Put().
+		Resource("pcitopologies").
+		Name(pCITopology.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(pCITopology).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the pCITopology and deletes it. Returns an error if one occurs.
+func (c *pCITopologies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("pcitopologies").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *pCITopologies) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Resource("pcitopologies").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched pCITopology.
+func (c *pCITopologies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PCITopology, err error) {
+	result = &v1beta1.PCITopology{}
+	err = c.client.Patch(pt).
+		Resource("pcitopologies").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
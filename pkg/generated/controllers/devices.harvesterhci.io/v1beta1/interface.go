@@ -30,8 +30,13 @@ func init() {
 }
 
 type Interface interface {
+	NodePassthrough() NodePassthroughController
 	PCIDevice() PCIDeviceController
 	PCIDeviceClaim() PCIDeviceClaimController
+	PCIDeviceHotplug() PCIDeviceHotplugController
+	PCIDeviceOperation() PCIDeviceOperationController
+	PCIDevicePolicy() PCIDevicePolicyController
+	PCITopology() PCITopologyController
 }
 
 func New(controllerFactory controller.SharedControllerFactory) Interface {
@@ -44,9 +49,24 @@ type version struct {
 	controllerFactory controller.SharedControllerFactory
 }
 
+func (c *version) NodePassthrough() NodePassthroughController {
+	return NewNodePassthroughController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "NodePassthrough"}, "nodepassthroughs", false, c.controllerFactory)
+}
 func (c *version) PCIDevice() PCIDeviceController {
 	return NewPCIDeviceController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDevice"}, "pcidevices", false, c.controllerFactory)
 }
 func (c *version) PCIDeviceClaim() PCIDeviceClaimController {
 	return NewPCIDeviceClaimController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDeviceClaim"}, "pcideviceclaims", false, c.controllerFactory)
 }
+func (c *version) PCIDeviceHotplug() PCIDeviceHotplugController {
+	return NewPCIDeviceHotplugController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDeviceHotplug"}, "pcidevicehotplugs", false, c.controllerFactory)
+}
+func (c *version) PCIDeviceOperation() PCIDeviceOperationController {
+	return NewPCIDeviceOperationController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDeviceOperation"}, "pcideviceoperations", false, c.controllerFactory)
+}
+func (c *version) PCIDevicePolicy() PCIDevicePolicyController {
+	return NewPCIDevicePolicyController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDevicePolicy"}, "pcidevicepolicies", false, c.controllerFactory)
+}
+func (c *version) PCITopology() PCITopologyController {
+	return NewPCITopologyController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCITopology"}, "pcitopologies", false, c.controllerFactory)
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/kv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type NodePassthroughHandler func(string, *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error)
+
+type NodePassthroughController interface {
+	generic.ControllerMeta
+	NodePassthroughClient
+
+	OnChange(ctx context.Context, name string, sync NodePassthroughHandler)
+	OnRemove(ctx context.Context, name string, sync NodePassthroughHandler)
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+
+	Cache() NodePassthroughCache
+}
+
+type NodePassthroughClient interface {
+	Create(*v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error)
+	Update(*v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error)
+	UpdateStatus(*v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	Get(name string, options metav1.GetOptions) (*v1beta1.NodePassthrough, error)
+	List(opts metav1.ListOptions) (*v1beta1.NodePassthroughList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.NodePassthrough, err error)
+}
+
+type NodePassthroughCache interface {
+	Get(name string) (*v1beta1.NodePassthrough, error)
+	List(selector labels.Selector) ([]*v1beta1.NodePassthrough, error)
+
+	AddIndexer(indexName string, indexer NodePassthroughIndexer)
+	GetByIndex(indexName, key string) ([]*v1beta1.NodePassthrough, error)
+}
+
+type NodePassthroughIndexer func(obj *v1beta1.NodePassthrough) ([]string, error)
+
+type nodePassthroughController struct {
+	controller    controller.SharedController
+	client        *client.Client
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+}
+
+func NewNodePassthroughController(gvk schema.GroupVersionKind, resource string, namespaced bool, controller controller.SharedControllerFactory) NodePassthroughController {
+	c := controller.ForResourceKind(gvk.GroupVersion().WithResource(resource), gvk.Kind, namespaced)
+	return &nodePassthroughController{
+		controller: c,
+		client:     c.Client(),
+		gvk:        gvk,
+		groupResource: schema.GroupResource{
+			Group:    gvk.Group,
+			Resource: resource,
+		},
+	}
+}
+
+func FromNodePassthroughHandlerToHandler(sync NodePassthroughHandler) generic.Handler {
+	return func(key string, obj runtime.Object) (ret runtime.Object, err error) {
+		var v *v1beta1.NodePassthrough
+		if obj == nil {
+			v, err = sync(key, nil)
+		} else {
+			v, err = sync(key, obj.(*v1beta1.NodePassthrough))
+		}
+		if v == nil {
+			return nil, err
+		}
+		return v, err
+	}
+}
+
+func (c *nodePassthroughController) Updater() generic.Updater {
+	return func(obj runtime.Object) (runtime.Object, error) {
+		newObj, err := c.Update(obj.(*v1beta1.NodePassthrough))
+		if newObj == nil {
+			return nil, err
+		}
+		return newObj, err
+	}
+}
+
+func UpdateNodePassthroughDeepCopyOnChange(client NodePassthroughClient, obj *v1beta1.NodePassthrough, handler func(obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error)) (*v1beta1.NodePassthrough, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	copyObj := obj.DeepCopy()
+	newObj, err := handler(copyObj)
+	if newObj != nil {
+		copyObj = newObj
+	}
+	if obj.ResourceVersion == copyObj.ResourceVersion && !equality.Semantic.DeepEqual(obj, copyObj) {
+		return client.Update(copyObj)
+	}
+
+	return copyObj, err
+}
+
+func (c *nodePassthroughController) AddGenericHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.controller.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(handler))
+}
+
+func (c *nodePassthroughController) AddGenericRemoveHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), handler))
+}
+
+func (c *nodePassthroughController) OnChange(ctx context.Context, name string, sync NodePassthroughHandler) {
+	c.AddGenericHandler(ctx, name, FromNodePassthroughHandlerToHandler(sync))
+}
+
+func (c *nodePassthroughController) OnRemove(ctx context.Context, name string, sync NodePassthroughHandler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), FromNodePassthroughHandlerToHandler(sync)))
+}
+
+func (c *nodePassthroughController) Enqueue(name string) {
+	c.controller.Enqueue("", name)
+}
+
+func (c *nodePassthroughController) EnqueueAfter(name string, duration time.Duration) {
+	c.controller.EnqueueAfter("", name, duration)
+}
+
+func (c *nodePassthroughController) Informer() cache.SharedIndexInformer {
+	return c.controller.Informer()
+}
+
+func (c *nodePassthroughController) GroupVersionKind() schema.GroupVersionKind {
+	return c.gvk
+}
+
+func (c *nodePassthroughController) Cache() NodePassthroughCache {
+	return &nodePassthroughCache{
+		indexer:  c.Informer().GetIndexer(),
+		resource: c.groupResource,
+	}
+}
+
+func (c *nodePassthroughController) Create(obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error) {
+	result := &v1beta1.NodePassthrough{}
+	return result, c.client.Create(context.TODO(), "", obj, result, metav1.CreateOptions{})
+}
+
+func (c *nodePassthroughController) Update(obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error) {
+	result := &v1beta1.NodePassthrough{}
+	return result, c.client.Update(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *nodePassthroughController) UpdateStatus(obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error) {
+	result := &v1beta1.NodePassthrough{}
+	return result, c.client.UpdateStatus(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *nodePassthroughController) Delete(name string, options *metav1.DeleteOptions) error {
+	if options == nil {
+		options = &metav1.DeleteOptions{}
+	}
+	return c.client.Delete(context.TODO(), "", name, *options)
+}
+
+func (c *nodePassthroughController) Get(name string, options metav1.GetOptions) (*v1beta1.NodePassthrough, error) {
+	result := &v1beta1.NodePassthrough{}
+	return result, c.client.Get(context.TODO(), "", name, result, options)
+}
+
+func (c *nodePassthroughController) List(opts metav1.ListOptions) (*v1beta1.NodePassthroughList, error) {
+	result := &v1beta1.NodePassthroughList{}
+	return result, c.client.List(context.TODO(), "", result, opts)
+}
+
+func (c *nodePassthroughController) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c.client.Watch(context.TODO(), "", opts)
+}
+
+func (c *nodePassthroughController) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1beta1.NodePassthrough, error) {
+	result := &v1beta1.NodePassthrough{}
+	return result, c.client.Patch(context.TODO(), "", name, pt, data, result, metav1.PatchOptions{}, subresources...)
+}
+
+type nodePassthroughCache struct {
+	indexer  cache.Indexer
+	resource schema.GroupResource
+}
+
+func (c *nodePassthroughCache) Get(name string) (*v1beta1.NodePassthrough, error) {
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(c.resource, name)
+	}
+	return obj.(*v1beta1.NodePassthrough), nil
+}
+
+func (c *nodePassthroughCache) List(selector labels.Selector) (ret []*v1beta1.NodePassthrough, err error) {
+
+	err = cache.ListAll(c.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.NodePassthrough))
+	})
+
+	return ret, err
+}
+
+func (c *nodePassthroughCache) AddIndexer(indexName string, indexer NodePassthroughIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]cache.IndexFunc{
+		indexName: func(obj interface{}) (strings []string, e error) {
+			return indexer(obj.(*v1beta1.NodePassthrough))
+		},
+	}))
+}
+
+func (c *nodePassthroughCache) GetByIndex(indexName, key string) (result []*v1beta1.NodePassthrough, err error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result = make([]*v1beta1.NodePassthrough, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1beta1.NodePassthrough))
+	}
+	return result, nil
+}
+
+type NodePassthroughStatusHandler func(obj *v1beta1.NodePassthrough, status v1beta1.NodePassthroughStatus) (v1beta1.NodePassthroughStatus, error)
+
+type NodePassthroughGeneratingHandler func(obj *v1beta1.NodePassthrough, status v1beta1.NodePassthroughStatus) ([]runtime.Object, v1beta1.NodePassthroughStatus, error)
+
+func RegisterNodePassthroughStatusHandler(ctx context.Context, controller NodePassthroughController, condition condition.Cond, name string, handler NodePassthroughStatusHandler) {
+	statusHandler := &nodePassthroughStatusHandler{
+		client:    controller,
+		condition: condition,
+		handler:   handler,
+	}
+	controller.AddGenericHandler(ctx, name, FromNodePassthroughHandlerToHandler(statusHandler.sync))
+}
+
+func RegisterNodePassthroughGeneratingHandler(ctx context.Context, controller NodePassthroughController, apply apply.Apply,
+	condition condition.Cond, name string, handler NodePassthroughGeneratingHandler, opts *generic.GeneratingHandlerOptions) {
+	statusHandler := &nodePassthroughGeneratingHandler{
+		NodePassthroughGeneratingHandler: handler,
+		apply:                            apply,
+		name:                             name,
+		gvk:                              controller.GroupVersionKind(),
+	}
+	if opts != nil {
+		statusHandler.opts = *opts
+	}
+	controller.OnChange(ctx, name, statusHandler.Remove)
+	RegisterNodePassthroughStatusHandler(ctx, controller, condition, name, statusHandler.Handle)
+}
+
+type nodePassthroughStatusHandler struct {
+	client    NodePassthroughClient
+	condition condition.Cond
+	handler   NodePassthroughStatusHandler
+}
+
+func (a *nodePassthroughStatusHandler) sync(key string, obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	origStatus := obj.Status.DeepCopy()
+	obj = obj.DeepCopy()
+	newStatus, err := a.handler(obj, obj.Status)
+	if err != nil {
+		// Revert to old status on error
+		newStatus = *origStatus.DeepCopy()
+	}
+
+	if a.condition != "" {
+		if errors.IsConflict(err) {
+			a.condition.SetError(&newStatus, "", nil)
+		} else {
+			a.condition.SetError(&newStatus, "", err)
+		}
+	}
+	if !equality.Semantic.DeepEqual(origStatus, &newStatus) {
+		if a.condition != "" {
+			// Since status has changed, update the lastUpdatedTime
+			a.condition.LastUpdated(&newStatus, time.Now().UTC().Format(time.RFC3339))
+		}
+
+		var newErr error
+		obj.Status = newStatus
+		newObj, newErr := a.client.UpdateStatus(obj)
+		if err == nil {
+			err = newErr
+		}
+		if newErr == nil {
+			obj = newObj
+		}
+	}
+	return obj, err
+}
+
+type nodePassthroughGeneratingHandler struct {
+	NodePassthroughGeneratingHandler
+	apply apply.Apply
+	opts  generic.GeneratingHandlerOptions
+	gvk   schema.GroupVersionKind
+	name  string
+}
+
+func (a *nodePassthroughGeneratingHandler) Remove(key string, obj *v1beta1.NodePassthrough) (*v1beta1.NodePassthrough, error) {
+	if obj != nil {
+		return obj, nil
+	}
+
+	obj = &v1beta1.NodePassthrough{}
+	obj.Namespace, obj.Name = kv.RSplit(key, "/")
+	obj.SetGroupVersionKind(a.gvk)
+
+	return nil, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects()
+}
+
+func (a *nodePassthroughGeneratingHandler) Handle(obj *v1beta1.NodePassthrough, status v1beta1.NodePassthroughStatus) (v1beta1.NodePassthroughStatus, error) {
+	if !obj.DeletionTimestamp.IsZero() {
+		return status, nil
+	}
+
+	objs, newStatus, err := a.NodePassthroughGeneratingHandler(obj, status)
+	if err != nil {
+		return newStatus, err
+	}
+
+	return newStatus, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects(objs...)
+}
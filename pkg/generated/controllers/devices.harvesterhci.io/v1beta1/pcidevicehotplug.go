@@ -0,0 +1,376 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/kv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type PCIDeviceHotplugHandler func(string, *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error)
+
+type PCIDeviceHotplugController interface {
+	generic.ControllerMeta
+	PCIDeviceHotplugClient
+
+	OnChange(ctx context.Context, name string, sync PCIDeviceHotplugHandler)
+	OnRemove(ctx context.Context, name string, sync PCIDeviceHotplugHandler)
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+
+	Cache() PCIDeviceHotplugCache
+}
+
+type PCIDeviceHotplugClient interface {
+	Create(*v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error)
+	Update(*v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error)
+	UpdateStatus(*v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	Get(name string, options metav1.GetOptions) (*v1beta1.PCIDeviceHotplug, error)
+	List(opts metav1.ListOptions) (*v1beta1.PCIDeviceHotplugList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PCIDeviceHotplug, err error)
+}
+
+type PCIDeviceHotplugCache interface {
+	Get(name string) (*v1beta1.PCIDeviceHotplug, error)
+	List(selector labels.Selector) ([]*v1beta1.PCIDeviceHotplug, error)
+
+	AddIndexer(indexName string, indexer PCIDeviceHotplugIndexer)
+	GetByIndex(indexName, key string) ([]*v1beta1.PCIDeviceHotplug, error)
+}
+
+type PCIDeviceHotplugIndexer func(obj *v1beta1.PCIDeviceHotplug) ([]string, error)
+
+type pCIDeviceHotplugController struct {
+	controller    controller.SharedController
+	client        *client.Client
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+}
+
+func NewPCIDeviceHotplugController(gvk schema.GroupVersionKind, resource string, namespaced bool, controller controller.SharedControllerFactory) PCIDeviceHotplugController {
+	c := controller.ForResourceKind(gvk.GroupVersion().WithResource(resource), gvk.Kind, namespaced)
+	return &pCIDeviceHotplugController{
+		controller: c,
+		client:     c.Client(),
+		gvk:        gvk,
+		groupResource: schema.GroupResource{
+			Group:    gvk.Group,
+			Resource: resource,
+		},
+	}
+}
+
+func FromPCIDeviceHotplugHandlerToHandler(sync PCIDeviceHotplugHandler) generic.Handler {
+	return func(key string, obj runtime.Object) (ret runtime.Object, err error) {
+		var v *v1beta1.PCIDeviceHotplug
+		if obj == nil {
+			v, err = sync(key, nil)
+		} else {
+			v, err = sync(key, obj.(*v1beta1.PCIDeviceHotplug))
+		}
+		if v == nil {
+			return nil, err
+		}
+		return v, err
+	}
+}
+
+func (c *pCIDeviceHotplugController) Updater() generic.Updater {
+	return func(obj runtime.Object) (runtime.Object, error) {
+		newObj, err := c.Update(obj.(*v1beta1.PCIDeviceHotplug))
+		if newObj == nil {
+			return nil, err
+		}
+		return newObj, err
+	}
+}
+
+func UpdatePCIDeviceHotplugDeepCopyOnChange(client PCIDeviceHotplugClient, obj *v1beta1.PCIDeviceHotplug, handler func(obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error)) (*v1beta1.PCIDeviceHotplug, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	copyObj := obj.DeepCopy()
+	newObj, err := handler(copyObj)
+	if newObj != nil {
+		copyObj = newObj
+	}
+	if obj.ResourceVersion == copyObj.ResourceVersion && !equality.Semantic.DeepEqual(obj, copyObj) {
+		return client.Update(copyObj)
+	}
+
+	return copyObj, err
+}
+
+func (c *pCIDeviceHotplugController) AddGenericHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.controller.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(handler))
+}
+
+func (c *pCIDeviceHotplugController) AddGenericRemoveHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), handler))
+}
+
+func (c *pCIDeviceHotplugController) OnChange(ctx context.Context, name string, sync PCIDeviceHotplugHandler) {
+	c.AddGenericHandler(ctx, name, FromPCIDeviceHotplugHandlerToHandler(sync))
+}
+
+func (c *pCIDeviceHotplugController) OnRemove(ctx context.Context, name string, sync PCIDeviceHotplugHandler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), FromPCIDeviceHotplugHandlerToHandler(sync)))
+}
+
+func (c *pCIDeviceHotplugController) Enqueue(name string) {
+	c.controller.Enqueue("", name)
+}
+
+func (c *pCIDeviceHotplugController) EnqueueAfter(name string, duration time.Duration) {
+	c.controller.EnqueueAfter("", name, duration)
+}
+
+func (c *pCIDeviceHotplugController) Informer() cache.SharedIndexInformer {
+	return c.controller.Informer()
+}
+
+func (c *pCIDeviceHotplugController) GroupVersionKind() schema.GroupVersionKind {
+	return c.gvk
+}
+
+func (c *pCIDeviceHotplugController) Cache() PCIDeviceHotplugCache {
+	return &pCIDeviceHotplugCache{
+		indexer:  c.Informer().GetIndexer(),
+		resource: c.groupResource,
+	}
+}
+
+func (c *pCIDeviceHotplugController) Create(obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	result := &v1beta1.PCIDeviceHotplug{}
+	return result, c.client.Create(context.TODO(), "", obj, result, metav1.CreateOptions{})
+}
+
+func (c *pCIDeviceHotplugController) Update(obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	result := &v1beta1.PCIDeviceHotplug{}
+	return result, c.client.Update(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *pCIDeviceHotplugController) UpdateStatus(obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	result := &v1beta1.PCIDeviceHotplug{}
+	return result, c.client.UpdateStatus(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *pCIDeviceHotplugController) Delete(name string, options *metav1.DeleteOptions) error {
+	if options == nil {
+		options = &metav1.DeleteOptions{}
+	}
+	return c.client.Delete(context.TODO(), "", name, *options)
+}
+
+func (c *pCIDeviceHotplugController) Get(name string, options metav1.GetOptions) (*v1beta1.PCIDeviceHotplug, error) {
+	result := &v1beta1.PCIDeviceHotplug{}
+	return result, c.client.Get(context.TODO(), "", name, result, options)
+}
+
+func (c *pCIDeviceHotplugController) List(opts metav1.ListOptions) (*v1beta1.PCIDeviceHotplugList, error) {
+	result := &v1beta1.PCIDeviceHotplugList{}
+	return result, c.client.List(context.TODO(), "", result, opts)
+}
+
+func (c *pCIDeviceHotplugController) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c.client.Watch(context.TODO(), "", opts)
+}
+
+func (c *pCIDeviceHotplugController) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1beta1.PCIDeviceHotplug, error) {
+	result := &v1beta1.PCIDeviceHotplug{}
+	return result, c.client.Patch(context.TODO(), "", name, pt, data, result, metav1.PatchOptions{}, subresources...)
+}
+
+type pCIDeviceHotplugCache struct {
+	indexer  cache.Indexer
+	resource schema.GroupResource
+}
+
+func (c *pCIDeviceHotplugCache) Get(name string) (*v1beta1.PCIDeviceHotplug, error) {
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(c.resource, name)
+	}
+	return obj.(*v1beta1.PCIDeviceHotplug), nil
+}
+
+func (c *pCIDeviceHotplugCache) List(selector labels.Selector) (ret []*v1beta1.PCIDeviceHotplug, err error) {
+
+	err = cache.ListAll(c.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.PCIDeviceHotplug))
+	})
+
+	return ret, err
+}
+
+func (c *pCIDeviceHotplugCache) AddIndexer(indexName string, indexer PCIDeviceHotplugIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]cache.IndexFunc{
+		indexName: func(obj interface{}) (strings []string, e error) {
+			return indexer(obj.(*v1beta1.PCIDeviceHotplug))
+		},
+	}))
+}
+
+func (c *pCIDeviceHotplugCache) GetByIndex(indexName, key string) (result []*v1beta1.PCIDeviceHotplug, err error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result = make([]*v1beta1.PCIDeviceHotplug, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1beta1.PCIDeviceHotplug))
+	}
+	return result, nil
+}
+
+type PCIDeviceHotplugStatusHandler func(obj *v1beta1.PCIDeviceHotplug, status v1beta1.PCIDeviceHotplugStatus) (v1beta1.PCIDeviceHotplugStatus, error)
+
+type PCIDeviceHotplugGeneratingHandler func(obj *v1beta1.PCIDeviceHotplug, status v1beta1.PCIDeviceHotplugStatus) ([]runtime.Object, v1beta1.PCIDeviceHotplugStatus, error)
+
+func RegisterPCIDeviceHotplugStatusHandler(ctx context.Context, controller PCIDeviceHotplugController, condition condition.Cond, name string, handler PCIDeviceHotplugStatusHandler) {
+	statusHandler := &pCIDeviceHotplugStatusHandler{
+		client:    controller,
+		condition: condition,
+		handler:   handler,
+	}
+	controller.AddGenericHandler(ctx, name, FromPCIDeviceHotplugHandlerToHandler(statusHandler.sync))
+}
+
+func RegisterPCIDeviceHotplugGeneratingHandler(ctx context.Context, controller PCIDeviceHotplugController, apply apply.Apply,
+	condition condition.Cond, name string, handler PCIDeviceHotplugGeneratingHandler, opts *generic.GeneratingHandlerOptions) {
+	statusHandler := &pCIDeviceHotplugGeneratingHandler{
+		PCIDeviceHotplugGeneratingHandler: handler,
+		apply:                             apply,
+		name:                              name,
+		gvk:                               controller.GroupVersionKind(),
+	}
+	if opts != nil {
+		statusHandler.opts = *opts
+	}
+	controller.OnChange(ctx, name, statusHandler.Remove)
+	RegisterPCIDeviceHotplugStatusHandler(ctx, controller, condition, name, statusHandler.Handle)
+}
+
+type pCIDeviceHotplugStatusHandler struct {
+	client    PCIDeviceHotplugClient
+	condition condition.Cond
+	handler   PCIDeviceHotplugStatusHandler
+}
+
+func (a *pCIDeviceHotplugStatusHandler) sync(key string, obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	origStatus := obj.Status.DeepCopy()
+	obj = obj.DeepCopy()
+	newStatus, err := a.handler(obj, obj.Status)
+	if err != nil {
+		// Revert to old status on error
+		newStatus = *origStatus.DeepCopy()
+	}
+
+	if a.condition != "" {
+		if errors.IsConflict(err) {
+			a.condition.SetError(&newStatus, "", nil)
+		} else {
+			a.condition.SetError(&newStatus, "", err)
+		}
+	}
+	if !equality.Semantic.DeepEqual(origStatus, &newStatus) {
+		if a.condition != "" {
+			// Since status has changed, update the lastUpdatedTime
+			a.condition.LastUpdated(&newStatus, time.Now().UTC().Format(time.RFC3339))
+		}
+
+		var newErr error
+		obj.Status = newStatus
+		newObj, newErr := a.client.UpdateStatus(obj)
+		if err == nil {
+			err = newErr
+		}
+		if newErr == nil {
+			obj = newObj
+		}
+	}
+	return obj, err
+}
+
+type pCIDeviceHotplugGeneratingHandler struct {
+	PCIDeviceHotplugGeneratingHandler
+	apply apply.Apply
+	opts  generic.GeneratingHandlerOptions
+	gvk   schema.GroupVersionKind
+	name  string
+}
+
+func (a *pCIDeviceHotplugGeneratingHandler) Remove(key string, obj *v1beta1.PCIDeviceHotplug) (*v1beta1.PCIDeviceHotplug, error) {
+	if obj != nil {
+		return obj, nil
+	}
+
+	obj = &v1beta1.PCIDeviceHotplug{}
+	obj.Namespace, obj.Name = kv.RSplit(key, "/")
+	obj.SetGroupVersionKind(a.gvk)
+
+	return nil, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects()
+}
+
+func (a *pCIDeviceHotplugGeneratingHandler) Handle(obj *v1beta1.PCIDeviceHotplug, status v1beta1.PCIDeviceHotplugStatus) (v1beta1.PCIDeviceHotplugStatus, error) {
+	if !obj.DeletionTimestamp.IsZero() {
+		return status, nil
+	}
+
+	objs, newStatus, err := a.PCIDeviceHotplugGeneratingHandler(obj, status)
+	if err != nil {
+		return newStatus, err
+	}
+
+	return newStatus, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects(objs...)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/generic"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type PCIDeviceOperationHandler func(string, *v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error)
+
+type PCIDeviceOperationController interface {
+	generic.ControllerMeta
+	PCIDeviceOperationClient
+
+	OnChange(ctx context.Context, name string, sync PCIDeviceOperationHandler)
+	OnRemove(ctx context.Context, name string, sync PCIDeviceOperationHandler)
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+
+	Cache() PCIDeviceOperationCache
+}
+
+type PCIDeviceOperationClient interface {
+	Create(*v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error)
+	Update(*v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	Get(name string, options metav1.GetOptions) (*v1beta1.PCIDeviceOperation, error)
+	List(opts metav1.ListOptions) (*v1beta1.PCIDeviceOperationList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PCIDeviceOperation, err error)
+}
+
+type PCIDeviceOperationCache interface {
+	Get(name string) (*v1beta1.PCIDeviceOperation, error)
+	List(selector labels.Selector) ([]*v1beta1.PCIDeviceOperation, error)
+
+	AddIndexer(indexName string, indexer PCIDeviceOperationIndexer)
+	GetByIndex(indexName, key string) ([]*v1beta1.PCIDeviceOperation, error)
+}
+
+type PCIDeviceOperationIndexer func(obj *v1beta1.PCIDeviceOperation) ([]string, error)
+
+type pCIDeviceOperationController struct {
+	controller    controller.SharedController
+	client        *client.Client
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+}
+
+func NewPCIDeviceOperationController(gvk schema.GroupVersionKind, resource string, namespaced bool, controller controller.SharedControllerFactory) PCIDeviceOperationController {
+	c := controller.ForResourceKind(gvk.GroupVersion().WithResource(resource), gvk.Kind, namespaced)
+	return &pCIDeviceOperationController{
+		controller: c,
+		client:     c.Client(),
+		gvk:        gvk,
+		groupResource: schema.GroupResource{
+			Group:    gvk.Group,
+			Resource: resource,
+		},
+	}
+}
+
+func FromPCIDeviceOperationHandlerToHandler(sync PCIDeviceOperationHandler) generic.Handler {
+	return func(key string, obj runtime.Object) (ret runtime.Object, err error) {
+		var v *v1beta1.PCIDeviceOperation
+		if obj == nil {
+			v, err = sync(key, nil)
+		} else {
+			v, err = sync(key, obj.(*v1beta1.PCIDeviceOperation))
+		}
+		if v == nil {
+			return nil, err
+		}
+		return v, err
+	}
+}
+
+func (c *pCIDeviceOperationController) Updater() generic.Updater {
+	return func(obj runtime.Object) (runtime.Object, error) {
+		newObj, err := c.Update(obj.(*v1beta1.PCIDeviceOperation))
+		if newObj == nil {
+			return nil, err
+		}
+		return newObj, err
+	}
+}
+
+func UpdatePCIDeviceOperationDeepCopyOnChange(client PCIDeviceOperationClient, obj *v1beta1.PCIDeviceOperation, handler func(obj *v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error)) (*v1beta1.PCIDeviceOperation, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	copyObj := obj.DeepCopy()
+	newObj, err := handler(copyObj)
+	if newObj != nil {
+		copyObj = newObj
+	}
+	if obj.ResourceVersion == copyObj.ResourceVersion && !equality.Semantic.DeepEqual(obj, copyObj) {
+		return client.Update(copyObj)
+	}
+
+	return copyObj, err
+}
+
+func (c *pCIDeviceOperationController) AddGenericHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.controller.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(handler))
+}
+
+func (c *pCIDeviceOperationController) AddGenericRemoveHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), handler))
+}
+
+func (c *pCIDeviceOperationController) OnChange(ctx context.Context, name string, sync PCIDeviceOperationHandler) {
+	c.AddGenericHandler(ctx, name, FromPCIDeviceOperationHandlerToHandler(sync))
+}
+
+func (c *pCIDeviceOperationController) OnRemove(ctx context.Context, name string, sync PCIDeviceOperationHandler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), FromPCIDeviceOperationHandlerToHandler(sync)))
+}
+
+func (c *pCIDeviceOperationController) Enqueue(name string) {
+	c.controller.Enqueue("", name)
+}
+
+func (c *pCIDeviceOperationController) EnqueueAfter(name string, duration time.Duration) {
+	c.controller.EnqueueAfter("", name, duration)
+}
+
+func (c *pCIDeviceOperationController) Informer() cache.SharedIndexInformer {
+	return c.controller.Informer()
+}
+
+func (c *pCIDeviceOperationController) GroupVersionKind() schema.GroupVersionKind {
+	return c.gvk
+}
+
+func (c *pCIDeviceOperationController) Cache() PCIDeviceOperationCache {
+	return &pCIDeviceOperationCache{
+		indexer:  c.Informer().GetIndexer(),
+		resource: c.groupResource,
+	}
+}
+
+func (c *pCIDeviceOperationController) Create(obj *v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error) {
+	result := &v1beta1.PCIDeviceOperation{}
+	return result, c.client.Create(context.TODO(), "", obj, result, metav1.CreateOptions{})
+}
+
+func (c *pCIDeviceOperationController) Update(obj *v1beta1.PCIDeviceOperation) (*v1beta1.PCIDeviceOperation, error) {
+	result := &v1beta1.PCIDeviceOperation{}
+	return result, c.client.Update(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *pCIDeviceOperationController) Delete(name string, options *metav1.DeleteOptions) error {
+	if options == nil {
+		options = &metav1.DeleteOptions{}
+	}
+	return c.client.Delete(context.TODO(), "", name, *options)
+}
+
+func (c *pCIDeviceOperationController) Get(name string, options metav1.GetOptions) (*v1beta1.PCIDeviceOperation, error) {
+	result := &v1beta1.PCIDeviceOperation{}
+	return result, c.client.Get(context.TODO(), "", name, result, options)
+}
+
+func (c *pCIDeviceOperationController) List(opts metav1.ListOptions) (*v1beta1.PCIDeviceOperationList, error) {
+	result := &v1beta1.PCIDeviceOperationList{}
+	return result, c.client.List(context.TODO(), "", result, opts)
+}
+
+func (c *pCIDeviceOperationController) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c.client.Watch(context.TODO(), "", opts)
+}
+
+func (c *pCIDeviceOperationController) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1beta1.PCIDeviceOperation, error) {
+	result := &v1beta1.PCIDeviceOperation{}
+	return result, c.client.Patch(context.TODO(), "", name, pt, data, result, metav1.PatchOptions{}, subresources...)
+}
+
+type pCIDeviceOperationCache struct {
+	indexer  cache.Indexer
+	resource schema.GroupResource
+}
+
+func (c *pCIDeviceOperationCache) Get(name string) (*v1beta1.PCIDeviceOperation, error) {
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(c.resource, name)
+	}
+	return obj.(*v1beta1.PCIDeviceOperation), nil
+}
+
+func (c *pCIDeviceOperationCache) List(selector labels.Selector) (ret []*v1beta1.PCIDeviceOperation, err error) {
+
+	err = cache.ListAll(c.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.PCIDeviceOperation))
+	})
+
+	return ret, err
+}
+
+func (c *pCIDeviceOperationCache) AddIndexer(indexName string, indexer PCIDeviceOperationIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]cache.IndexFunc{
+		indexName: func(obj interface{}) (strings []string, e error) {
+			return indexer(obj.(*v1beta1.PCIDeviceOperation))
+		},
+	}))
+}
+
+func (c *pCIDeviceOperationCache) GetByIndex(indexName, key string) (result []*v1beta1.PCIDeviceOperation, err error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result = make([]*v1beta1.PCIDeviceOperation, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1beta1.PCIDeviceOperation))
+	}
+	return result, nil
+}
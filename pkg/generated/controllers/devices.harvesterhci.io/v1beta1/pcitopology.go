@@ -0,0 +1,376 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/kv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type PCITopologyHandler func(string, *v1beta1.PCITopology) (*v1beta1.PCITopology, error)
+
+type PCITopologyController interface {
+	generic.ControllerMeta
+	PCITopologyClient
+
+	OnChange(ctx context.Context, name string, sync PCITopologyHandler)
+	OnRemove(ctx context.Context, name string, sync PCITopologyHandler)
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+
+	Cache() PCITopologyCache
+}
+
+type PCITopologyClient interface {
+	Create(*v1beta1.PCITopology) (*v1beta1.PCITopology, error)
+	Update(*v1beta1.PCITopology) (*v1beta1.PCITopology, error)
+	UpdateStatus(*v1beta1.PCITopology) (*v1beta1.PCITopology, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	Get(name string, options metav1.GetOptions) (*v1beta1.PCITopology, error)
+	List(opts metav1.ListOptions) (*v1beta1.PCITopologyList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PCITopology, err error)
+}
+
+type PCITopologyCache interface {
+	Get(name string) (*v1beta1.PCITopology, error)
+	List(selector labels.Selector) ([]*v1beta1.PCITopology, error)
+
+	AddIndexer(indexName string, indexer PCITopologyIndexer)
+	GetByIndex(indexName, key string) ([]*v1beta1.PCITopology, error)
+}
+
+type PCITopologyIndexer func(obj *v1beta1.PCITopology) ([]string, error)
+
+type pCITopologyController struct {
+	controller    controller.SharedController
+	client        *client.Client
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+}
+
+func NewPCITopologyController(gvk schema.GroupVersionKind, resource string, namespaced bool, controller controller.SharedControllerFactory) PCITopologyController {
+	c := controller.ForResourceKind(gvk.GroupVersion().WithResource(resource), gvk.Kind, namespaced)
+	return &pCITopologyController{
+		controller: c,
+		client:     c.Client(),
+		gvk:        gvk,
+		groupResource: schema.GroupResource{
+			Group:    gvk.Group,
+			Resource: resource,
+		},
+	}
+}
+
+func FromPCITopologyHandlerToHandler(sync PCITopologyHandler) generic.Handler {
+	return func(key string, obj runtime.Object) (ret runtime.Object, err error) {
+		var v *v1beta1.PCITopology
+		if obj == nil {
+			v, err = sync(key, nil)
+		} else {
+			v, err = sync(key, obj.(*v1beta1.PCITopology))
+		}
+		if v == nil {
+			return nil, err
+		}
+		return v, err
+	}
+}
+
+func (c *pCITopologyController) Updater() generic.Updater {
+	return func(obj runtime.Object) (runtime.Object, error) {
+		newObj, err := c.Update(obj.(*v1beta1.PCITopology))
+		if newObj == nil {
+			return nil, err
+		}
+		return newObj, err
+	}
+}
+
+func UpdatePCITopologyDeepCopyOnChange(client PCITopologyClient, obj *v1beta1.PCITopology, handler func(obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error)) (*v1beta1.PCITopology, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	copyObj := obj.DeepCopy()
+	newObj, err := handler(copyObj)
+	if newObj != nil {
+		copyObj = newObj
+	}
+	if obj.ResourceVersion == copyObj.ResourceVersion && !equality.Semantic.DeepEqual(obj, copyObj) {
+		return client.Update(copyObj)
+	}
+
+	return copyObj, err
+}
+
+func (c *pCITopologyController) AddGenericHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.controller.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(handler))
+}
+
+func (c *pCITopologyController) AddGenericRemoveHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), handler))
+}
+
+func (c *pCITopologyController) OnChange(ctx context.Context, name string, sync PCITopologyHandler) {
+	c.AddGenericHandler(ctx, name, FromPCITopologyHandlerToHandler(sync))
+}
+
+func (c *pCITopologyController) OnRemove(ctx context.Context, name string, sync PCITopologyHandler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), FromPCITopologyHandlerToHandler(sync)))
+}
+
+func (c *pCITopologyController) Enqueue(name string) {
+	c.controller.Enqueue("", name)
+}
+
+func (c *pCITopologyController) EnqueueAfter(name string, duration time.Duration) {
+	c.controller.EnqueueAfter("", name, duration)
+}
+
+func (c *pCITopologyController) Informer() cache.SharedIndexInformer {
+	return c.controller.Informer()
+}
+
+func (c *pCITopologyController) GroupVersionKind() schema.GroupVersionKind {
+	return c.gvk
+}
+
+func (c *pCITopologyController) Cache() PCITopologyCache {
+	return &pCITopologyCache{
+		indexer:  c.Informer().GetIndexer(),
+		resource: c.groupResource,
+	}
+}
+
+func (c *pCITopologyController) Create(obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error) {
+	result := &v1beta1.PCITopology{}
+	return result, c.client.Create(context.TODO(), "", obj, result, metav1.CreateOptions{})
+}
+
+func (c *pCITopologyController) Update(obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error) {
+	result := &v1beta1.PCITopology{}
+	return result, c.client.Update(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *pCITopologyController) UpdateStatus(obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error) {
+	result := &v1beta1.PCITopology{}
+	return result, c.client.UpdateStatus(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *pCITopologyController) Delete(name string, options *metav1.DeleteOptions) error {
+	if options == nil {
+		options = &metav1.DeleteOptions{}
+	}
+	return c.client.Delete(context.TODO(), "", name, *options)
+}
+
+func (c *pCITopologyController) Get(name string, options metav1.GetOptions) (*v1beta1.PCITopology, error) {
+	result := &v1beta1.PCITopology{}
+	return result, c.client.Get(context.TODO(), "", name, result, options)
+}
+
+func (c *pCITopologyController) List(opts metav1.ListOptions) (*v1beta1.PCITopologyList, error) {
+	result := &v1beta1.PCITopologyList{}
+	return result, c.client.List(context.TODO(), "", result, opts)
+}
+
+func (c *pCITopologyController) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c.client.Watch(context.TODO(), "", opts)
+}
+
+func (c *pCITopologyController) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1beta1.PCITopology, error) {
+	result := &v1beta1.PCITopology{}
+	return result, c.client.Patch(context.TODO(), "", name, pt, data, result, metav1.PatchOptions{}, subresources...)
+}
+
+type pCITopologyCache struct {
+	indexer  cache.Indexer
+	resource schema.GroupResource
+}
+
+func (c *pCITopologyCache) Get(name string) (*v1beta1.PCITopology, error) {
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(c.resource, name)
+	}
+	return obj.(*v1beta1.PCITopology), nil
+}
+
+func (c *pCITopologyCache) List(selector labels.Selector) (ret []*v1beta1.PCITopology, err error) {
+
+	err = cache.ListAll(c.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.PCITopology))
+	})
+
+	return ret, err
+}
+
+func (c *pCITopologyCache) AddIndexer(indexName string, indexer PCITopologyIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]cache.IndexFunc{
+		indexName: func(obj interface{}) (strings []string, e error) {
+			return indexer(obj.(*v1beta1.PCITopology))
+		},
+	}))
+}
+
+func (c *pCITopologyCache) GetByIndex(indexName, key string) (result []*v1beta1.PCITopology, err error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result = make([]*v1beta1.PCITopology, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1beta1.PCITopology))
+	}
+	return result, nil
+}
+
+type PCITopologyStatusHandler func(obj *v1beta1.PCITopology, status v1beta1.PCITopologyStatus) (v1beta1.PCITopologyStatus, error)
+
+type PCITopologyGeneratingHandler func(obj *v1beta1.PCITopology, status v1beta1.PCITopologyStatus) ([]runtime.Object, v1beta1.PCITopologyStatus, error)
+
+func RegisterPCITopologyStatusHandler(ctx context.Context, controller PCITopologyController, condition condition.Cond, name string, handler PCITopologyStatusHandler) {
+	statusHandler := &pCITopologyStatusHandler{
+		client:    controller,
+		condition: condition,
+		handler:   handler,
+	}
+	controller.AddGenericHandler(ctx, name, FromPCITopologyHandlerToHandler(statusHandler.sync))
+}
+
+func RegisterPCITopologyGeneratingHandler(ctx context.Context, controller PCITopologyController, apply apply.Apply,
+	condition condition.Cond, name string, handler PCITopologyGeneratingHandler, opts *generic.GeneratingHandlerOptions) {
+	statusHandler := &pCITopologyGeneratingHandler{
+		PCITopologyGeneratingHandler: handler,
+		apply:                        apply,
+		name:                         name,
+		gvk:                          controller.GroupVersionKind(),
+	}
+	if opts != nil {
+		statusHandler.opts = *opts
+	}
+	controller.OnChange(ctx, name, statusHandler.Remove)
+	RegisterPCITopologyStatusHandler(ctx, controller, condition, name, statusHandler.Handle)
+}
+
+type pCITopologyStatusHandler struct {
+	client    PCITopologyClient
+	condition condition.Cond
+	handler   PCITopologyStatusHandler
+}
+
+func (a *pCITopologyStatusHandler) sync(key string, obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	origStatus := obj.Status.DeepCopy()
+	obj = obj.DeepCopy()
+	newStatus, err := a.handler(obj, obj.Status)
+	if err != nil {
+		// Revert to old status on error
+		newStatus = *origStatus.DeepCopy()
+	}
+
+	if a.condition != "" {
+		if errors.IsConflict(err) {
+			a.condition.SetError(&newStatus, "", nil)
+		} else {
+			a.condition.SetError(&newStatus, "", err)
+		}
+	}
+	if !equality.Semantic.DeepEqual(origStatus, &newStatus) {
+		if a.condition != "" {
+			// Since status has changed, update the lastUpdatedTime
+			a.condition.LastUpdated(&newStatus, time.Now().UTC().Format(time.RFC3339))
+		}
+
+		var newErr error
+		obj.Status = newStatus
+		newObj, newErr := a.client.UpdateStatus(obj)
+		if err == nil {
+			err = newErr
+		}
+		if newErr == nil {
+			obj = newObj
+		}
+	}
+	return obj, err
+}
+
+type pCITopologyGeneratingHandler struct {
+	PCITopologyGeneratingHandler
+	apply apply.Apply
+	opts  generic.GeneratingHandlerOptions
+	gvk   schema.GroupVersionKind
+	name  string
+}
+
+func (a *pCITopologyGeneratingHandler) Remove(key string, obj *v1beta1.PCITopology) (*v1beta1.PCITopology, error) {
+	if obj != nil {
+		return obj, nil
+	}
+
+	obj = &v1beta1.PCITopology{}
+	obj.Namespace, obj.Name = kv.RSplit(key, "/")
+	obj.SetGroupVersionKind(a.gvk)
+
+	return nil, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects()
+}
+
+func (a *pCITopologyGeneratingHandler) Handle(obj *v1beta1.PCITopology, status v1beta1.PCITopologyStatus) (v1beta1.PCITopologyStatus, error) {
+	if !obj.DeletionTimestamp.IsZero() {
+		return status, nil
+	}
+
+	objs, newStatus, err := a.PCITopologyGeneratingHandler(obj, status)
+	if err != nil {
+		return newStatus, err
+	}
+
+	return newStatus, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects(objs...)
+}
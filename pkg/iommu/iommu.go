@@ -2,54 +2,64 @@ package iommu
 
 import (
 	"fmt"
-	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 
-	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/harvester/pcidevices/pkg/util/pciaddress"
 )
 
-func GroupMapForPCIDevices(groupPaths []string) map[string]int {
-	groupMap := make(map[string]int)
-	for _, groupPath := range groupPaths {
-		groupPathSplit := strings.Split(groupPath, "/")
-		if len(groupPathSplit) > 6 {
-			deviceAddr := groupPathSplit[6]
-			group := groupPathSplit[4]
-			groupInt, err := strconv.Atoi(group)
-			if err == nil {
-				groupMap[deviceAddr] = groupInt
-			} else {
-				logrus.Errorf("groupPath %s contains an invalid IOMMU Group: %v", groupPath, err)
-			}
-		} else {
-			logrus.Fatalf("groupPath %s does not contain a valid PCI address", groupPath)
-		}
-	}
-	return groupMap
+// Groups returns the IOMMU group of each PCI device of the node by address
+func Groups() (map[string]int, error) {
+	return DefaultHost.Groups()
 }
 
-const sysKernelIommuGroups = "/sys/kernel/iommu_groups"
-
-// return all paths like /sys/kernel/iommu_groups/$GROUP/devices/$DEVICE
-func GroupPaths() ([]string, error) {
-	// list all iommu groups
-	iommuGroups, err := ioutil.ReadDir(sysKernelIommuGroups)
+// Groups returns the IOMMU group of each PCI device by address, which is resolved through the iommu_group
+// link of the device, e.g. /sys/bus/pci/devices/0000:65:00.0/iommu_group -> ../../../../kernel/iommu_groups/45
+// Devices without a group are left out, as the kernel only places devices into groups if the IOMMU is
+// enabled. Devices which cannot be read are reported in the error, along with the groups of the other devices
+func (h Host) Groups() (map[string]int, error) {
+	groups := make(map[string]int)
+	devicesPath := filepath.Join(h.SysPath, "bus", "pci", "devices")
+	devices, err := os.ReadDir(devicesPath)
 	if err != nil {
-		// TODO log the error
-		return []string{}, err
+		return groups, fmt.Errorf("error listing pci devices in %s: %v", devicesPath, err)
 	}
-	var groupPaths []string = []string{}
-	for _, group := range iommuGroups {
-		path := fmt.Sprintf("%s/%s/devices", sysKernelIommuGroups, group.Name())
-		devices, err := ioutil.ReadDir(path)
+
+	var errs []error
+	for _, device := range devices {
+		address, err := pciaddress.Parse(device.Name())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		group, ok, err := h.DeviceGroup(address.String())
 		if err != nil {
-			return []string{}, err
+			errs = append(errs, err)
+			continue
 		}
-		for _, device := range devices {
-			groupPath := fmt.Sprintf("%s/%s/devices/%s", sysKernelIommuGroups, group.Name(), device.Name())
-			groupPaths = append(groupPaths, groupPath)
+		if ok {
+			groups[address.String()] = group
 		}
 	}
-	return groupPaths, nil
+	return groups, utilerrors.NewAggregate(errs)
+}
+
+// DeviceGroup returns the IOMMU group of the device at address, and false if the device is in no group
+func (h Host) DeviceGroup(address string) (int, bool, error) {
+	link := filepath.Join(h.SysPath, "bus", "pci", "devices", address, "iommu_group")
+	target, err := os.Readlink(link)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("error reading iommu group of %s: %v", address, err)
+	}
+	group, err := strconv.Atoi(filepath.Base(target))
+	if err != nil || filepath.Base(filepath.Dir(target)) != "iommu_groups" {
+		return 0, false, fmt.Errorf("iommu group link of %s points to an unexpected path %s", address, target)
+	}
+	return group, true, nil
 }
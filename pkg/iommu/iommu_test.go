@@ -1,46 +1,74 @@
 package iommu
 
 import (
-	"reflect"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
-func TestGroupMapForPCIDevices(t *testing.T) {
-	iommuGroups := []string{
-		"/sys/kernel/iommu_groups/9/devices/0000:00:1c.0",
-		"/sys/kernel/iommu_groups/9/devices/0000:00:1c.5",
-		"/sys/kernel/iommu_groups/9/devices/0000:06:00.0",
-		"/sys/kernel/iommu_groups/9/devices/0000:05:00.0",
-		"/sys/kernel/iommu_groups/27/devices/0000:3e:04.2",
-		"/sys/kernel/iommu_groups/27/devices/0000:3e:04.0",
-		"/sys/kernel/iommu_groups/27/devices/0000:3e:04.3",
-		"/sys/kernel/iommu_groups/27/devices/0000:3e:04.1",
-	}
-	tests := []struct {
-		name string
-		args []string
-		want map[string]int
-	}{
-		{
-			name: "Test GroupMapForPCIDevices",
-			args: iommuGroups,
-			want: map[string]int{
-				"0000:00:1c.0": 9,
-				"0000:00:1c.5": 9,
-				"0000:06:00.0": 9,
-				"0000:05:00.0": 9,
-				"0000:3e:04.2": 27,
-				"0000:3e:04.0": 27,
-				"0000:3e:04.3": 27,
-				"0000:3e:04.1": 27,
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := GroupMapForPCIDevices(tt.args); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GroupMapForPCIDevices() = %v, want %v", got, tt.want)
-			}
-		})
+// newSysfs lays out the iommu groups of devices by address, and the iommu_group links of the devices
+func newSysfs(t *testing.T, groups map[string]string) Host {
+	assert := require.New(t)
+	host := Host{SysPath: t.TempDir()}
+	devicesPath := filepath.Join(host.SysPath, "bus", "pci", "devices")
+	for address, group := range groups {
+		assert.NoError(os.MkdirAll(filepath.Join(devicesPath, address), 0755))
+		if group == "" {
+			continue
+		}
+		assert.NoError(os.MkdirAll(filepath.Join(host.SysPath, "kernel", "iommu_groups", group), 0755))
+		assert.NoError(os.Symlink(filepath.Join("..", "..", "..", "..", "kernel", "iommu_groups", group), filepath.Join(devicesPath, address, "iommu_group")))
 	}
+	return host
+}
+
+func Test_Groups(t *testing.T) {
+	assert := require.New(t)
+	host := newSysfs(t, map[string]string{
+		"0000:00:1c.0":  "9",
+		"0000:06:00.0":  "9",
+		"0000:3e:04.2":  "27",
+		"0001:3e:04.2":  "127",
+		"10000:e1:00.0": "200",
+		"0000:00:00.0":  "",
+	})
+
+	groups, err := host.Groups()
+	assert.NoError(err)
+	assert.Equal(map[string]int{
+		"0000:00:1c.0":  9,
+		"0000:06:00.0":  9,
+		"0000:3e:04.2":  27,
+		"0001:3e:04.2":  127,
+		"10000:e1:00.0": 200,
+	}, groups, "expected devices in other pci domains to be told apart, and devices without a group to be left out")
+
+	group, ok, err := host.DeviceGroup("0001:3e:04.2")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(127, group)
+}
+
+func Test_GroupsReportsErrors(t *testing.T) {
+	assert := require.New(t)
+	host := newSysfs(t, map[string]string{
+		"0000:00:1c.0": "9",
+		"0000:06:00.0": "",
+	})
+	devicesPath := filepath.Join(host.SysPath, "bus", "pci", "devices")
+	assert.NoError(os.Symlink("../../../devices/platform/unexpected", filepath.Join(devicesPath, "0000:06:00.0", "iommu_group")))
+	assert.NoError(os.MkdirAll(filepath.Join(devicesPath, "not-a-device"), 0755))
+
+	groups, err := host.Groups()
+	assert.Error(err)
+	assert.Contains(err.Error(), "0000:06:00.0")
+	assert.Contains(err.Error(), "not-a-device")
+	assert.Equal(map[string]int{"0000:00:1c.0": 9}, groups, "expected the groups of readable devices to be returned")
+
+	// without sysfs, no device is in a group
+	groups, err = Host{SysPath: filepath.Join(t.TempDir(), "missing")}.Groups()
+	assert.Error(err)
+	assert.Empty(groups)
 }
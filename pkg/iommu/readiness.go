@@ -0,0 +1,248 @@
+package iommu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+// Host is where the readiness of a node for PCI passthrough is read from
+type Host struct {
+	SysPath  string
+	ProcPath string
+	// Arch is the GOARCH of the node, the architecture of the controller if empty
+	Arch string
+}
+
+// DefaultHost reads the sysfs and procfs of the node
+var DefaultHost = Host{
+	SysPath:  "/sys",
+	ProcPath: "/proc",
+}
+
+// kernelParameterPrefixes select the parameters of the kernel command line related to passthrough
+var kernelParameterPrefixes = []string{"intel_iommu=", "amd_iommu=", "iommu=", "iommu.", "intremap=", "nointremap", "vfio"}
+
+// hostState is what the checks are based on
+type hostState struct {
+	iommuGroups        int
+	kernelParameters   []string
+	cpuVendor          string
+	arch               string
+	interruptRemapping bool
+	unsafeInterrupts   bool
+	vfioPCILoaded      bool
+	vfioType1Loaded    bool
+	noIOMMUMode        bool
+}
+
+// CheckReadiness checks if the node is ready for PCI passthrough, and returns the result of each check
+// along with hints on how to fix failed checks
+func (h Host) CheckReadiness() v1beta1.NodePassthroughStatus {
+	state := h.readState()
+	checks := []v1beta1.PassthroughCheck{
+		checkIOMMUGroups(state),
+		checkKernelParameters(state),
+		checkInterruptRemapping(state),
+		checkVFIOModules(state),
+		checkNoIOMMUMode(state),
+	}
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Passed
+	}
+	return v1beta1.NodePassthroughStatus{
+		Ready:            ready,
+		IOMMUGroups:      state.iommuGroups,
+		KernelParameters: state.kernelParameters,
+		Checks:           checks,
+	}
+}
+
+func (h Host) readState() hostState {
+	state := hostState{}
+	if groups, err := os.ReadDir(filepath.Join(h.SysPath, "kernel", "iommu_groups")); err == nil {
+		state.iommuGroups = len(groups)
+	}
+
+	if cmdline, err := os.ReadFile(filepath.Join(h.ProcPath, "cmdline")); err == nil {
+		for _, param := range strings.Fields(string(cmdline)) {
+			for _, prefix := range kernelParameterPrefixes {
+				if strings.HasPrefix(param, prefix) {
+					state.kernelParameters = append(state.kernelParameters, param)
+					break
+				}
+			}
+		}
+	}
+	state.cpuVendor = readCPUVendor(filepath.Join(h.ProcPath, "cpuinfo"))
+
+	state.arch = h.Arch
+	if state.arch == "" {
+		state.arch = runtime.GOARCH
+	}
+	// the interrupt chips of remapped interrupts are prefixed with IR on x86, e.g. IR-PCI-MSI
+	if interrupts, err := os.ReadFile(filepath.Join(h.ProcPath, "interrupts")); err == nil && isX86(state.arch) {
+		state.interruptRemapping = bytes.Contains(interrupts, []byte(" IR-"))
+	}
+	state.unsafeInterrupts = readModuleParameter(h.SysPath, "vfio_iommu_type1", "allow_unsafe_interrupts") == "Y"
+	state.vfioPCILoaded = exists(filepath.Join(h.SysPath, "bus", "pci", "drivers", "vfio-pci"))
+	state.vfioType1Loaded = exists(filepath.Join(h.SysPath, "module", "vfio_iommu_type1"))
+	state.noIOMMUMode = readModuleParameter(h.SysPath, "vfio", "enable_unsafe_noiommu_mode") == "Y"
+	return state
+}
+
+func checkIOMMUGroups(state hostState) v1beta1.PassthroughCheck {
+	check := v1beta1.PassthroughCheck{Name: v1beta1.CheckIOMMUGroups}
+	if state.iommuGroups == 0 {
+		check.Message = "no iommu groups found, the iommu is disabled"
+		check.Remediation = fmt.Sprintf("enable %s in the firmware settings of the node, and add %s to the kernel command line",
+			firmwareIOMMU(state.cpuVendor), iommuParameter(state.cpuVendor))
+		return check
+	}
+	check.Passed = true
+	check.Message = fmt.Sprintf("%d iommu groups found", state.iommuGroups)
+	return check
+}
+
+func checkKernelParameters(state hostState) v1beta1.PassthroughCheck {
+	check := v1beta1.PassthroughCheck{Name: v1beta1.CheckKernelParameters}
+	for _, param := range state.kernelParameters {
+		switch param {
+		case "intel_iommu=off", "amd_iommu=off", "iommu=off":
+			check.Message = fmt.Sprintf("the iommu is disabled by %s", param)
+			check.Remediation = fmt.Sprintf("remove %s from the kernel command line, and add %s", param, iommuParameter(state.cpuVendor))
+			return check
+		}
+	}
+	if state.iommuGroups == 0 && state.cpuVendor == "GenuineIntel" && !contains(state.kernelParameters, "intel_iommu=on") {
+		check.Message = "intel_iommu=on is missing from the kernel command line"
+		check.Remediation = "add intel_iommu=on to the kernel command line"
+		return check
+	}
+
+	check.Passed = true
+	check.Message = fmt.Sprintf("kernel parameters: %s", strings.Join(state.kernelParameters, " "))
+	if !contains(state.kernelParameters, "iommu=pt") {
+		check.Remediation = "add iommu=pt to the kernel command line, so devices used by the host bypass dma translation"
+	}
+	return check
+}
+
+func checkInterruptRemapping(state hostState) v1beta1.PassthroughCheck {
+	check := v1beta1.PassthroughCheck{Name: v1beta1.CheckInterruptRemapping, Passed: true}
+	switch {
+	case !isX86(state.arch):
+		// other platforms isolate interrupts without remapping them, e.g. the GIC ITS on arm64, which vfio checks
+		// when a device is assigned
+		check.Message = fmt.Sprintf("interrupt remapping is not checked on %s", state.arch)
+	case state.interruptRemapping:
+		check.Message = "interrupt remapping is enabled"
+	case state.unsafeInterrupts:
+		check.Message = "interrupt remapping is disabled, and unsafe interrupts are allowed"
+		check.Remediation = "enable interrupt remapping in the firmware settings of the node, devices can inject interrupts into the host while unsafe interrupts are allowed"
+	default:
+		check.Passed = false
+		check.Message = "interrupt remapping is disabled, vfio refuses to assign devices"
+		check.Remediation = "enable interrupt remapping and x2apic in the firmware settings of the node, and remove intremap=off or nointremap from the kernel command line"
+	}
+	return check
+}
+
+// isX86 checks if arch remaps interrupts through the iommu, as reported in /proc/interrupts
+func isX86(arch string) bool {
+	return arch == "amd64" || arch == "386"
+}
+
+func checkVFIOModules(state hostState) v1beta1.PassthroughCheck {
+	check := v1beta1.PassthroughCheck{Name: v1beta1.CheckVFIOModules}
+	var missing []string
+	if !state.vfioPCILoaded {
+		missing = append(missing, "vfio-pci")
+	}
+	if !state.vfioType1Loaded {
+		missing = append(missing, "vfio_iommu_type1")
+	}
+	if len(missing) > 0 {
+		check.Message = fmt.Sprintf("%s not loaded", strings.Join(missing, " and "))
+		check.Remediation = fmt.Sprintf("load the modules with modprobe %s, and check that the kernel of the node ships them", strings.Join(missing, " "))
+		return check
+	}
+	check.Passed = true
+	check.Message = "vfio-pci and vfio_iommu_type1 are loaded"
+	return check
+}
+
+func checkNoIOMMUMode(state hostState) v1beta1.PassthroughCheck {
+	check := v1beta1.PassthroughCheck{Name: v1beta1.CheckNoIOMMUMode, Passed: true}
+	if state.noIOMMUMode {
+		check.Passed = false
+		check.Message = "vfio runs in unsafe no-iommu mode, devices are not isolated from the host"
+		check.Remediation = "remove vfio.enable_unsafe_noiommu_mode=1 from the kernel command line or the module options, and reload vfio"
+	}
+	return check
+}
+
+func firmwareIOMMU(cpuVendor string) string {
+	switch cpuVendor {
+	case "GenuineIntel":
+		return "VT-d"
+	case "AuthenticAMD":
+		return "AMD-Vi"
+	}
+	return "the iommu"
+}
+
+func iommuParameter(cpuVendor string) string {
+	switch cpuVendor {
+	case "GenuineIntel":
+		return "intel_iommu=on iommu=pt"
+	case "AuthenticAMD":
+		return "amd_iommu=on iommu=pt"
+	}
+	return "intel_iommu=on or amd_iommu=on, and iommu=pt"
+}
+
+func readCPUVendor(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(key) == "vendor_id" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func readModuleParameter(sysPath, module, parameter string) string {
+	value, err := os.ReadFile(filepath.Join(sysPath, "module", module, "parameters", parameter))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package iommu
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+// fakeHost lays out the files read by the readiness checks in a temporary directory
+type fakeHost struct {
+	arch               string
+	iommuGroups        int
+	cmdline            string
+	interruptRemapping bool
+	unsafeInterrupts   bool
+	vfioLoaded         bool
+	noIOMMUMode        bool
+}
+
+func (f fakeHost) create(t *testing.T) Host {
+	assert := require.New(t)
+	root := t.TempDir()
+	host := Host{SysPath: filepath.Join(root, "sys"), ProcPath: filepath.Join(root, "proc"), Arch: f.arch}
+	if host.Arch == "" {
+		host.Arch = "amd64"
+	}
+	write := func(path, content string) {
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(os.WriteFile(path, []byte(content), 0644))
+	}
+
+	assert.NoError(os.MkdirAll(filepath.Join(host.SysPath, "kernel", "iommu_groups"), 0755))
+	for i := 0; i < f.iommuGroups; i++ {
+		assert.NoError(os.MkdirAll(filepath.Join(host.SysPath, "kernel", "iommu_groups", strconv.Itoa(i), "devices"), 0755))
+	}
+	write(filepath.Join(host.ProcPath, "cmdline"), f.cmdline+"\n")
+	write(filepath.Join(host.ProcPath, "cpuinfo"), "processor\t: 0\nvendor_id\t: GenuineIntel\n")
+	interrupts := "  24:  0  PCI-MSI 1048576-edge  eth0\n"
+	if f.interruptRemapping {
+		interrupts = "  24:  0  IR-PCI-MSI 1048576-edge  eth0\n"
+	}
+	write(filepath.Join(host.ProcPath, "interrupts"), interrupts)
+	write(filepath.Join(host.SysPath, "module", "vfio", "parameters", "enable_unsafe_noiommu_mode"), yesNo(f.noIOMMUMode))
+	if f.vfioLoaded {
+		assert.NoError(os.MkdirAll(filepath.Join(host.SysPath, "bus", "pci", "drivers", "vfio-pci"), 0755))
+		write(filepath.Join(host.SysPath, "module", "vfio_iommu_type1", "parameters", "allow_unsafe_interrupts"), yesNo(f.unsafeInterrupts))
+	}
+	return host
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Y\n"
+	}
+	return "N\n"
+}
+
+func Test_CheckReadiness(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		host              fakeHost
+		expectReady       bool
+		expectFailed      []v1beta1.PassthroughCheckName
+		expectRemediation map[v1beta1.PassthroughCheckName]string
+	}{
+		{
+			name: "ready node",
+			host: fakeHost{
+				iommuGroups:        3,
+				cmdline:            "BOOT_IMAGE=/vmlinuz root=/dev/sda1 intel_iommu=on iommu=pt",
+				interruptRemapping: true,
+				vfioLoaded:         true,
+			},
+			expectReady: true,
+		},
+		{
+			name: "iommu disabled",
+			host: fakeHost{
+				cmdline:    "BOOT_IMAGE=/vmlinuz root=/dev/sda1 intel_iommu=off",
+				vfioLoaded: true,
+			},
+			expectFailed: []v1beta1.PassthroughCheckName{v1beta1.CheckIOMMUGroups, v1beta1.CheckKernelParameters, v1beta1.CheckInterruptRemapping},
+			expectRemediation: map[v1beta1.PassthroughCheckName]string{
+				v1beta1.CheckIOMMUGroups:      "enable VT-d in the firmware settings of the node, and add intel_iommu=on iommu=pt to the kernel command line",
+				v1beta1.CheckKernelParameters: "remove intel_iommu=off from the kernel command line, and add intel_iommu=on iommu=pt",
+			},
+		},
+		{
+			name: "intel iommu not enabled",
+			host: fakeHost{
+				cmdline:    "BOOT_IMAGE=/vmlinuz root=/dev/sda1",
+				vfioLoaded: true,
+			},
+			expectFailed: []v1beta1.PassthroughCheckName{v1beta1.CheckIOMMUGroups, v1beta1.CheckKernelParameters, v1beta1.CheckInterruptRemapping},
+		},
+		{
+			name: "unsafe interrupts pass with a warning",
+			host: fakeHost{
+				iommuGroups:      1,
+				cmdline:          "intel_iommu=on",
+				unsafeInterrupts: true,
+				vfioLoaded:       true,
+			},
+			expectReady: true,
+			expectRemediation: map[v1beta1.PassthroughCheckName]string{
+				v1beta1.CheckKernelParameters: "add iommu=pt to the kernel command line, so devices used by the host bypass dma translation",
+			},
+		},
+		{
+			name: "interrupt remapping is not checked on arm64",
+			host: fakeHost{
+				arch:        "arm64",
+				iommuGroups: 1,
+				cmdline:     "iommu.passthrough=1",
+				vfioLoaded:  true,
+			},
+			expectReady: true,
+		},
+		{
+			name: "vfio not loaded",
+			host: fakeHost{
+				iommuGroups:        1,
+				cmdline:            "intel_iommu=on iommu=pt",
+				interruptRemapping: true,
+			},
+			expectFailed: []v1beta1.PassthroughCheckName{v1beta1.CheckVFIOModules},
+			expectRemediation: map[v1beta1.PassthroughCheckName]string{
+				v1beta1.CheckVFIOModules: "load the modules with modprobe vfio-pci vfio_iommu_type1, and check that the kernel of the node ships them",
+			},
+		},
+		{
+			name: "no-iommu mode",
+			host: fakeHost{
+				iommuGroups:        1,
+				cmdline:            "intel_iommu=on iommu=pt vfio.enable_unsafe_noiommu_mode=1",
+				interruptRemapping: true,
+				vfioLoaded:         true,
+				noIOMMUMode:        true,
+			},
+			expectFailed: []v1beta1.PassthroughCheckName{v1beta1.CheckNoIOMMUMode},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			status := v.host.create(t).CheckReadiness()
+			assert.Equal(v.expectReady, status.Ready)
+			assert.Equal(v.host.iommuGroups, status.IOMMUGroups)
+			assert.Len(status.Checks, 5)
+
+			var failed []v1beta1.PassthroughCheckName
+			for _, check := range status.Checks {
+				if !check.Passed {
+					failed = append(failed, check.Name)
+					assert.NotEmpty(check.Remediation, "expected failed check %s to have a remediation", check.Name)
+				}
+				if remediation, ok := v.expectRemediation[check.Name]; ok {
+					assert.Equal(remediation, check.Remediation)
+				}
+			}
+			assert.Equal(v.expectFailed, failed)
+		})
+	}
+}
+
+func Test_CheckReadinessKernelParameters(t *testing.T) {
+	assert := require.New(t)
+	host := fakeHost{cmdline: "BOOT_IMAGE=/vmlinuz root=/dev/sda1 quiet intel_iommu=on iommu=pt vfio-pci.ids=10de:1eb8"}.create(t)
+	status := host.CheckReadiness()
+	assert.Equal([]string{"intel_iommu=on", "iommu=pt", "vfio-pci.ids=10de:1eb8"}, status.KernelParameters)
+}
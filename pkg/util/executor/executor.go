@@ -0,0 +1,110 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Executor serializes host side device operations on a node. Every operation is
+// tagged with a set of keys, usually the PCI address of the device and its IOMMU
+// group. Two operations sharing any key never run concurrently, while operations
+// on unrelated devices are free to proceed in parallel.
+type Executor struct {
+	lock  sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is a reference counted mutex, which allows the executor to drop locks
+// for keys no longer in use
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New helps initialise a new Executor
+func New() *Executor {
+	return &Executor{
+		locks: make(map[string]*keyLock),
+	}
+}
+
+// DeviceKey is the key used to serialize operations on a single PCI device
+func DeviceKey(address string) string {
+	return fmt.Sprintf("device/%s", address)
+}
+
+// IOMMUGroupKey is the key used to serialize operations on all devices in an IOMMU group
+func IOMMUGroupKey(group string) string {
+	return fmt.Sprintf("iommugroup/%s", group)
+}
+
+// DeviceKeys returns the keys needed to operate on a device with address in the iommu group.
+// An empty group is ignored, as devices without IOMMU group only conflict with themselves
+func DeviceKeys(address string, iommuGroup string) []string {
+	keys := []string{DeviceKey(address)}
+	if iommuGroup != "" {
+		keys = append(keys, IOMMUGroupKey(iommuGroup))
+	}
+	return keys
+}
+
+// Run executes op once the locks for all keys have been acquired. Locks are always acquired in
+// the same order to ensure concurrent calls with overlapping keys can not deadlock
+func (e *Executor) Run(keys []string, op func() error) error {
+	keys = uniqueSorted(keys)
+	held := make([]*keyLock, 0, len(keys))
+	for _, key := range keys {
+		l := e.acquire(key)
+		l.Lock()
+		held = append(held, l)
+	}
+
+	defer func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+			e.release(keys[i])
+		}
+	}()
+
+	return op()
+}
+
+func (e *Executor) acquire(key string) *keyLock {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l, ok := e.locks[key]
+	if !ok {
+		l = &keyLock{}
+		e.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (e *Executor) release(key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l, ok := e.locks[key]
+	if !ok {
+		return
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(e.locks, key)
+	}
+}
+
+func uniqueSorted(keys []string) []string {
+	set := make(map[string]struct{}, len(keys))
+	result := make([]string, 0, len(keys))
+	for _, v := range keys {
+		if _, ok := set[v]; ok {
+			continue
+		}
+		set[v] = struct{}{}
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunSerializesSharedKeys(t *testing.T) {
+	assert := require.New(t)
+	e := New()
+	var inFlight, maxInFlight int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		// alternate between two devices in the same iommu group
+		addr := "0000:04:10.0"
+		if i%2 == 0 {
+			addr = "0000:04:10.1"
+		}
+		go func(addr string) {
+			defer wg.Done()
+			err := e.Run(DeviceKeys(addr, "89"), func() error {
+				current := atomic.AddInt32(&inFlight, 1)
+				for {
+					seen := atomic.LoadInt32(&maxInFlight)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+				return nil
+			})
+			assert.NoError(err)
+		}(addr)
+	}
+	wg.Wait()
+	assert.Equal(int32(1), maxInFlight, "expected operations in the same iommu group to be serialized")
+	assert.Len(e.locks, 0, "expected all locks to be released")
+}
+
+func Test_RunAllowsUnrelatedKeys(t *testing.T) {
+	assert := require.New(t)
+	e := New()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = e.Run(DeviceKeys("0000:04:10.0", "89"), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		_ = e.Run(DeviceKeys("0000:05:00.0", "90"), func() error {
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("expected operation on an unrelated device to not be blocked")
+	}
+	close(release)
+}
+
+func Test_DeviceKeysWithoutIOMMUGroup(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal([]string{"device/0000:04:10.0"}, DeviceKeys("0000:04:10.0", ""))
+}
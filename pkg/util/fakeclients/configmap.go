@@ -0,0 +1,40 @@
+package fakeclients
+
+import (
+	"context"
+
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1type "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type ConfigMapCache func(string) corev1type.ConfigMapInterface
+
+func (c ConfigMapCache) Get(namespace, name string) (*v1.ConfigMap, error) {
+	return c(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c ConfigMapCache) List(namespace string, selector labels.Selector) ([]*v1.ConfigMap, error) {
+	list, err := c(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*v1.ConfigMap, 0, len(list.Items))
+	for _, cm := range list.Items {
+		obj := cm
+		result = append(result, &obj)
+	}
+	return result, err
+}
+
+func (c ConfigMapCache) AddIndexer(indexName string, indexer ctlcorev1.ConfigMapIndexer) {
+	panic("implement me")
+}
+
+func (c ConfigMapCache) GetByIndex(indexName, key string) ([]*v1.ConfigMap, error) {
+	panic("implement me")
+}
@@ -0,0 +1,86 @@
+package fakeclients
+
+import (
+	"encoding/json"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// KubeVirtClient serves a single KubeVirt CR from memory, as the kubevirt client only comes with a mock
+type KubeVirtClient struct {
+	KubeVirt *kubevirtv1.KubeVirt
+	// Patches counts the patches applied to the KubeVirt CR
+	Patches int
+	// Conflicts is the number of patches which fail with a conflict, as if the KubeVirt CR was
+	// changed concurrently
+	Conflicts int
+}
+
+func (c *KubeVirtClient) Get(name string, _ *metav1.GetOptions) (*kubevirtv1.KubeVirt, error) {
+	if c.KubeVirt == nil || c.KubeVirt.Name != name {
+		return nil, apierrors.NewNotFound(kubevirtv1.Resource("kubevirts"), name)
+	}
+	return c.KubeVirt.DeepCopy(), nil
+}
+
+func (c *KubeVirtClient) List(_ *metav1.ListOptions) (*kubevirtv1.KubeVirtList, error) {
+	list := &kubevirtv1.KubeVirtList{}
+	if c.KubeVirt != nil {
+		list.Items = append(list.Items, *c.KubeVirt.DeepCopy())
+	}
+	return list, nil
+}
+
+// Patch applies merge patches, which fail with a conflict if they carry another resourceVersion
+func (c *KubeVirtClient) Patch(name string, pt types.PatchType, data []byte, _ *metav1.PatchOptions, _ ...string) (*kubevirtv1.KubeVirt, error) {
+	if c.KubeVirt == nil || c.KubeVirt.Name != name {
+		return nil, apierrors.NewNotFound(kubevirtv1.Resource("kubevirts"), name)
+	}
+	if pt != types.MergePatchType {
+		return nil, apierrors.NewBadRequest("only merge patches are supported")
+	}
+	if c.Conflicts > 0 {
+		c.Conflicts--
+		c.bumpResourceVersion()
+		return nil, apierrors.NewConflict(kubevirtv1.Resource("kubevirts"), name, nil)
+	}
+
+	var precondition struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &precondition); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if rv := precondition.Metadata.ResourceVersion; rv != "" && rv != c.KubeVirt.ResourceVersion {
+		return nil, apierrors.NewConflict(kubevirtv1.Resource("kubevirts"), name, nil)
+	}
+
+	original, err := json.Marshal(c.KubeVirt)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := jsonpatch.MergePatch(original, data)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	kv := &kubevirtv1.KubeVirt{}
+	if err := json.Unmarshal(patched, kv); err != nil {
+		return nil, err
+	}
+	c.KubeVirt = kv
+	c.bumpResourceVersion()
+	c.Patches++
+	return kv.DeepCopy(), nil
+}
+
+func (c *KubeVirtClient) bumpResourceVersion() {
+	rv, _ := strconv.Atoi(c.KubeVirt.ResourceVersion)
+	c.KubeVirt.ResourceVersion = strconv.Itoa(rv + 1)
+}
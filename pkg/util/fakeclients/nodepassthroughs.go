@@ -0,0 +1,74 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	pcidevicev1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+type NodePassthroughsClient func() v1beta1.NodePassthroughInterface
+
+func (n NodePassthroughsClient) Create(np *pcidevicev1beta1.NodePassthrough) (*pcidevicev1beta1.NodePassthrough, error) {
+	return n().Create(context.TODO(), np, metav1.CreateOptions{})
+}
+
+func (n NodePassthroughsClient) Update(np *pcidevicev1beta1.NodePassthrough) (*pcidevicev1beta1.NodePassthrough, error) {
+	return n().Update(context.TODO(), np, metav1.UpdateOptions{})
+}
+
+func (n NodePassthroughsClient) UpdateStatus(np *pcidevicev1beta1.NodePassthrough) (*pcidevicev1beta1.NodePassthrough, error) {
+	return n().Update(context.TODO(), np, metav1.UpdateOptions{})
+}
+
+func (n NodePassthroughsClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return n().Delete(context.TODO(), name, *options)
+}
+
+func (n NodePassthroughsClient) Get(name string, options metav1.GetOptions) (*pcidevicev1beta1.NodePassthrough, error) {
+	return n().Get(context.TODO(), name, options)
+}
+
+func (n NodePassthroughsClient) List(opts metav1.ListOptions) (*pcidevicev1beta1.NodePassthroughList, error) {
+	return n().List(context.TODO(), opts)
+}
+
+func (n NodePassthroughsClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (n NodePassthroughsClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *pcidevicev1beta1.NodePassthrough, err error) {
+	panic("implement me")
+}
+
+type NodePassthroughsCache func() v1beta1.NodePassthroughInterface
+
+func (n NodePassthroughsCache) Get(name string) (*pcidevicev1beta1.NodePassthrough, error) {
+	return n().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (n NodePassthroughsCache) List(selector labels.Selector) ([]*pcidevicev1beta1.NodePassthrough, error) {
+	list, err := n().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pcidevicev1beta1.NodePassthrough, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, nil
+}
+
+func (n NodePassthroughsCache) AddIndexer(indexName string, indexer pcidevicesv1beta1ctl.NodePassthroughIndexer) {
+	panic("implement me")
+}
+
+func (n NodePassthroughsCache) GetByIndex(indexName, key string) ([]*pcidevicev1beta1.NodePassthrough, error) {
+	panic("implement me")
+}
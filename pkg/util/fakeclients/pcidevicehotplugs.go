@@ -0,0 +1,77 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	pcidevicev1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+type PCIDeviceHotplugsClient func() v1beta1.PCIDeviceHotplugInterface
+
+func (p PCIDeviceHotplugsClient) Update(d *pcidevicev1beta1.PCIDeviceHotplug) (*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	return p().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+func (p PCIDeviceHotplugsClient) Get(name string, options metav1.GetOptions) (*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	return p().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (p PCIDeviceHotplugsClient) Create(d *pcidevicev1beta1.PCIDeviceHotplug) (*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	return p().Create(context.TODO(), d, metav1.CreateOptions{})
+}
+
+func (p PCIDeviceHotplugsClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return p().Delete(context.TODO(), name, *options)
+}
+
+func (p PCIDeviceHotplugsClient) List(opts metav1.ListOptions) (*pcidevicev1beta1.PCIDeviceHotplugList, error) {
+	return p().List(context.TODO(), opts)
+}
+
+func (p PCIDeviceHotplugsClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (p PCIDeviceHotplugsClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *pcidevicev1beta1.PCIDeviceHotplug, err error) {
+	panic("implement me")
+}
+
+func (p PCIDeviceHotplugsClient) UpdateStatus(d *pcidevicev1beta1.PCIDeviceHotplug) (*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	return p().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+type PCIDeviceHotplugsCache func() v1beta1.PCIDeviceHotplugInterface
+
+func (p PCIDeviceHotplugsCache) Get(name string) (*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	return p().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (p PCIDeviceHotplugsCache) List(selector labels.Selector) ([]*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	list, err := p().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pcidevicev1beta1.PCIDeviceHotplug, 0, len(list.Items))
+	for _, pdc := range list.Items {
+		obj := pdc
+		result = append(result, &obj)
+	}
+	return result, err
+}
+
+func (p PCIDeviceHotplugsCache) AddIndexer(indexName string, indexer pcidevicesv1beta1ctl.PCIDeviceHotplugIndexer) {
+	panic("implement me")
+}
+
+func (p PCIDeviceHotplugsCache) GetByIndex(indexName, key string) ([]*pcidevicev1beta1.PCIDeviceHotplug, error) {
+	panic("implement me")
+}
@@ -0,0 +1,42 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	pcidevicev1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+)
+
+type PCIDeviceOperationsClient func() v1beta1.PCIDeviceOperationInterface
+
+func (p PCIDeviceOperationsClient) Create(d *pcidevicev1beta1.PCIDeviceOperation) (*pcidevicev1beta1.PCIDeviceOperation, error) {
+	return p().Create(context.TODO(), d, metav1.CreateOptions{})
+}
+
+func (p PCIDeviceOperationsClient) Update(d *pcidevicev1beta1.PCIDeviceOperation) (*pcidevicev1beta1.PCIDeviceOperation, error) {
+	return p().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+func (p PCIDeviceOperationsClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return p().Delete(context.TODO(), name, *options)
+}
+
+func (p PCIDeviceOperationsClient) Get(name string, options metav1.GetOptions) (*pcidevicev1beta1.PCIDeviceOperation, error) {
+	return p().Get(context.TODO(), name, options)
+}
+
+func (p PCIDeviceOperationsClient) List(opts metav1.ListOptions) (*pcidevicev1beta1.PCIDeviceOperationList, error) {
+	return p().List(context.TODO(), opts)
+}
+
+func (p PCIDeviceOperationsClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (p PCIDeviceOperationsClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *pcidevicev1beta1.PCIDeviceOperation, err error) {
+	panic("implement me")
+}
@@ -0,0 +1,41 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	pcidevicev1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+type PCIDevicePoliciesCache func() v1beta1.PCIDevicePolicyInterface
+
+func (p PCIDevicePoliciesCache) Get(name string) (*pcidevicev1beta1.PCIDevicePolicy, error) {
+	return p().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (p PCIDevicePoliciesCache) List(selector labels.Selector) ([]*pcidevicev1beta1.PCIDevicePolicy, error) {
+	list, err := p().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pcidevicev1beta1.PCIDevicePolicy, 0, len(list.Items))
+	for _, policy := range list.Items {
+		obj := policy
+		result = append(result, &obj)
+	}
+	return result, err
+}
+
+func (p PCIDevicePoliciesCache) AddIndexer(indexName string, indexer pcidevicesv1beta1ctl.PCIDevicePolicyIndexer) {
+	panic("implement me")
+}
+
+func (p PCIDevicePoliciesCache) GetByIndex(indexName, key string) ([]*pcidevicev1beta1.PCIDevicePolicy, error) {
+	panic("implement me")
+}
@@ -15,7 +15,9 @@ import (
 )
 
 const (
-	IommuGroupByNode = "pcidevice.harvesterhci.io/iommu-by-node"
+	IommuGroupByNode        = "pcidevice.harvesterhci.io/iommu-by-node"
+	PCIDeviceByResourceName = "harvesterhcio.io/pcidevice-by-resource-name"
+	VMByName                = "harvesterhci.io/vm-by-name"
 )
 
 type PCIDevicesClient func() v1beta1.PCIDeviceInterface
@@ -59,7 +61,17 @@ func (p PCIDevicesCache) Get(name string) (*pcidevicev1beta1.PCIDevice, error) {
 }
 
 func (p PCIDevicesCache) List(selector labels.Selector) ([]*pcidevicev1beta1.PCIDevice, error) {
-	panic("implement me")
+	list, err := p().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pcidevicev1beta1.PCIDevice, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, err
 }
 
 func (p PCIDevicesCache) AddIndexer(indexName string, indexer pcidevicesv1beta1ctl.PCIDeviceIndexer) {
@@ -80,6 +92,18 @@ func (p PCIDevicesCache) GetByIndex(indexName, key string) ([]*pcidevicev1beta1.
 			}
 		}
 		return resp, err
+	case PCIDeviceByResourceName:
+		list, err := p().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var resp []*pcidevicev1beta1.PCIDevice
+		for i, v := range list.Items {
+			if key == v.Status.ResourceName {
+				resp = append(resp, &list.Items[i])
+			}
+		}
+		return resp, err
 	default:
 		return nil, nil
 	}
@@ -54,7 +54,18 @@ func (p PCIDeviceClaimsCache) Get(name string) (*pcidevicev1beta1.PCIDeviceClaim
 }
 
 func (p PCIDeviceClaimsCache) List(selector labels.Selector) ([]*pcidevicev1beta1.PCIDeviceClaim, error) {
-	panic("implement me")
+	list, err := p().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pcidevicev1beta1.PCIDeviceClaim, 0, len(list.Items))
+	for _, pdc := range list.Items {
+		obj := pdc
+		result = append(result, &obj)
+	}
+	return result, err
 }
 
 func (p PCIDeviceClaimsCache) AddIndexer(indexName string, indexer pcidevicesv1beta1ctl.PCIDeviceClaimIndexer) {
@@ -0,0 +1,46 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	pcidevicev1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+)
+
+type PCITopologiesClient func() v1beta1.PCITopologyInterface
+
+func (p PCITopologiesClient) Create(topology *pcidevicev1beta1.PCITopology) (*pcidevicev1beta1.PCITopology, error) {
+	return p().Create(context.TODO(), topology, metav1.CreateOptions{})
+}
+
+func (p PCITopologiesClient) Update(topology *pcidevicev1beta1.PCITopology) (*pcidevicev1beta1.PCITopology, error) {
+	return p().Update(context.TODO(), topology, metav1.UpdateOptions{})
+}
+
+func (p PCITopologiesClient) UpdateStatus(topology *pcidevicev1beta1.PCITopology) (*pcidevicev1beta1.PCITopology, error) {
+	return p().Update(context.TODO(), topology, metav1.UpdateOptions{})
+}
+
+func (p PCITopologiesClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return p().Delete(context.TODO(), name, *options)
+}
+
+func (p PCITopologiesClient) Get(name string, options metav1.GetOptions) (*pcidevicev1beta1.PCITopology, error) {
+	return p().Get(context.TODO(), name, options)
+}
+
+func (p PCITopologiesClient) List(opts metav1.ListOptions) (*pcidevicev1beta1.PCITopologyList, error) {
+	return p().List(context.TODO(), opts)
+}
+
+func (p PCITopologiesClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (p PCITopologiesClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *pcidevicev1beta1.PCITopology, err error) {
+	panic("implement me")
+}
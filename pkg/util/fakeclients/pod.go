@@ -0,0 +1,45 @@
+package fakeclients
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1type "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type PodClient func(string) corev1type.PodInterface
+
+func (c PodClient) Create(pod *v1.Pod) (*v1.Pod, error) {
+	return c(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+}
+
+func (c PodClient) Update(pod *v1.Pod) (*v1.Pod, error) {
+	return c(pod.Namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+}
+
+func (c PodClient) UpdateStatus(pod *v1.Pod) (*v1.Pod, error) {
+	return c(pod.Namespace).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+}
+
+func (c PodClient) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return c(namespace).Delete(context.TODO(), name, *options)
+}
+
+func (c PodClient) Get(namespace, name string, options metav1.GetOptions) (*v1.Pod, error) {
+	return c(namespace).Get(context.TODO(), name, options)
+}
+
+func (c PodClient) List(namespace string, opts metav1.ListOptions) (*v1.PodList, error) {
+	return c(namespace).List(context.TODO(), opts)
+}
+
+func (c PodClient) Watch(namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c PodClient) Patch(namespace, name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.Pod, err error) {
+	panic("implement me")
+}
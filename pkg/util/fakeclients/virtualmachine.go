@@ -0,0 +1,52 @@
+package fakeclients
+
+import (
+	"fmt"
+
+	ctlkubevirtv1 "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// VirtualMachineCache serves a fixed list of VMs, as the typed kubevirt clientset
+// pulls in the dependencies of all harvester APIs
+type VirtualMachineCache []*kubevirtv1.VirtualMachine
+
+func (c VirtualMachineCache) Get(namespace, name string) (*kubevirtv1.VirtualMachine, error) {
+	for _, vm := range c {
+		if vm.Namespace == namespace && vm.Name == name {
+			return vm, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(kubevirtv1.Resource("virtualmachines"), name)
+}
+
+func (c VirtualMachineCache) List(namespace string, selector labels.Selector) ([]*kubevirtv1.VirtualMachine, error) {
+	var result []*kubevirtv1.VirtualMachine
+	for _, vm := range c {
+		if (namespace == "" || vm.Namespace == namespace) && selector.Matches(labels.Set(vm.Labels)) {
+			result = append(result, vm)
+		}
+	}
+	return result, nil
+}
+
+func (c VirtualMachineCache) AddIndexer(indexName string, indexer ctlkubevirtv1.VirtualMachineIndexer) {
+	panic("implement me")
+}
+
+func (c VirtualMachineCache) GetByIndex(indexName, key string) ([]*kubevirtv1.VirtualMachine, error) {
+	switch indexName {
+	case VMByName:
+		var result []*kubevirtv1.VirtualMachine
+		for _, vm := range c {
+			if key == fmt.Sprintf("%s-%s", vm.Name, vm.Namespace) {
+				result = append(result, vm)
+			}
+		}
+		return result, nil
+	default:
+		panic("implement me")
+	}
+}
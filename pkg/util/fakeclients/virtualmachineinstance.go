@@ -0,0 +1,39 @@
+package fakeclients
+
+import (
+	ctlkubevirtv1 "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// VirtualMachineInstanceCache serves a fixed list of VMIs, as the typed kubevirt clientset
+// pulls in the dependencies of all harvester APIs
+type VirtualMachineInstanceCache []*kubevirtv1.VirtualMachineInstance
+
+func (c VirtualMachineInstanceCache) Get(namespace, name string) (*kubevirtv1.VirtualMachineInstance, error) {
+	for _, vmi := range c {
+		if vmi.Namespace == namespace && vmi.Name == name {
+			return vmi, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(kubevirtv1.Resource("virtualmachineinstances"), name)
+}
+
+func (c VirtualMachineInstanceCache) List(namespace string, selector labels.Selector) ([]*kubevirtv1.VirtualMachineInstance, error) {
+	var result []*kubevirtv1.VirtualMachineInstance
+	for _, vmi := range c {
+		if (namespace == "" || vmi.Namespace == namespace) && selector.Matches(labels.Set(vmi.Labels)) {
+			result = append(result, vmi)
+		}
+	}
+	return result, nil
+}
+
+func (c VirtualMachineInstanceCache) AddIndexer(indexName string, indexer ctlkubevirtv1.VirtualMachineInstanceIndexer) {
+	panic("implement me")
+}
+
+func (c VirtualMachineInstanceCache) GetByIndex(indexName, key string) ([]*kubevirtv1.VirtualMachineInstance, error) {
+	panic("implement me")
+}
@@ -0,0 +1,101 @@
+// Package hotplug attaches host devices to running VMIs and detaches them again, through the
+// addhostdevice and removehostdevice subresources of the KubeVirt API. KubeVirt releases which do not
+// serve these subresources do not support host device hotplug, which is reported by Supported
+package hotplug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+const (
+	subresourcesGroupVersion = "subresources.kubevirt.io/v1"
+	addHostDevice            = "addhostdevice"
+	removeHostDevice         = "removehostdevice"
+	vmiSubresourceURL        = "/apis/%s/namespaces/%s/virtualmachineinstances/%s/%s"
+)
+
+// Client attaches host devices to running VMIs
+type Client interface {
+	// Supported checks if KubeVirt supports host device hotplug
+	Supported() (bool, error)
+	// Attach attaches device to the running VMI name in namespace
+	Attach(namespace, name string, device kubevirtv1.HostDevice) error
+	// Detach detaches the host device deviceName from the running VMI name in namespace
+	Detach(namespace, name, deviceName string) error
+}
+
+// AddHostDeviceOptions is the body of the addhostdevice subresource
+type AddHostDeviceOptions struct {
+	Name       string `json:"name"`
+	DeviceName string `json:"deviceName"`
+}
+
+// RemoveHostDeviceOptions is the body of the removehostdevice subresource
+type RemoveHostDeviceOptions struct {
+	Name string `json:"name"`
+}
+
+type kubeVirtClient struct {
+	discovery discovery.DiscoveryInterface
+	rest      rest.Interface
+}
+
+// New returns a Client using the discovery client and the REST client of the KubeVirt API
+func New(discovery discovery.DiscoveryInterface, restClient rest.Interface) Client {
+	return &kubeVirtClient{
+		discovery: discovery,
+		rest:      restClient,
+	}
+}
+
+func (c *kubeVirtClient) Supported() (bool, error) {
+	resources, err := c.discovery.ServerResourcesForGroupVersion(subresourcesGroupVersion)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error discovering kubevirt subresources: %v", err)
+	}
+	var add, remove bool
+	for _, resource := range resources.APIResources {
+		switch resource.Name {
+		case "virtualmachineinstances/" + addHostDevice:
+			add = true
+		case "virtualmachineinstances/" + removeHostDevice:
+			remove = true
+		}
+	}
+	return add && remove, nil
+}
+
+func (c *kubeVirtClient) Attach(namespace, name string, device kubevirtv1.HostDevice) error {
+	return c.put(namespace, name, addHostDevice, AddHostDeviceOptions{
+		Name:       device.Name,
+		DeviceName: device.DeviceName,
+	})
+}
+
+func (c *kubeVirtClient) Detach(namespace, name, deviceName string) error {
+	return c.put(namespace, name, removeHostDevice, RemoveHostDeviceOptions{
+		Name: deviceName,
+	})
+}
+
+func (c *kubeVirtClient) put(namespace, name, subresource string, options interface{}) error {
+	body, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	uri := fmt.Sprintf(vmiSubresourceURL, subresourcesGroupVersion, namespace, name, subresource)
+	if err := c.rest.Put().AbsPath(uri).Body(body).Do(context.TODO()).Error(); err != nil {
+		return fmt.Errorf("error calling %s of vmi %s/%s: %v", subresource, namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package inuse
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	defaultSysfsRoot         = "/sys"
+	defaultHostProcRoot      = "/host/proc"
+	defaultHostNetworkNSPath = "/host/proc/1/ns/net"
+	iffUp                    = 0x1
+)
+
+// Error lists the reasons a device is in use by the host
+type Error struct {
+	Address string
+	Usages  []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("device %s is in use by the host: %s", e.Address, strings.Join(e.Usages, "; "))
+}
+
+// NetworkInfo reports if the host network interface name has addresses or routes configured
+type NetworkInfo func(name string) (addresses []string, routes []string, err error)
+
+// Checker identifies host usage of a PCI device, which would be disrupted by unbinding the device
+// from its host driver
+type Checker struct {
+	// SysfsRoot is the mount point of sysfs
+	SysfsRoot string
+	// ProcRoot is the procfs of the host pid namespace, as pcidevices runs in a container
+	ProcRoot string
+	// NetworkInfo queries addresses and routes of network interfaces
+	NetworkInfo NetworkInfo
+}
+
+// NewChecker helps initialise a Checker for the current host
+func NewChecker() *Checker {
+	return &Checker{
+		SysfsRoot:   defaultSysfsRoot,
+		ProcRoot:    defaultHostProcRoot,
+		NetworkInfo: hostNetworkInfo,
+	}
+}
+
+// Check runs all checks against the device at address, and returns an *Error if the host is using the device
+func (c *Checker) Check(address string) error {
+	var usages []string
+	checks := []func(string) ([]string, error){
+		c.bootVGA,
+		c.mountedBlockDevices,
+		c.activeNetworkInterfaces,
+		c.openDRMDevices,
+	}
+	for _, check := range checks {
+		result, err := check(address)
+		if err != nil {
+			return fmt.Errorf("error checking usage of device %s: %v", address, err)
+		}
+		usages = append(usages, result...)
+	}
+
+	if len(usages) == 0 {
+		return nil
+	}
+
+	return &Error{
+		Address: address,
+		Usages:  usages,
+	}
+}
+
+func (c *Checker) devicePath(address string) string {
+	return filepath.Join(c.SysfsRoot, "bus", "pci", "devices", address)
+}
+
+// bootVGA checks if the device is the VGA device used by the firmware and the host console
+func (c *Checker) bootVGA(address string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(c.devicePath(address), "boot_vga"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(content)) == "1" {
+		return []string{"device is the boot VGA device"}, nil
+	}
+	return nil, nil
+}
+
+// mountedBlockDevices checks block devices provided by NVMe/SATA/SCSI controllers for mounted filesystems,
+// active swap and holders such as LVM or device mapper
+func (c *Checker) mountedBlockDevices(address string) ([]string, error) {
+	blockDevices, err := c.classDevicesForAddress("block", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blockDevices) == 0 {
+		return nil, nil
+	}
+
+	mounts, err := c.hostMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	swaps, err := c.hostSwaps()
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []string
+	for _, name := range blockDevices {
+		classPath := filepath.Join(c.SysfsRoot, "class", "block", name)
+		devNumber, err := readTrimmed(filepath.Join(classPath, "dev"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if mountPoint, ok := mounts[devNumber]; ok && devNumber != "" {
+			usages = append(usages, fmt.Sprintf("block device %s is mounted at %s", name, mountPoint))
+		}
+
+		if swaps[name] {
+			usages = append(usages, fmt.Sprintf("block device %s is used as swap", name))
+		}
+
+		holders, err := os.ReadDir(filepath.Join(classPath, "holders"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, holder := range holders {
+			usages = append(usages, fmt.Sprintf("block device %s is held by %s", name, holder.Name()))
+		}
+	}
+	return usages, nil
+}
+
+// activeNetworkInterfaces checks network interfaces which are up, or have addresses or routes configured
+func (c *Checker) activeNetworkInterfaces(address string) ([]string, error) {
+	interfaces, err := c.classDevicesForAddress("net", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []string
+	for _, name := range interfaces {
+		flags, err := readTrimmed(filepath.Join(c.SysfsRoot, "class", "net", name, "flags"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if flagValue, err := strconv.ParseUint(strings.TrimPrefix(flags, "0x"), 16, 64); err == nil && flagValue&iffUp != 0 {
+			usages = append(usages, fmt.Sprintf("network interface %s is up", name))
+		}
+
+		if c.NetworkInfo == nil {
+			continue
+		}
+
+		addresses, routes, err := c.NetworkInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(addresses) > 0 {
+			usages = append(usages, fmt.Sprintf("network interface %s has addresses %s", name, strings.Join(addresses, ",")))
+		}
+		if len(routes) > 0 {
+			usages = append(usages, fmt.Sprintf("network interface %s has routes %s", name, strings.Join(routes, ",")))
+		}
+	}
+	return usages, nil
+}
+
+// openDRMDevices checks for host processes with open handles to DRM devices of a GPU
+func (c *Checker) openDRMDevices(address string) ([]string, error) {
+	drmDevices, err := os.ReadDir(filepath.Join(c.devicePath(address), "drm"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	devNodes := make(map[string]string)
+	for _, v := range drmDevices {
+		if strings.HasPrefix(v.Name(), "card") || strings.HasPrefix(v.Name(), "renderD") {
+			devNodes[filepath.Join("/dev/dri", v.Name())] = v.Name()
+		}
+	}
+
+	if len(devNodes) == 0 {
+		return nil, nil
+	}
+
+	processes, err := os.ReadDir(c.ProcRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []string
+	for _, process := range processes {
+		if _, err := strconv.Atoi(process.Name()); err != nil {
+			continue
+		}
+		// processes may exit or deny access while being inspected, both are not errors
+		fds, err := os.ReadDir(filepath.Join(c.ProcRoot, process.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(c.ProcRoot, process.Name(), "fd", fd.Name()))
+			if err != nil {
+				continue
+			}
+			if name, ok := devNodes[target]; ok {
+				usages = append(usages, fmt.Sprintf("drm device %s is open by %s", name, processName(c.ProcRoot, process.Name())))
+				break
+			}
+		}
+	}
+	return usages, nil
+}
+
+// classDevicesForAddress lists entries in /sys/class/<class> which are provided by the PCI device at address,
+// e.g. /sys/class/block/nvme0n1 -> ../../devices/pci0000:00/0000:00:1d.0/0000:3d:00.0/nvme/nvme0/nvme0n1
+func (c *Checker) classDevicesForAddress(class string, address string) ([]string, error) {
+	classPath := filepath.Join(c.SysfsRoot, "class", class)
+	entries, err := os.ReadDir(classPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var result []string
+	for _, v := range entries {
+		target, err := os.Readlink(filepath.Join(classPath, v.Name()))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(target+"/", "/"+address+"/") {
+			result = append(result, v.Name())
+		}
+	}
+	return result, nil
+}
+
+// hostMounts returns a map of major:minor device numbers to mount points from the host mount namespace
+func (c *Checker) hostMounts() (map[string]string, error) {
+	file, err := os.Open(filepath.Join(c.ProcRoot, "1", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if _, ok := mounts[fields[2]]; !ok {
+			mounts[fields[2]] = fields[4]
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// hostSwaps returns the names of block devices used as swap on the host
+func (c *Checker) hostSwaps() (map[string]bool, error) {
+	swaps := make(map[string]bool)
+	file, err := os.Open(filepath.Join(c.ProcRoot, "swaps"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return swaps, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Filename				Type		Size		Used		Priority
+		// /dev/sda2                               partition	8388604		0		-2
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		swaps[filepath.Base(fields[0])] = true
+	}
+	return swaps, scanner.Err()
+}
+
+func processName(procRoot string, pid string) string {
+	comm, err := readTrimmed(filepath.Join(procRoot, pid, "comm"))
+	if err != nil || comm == "" {
+		return fmt.Sprintf("pid %s", pid)
+	}
+	return fmt.Sprintf("%s (pid %s)", comm, pid)
+}
+
+func readTrimmed(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// hostNetworkInfo queries the host network namespace for addresses and routes on interface name.
+// Link local addresses and routes are ignored, as the kernel configures these for any link which is up
+func hostNetworkInfo(name string) ([]string, []string, error) {
+	hostProcessNS, err := netns.GetFromPath(defaultHostNetworkNSPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching host network namespace: %v", err)
+	}
+	defer hostProcessNS.Close()
+
+	handler, err := netlink.NewHandleAt(hostProcessNS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating handler for host network namespace: %v", err)
+	}
+	defer handler.Close()
+
+	link, err := handler.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error fetching link %s: %v", name, err)
+	}
+
+	addrList, err := handler.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing addresses for link %s: %v", name, err)
+	}
+
+	var addresses []string
+	for _, v := range addrList {
+		if v.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addresses = append(addresses, v.IPNet.String())
+	}
+
+	routeList, err := handler.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing routes for link %s: %v", name, err)
+	}
+
+	var routes []string
+	for _, v := range routeList {
+		if v.Dst != nil && isLinkLocalNetwork(v.Dst) {
+			continue
+		}
+		if v.Dst == nil {
+			routes = append(routes, "default")
+			continue
+		}
+		routes = append(routes, v.Dst.String())
+	}
+	return addresses, routes, nil
+}
+
+func isLinkLocalNetwork(n *net.IPNet) bool {
+	return n.IP.IsLinkLocalUnicast() || n.IP.IsLinkLocalMulticast() || n.IP.IsMulticast()
+}
@@ -0,0 +1,165 @@
+package inuse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	nvmeAddress = "0000:3d:00.0"
+	nicAddress  = "0000:04:00.0"
+	gpuAddress  = "0000:01:00.0"
+)
+
+// fakeHost builds sysfs and procfs trees with an nvme drive, a nic and a gpu
+type fakeHost struct {
+	t    *testing.T
+	root string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	f := &fakeHost{t: t, root: t.TempDir()}
+	for _, address := range []string{nvmeAddress, nicAddress, gpuAddress} {
+		f.mkdir("sys", "devices", "pci0000:00", address)
+		f.symlink(filepath.Join("..", "..", "..", "devices", "pci0000:00", address), "sys", "bus", "pci", "devices", address)
+	}
+	f.mkdir("sys", "class", "block")
+	f.mkdir("sys", "class", "net")
+	f.mkdir("proc", "1")
+	f.write("", "proc", "1", "mountinfo")
+	return f
+}
+
+func (f *fakeHost) path(elem ...string) string {
+	return filepath.Join(append([]string{f.root}, elem...)...)
+}
+
+func (f *fakeHost) mkdir(elem ...string) {
+	require.NoError(f.t, os.MkdirAll(f.path(elem...), 0755))
+}
+
+func (f *fakeHost) write(content string, elem ...string) {
+	f.mkdir(elem[:len(elem)-1]...)
+	require.NoError(f.t, os.WriteFile(f.path(elem...), []byte(content), 0644))
+}
+
+func (f *fakeHost) symlink(target string, elem ...string) {
+	f.mkdir(elem[:len(elem)-1]...)
+	require.NoError(f.t, os.Symlink(target, f.path(elem...)))
+}
+
+func (f *fakeHost) addBlockDevice(name string, dev string) {
+	f.mkdir("sys", "devices", "pci0000:00", nvmeAddress, "nvme", "nvme0", name, "holders")
+	f.write(dev, "sys", "devices", "pci0000:00", nvmeAddress, "nvme", "nvme0", name, "dev")
+	f.symlink(filepath.Join("..", "..", "devices", "pci0000:00", nvmeAddress, "nvme", "nvme0", name), "sys", "class", "block", name)
+}
+
+func (f *fakeHost) addInterface(name string, flags string) {
+	f.write(flags, "sys", "devices", "pci0000:00", nicAddress, "net", name, "flags")
+	f.symlink(filepath.Join("..", "..", "devices", "pci0000:00", nicAddress, "net", name), "sys", "class", "net", name)
+}
+
+func (f *fakeHost) checker(networkInfo NetworkInfo) *Checker {
+	return &Checker{
+		SysfsRoot:   f.path("sys"),
+		ProcRoot:    f.path("proc"),
+		NetworkInfo: networkInfo,
+	}
+}
+
+func noNetworkInfo(_ string) ([]string, []string, error) {
+	return nil, nil, nil
+}
+
+func checkUsages(t *testing.T, c *Checker, address string) []string {
+	err := c.Check(address)
+	if err == nil {
+		return nil
+	}
+	var inUseErr *Error
+	require.True(t, errors.As(err, &inUseErr), "expected an in use error, got %v", err)
+	return inUseErr.Usages
+}
+
+func Test_DeviceNotInUse(t *testing.T) {
+	f := newFakeHost(t)
+	f.addBlockDevice("nvme0n1", "259:0")
+	f.addInterface("eth1", "0x1002")
+	f.write("0", "sys", "devices", "pci0000:00", gpuAddress, "boot_vga")
+	c := f.checker(noNetworkInfo)
+	for _, address := range []string{nvmeAddress, nicAddress, gpuAddress} {
+		require.NoError(t, c.Check(address), "expected device %s to not be in use", address)
+	}
+}
+
+func Test_MountedBlockDevice(t *testing.T) {
+	assert := require.New(t)
+	f := newFakeHost(t)
+	f.addBlockDevice("nvme0n1", "259:0")
+	f.addBlockDevice("nvme0n1p1", "259:1")
+	f.addBlockDevice("nvme0n1p2", "259:2")
+	f.write("36 35 259:1 / /var/lib/longhorn rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw\n", "proc", "1", "mountinfo")
+	f.write("Filename\tType\tSize\tUsed\tPriority\n/dev/nvme0n1p2 partition\t8388604\t0\t-2\n", "proc", "swaps")
+	f.mkdir("sys", "devices", "pci0000:00", nvmeAddress, "nvme", "nvme0", "nvme0n1", "holders", "dm-0")
+
+	usages := checkUsages(t, f.checker(noNetworkInfo), nvmeAddress)
+	assert.ElementsMatch([]string{
+		"block device nvme0n1 is held by dm-0",
+		"block device nvme0n1p1 is mounted at /var/lib/longhorn",
+		"block device nvme0n1p2 is used as swap",
+	}, usages)
+	assert.Empty(checkUsages(t, f.checker(noNetworkInfo), nicAddress), "expected nic to not be affected by nvme mounts")
+}
+
+func Test_ActiveNetworkInterface(t *testing.T) {
+	assert := require.New(t)
+	f := newFakeHost(t)
+	f.addInterface("eth1", "0x1003")
+	f.addInterface("eth2", "0x1002")
+	networkInfo := func(name string) ([]string, []string, error) {
+		if name == "eth2" {
+			return []string{"192.168.1.10/24"}, []string{"192.168.1.0/24"}, nil
+		}
+		return nil, nil, nil
+	}
+
+	usages := checkUsages(t, f.checker(networkInfo), nicAddress)
+	assert.ElementsMatch([]string{
+		"network interface eth1 is up",
+		"network interface eth2 has addresses 192.168.1.10/24",
+		"network interface eth2 has routes 192.168.1.0/24",
+	}, usages)
+
+	failing := func(_ string) ([]string, []string, error) {
+		return nil, nil, errors.New("netlink failure")
+	}
+	err := f.checker(failing).Check(nicAddress)
+	var inUseErr *Error
+	assert.Error(err, "expected netlink failure to be returned")
+	assert.False(errors.As(err, &inUseErr), "expected netlink failure to not be reported as usage")
+}
+
+func Test_OpenDRMDevice(t *testing.T) {
+	assert := require.New(t)
+	f := newFakeHost(t)
+	f.mkdir("sys", "devices", "pci0000:00", gpuAddress, "drm", "card0")
+	f.mkdir("sys", "devices", "pci0000:00", gpuAddress, "drm", "renderD128")
+	f.write("Xorg\n", "proc", "1234", "comm")
+	f.symlink("/dev/null", "proc", "1234", "fd", "0")
+	f.symlink("/dev/dri/card0", "proc", "1234", "fd", "5")
+	f.symlink("/dev/dri/card1", "proc", "4321", "fd", "5")
+
+	usages := checkUsages(t, f.checker(noNetworkInfo), gpuAddress)
+	assert.Equal([]string{"drm device card0 is open by Xorg (pid 1234)"}, usages)
+}
+
+func Test_BootVGA(t *testing.T) {
+	f := newFakeHost(t)
+	f.write("1\n", "sys", "devices", "pci0000:00", gpuAddress, "boot_vga")
+	usages := checkUsages(t, f.checker(noNetworkInfo), gpuAddress)
+	require.Equal(t, []string{"device is the boot VGA device"}, usages)
+}
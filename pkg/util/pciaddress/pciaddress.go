@@ -0,0 +1,65 @@
+// Package pciaddress parses PCI addresses in the domain:bus:device.function notation used by sysfs.
+// Servers with several PCI segments, or devices behind Intel VMD, use domains other than 0000, and VMD
+// domains are wider than 4 hex digits, e.g. 10000:e1:00.0
+package pciaddress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Address is a parsed PCI address
+type Address struct {
+	Domain   uint32
+	Bus      uint8
+	Device   uint8
+	Function uint8
+}
+
+// Parse parses a PCI address. The domain is optional and defaults to 0000, as in the notation of lspci
+func Parse(address string) (Address, error) {
+	var a Address
+	parts := strings.Split(address, ":")
+	switch len(parts) {
+	case 2:
+	case 3:
+		domain, err := strconv.ParseUint(parts[0], 16, 32)
+		if err != nil || len(parts[0]) < 4 {
+			return a, fmt.Errorf("invalid domain in pci address %q", address)
+		}
+		a.Domain = uint32(domain)
+		parts = parts[1:]
+	default:
+		return a, fmt.Errorf("invalid pci address %q", address)
+	}
+
+	bus, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || len(parts[0]) != 2 {
+		return a, fmt.Errorf("invalid bus in pci address %q", address)
+	}
+	deviceFunction := strings.Split(parts[1], ".")
+	if len(deviceFunction) != 2 {
+		return a, fmt.Errorf("invalid pci address %q", address)
+	}
+	device, err := strconv.ParseUint(deviceFunction[0], 16, 8)
+	if err != nil || len(deviceFunction[0]) != 2 || device > 0x1f {
+		return a, fmt.Errorf("invalid device in pci address %q", address)
+	}
+	function, err := strconv.ParseUint(deviceFunction[1], 16, 8)
+	if err != nil || len(deviceFunction[1]) != 1 || function > 7 {
+		return a, fmt.Errorf("invalid function in pci address %q", address)
+	}
+	a.Bus, a.Device, a.Function = uint8(bus), uint8(device), uint8(function)
+	return a, nil
+}
+
+// String returns the address in the notation of sysfs, e.g. 0000:04:00.0
+func (a Address) String() string {
+	return fmt.Sprintf("%s:%02x:%02x.%x", a.DomainString(), a.Bus, a.Device, a.Function)
+}
+
+// DomainString returns the domain padded to 4 hex digits, e.g. 0000
+func (a Address) DomainString() string {
+	return fmt.Sprintf("%04x", a.Domain)
+}
@@ -0,0 +1,43 @@
+package pciaddress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Parse(t *testing.T) {
+	var testCases = []struct {
+		address     string
+		expected    Address
+		expectedStr string
+		expectErr   bool
+	}{
+		{address: "0000:04:00.0", expected: Address{Bus: 0x04}, expectedStr: "0000:04:00.0"},
+		{address: "0000:3e:1f.7", expected: Address{Bus: 0x3e, Device: 0x1f, Function: 7}, expectedStr: "0000:3e:1f.7"},
+		{address: "0001:81:00.1", expected: Address{Domain: 1, Bus: 0x81, Function: 1}, expectedStr: "0001:81:00.1"},
+		{address: "10000:e1:00.0", expected: Address{Domain: 0x10000, Bus: 0xe1}, expectedStr: "10000:e1:00.0"},
+		{address: "00:1f.6", expected: Address{Device: 0x1f, Function: 6}, expectedStr: "0000:00:1f.6"},
+		{address: "0000:04:00", expectErr: true},
+		{address: "000:04:00.0", expectErr: true},
+		{address: "0000:4:00.0", expectErr: true},
+		{address: "0000:04:20.0", expectErr: true},
+		{address: "0000:04:00.8", expectErr: true},
+		{address: "0000:0g:00.0", expectErr: true},
+		{address: "", expectErr: true},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.address, func(t *testing.T) {
+			assert := require.New(t)
+			a, err := Parse(v.address)
+			if v.expectErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(v.expected, a)
+			assert.Equal(v.expectedStr, a.String())
+		})
+	}
+}
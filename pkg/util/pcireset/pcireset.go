@@ -0,0 +1,108 @@
+package pcireset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultSysfsRoot = "/sys"
+
+	// MethodNone is reported for devices which the kernel cannot reset
+	MethodNone = "none"
+	// MethodDefault is reported when the kernel does not expose reset_method, and picks
+	// the reset method itself
+	MethodDefault = "default"
+)
+
+// ErrResetNotSupported is returned for devices without a reset file, as the kernel found no usable reset method
+var ErrResetNotSupported = errors.New("device does not support reset")
+
+// Resetter performs function level or bus resets of PCI devices through sysfs
+type Resetter struct {
+	// SysfsRoot is the mount point of sysfs
+	SysfsRoot string
+}
+
+// New helps initialise a Resetter for the current host
+func New() *Resetter {
+	return &Resetter{
+		SysfsRoot: defaultSysfsRoot,
+	}
+}
+
+// Reset resets the device at address and returns the reset method which succeeded.
+// The methods enabled in reset_method, e.g. "flr bus", are attempted one at a time so the
+// method used can be reported. reset_method is restored once done
+func (r *Resetter) Reset(address string) (string, error) {
+	devicePath := filepath.Join(r.SysfsRoot, "bus", "pci", "devices", address)
+	resetPath := filepath.Join(devicePath, "reset")
+	if _, err := os.Stat(resetPath); err != nil {
+		if os.IsNotExist(err) {
+			return MethodNone, fmt.Errorf("error resetting device %s: %w", address, ErrResetNotSupported)
+		}
+		return "", err
+	}
+
+	methodPath := filepath.Join(devicePath, "reset_method")
+	content, err := os.ReadFile(methodPath)
+	if err != nil {
+		// reset_method is only available on kernels 5.15 and later
+		if os.IsNotExist(err) {
+			if err := writeFile(resetPath, "1"); err != nil {
+				return "", fmt.Errorf("error resetting device %s: %v", address, err)
+			}
+			return MethodDefault, nil
+		}
+		return "", err
+	}
+
+	enabled := strings.TrimSpace(string(content))
+	methods := strings.Fields(enabled)
+	if len(methods) == 0 {
+		return MethodNone, fmt.Errorf("error resetting device %s: %w", address, ErrResetNotSupported)
+	}
+
+	method, resetErr := resetWithMethods(methodPath, resetPath, methods)
+	if len(methods) > 1 {
+		if err := writeFile(methodPath, enabled); err != nil {
+			return method, fmt.Errorf("error restoring reset methods %s for device %s: %v", enabled, address, err)
+		}
+	}
+
+	if resetErr != nil {
+		return "", fmt.Errorf("error resetting device %s: %v", address, resetErr)
+	}
+	return method, nil
+}
+
+func resetWithMethods(methodPath, resetPath string, methods []string) (string, error) {
+	var errs []string
+	for _, method := range methods {
+		if len(methods) > 1 {
+			if err := writeFile(methodPath, method); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", method, err))
+				continue
+			}
+		}
+		if err := writeFile(resetPath, "1"); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", method, err))
+			continue
+		}
+		return method, nil
+	}
+	return "", fmt.Errorf("all reset methods failed: %s", strings.Join(errs, ", "))
+}
+
+func writeFile(path string, value string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0200)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(value)
+	return err
+}
@@ -0,0 +1,84 @@
+package pcireset
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const address = "0000:01:00.0"
+
+func newDevice(t *testing.T, files map[string]string) *Resetter {
+	root := t.TempDir()
+	devicePath := filepath.Join(root, "bus", "pci", "devices", address)
+	require.NoError(t, os.MkdirAll(devicePath, 0755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(devicePath, name), []byte(content), 0644))
+	}
+	return &Resetter{SysfsRoot: root}
+}
+
+func readFile(t *testing.T, r *Resetter, name string) string {
+	content, err := os.ReadFile(filepath.Join(r.SysfsRoot, "bus", "pci", "devices", address, name))
+	require.NoError(t, err)
+	return string(content)
+}
+
+func Test_ResetWithFirstMethod(t *testing.T) {
+	assert := require.New(t)
+	r := newDevice(t, map[string]string{
+		"reset":        "",
+		"reset_method": "flr bus\n",
+	})
+	method, err := r.Reset(address)
+	assert.NoError(err)
+	assert.Equal("flr", method)
+	assert.Equal("1", readFile(t, r, "reset"), "expected reset to be triggered")
+	assert.Equal("flr bus", readFile(t, r, "reset_method"), "expected reset methods to be restored")
+}
+
+func Test_ResetWithSingleMethod(t *testing.T) {
+	assert := require.New(t)
+	r := newDevice(t, map[string]string{
+		"reset":        "",
+		"reset_method": "bus\n",
+	})
+	method, err := r.Reset(address)
+	assert.NoError(err)
+	assert.Equal("bus", method)
+	assert.Equal("bus\n", readFile(t, r, "reset_method"), "expected reset methods to not be modified")
+}
+
+func Test_ResetWithoutResetMethod(t *testing.T) {
+	assert := require.New(t)
+	r := newDevice(t, map[string]string{
+		"reset": "",
+	})
+	method, err := r.Reset(address)
+	assert.NoError(err)
+	assert.Equal(MethodDefault, method)
+	assert.Equal("1", readFile(t, r, "reset"))
+}
+
+func Test_ResetNotSupported(t *testing.T) {
+	assert := require.New(t)
+	r := newDevice(t, nil)
+	method, err := r.Reset(address)
+	assert.True(errors.Is(err, ErrResetNotSupported), "expected reset to not be supported")
+	assert.Equal(MethodNone, method)
+}
+
+func Test_ResetFailure(t *testing.T) {
+	assert := require.New(t)
+	r := newDevice(t, map[string]string{
+		"reset_method": "flr",
+	})
+	// a directory in place of the reset file makes every write fail
+	assert.NoError(os.Mkdir(filepath.Join(r.SysfsRoot, "bus", "pci", "devices", address, "reset"), 0755))
+	_, err := r.Reset(address)
+	assert.Error(err)
+	assert.False(errors.Is(err, ErrResetNotSupported), "expected failure to not be reported as unsupported")
+}
@@ -0,0 +1,267 @@
+// Package pcitopology reads the PCIe tree of a node from sysfs. The tree is taken from the sysfs paths of
+// the devices, e.g. /sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0 is an endpoint behind the root port
+// 0000:00:01.0 of the root bus pci0000:00, and the type and ACS capability of each device are parsed
+// from its PCI configuration space
+package pcitopology
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+const (
+	defaultSysfsRoot = "/sys"
+
+	pciBridgeClassID = "0604"
+
+	// offsets and ids of the PCI configuration space
+	pciStatus                = 0x06
+	pciStatusCapabilityList  = 0x10
+	pciCapabilityPointer     = 0x34
+	pciCapabilityIDExpress   = 0x10
+	pciExpressFlags          = 0x02
+	pciExtendedCapabilities  = 0x100
+	pciExtendedCapabilityACS = 0x0d
+	pciACSCapability         = 0x04
+	pciACSControl            = 0x06
+)
+
+// acsControls are the ACS controls by bit in the ACS capability and control registers
+var acsControls = []string{
+	"SourceValidation",
+	"TranslationBlocking",
+	"RequestRedirect",
+	"CompletionRedirect",
+	"UpstreamForwarding",
+	"EgressControl",
+	"DirectTranslatedP2P",
+}
+
+// acsIsolation are the ACS controls the kernel requires to be enabled on a port to place the devices
+// below it into separate IOMMU groups: source validation, request and completion redirect, and upstream
+// forwarding. Controls the port does not support are not required
+const acsIsolation = 0x1 | 0x4 | 0x8 | 0x10
+
+// portTypes are the device/port types of the PCI Express capability
+var portTypes = map[uint16]v1beta1.PCIPortType{
+	0x0: v1beta1.PCIPortTypeEndpoint,
+	0x1: v1beta1.PCIPortTypeLegacyEndpoint,
+	0x4: v1beta1.PCIPortTypeRootPort,
+	0x5: v1beta1.PCIPortTypeSwitchUpstreamPort,
+	0x6: v1beta1.PCIPortTypeSwitchDownstreamPort,
+	0x7: v1beta1.PCIPortTypePCIeToPCIBridge,
+	0x8: v1beta1.PCIPortTypePCIToPCIeBridge,
+	0x9: v1beta1.PCIPortTypeRootComplexIntegratedEndpoint,
+	0xa: v1beta1.PCIPortTypeRootComplexEventCollector,
+}
+
+// Reader reads the PCIe tree of the node from sysfs
+type Reader struct {
+	// SysfsRoot is the mount point of sysfs
+	SysfsRoot string
+}
+
+// New helps initialise a Reader for the current host
+func New() *Reader {
+	return &Reader{
+		SysfsRoot: defaultSysfsRoot,
+	}
+}
+
+// Read returns the PCI devices of the node ordered as in the PCIe tree, and the IOMMU groups of the node
+func (r *Reader) Read() ([]v1beta1.PCITopologyDevice, []v1beta1.PCITopologyIOMMUGroup, error) {
+	devicesPath := filepath.Join(r.SysfsRoot, "bus", "pci", "devices")
+	entries, err := os.ReadDir(devicesPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing pci devices in %s: %v", devicesPath, err)
+	}
+
+	var devices []v1beta1.PCITopologyDevice
+	paths := make(map[string][]string)
+	for _, entry := range entries {
+		address := entry.Name()
+		target, err := filepath.EvalSymlinks(filepath.Join(devicesPath, address))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error resolving sysfs path of %s: %v", address, err)
+		}
+		dev := r.readDevice(address, target)
+		devices = append(devices, dev)
+		paths[address] = treePath(target)
+	}
+
+	// devices are ordered depth first, so devices follow the port or bridge they are behind
+	sort.Slice(devices, func(i, j int) bool {
+		return lessPath(paths[devices[i].Address], paths[devices[j].Address])
+	})
+	return devices, iommuGroups(devices), nil
+}
+
+func (r *Reader) readDevice(address, devicePath string) v1beta1.PCITopologyDevice {
+	dev := v1beta1.PCITopologyDevice{
+		Address:  address,
+		VendorID: strings.TrimPrefix(readTrimmed(filepath.Join(devicePath, "vendor")), "0x"),
+		DeviceID: strings.TrimPrefix(readTrimmed(filepath.Join(devicePath, "device")), "0x"),
+		NUMANode: -1,
+	}
+	// the class is e.g. 0x060400, of which the PCIDevices report the class and subclass
+	if class := strings.TrimPrefix(readTrimmed(filepath.Join(devicePath, "class")), "0x"); len(class) >= 4 {
+		dev.ClassID = class[:4]
+	}
+	if numaNode, err := strconv.Atoi(readTrimmed(filepath.Join(devicePath, "numa_node"))); err == nil {
+		dev.NUMANode = numaNode
+	}
+	if target, err := os.Readlink(filepath.Join(devicePath, "driver")); err == nil {
+		dev.Driver = filepath.Base(target)
+	}
+	if target, err := os.Readlink(filepath.Join(devicePath, "iommu_group")); err == nil {
+		dev.IOMMUGroup = filepath.Base(target)
+	}
+
+	// the parent is the closest port or bridge, and the root bus the closest root bus, which differ for
+	// devices behind Intel VMD, whose root bus is below the VMD controller
+	path := treePath(devicePath)
+	for i := len(path) - 2; i >= 0; i-- {
+		if strings.HasPrefix(path[i], "pci") {
+			if dev.RootBus == "" {
+				dev.RootBus = path[i]
+			}
+			continue
+		}
+		if dev.Parent == "" {
+			dev.Parent = path[i]
+		}
+	}
+
+	config, _ := os.ReadFile(filepath.Join(devicePath, "config"))
+	dev.Type = portType(config, dev.ClassID)
+	dev.ACS = acs(config)
+	return dev
+}
+
+// treePath returns the root buses and devices in the sysfs path of a device, from the root bus down to the
+// device, e.g. [pci0000:00 0000:00:01.0 0000:01:00.0]
+func treePath(devicePath string) []string {
+	var path []string
+	for _, element := range strings.Split(devicePath, string(filepath.Separator)) {
+		if strings.HasPrefix(element, "pci") || strings.Count(element, ":") == 2 {
+			path = append(path, element)
+		}
+	}
+	return path
+}
+
+func lessPath(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// portType returns the device/port type of the PCI Express capability, or whether the device is a
+// conventional PCI bridge or device if it has no PCI Express capability
+func portType(config []byte, classID string) v1beta1.PCIPortType {
+	if offset := findCapability(config, pciCapabilityIDExpress); offset > 0 && offset+pciExpressFlags+2 <= len(config) {
+		flags := binary.LittleEndian.Uint16(config[offset+pciExpressFlags:])
+		if t, ok := portTypes[(flags>>4)&0xf]; ok {
+			return t
+		}
+	}
+	if classID == pciBridgeClassID {
+		return v1beta1.PCIPortTypePCIBridge
+	}
+	return v1beta1.PCIPortTypePCIDevice
+}
+
+// findCapability returns the offset of a capability in the configuration space, or 0 if not found
+func findCapability(config []byte, id byte) int {
+	if len(config) <= pciCapabilityPointer || config[pciStatus]&pciStatusCapabilityList == 0 {
+		return 0
+	}
+	offset := int(config[pciCapabilityPointer] &^ 0x3)
+	// the list is bounded, as a malformed list may loop
+	for i := 0; i < 48 && offset >= 0x40 && offset+1 < len(config); i++ {
+		if config[offset] == id {
+			return offset
+		}
+		offset = int(config[offset+1] &^ 0x3)
+	}
+	return 0
+}
+
+// acs returns the ACS capability of the device, or nil if the device has none. The extended configuration
+// space holding the ACS capability is only readable with CAP_SYS_ADMIN
+func acs(config []byte) *v1beta1.PCIACS {
+	offset := pciExtendedCapabilities
+	for i := 0; i < (4096-pciExtendedCapabilities)/4 && offset+4 <= len(config); i++ {
+		header := binary.LittleEndian.Uint32(config[offset:])
+		if header == 0 || header == 0xffffffff {
+			return nil
+		}
+		if header&0xffff == pciExtendedCapabilityACS && offset+pciACSControl+2 <= len(config) {
+			capability := binary.LittleEndian.Uint16(config[offset+pciACSCapability:])
+			control := binary.LittleEndian.Uint16(config[offset+pciACSControl:])
+			required := acsIsolation & capability
+			return &v1beta1.PCIACS{
+				Capabilities: acsControlNames(capability),
+				Enabled:      acsControlNames(control & capability),
+				Isolated:     control&required == required,
+			}
+		}
+		next := int(header>>20) &^ 0x3
+		if next < pciExtendedCapabilities {
+			return nil
+		}
+		offset = next
+	}
+	return nil
+}
+
+func acsControlNames(bits uint16) []string {
+	var names []string
+	for i, name := range acsControls {
+		if bits&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// iommuGroups returns the IOMMU groups of devices, ordered by group number
+func iommuGroups(devices []v1beta1.PCITopologyDevice) []v1beta1.PCITopologyIOMMUGroup {
+	members := make(map[string][]string)
+	for _, dev := range devices {
+		if dev.IOMMUGroup != "" {
+			members[dev.IOMMUGroup] = append(members[dev.IOMMUGroup], dev.Address)
+		}
+	}
+	groups := make([]v1beta1.PCITopologyIOMMUGroup, 0, len(members))
+	for group, addresses := range members {
+		groups = append(groups, v1beta1.PCITopologyIOMMUGroup{Group: group, Devices: addresses})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, errA := strconv.Atoi(groups[i].Group)
+		b, errB := strconv.Atoi(groups[j].Group)
+		if errA != nil || errB != nil {
+			return groups[i].Group < groups[j].Group
+		}
+		return a < b
+	})
+	return groups
+}
+
+func readTrimmed(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
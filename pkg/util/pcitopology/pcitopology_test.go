@@ -0,0 +1,202 @@
+package pcitopology
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+type fakeDevice struct {
+	// path is the sysfs path of the device below /sys/devices
+	path       string
+	class      string
+	iommuGroup string
+	numaNode   string
+	driver     string
+	config     []byte
+}
+
+// pcieConfig returns a configuration space with a PCI Express capability of portType, and optionally an ACS
+// capability in the extended configuration space
+func pcieConfig(portType uint16, acs bool, acsCapability, acsControl uint16) []byte {
+	config := make([]byte, 4096)
+	config[pciStatus] = pciStatusCapabilityList
+	config[pciCapabilityPointer] = 0x40
+	// a power management capability is followed by the PCI Express capability
+	config[0x40], config[0x41] = 0x01, 0x50
+	config[0x50] = pciCapabilityIDExpress
+	binary.LittleEndian.PutUint16(config[0x52:], portType<<4|0x2)
+	if acs {
+		// an AER capability is followed by the ACS capability
+		binary.LittleEndian.PutUint32(config[0x100:], 0x0001|1<<16|0x148<<20)
+		binary.LittleEndian.PutUint32(config[0x148:], pciExtendedCapabilityACS|1<<16)
+		binary.LittleEndian.PutUint16(config[0x148+pciACSCapability:], acsCapability)
+		binary.LittleEndian.PutUint16(config[0x148+pciACSControl:], acsControl)
+	}
+	return config
+}
+
+func newSysfs(t *testing.T, devices []fakeDevice) *Reader {
+	assert := require.New(t)
+	root := t.TempDir()
+	assert.NoError(os.MkdirAll(filepath.Join(root, "bus", "pci", "devices"), 0755))
+	for _, dev := range devices {
+		devicePath := filepath.Join(root, "devices", dev.path)
+		address := filepath.Base(devicePath)
+		assert.NoError(os.MkdirAll(devicePath, 0755))
+		files := map[string]string{
+			"vendor":    "0x8086\n",
+			"device":    "0x1901\n",
+			"class":     dev.class + "\n",
+			"numa_node": dev.numaNode + "\n",
+		}
+		for name, content := range files {
+			assert.NoError(os.WriteFile(filepath.Join(devicePath, name), []byte(content), 0644))
+		}
+		if dev.config != nil {
+			assert.NoError(os.WriteFile(filepath.Join(devicePath, "config"), dev.config, 0644))
+		}
+		if dev.iommuGroup != "" {
+			assert.NoError(os.MkdirAll(filepath.Join(root, "kernel", "iommu_groups", dev.iommuGroup), 0755))
+			target, err := filepath.Rel(devicePath, filepath.Join(root, "kernel", "iommu_groups", dev.iommuGroup))
+			assert.NoError(err)
+			assert.NoError(os.Symlink(target, filepath.Join(devicePath, "iommu_group")))
+		}
+		if dev.driver != "" {
+			assert.NoError(os.Symlink(filepath.Join("..", "..", "bus", "pci", "drivers", dev.driver), filepath.Join(devicePath, "driver")))
+		}
+		assert.NoError(os.Symlink(filepath.Join("..", "..", "..", "devices", dev.path), filepath.Join(root, "bus", "pci", "devices", address)))
+	}
+	return &Reader{SysfsRoot: root}
+}
+
+// topology is a root port isolating a PCIe switch, whose downstream port does not isolate the two functions of
+// a GPU, an NVMe behind Intel VMD, and a root port of a second PCI segment
+var topology = []fakeDevice{
+	{path: "pci0000:00/0000:00:00.0", class: "0x060000", iommuGroup: "0", numaNode: "0"},
+	{path: "pci0000:00/0000:00:01.0", class: "0x060400", iommuGroup: "1", numaNode: "0", driver: "pcieport", config: pcieConfig(0x4, true, 0x1f, 0x1d)},
+	{path: "pci0000:00/0000:00:01.0/0000:01:00.0", class: "0x060400", iommuGroup: "2", numaNode: "0", driver: "pcieport", config: pcieConfig(0x5, false, 0, 0)},
+	{path: "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:08.0", class: "0x060400", iommuGroup: "3", numaNode: "0", driver: "pcieport", config: pcieConfig(0x6, true, 0x1f, 0)},
+	{path: "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:08.0/0000:03:00.0", class: "0x030200", iommuGroup: "3", numaNode: "0", driver: "vfio-pci", config: pcieConfig(0x0, false, 0, 0)},
+	{path: "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:08.0/0000:03:00.1", class: "0x040300", iommuGroup: "3", numaNode: "0", config: pcieConfig(0x0, false, 0, 0)},
+	{path: "pci0000:00/0000:00:0e.0", class: "0x010400", iommuGroup: "4", numaNode: "0", driver: "vmd", config: pcieConfig(0x9, false, 0, 0)},
+	{path: "pci0000:00/0000:00:0e.0/pci10000:00/10000:00:02.0", class: "0x060400", numaNode: "0", driver: "pcieport", config: pcieConfig(0x4, false, 0, 0)},
+	{path: "pci0000:00/0000:00:0e.0/pci10000:00/10000:00:02.0/10000:01:00.0", class: "0x010802", numaNode: "0", driver: "nvme", config: pcieConfig(0x0, false, 0, 0)},
+	{path: "pci0001:00/0001:00:01.0", class: "0x060400", iommuGroup: "30", numaNode: "1", driver: "pcieport", config: pcieConfig(0x4, true, 0x2d, 0x1d)},
+}
+
+func Test_Read(t *testing.T) {
+	assert := require.New(t)
+	devices, groups, err := newSysfs(t, topology).Read()
+	assert.NoError(err)
+
+	addresses := make([]string, 0, len(devices))
+	byAddress := make(map[string]v1beta1.PCITopologyDevice)
+	for _, dev := range devices {
+		addresses = append(addresses, dev.Address)
+		byAddress[dev.Address] = dev
+	}
+	assert.Equal([]string{
+		"0000:00:00.0", "0000:00:01.0", "0000:01:00.0", "0000:02:08.0", "0000:03:00.0", "0000:03:00.1",
+		"0000:00:0e.0", "10000:00:02.0", "10000:01:00.0", "0001:00:01.0",
+	}, addresses, "expected devices to follow the port they are behind")
+
+	assert.Equal(v1beta1.PCITopologyDevice{
+		Address:    "0000:03:00.0",
+		RootBus:    "pci0000:00",
+		Parent:     "0000:02:08.0",
+		Type:       v1beta1.PCIPortTypeEndpoint,
+		VendorID:   "8086",
+		DeviceID:   "1901",
+		ClassID:    "0302",
+		Driver:     "vfio-pci",
+		IOMMUGroup: "3",
+		NUMANode:   0,
+	}, byAddress["0000:03:00.0"])
+
+	var testCases = []struct {
+		address    string
+		rootBus    string
+		parent     string
+		portType   v1beta1.PCIPortType
+		acs        *v1beta1.PCIACS
+		iommuGroup string
+	}{
+		{address: "0000:00:00.0", rootBus: "pci0000:00", portType: v1beta1.PCIPortTypePCIDevice, iommuGroup: "0"},
+		{
+			address: "0000:00:01.0", rootBus: "pci0000:00", portType: v1beta1.PCIPortTypeRootPort, iommuGroup: "1",
+			acs: &v1beta1.PCIACS{
+				Capabilities: []string{"SourceValidation", "TranslationBlocking", "RequestRedirect", "CompletionRedirect", "UpstreamForwarding"},
+				Enabled:      []string{"SourceValidation", "RequestRedirect", "CompletionRedirect", "UpstreamForwarding"},
+				Isolated:     true,
+			},
+		},
+		{address: "0000:01:00.0", rootBus: "pci0000:00", parent: "0000:00:01.0", portType: v1beta1.PCIPortTypeSwitchUpstreamPort, iommuGroup: "2"},
+		{
+			address: "0000:02:08.0", rootBus: "pci0000:00", parent: "0000:01:00.0", portType: v1beta1.PCIPortTypeSwitchDownstreamPort, iommuGroup: "3",
+			acs: &v1beta1.PCIACS{
+				Capabilities: []string{"SourceValidation", "TranslationBlocking", "RequestRedirect", "CompletionRedirect", "UpstreamForwarding"},
+			},
+		},
+		{address: "0000:00:0e.0", rootBus: "pci0000:00", portType: v1beta1.PCIPortTypeRootComplexIntegratedEndpoint, iommuGroup: "4"},
+		{address: "10000:00:02.0", rootBus: "pci10000:00", parent: "0000:00:0e.0", portType: v1beta1.PCIPortTypeRootPort},
+		{address: "10000:01:00.0", rootBus: "pci10000:00", parent: "10000:00:02.0", portType: v1beta1.PCIPortTypeEndpoint},
+		{
+			address: "0001:00:01.0", rootBus: "pci0001:00", portType: v1beta1.PCIPortTypeRootPort, iommuGroup: "30",
+			// the port does not support translation blocking and upstream forwarding
+			acs: &v1beta1.PCIACS{
+				Capabilities: []string{"SourceValidation", "RequestRedirect", "CompletionRedirect", "EgressControl"},
+				Enabled:      []string{"SourceValidation", "RequestRedirect", "CompletionRedirect"},
+				Isolated:     true,
+			},
+		},
+	}
+	for _, v := range testCases {
+		dev := byAddress[v.address]
+		assert.Equal(v.rootBus, dev.RootBus, v.address)
+		assert.Equal(v.parent, dev.Parent, v.address)
+		assert.Equal(v.portType, dev.Type, v.address)
+		assert.Equal(v.acs, dev.ACS, v.address)
+		assert.Equal(v.iommuGroup, dev.IOMMUGroup, v.address)
+	}
+
+	assert.Equal([]v1beta1.PCITopologyIOMMUGroup{
+		{Group: "0", Devices: []string{"0000:00:00.0"}},
+		{Group: "1", Devices: []string{"0000:00:01.0"}},
+		{Group: "2", Devices: []string{"0000:01:00.0"}},
+		{Group: "3", Devices: []string{"0000:02:08.0", "0000:03:00.0", "0000:03:00.1"}},
+		{Group: "4", Devices: []string{"0000:00:0e.0"}},
+		{Group: "30", Devices: []string{"0001:00:01.0"}},
+	}, groups)
+}
+
+func Test_Tree(t *testing.T) {
+	assert := require.New(t)
+	devices, _, err := newSysfs(t, topology).Read()
+	assert.NoError(err)
+	for i := range devices {
+		if devices[i].Address == "0000:03:00.0" {
+			devices[i].Description = "3D controller: NVIDIA Corporation TU104GL [Tesla T4]"
+			devices[i].PCIDevice = "node1-000003000"
+		}
+	}
+
+	assert.Equal(`pci0000:00
++-0000:00:00.0 PCIDevice [8086:1901] (iommu group 0, numa node 0)
++-0000:00:01.0 RootPort [8086:1901] (iommu group 1, numa node 0, acs isolated, driver pcieport)
+| \-0000:01:00.0 SwitchUpstreamPort [8086:1901] (iommu group 2, numa node 0, driver pcieport)
+|   \-0000:02:08.0 SwitchDownstreamPort [8086:1901] (iommu group 3, numa node 0, acs not isolated, driver pcieport)
+|     +-0000:03:00.0 Endpoint [8086:1901] 3D controller: NVIDIA Corporation TU104GL [Tesla T4] (iommu group 3, numa node 0, driver vfio-pci, pcidevice node1-000003000)
+|     \-0000:03:00.1 Endpoint [8086:1901] (iommu group 3, numa node 0)
+\-0000:00:0e.0 RootComplexIntegratedEndpoint [8086:1901] (iommu group 4, numa node 0, driver vmd)
+  \-10000:00:02.0 RootPort [8086:1901] (numa node 0, driver pcieport)
+    \-10000:01:00.0 Endpoint [8086:1901] (numa node 0, driver nvme)
+pci0001:00
+\-0001:00:01.0 RootPort [8086:1901] (iommu group 30, numa node 1, acs isolated, driver pcieport)
+`, Tree(devices))
+}
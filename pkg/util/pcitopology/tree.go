@@ -0,0 +1,81 @@
+package pcitopology
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+// Tree renders the PCIe tree of devices like lspci -tv, with a tree per root bus:
+//
+//	pci0000:00
+//	+-0000:00:01.0 RootPort [8086:1901] PCI bridge: Intel Corporation ... (acs isolated)
+//	| \-0000:01:00.0 Endpoint [10de:1eb8] 3D controller: NVIDIA Corporation ... (iommu group 1, numa node 0)
+//	\-0000:00:14.0 RootComplexIntegratedEndpoint [8086:a36d] USB controller: Intel Corporation ...
+//
+// devices have to be ordered as in the PCIe tree, as returned by Read
+func Tree(devices []v1beta1.PCITopologyDevice) string {
+	children := make(map[string][]v1beta1.PCITopologyDevice)
+	var rootBuses []string
+	for _, dev := range devices {
+		key := dev.Parent
+		if key == "" {
+			key = dev.RootBus
+			if len(children[key]) == 0 {
+				rootBuses = append(rootBuses, key)
+			}
+		}
+		children[key] = append(children[key], dev)
+	}
+
+	var b strings.Builder
+	for _, bus := range rootBuses {
+		b.WriteString(bus + "\n")
+		writeChildren(&b, children, bus, "")
+	}
+	return b.String()
+}
+
+func writeChildren(b *strings.Builder, children map[string][]v1beta1.PCITopologyDevice, key, prefix string) {
+	for i, dev := range children[key] {
+		branch, indent := "+-", "| "
+		if i == len(children[key])-1 {
+			branch, indent = "\\-", "  "
+		}
+		b.WriteString(prefix + branch + deviceLine(dev) + "\n")
+		writeChildren(b, children, dev.Address, prefix+indent)
+	}
+}
+
+func deviceLine(dev v1beta1.PCITopologyDevice) string {
+	line := fmt.Sprintf("%s %s [%s:%s]", dev.Address, dev.Type, dev.VendorID, dev.DeviceID)
+	if dev.Description != "" {
+		line += " " + dev.Description
+	}
+
+	var details []string
+	if dev.IOMMUGroup != "" {
+		details = append(details, "iommu group "+dev.IOMMUGroup)
+	}
+	if dev.NUMANode >= 0 {
+		details = append(details, fmt.Sprintf("numa node %d", dev.NUMANode))
+	}
+	if dev.ACS != nil {
+		if dev.ACS.Isolated {
+			details = append(details, "acs isolated")
+		} else {
+			details = append(details, "acs not isolated")
+		}
+	}
+	if dev.Driver != "" {
+		details = append(details, "driver "+dev.Driver)
+	}
+	if dev.PCIDevice != "" {
+		details = append(details, "pcidevice "+dev.PCIDevice)
+	}
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	return line
+}
@@ -0,0 +1,103 @@
+// Package podresources queries the devices allocated to pods through the PodResources API of the kubelet.
+// It only carries the messages and fields of the v1 API which are needed to map devices to pods, the wire
+// format matches k8s.io/kubelet/pkg/apis/podresources/v1
+package podresources
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// DefaultSocket is where the kubelet serves the PodResources API
+	DefaultSocket = "/var/lib/kubelet/pod-resources/kubelet.sock"
+
+	listMethod     = "/v1.PodResourcesLister/List"
+	connectTimeout = 10 * time.Second
+	maxMessageSize = 1024 * 1024 * 16
+)
+
+// Lister lists the resources allocated to the pods of the node
+type Lister interface {
+	List(ctx context.Context) ([]*PodResources, error)
+}
+
+type client struct {
+	socket string
+}
+
+// NewLister returns a Lister connecting to the kubelet on socket for each request
+func NewLister(socket string) Lister {
+	return &client{socket: socket}
+}
+
+func (c *client) List(ctx context.Context) ([]*PodResources, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, "unix://"+c.socket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to kubelet pod resources socket %s: %v", c.socket, err)
+	}
+	defer conn.Close()
+
+	resp := new(ListPodResourcesResponse)
+	if err := conn.Invoke(ctx, listMethod, new(ListPodResourcesRequest), resp); err != nil {
+		return nil, fmt.Errorf("error listing pod resources: %v", err)
+	}
+	return resp.PodResources, nil
+}
+
+// ListPodResourcesRequest is the request made to the PodResourcesLister service
+type ListPodResourcesRequest struct{}
+
+func (m *ListPodResourcesRequest) Reset()         { *m = ListPodResourcesRequest{} }
+func (m *ListPodResourcesRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ListPodResourcesRequest) ProtoMessage()    {}
+
+// ListPodResourcesResponse is the response returned by the List function
+type ListPodResourcesResponse struct {
+	PodResources []*PodResources `protobuf:"bytes,1,rep,name=pod_resources,json=podResources,proto3" json:"pod_resources,omitempty"`
+}
+
+func (m *ListPodResourcesResponse) Reset()         { *m = ListPodResourcesResponse{} }
+func (m *ListPodResourcesResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ListPodResourcesResponse) ProtoMessage()    {}
+
+// PodResources contains information about the node resources assigned to a pod
+type PodResources struct {
+	Name       string                `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Namespace  string                `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Containers []*ContainerResources `protobuf:"bytes,3,rep,name=containers,proto3" json:"containers,omitempty"`
+}
+
+func (m *PodResources) Reset()         { *m = PodResources{} }
+func (m *PodResources) String() string { return fmt.Sprintf("%+v", *m) }
+func (*PodResources) ProtoMessage()    {}
+
+// ContainerResources contains information about the resources assigned to a container
+type ContainerResources struct {
+	Name    string              `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Devices []*ContainerDevices `protobuf:"bytes,2,rep,name=devices,proto3" json:"devices,omitempty"`
+}
+
+func (m *ContainerResources) Reset()         { *m = ContainerResources{} }
+func (m *ContainerResources) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ContainerResources) ProtoMessage()    {}
+
+// ContainerDevices contains the ids of the devices of a resource assigned to a container
+type ContainerDevices struct {
+	ResourceName string   `protobuf:"bytes,1,opt,name=resource_name,json=resourceName,proto3" json:"resource_name,omitempty"`
+	DeviceIds    []string `protobuf:"bytes,2,rep,name=device_ids,json=deviceIds,proto3" json:"device_ids,omitempty"`
+}
+
+func (m *ContainerDevices) Reset()         { *m = ContainerDevices{} }
+func (m *ContainerDevices) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ContainerDevices) ProtoMessage()    {}
@@ -0,0 +1,69 @@
+package podresources
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type fakeLister struct {
+	resp *ListPodResourcesResponse
+}
+
+func (f *fakeLister) list(_ context.Context, _ *ListPodResourcesRequest) (*ListPodResourcesResponse, error) {
+	return f.resp, nil
+}
+
+func serveFakeKubelet(t *testing.T, resp *ListPodResourcesResponse) string {
+	socket := filepath.Join(t.TempDir(), "kubelet.sock")
+	lis, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "v1.PodResourcesLister",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "List",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(ListPodResourcesRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				return srv.(*fakeLister).list(ctx, req)
+			},
+		}},
+	}, &fakeLister{resp: resp})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return socket
+}
+
+func Test_List(t *testing.T) {
+	assert := require.New(t)
+	want := []*PodResources{
+		{
+			Name:      "virt-launcher-vm1-abcde",
+			Namespace: "default",
+			Containers: []*ContainerResources{
+				{
+					Name: "compute",
+					Devices: []*ContainerDevices{
+						{ResourceName: "nvidia.com/GA102GL_A10", DeviceIds: []string{"0000:04:00.0", "0000:05:00.0"}},
+					},
+				},
+			},
+		},
+	}
+	socket := serveFakeKubelet(t, &ListPodResourcesResponse{PodResources: want})
+
+	got, err := NewLister(socket).List(context.TODO())
+	assert.NoError(err)
+	assert.Equal(want, got)
+}
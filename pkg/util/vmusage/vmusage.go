@@ -0,0 +1,120 @@
+package vmusage
+
+import (
+	"fmt"
+
+	ctlkubevirtv1 "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+// usage identifies a device for matching the VMIs holding it
+type usage struct {
+	nodeName     string
+	resourceName string
+	usedBy       *v1beta1.DeviceUsage
+}
+
+// VMsUsingDevices maps the names of devices to the VMs which use them, in namespace/name form. Only VMIs
+// which are not final hold a device. A device the kubelet allocated, as published in usedBy, is held by that
+// VMI only. Other devices are held by the VMIs scheduled to their node which request more devices of their
+// resourceName than they are published to be allocated, as the kubelet may allocate them the device before
+// usedBy is published. The names VMs give their host devices are free-form, so they are not matched
+func VMsUsingDevices(vmiCache ctlkubevirtv1.VirtualMachineInstanceCache, pdCache v1beta1gen.PCIDeviceCache, devices ...*v1beta1.PCIDevice) (map[string][]string, error) {
+	pds, err := pdCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	usages := make(map[string]usage, len(devices))
+	for _, pd := range devices {
+		usages[pd.Name] = usage{
+			nodeName:     pd.Status.NodeName,
+			resourceName: pd.Status.ResourceName,
+			usedBy:       pd.Status.UsedBy,
+		}
+	}
+	return vmsUsing(vmiCache, pds, usages)
+}
+
+// VMsUsingClaims maps the names of claims to the VMs which use the claimed devices, in namespace/name form,
+// as VMsUsingDevices. Claims of devices which no longer exist are only held by the VMI in their usedBy
+func VMsUsingClaims(vmiCache ctlkubevirtv1.VirtualMachineInstanceCache, pdCache v1beta1gen.PCIDeviceCache, claims ...*v1beta1.PCIDeviceClaim) (map[string][]string, error) {
+	pds, err := pdCache.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcidevices: %v", err)
+	}
+	devices := make(map[string]*v1beta1.PCIDevice, len(pds))
+	for _, pd := range pds {
+		devices[fmt.Sprintf("%s-%s", pd.Status.NodeName, pd.Status.Address)] = pd
+	}
+
+	usages := make(map[string]usage, len(claims))
+	for _, pdc := range claims {
+		u := usage{
+			nodeName: pdc.Spec.NodeName,
+			usedBy:   pdc.Status.UsedBy,
+		}
+		if pd, ok := devices[pdc.Spec.NodeAddr()]; ok {
+			u.resourceName = pd.Status.ResourceName
+			if u.usedBy == nil {
+				u.usedBy = pd.Status.UsedBy
+			}
+		}
+		usages[pdc.Name] = u
+	}
+	return vmsUsing(vmiCache, pds, usages)
+}
+
+func vmsUsing(vmiCache ctlkubevirtv1.VirtualMachineInstanceCache, pds []*v1beta1.PCIDevice, usages map[string]usage) (map[string][]string, error) {
+	vmis, err := vmiCache.List(metav1.NamespaceAll, labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing virtualmachineinstances: %v", err)
+	}
+
+	// allocated counts the devices published to be allocated to a VMI by resourceName
+	allocated := make(map[string]map[string]int)
+	for _, pd := range pds {
+		if pd.Status.UsedBy == nil || pd.Status.UsedBy.VirtualMachineInstance == "" {
+			continue
+		}
+		key := pd.Status.UsedBy.VirtualMachineInstance
+		if allocated[key] == nil {
+			allocated[key] = make(map[string]int)
+		}
+		allocated[key][pd.Status.ResourceName]++
+	}
+
+	result := make(map[string][]string)
+	for _, vmi := range vmis {
+		if vmi.IsFinal() {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s", vmi.Namespace, vmi.Name)
+		for name, u := range usages {
+			if u.holdsDevice(vmi, key, allocated[key]) {
+				result[name] = append(result[name], key)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (u usage) holdsDevice(vmi *kubevirtv1.VirtualMachineInstance, key string, allocated map[string]int) bool {
+	if u.usedBy != nil {
+		return u.usedBy.VirtualMachineInstance == key
+	}
+	if u.resourceName == "" || vmi.Status.NodeName != u.nodeName {
+		return false
+	}
+	var requested int
+	for _, hostDevice := range vmi.Spec.Domain.Devices.HostDevices {
+		if hostDevice.DeviceName == u.resourceName {
+			requested++
+		}
+	}
+	return requested > allocated[u.resourceName]
+}
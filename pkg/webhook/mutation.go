@@ -9,14 +9,20 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Mutation(clients *Clients) (http.Handler, []types.Resource, error) {
+func Mutation(clients *Clients, options Options) (http.Handler, []types.Resource, error) {
 	var resources []types.Resource
+	policy := newClientsPolicyChecker(clients, options)
 	mutators := []types.Mutator{
 		NewPodMutator(clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(),
-			clients.KubevirtFactory.Kubevirt().V1().VirtualMachine().Cache()),
+			clients.KubevirtFactory.Kubevirt().V1().VirtualMachine().Cache(),
+			clients.CoreFactory.Core().V1().ConfigMap().Cache(),
+			options.Namespace),
 		NewPCIVMMutator(clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(),
 			clients.PCIFactory.Devices().V1beta1().PCIDeviceClaim().Cache(),
-			clients.PCIFactory.Devices().V1beta1().PCIDeviceClaim()),
+			clients.PCIFactory.Devices().V1beta1().PCIDeviceClaim(),
+			policy),
+		NewPCIDeviceClaimMutator(policy),
+		NewPCIDeviceHotplugMutator(policy),
 	}
 
 	router := webhook.NewRouter()
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	werror "github.com/harvester/harvester/pkg/webhook/error"
+	"github.com/harvester/harvester/pkg/webhook/types"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+
+	devicesv1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+func NewPCIDeviceClaimMutator(policy *policyChecker) types.Mutator {
+	return &pciDeviceClaimMutator{
+		policy: policy,
+	}
+}
+
+// pciDeviceClaimMutator records the requester as owner of new claims, which PCIDevicePolicies cap the
+// devices of users and groups by
+type pciDeviceClaimMutator struct {
+	types.DefaultMutator
+	policy *policyChecker
+}
+
+func (m *pciDeviceClaimMutator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{devicesv1beta1.PCIDeviceClaimResourceName},
+		Scope:      admissionregv1.ClusterScope,
+		APIGroup:   devicesv1beta1.SchemeGroupVersion.Group,
+		APIVersion: devicesv1beta1.SchemeGroupVersion.Version,
+		ObjectType: &devicesv1beta1.PCIDeviceClaim{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+		},
+	}
+}
+
+func (m *pciDeviceClaimMutator) Create(request *types.Request, newObj runtime.Object) (types.PatchOps, error) {
+	pdc := newObj.(*devicesv1beta1.PCIDeviceClaim)
+	req := newRequester(request, "", "")
+	if m.policy.trusted(req) {
+		// controllers creating claims on behalf of a user record the user themselves
+		if pdc.Annotations[devicesv1beta1.RequestedByAnnotation] != "" {
+			return nil, nil
+		}
+		return requestedByPatch(pdc, req.user)
+	}
+
+	patchOps, err := annotationsPatch(pdc, map[string]string{
+		devicesv1beta1.RequestedByAnnotation:     req.user,
+		devicesv1beta1.RequesterGroupsAnnotation: strings.Join(req.groups, ","),
+	})
+	if err != nil || pdc.Spec.UserName == req.user {
+		return patchOps, err
+	}
+
+	// policies cap the devices of a user by the userName of their claims, which must not be chosen freely
+	userName, err := json.Marshal(req.user)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling username: %v", err)
+	}
+	return append(patchOps, fmt.Sprintf(`{"op": "add", "path": "/spec/userName", "value": %s}`, userName)), nil
+}
+
+func NewPCIDeviceClaimValidator(deviceCache v1beta1.PCIDeviceCache, policy *policyChecker) types.Validator {
+	return &pciDeviceClaimValidator{
+		deviceCache: deviceCache,
+		policy:      policy,
+	}
+}
+
+// pciDeviceClaimValidator admits new claims against PCIDevicePolicies, and keeps the requester of claims
+type pciDeviceClaimValidator struct {
+	types.DefaultValidator
+	deviceCache v1beta1.PCIDeviceCache
+	policy      *policyChecker
+}
+
+func (v *pciDeviceClaimValidator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{devicesv1beta1.PCIDeviceClaimResourceName},
+		Scope:      admissionregv1.ClusterScope,
+		APIGroup:   devicesv1beta1.SchemeGroupVersion.Group,
+		APIVersion: devicesv1beta1.SchemeGroupVersion.Version,
+		ObjectType: &devicesv1beta1.PCIDeviceClaim{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+			admissionregv1.Update,
+		},
+	}
+}
+
+func (v *pciDeviceClaimValidator) Create(request *types.Request, newObj runtime.Object) error {
+	pdc := newObj.(*devicesv1beta1.PCIDeviceClaim)
+	req := newRequester(request, "", "")
+	if v.policy.trusted(req) {
+		return nil
+	}
+
+	name := claimedDeviceName(pdc)
+	pd, err := v.deviceCache.Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return werror.NewBadRequest(fmt.Sprintf("pcidevice %s not found", name))
+		}
+		return fmt.Errorf("error looking up pcidevice %s from cache: %v", name, err)
+	}
+	return v.policy.check(req, []*devicesv1beta1.PCIDevice{pd})
+}
+
+// Update refuses changes of the userName and the requester of a claim, as policies cap the devices of
+// users and groups by the userName and requester groups of their claims, and the requester is the audit
+// trail of the claim
+func (v *pciDeviceClaimValidator) Update(_ *types.Request, oldObj runtime.Object, newObj runtime.Object) error {
+	oldPDC := oldObj.(*devicesv1beta1.PCIDeviceClaim)
+	newPDC := newObj.(*devicesv1beta1.PCIDeviceClaim)
+	if oldPDC.Spec.UserName != newPDC.Spec.UserName {
+		return werror.NewBadRequest("the userName of a pcideviceclaim cannot be changed")
+	}
+	for _, annotation := range []string{devicesv1beta1.RequestedByAnnotation, devicesv1beta1.RequesterGroupsAnnotation} {
+		if oldPDC.Annotations[annotation] != newPDC.Annotations[annotation] {
+			return werror.NewBadRequest(fmt.Sprintf("the %s annotation of a pcideviceclaim cannot be changed", annotation))
+		}
+	}
+	return nil
+}
+
+// requestedByPatch records user in the requested-by annotation of obj, which the audit trail of the
+// operations performed for the claim refers to
+func requestedByPatch(obj metav1.Object, user string) (types.PatchOps, error) {
+	return annotationsPatch(obj, map[string]string{devicesv1beta1.RequestedByAnnotation: user})
+}
+
+// annotationsPatch sets the annotations of obj to the values, the annotations are added if obj has none
+func annotationsPatch(obj metav1.Object, values map[string]string) (types.PatchOps, error) {
+	if obj.GetAnnotations() == nil {
+		annotations, err := json.Marshal(values)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling annotations: %v", err)
+		}
+		return types.PatchOps{fmt.Sprintf(`{"op": "add", "path": "/metadata/annotations", "value": %s}`, annotations)}, nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var patchOps types.PatchOps
+	for _, key := range keys {
+		if current, ok := obj.GetAnnotations()[key]; ok && current == values[key] {
+			continue
+		}
+		value, err := json.Marshal(values[key])
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling annotation %s: %v", key, err)
+		}
+		patchOps = append(patchOps, fmt.Sprintf(`{"op": "add", "path": "/metadata/annotations/%s", "value": %s}`, escapePathKey(key), value))
+	}
+	return patchOps, nil
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"fmt"
+
+	werror "github.com/harvester/harvester/pkg/webhook/error"
+	"github.com/harvester/harvester/pkg/webhook/types"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	devicesv1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+func NewPCIDeviceHotplugMutator(policy *policyChecker) types.Mutator {
+	return &pciDeviceHotplugMutator{
+		policy: policy,
+	}
+}
+
+// pciDeviceHotplugMutator records the requester of new hotplugs, who the device is claimed for
+type pciDeviceHotplugMutator struct {
+	types.DefaultMutator
+	policy *policyChecker
+}
+
+func (m *pciDeviceHotplugMutator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{devicesv1beta1.PCIDeviceHotplugResourceName},
+		Scope:      admissionregv1.ClusterScope,
+		APIGroup:   devicesv1beta1.SchemeGroupVersion.Group,
+		APIVersion: devicesv1beta1.SchemeGroupVersion.Version,
+		ObjectType: &devicesv1beta1.PCIDeviceHotplug{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+		},
+	}
+}
+
+func (m *pciDeviceHotplugMutator) Create(request *types.Request, newObj runtime.Object) (types.PatchOps, error) {
+	hp := newObj.(*devicesv1beta1.PCIDeviceHotplug)
+	req := newRequester(request, hp.Spec.VMINamespace, "")
+	if m.policy.trusted(req) && hp.Annotations[devicesv1beta1.RequestedByAnnotation] != "" {
+		// controllers hot-plugging devices on behalf of a user record the user themselves
+		return nil, nil
+	}
+	return requestedByPatch(hp, req.user)
+}
+
+func NewPCIDeviceHotplugValidator(deviceCache v1beta1.PCIDeviceCache, policy *policyChecker) types.Validator {
+	return &pciDeviceHotplugValidator{
+		deviceCache: deviceCache,
+		policy:      policy,
+	}
+}
+
+// pciDeviceHotplugValidator admits new hotplugs against PCIDevicePolicies like the devices of VMs
+type pciDeviceHotplugValidator struct {
+	types.DefaultValidator
+	deviceCache v1beta1.PCIDeviceCache
+	policy      *policyChecker
+}
+
+func (v *pciDeviceHotplugValidator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{devicesv1beta1.PCIDeviceHotplugResourceName},
+		Scope:      admissionregv1.ClusterScope,
+		APIGroup:   devicesv1beta1.SchemeGroupVersion.Group,
+		APIVersion: devicesv1beta1.SchemeGroupVersion.Version,
+		ObjectType: &devicesv1beta1.PCIDeviceHotplug{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+			admissionregv1.Update,
+		},
+	}
+}
+
+func (v *pciDeviceHotplugValidator) Create(request *types.Request, newObj runtime.Object) error {
+	hp := newObj.(*devicesv1beta1.PCIDeviceHotplug)
+	if hp.Spec.DeviceName == "" || hp.Spec.VMINamespace == "" || hp.Spec.VMIName == "" {
+		return werror.NewBadRequest("deviceName, vmiNamespace and vmiName of a pcidevicehotplug are required")
+	}
+
+	pd, err := v.deviceCache.Get(hp.Spec.DeviceName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return werror.NewBadRequest(fmt.Sprintf("pcidevice %s not found", hp.Spec.DeviceName))
+		}
+		return fmt.Errorf("error looking up pcidevice %s from cache: %v", hp.Spec.DeviceName, err)
+	}
+
+	// the hotplug adds a device to the devices the VM already holds, none of which it submits again
+	return v.policy.check(newRequester(request, hp.Spec.VMINamespace, ""), []*devicesv1beta1.PCIDevice{pd})
+}
+
+// Update refuses changes of the device or VMI, a device is moved to another VMI by deleting the hotplug,
+// which detaches the device, and creating a new one
+func (v *pciDeviceHotplugValidator) Update(_ *types.Request, oldObj runtime.Object, newObj runtime.Object) error {
+	oldHP := oldObj.(*devicesv1beta1.PCIDeviceHotplug)
+	newHP := newObj.(*devicesv1beta1.PCIDeviceHotplug)
+	if oldHP.Spec != newHP.Spec {
+		return werror.NewBadRequest("the spec of a pcidevicehotplug cannot be changed")
+	}
+	return nil
+}
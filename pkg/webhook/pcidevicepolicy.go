@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"fmt"
+
+	werror "github.com/harvester/harvester/pkg/webhook/error"
+	"github.com/harvester/harvester/pkg/webhook/types"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	devicesv1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+func NewPCIDevicePolicyValidator() types.Validator {
+	return &pciDevicePolicyValidator{}
+}
+
+// pciDevicePolicyValidator refuses PCIDevicePolicies which cannot match any request, as a policy
+// which matches nothing still enables policy enforcement for the whole cluster
+type pciDevicePolicyValidator struct {
+	types.DefaultValidator
+}
+
+func (v *pciDevicePolicyValidator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{devicesv1beta1.PCIDevicePolicyResourceName},
+		Scope:      admissionregv1.ClusterScope,
+		APIGroup:   devicesv1beta1.SchemeGroupVersion.Group,
+		APIVersion: devicesv1beta1.SchemeGroupVersion.Version,
+		ObjectType: &devicesv1beta1.PCIDevicePolicy{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+			admissionregv1.Update,
+		},
+	}
+}
+
+func (v *pciDevicePolicyValidator) Create(_ *types.Request, newObj runtime.Object) error {
+	return validatePolicy(newObj.(*devicesv1beta1.PCIDevicePolicy))
+}
+
+func (v *pciDevicePolicyValidator) Update(_ *types.Request, _ runtime.Object, newObj runtime.Object) error {
+	return validatePolicy(newObj.(*devicesv1beta1.PCIDevicePolicy))
+}
+
+func validatePolicy(policy *devicesv1beta1.PCIDevicePolicy) error {
+	if len(policy.Spec.Subjects) == 0 {
+		return werror.NewInvalidError("a pcidevicepolicy requires at least one subject", "spec.subjects")
+	}
+	for idx, subject := range policy.Spec.Subjects {
+		field := fmt.Sprintf("spec.subjects[%d]", idx)
+		switch subject.Kind {
+		case devicesv1beta1.PolicySubjectUser, devicesv1beta1.PolicySubjectGroup, devicesv1beta1.PolicySubjectNamespace:
+		default:
+			return werror.NewInvalidError(fmt.Sprintf("kind %q of subject is not one of %s, %s or %s", subject.Kind,
+				devicesv1beta1.PolicySubjectUser, devicesv1beta1.PolicySubjectGroup, devicesv1beta1.PolicySubjectNamespace), field+".kind")
+		}
+		if subject.Name == "" {
+			return werror.NewInvalidError("name of subject is required", field+".name")
+		}
+	}
+	if policy.Spec.MaxDevices != nil && *policy.Spec.MaxDevices < 0 {
+		return werror.NewInvalidError("maxDevices cannot be negative", "spec.maxDevices")
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package webhook
 
 import (
 	"fmt"
+	"sort"
 
 	kubevirtctl "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
 	"github.com/harvester/harvester/pkg/webhook/types"
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -17,27 +19,46 @@ import (
 
 const (
 	VMLabel = "harvesterhci.io/vmName"
+	// computeContainerName is the container of virt-launcher pods running the VM, other containers like
+	// hook sidecars do not use the devices
+	computeContainerName = "compute"
 )
 
+// vmCapabilities are added to the compute container of VMs using PCI devices, SYS_RESOURCE allows
+// raising RLIMIT_MEMLOCK for the guest memory locked for DMA
+var vmCapabilities = []corev1.Capability{"SYS_RESOURCE"}
+
+// userspaceDriverCapabilities are needed by userspace drivers like DPDK or SPDK to use VFIO devices, which
+// pin the memory they map for DMA. IPC_LOCK allows locking memory beyond RLIMIT_MEMLOCK, and SYS_RESOURCE
+// allows raising the limit
+var userspaceDriverCapabilities = []corev1.Capability{"IPC_LOCK", "SYS_RESOURCE"}
+
 var matchingLabels = []labels.Set{
 	{
 		"kubevirt.io": "virt-launcher",
 	},
 }
 
-func NewPodMutator(deviceCache v1beta1.PCIDeviceCache, kubevirtCache kubevirtctl.VirtualMachineCache) types.Mutator {
+func NewPodMutator(deviceCache v1beta1.PCIDeviceCache, kubevirtCache kubevirtctl.VirtualMachineCache, configMapCache ctlcorev1.ConfigMapCache,
+	namespace string) types.Mutator {
 	return &podMutator{
-		deviceCache:   deviceCache,
-		kubevirtCache: kubevirtCache,
+		deviceCache:    deviceCache,
+		kubevirtCache:  kubevirtCache,
+		configMapCache: configMapCache,
+		namespace:      namespace,
 	}
 }
 
-// podMutator injects Harvester settings like http proxy envs and trusted CA certs to system pods that may access
-// external services. It includes harvester apiserver and longhorn backing-image-data-source pods.
+// podMutator adds the capabilities and resources needed for PCI devices to the containers using them, the
+// compute container of virt-launcher pods and the containers of other pods requesting the devices. Both can
+// be configured per resourceName with the PodMutationConfigName ConfigMap
 type podMutator struct {
 	types.DefaultMutator
-	deviceCache   v1beta1.PCIDeviceCache
-	kubevirtCache kubevirtctl.VirtualMachineCache
+	deviceCache    v1beta1.PCIDeviceCache
+	kubevirtCache  kubevirtctl.VirtualMachineCache
+	configMapCache ctlcorev1.ConfigMapCache
+	// namespace of the webhook, which holds the PodMutationConfigName ConfigMap
+	namespace string
 }
 
 func newResource(ops []admissionregv1.OperationType) types.Resource {
@@ -69,8 +90,7 @@ func (m *podMutator) Create(request *types.Request, newObj runtime.Object) (type
 		}
 	}
 	if !match {
-		logrus.Infof("ignoring pod %s in ns %s as no valid labels found", pod.Name, pod.Namespace)
-		return nil, nil
+		return m.podDevicesPatch(pod, loadPodMutationConfig(m.configMapCache, m.namespace))
 	}
 
 	var patchOps types.PatchOps
@@ -91,13 +111,13 @@ func (m *podMutator) Create(request *types.Request, newObj runtime.Object) (type
 		return nil, fmt.Errorf("expected to find exactly 1 vm but found %d", len(vm))
 	}
 
-	var found bool
-
 	if len(vm[0].Spec.Template.Spec.Domain.Devices.HostDevices) == 0 {
 		logrus.Infof("vm %s in ns %s has no device attachments, skipping", vm[0].Name, vm[0].Namespace)
 		return nil, nil
 	}
 
+	// number of host devices of the vm by resourceName
+	devices := make(map[string]int64)
 	for _, v := range vm[0].Spec.Template.Spec.Domain.Devices.HostDevices {
 		hostDevices, err := m.deviceCache.GetByIndex(PCIDeviceByResourceName, v.DeviceName)
 		if err != nil {
@@ -106,21 +126,29 @@ func (m *podMutator) Create(request *types.Request, newObj runtime.Object) (type
 		}
 
 		if len(hostDevices) > 0 {
-			found = true
-			break
+			devices[v.DeviceName]++
 		}
 	}
 
-	if found {
-		capPatchOptions, err := createCapabilityPatch(pod)
+	if len(devices) == 0 {
+		logrus.Infof("no device found in vm: %s, for matching pcidevices", vmName)
+		return nil, nil
+	}
+
+	capabilities, resources := loadPodMutationConfig(m.configMapCache, m.namespace).merge(devices, vmCapabilities)
+	capPatchOptions, err := createCapabilityPatch(pod, capabilities)
+	if err != nil {
+		logrus.Errorf("error creating capability patch for pod %s in ns %s %v", pod.Name, pod.Namespace, err)
+		return nil, fmt.Errorf("error creating capability patch: %v", err)
+	}
+	patchOps = append(patchOps, capPatchOptions...)
+
+	if idx := computeContainerIndex(pod); idx >= 0 {
+		resourcesPatch, err := addResourcesPatch(pod.Spec.Containers[idx].Resources, fmt.Sprintf("/spec/containers/%d/resources", idx), resources)
 		if err != nil {
-			logrus.Errorf("error creating capability patch for pod %s in ns %s %v", pod.Name, pod.Namespace, err)
-			return nil, fmt.Errorf("error creating capability patch: %v", err)
+			return nil, fmt.Errorf("error creating resources patch: %v", err)
 		}
-
-		patchOps = append(patchOps, capPatchOptions...)
-	} else {
-		logrus.Infof("no device found in vm: %s, for matching pcidevices", vmName)
+		patchOps = append(patchOps, resourcesPatch...)
 	}
 
 	logrus.Debugf("patch generated %v, for pod %s in ns %s", patchOps, pod.Name, pod.Namespace)
@@ -128,31 +156,208 @@ func (m *podMutator) Create(request *types.Request, newObj runtime.Object) (type
 	return patchOps, nil
 }
 
-func createCapabilityPatch(pod *corev1.Pod) (types.PatchOps, error) {
+// createCapabilityPatch adds capabilities to the compute container of a virt-launcher pod
+func createCapabilityPatch(pod *corev1.Pod, capabilities []corev1.Capability) (types.PatchOps, error) {
+	idx := computeContainerIndex(pod)
+	if idx < 0 {
+		logrus.Warnf("pod %s in ns %s has no %s container, skipping", pod.Name, pod.Namespace, computeContainerName)
+		return nil, nil
+	}
+	return addCapabilitiesPatch(pod.Spec.Containers[idx].SecurityContext, fmt.Sprintf("/spec/containers/%d/securityContext", idx), capabilities)
+}
+
+func computeContainerIndex(pod *corev1.Pod) int {
+	for idx, container := range pod.Spec.Containers {
+		if container.Name == computeContainerName {
+			return idx
+		}
+	}
+	return -1
+}
+
+// podDevicesPatch prepares pods which are not VM pods for the PCI devices they request, e.g. DPDK or SPDK
+// containers. The containers requesting the resourceName of a PCI device get the capabilities needed by
+// userspace drivers, and the limits of devices which are only requested, as extended resources must be limited
+func (m *podMutator) podDevicesPatch(pod *corev1.Pod, config podMutationConfig) (types.PatchOps, error) {
 	var patchOps types.PatchOps
 	for idx, container := range pod.Spec.Containers {
-		addPatch, err := resourcePatch(container.SecurityContext.Capabilities.Add, fmt.Sprintf("/spec/containers/%d/securityContext/capabilities/add", idx))
+		requested, err := m.requestedDevices(container)
+		if err != nil {
+			logrus.Errorf("error looking up pcidevices requested by pod %s in ns %s: %v", pod.Name, pod.Namespace, err)
+			return nil, fmt.Errorf("error listing pcidevices by resourceName: %v", err)
+		}
+		if len(requested) == 0 {
+			continue
+		}
+
+		devices := make(map[string]int64, len(requested))
+		for name, quantity := range requested {
+			devices[string(name)] = quantity.Value()
+		}
+		capabilities, resources := config.merge(devices, userspaceDriverCapabilities)
+
+		basePath := fmt.Sprintf("/spec/containers/%d", idx)
+		capPatch, err := addCapabilitiesPatch(container.SecurityContext, basePath+"/securityContext", capabilities)
 		if err != nil {
 			return nil, err
 		}
-		patchOps = append(patchOps, addPatch...)
+		patchOps = append(patchOps, capPatch...)
+		limitsPatch, err := addLimitsPatch(container.Resources, basePath+"/resources", requested)
+		if err != nil {
+			return nil, err
+		}
+		patchOps = append(patchOps, limitsPatch...)
+		// the devices are limited before, so limits only hold the resources the container limited itself
+		resourcesPatch, err := addResourcesPatch(container.Resources, basePath+"/resources", resources)
+		if err != nil {
+			return nil, err
+		}
+		patchOps = append(patchOps, resourcesPatch...)
 	}
 
+	if len(patchOps) == 0 {
+		logrus.Debugf("ignoring pod %s in ns %s as it requests no pcidevices", pod.Name, pod.Namespace)
+		return nil, nil
+	}
+	logrus.Debugf("patch generated %v, for pod %s in ns %s", patchOps, pod.Name, pod.Namespace)
 	return patchOps, nil
 }
 
-func resourcePatch(add []corev1.Capability, basePath string) (types.PatchOps, error) {
+// requestedDevices returns the resourceNames of PCI devices limited or requested by container, along with
+// the requested quantity
+func (m *podMutator) requestedDevices(container corev1.Container) (corev1.ResourceList, error) {
+	requested := corev1.ResourceList{}
+	for _, resources := range []corev1.ResourceList{container.Resources.Requests, container.Resources.Limits} {
+		for name, quantity := range resources {
+			if _, ok := requested[name]; ok {
+				continue
+			}
+			devices, err := m.deviceCache.GetByIndex(PCIDeviceByResourceName, string(name))
+			if err != nil {
+				return nil, err
+			}
+			if len(devices) > 0 {
+				requested[name] = quantity
+			}
+		}
+	}
+	return requested, nil
+}
+
+// addCapabilitiesPatch adds the missing capabilities to the securityContext of a container at basePath
+func addCapabilitiesPatch(securityContext *corev1.SecurityContext, basePath string, capabilities []corev1.Capability) (types.PatchOps, error) {
+	switch {
+	case len(capabilities) == 0:
+		return nil, nil
+	case securityContext == nil:
+		return addPatch(basePath, corev1.SecurityContext{Capabilities: &corev1.Capabilities{Add: capabilities}})
+	case securityContext.Capabilities == nil:
+		return addPatch(basePath+"/capabilities", corev1.Capabilities{Add: capabilities})
+	case len(securityContext.Capabilities.Add) == 0:
+		return addPatch(basePath+"/capabilities/add", capabilities)
+	}
+
+	var patchOps types.PatchOps
+	for _, capability := range capabilities {
+		if containsCapability(securityContext.Capabilities.Add, capability) {
+			continue
+		}
+		capPatch, err := addPatch(basePath+"/capabilities/add/-", capability)
+		if err != nil {
+			return nil, err
+		}
+		patchOps = append(patchOps, capPatch...)
+	}
+	return patchOps, nil
+}
+
+// addLimitsPatch limits the requested resources of a container at basePath which are not limited yet
+func addLimitsPatch(resources corev1.ResourceRequirements, basePath string, requested corev1.ResourceList) (types.PatchOps, error) {
+	missing := corev1.ResourceList{}
+	for name, quantity := range requested {
+		if _, ok := resources.Limits[name]; !ok {
+			missing[name] = quantity
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if len(resources.Limits) == 0 {
+		return addPatch(basePath+"/limits", missing)
+	}
+
+	var patchOps types.PatchOps
+	for name, quantity := range missing {
+		limitPatch, err := addPatch(basePath+"/limits/"+escapePathKey(string(name)), quantity)
+		if err != nil {
+			return nil, err
+		}
+		patchOps = append(patchOps, limitPatch...)
+	}
+	return patchOps, nil
+}
+
+// addResourcesPatch adds extra to the requests of a container at basePath, and to the limits of the resources
+// the container is limited in
+func addResourcesPatch(resources corev1.ResourceRequirements, basePath string, extra corev1.ResourceList) (types.PatchOps, error) {
+	if len(extra) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	requests := corev1.ResourceList{}
 	var patchOps types.PatchOps
-	if len(add) == 0 {
-		basePath = basePath + "/-"
+	for _, v := range names {
+		name := corev1.ResourceName(v)
+		request := extra[name].DeepCopy()
+		if current, ok := resources.Requests[name]; ok {
+			request.Add(current)
+		}
+		requests[name] = request
+		if len(resources.Requests) > 0 {
+			requestPatch, err := addPatch(basePath+"/requests/"+escapePathKey(v), request)
+			if err != nil {
+				return nil, err
+			}
+			patchOps = append(patchOps, requestPatch...)
+		}
+
+		if current, ok := resources.Limits[name]; ok {
+			current.Add(extra[name])
+			limitPatch, err := addPatch(basePath+"/limits/"+escapePathKey(v), current)
+			if err != nil {
+				return nil, err
+			}
+			patchOps = append(patchOps, limitPatch...)
+		}
+	}
+	if len(resources.Requests) == 0 {
+		requestsPatch, err := addPatch(basePath+"/requests", requests)
+		if err != nil {
+			return nil, err
+		}
+		patchOps = append(requestsPatch, patchOps...)
 	}
+	return patchOps, nil
+}
 
-	value := append(add, "SYS_RESOURCE")
+func addPatch(path string, value interface{}) (types.PatchOps, error) {
 	valueStr, err := json.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
+	return types.PatchOps{fmt.Sprintf(`{"op": "add", "path": "%s", "value": %s}`, path, valueStr)}, nil
+}
 
-	patchOps = append(patchOps, fmt.Sprintf(`{"op": "add", "path": "%s", "value": %s}`, basePath, valueStr))
-	return patchOps, err
+func containsCapability(capabilities []corev1.Capability, capability corev1.Capability) bool {
+	for _, v := range capabilities {
+		if v == capability {
+			return true
+		}
+	}
+	return false
 }
@@ -10,13 +10,21 @@ import (
 	"github.com/rancher/wrangler/pkg/yaml"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/json"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	devicesfake "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
 )
 
 const (
@@ -267,7 +275,7 @@ func Test_createCapabilitiesPatch(t *testing.T) {
 	p := &corev1.Pod{}
 	err = c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "virt-launcher-demo-clvzw"}, p)
 	assert.NoError(err, "expect no error during pod fetch")
-	patch, err := createCapabilityPatch(p)
+	patch, err := createCapabilityPatch(p, vmCapabilities)
 	assert.NoError(err, "expected no error during patch generation")
 	patchData := fmt.Sprintf("[%s]", strings.Join(patch, ","))
 	podJson, err := json.Marshal(p)
@@ -282,3 +290,213 @@ func Test_createCapabilitiesPatch(t *testing.T) {
 	assert.Len(newPod.Spec.Containers[0].SecurityContext.Capabilities.Add, 3, "expected to find 3 capabilities")
 	assert.Equal(newPod.Spec.Containers[0].SecurityContext.Capabilities.Add[2], corev1.Capability("SYS_RESOURCE"))
 }
+
+// applyPodPatch applies patch to pod, and returns the patched pod
+func applyPodPatch(t *testing.T, pod *corev1.Pod, patch []string) *corev1.Pod {
+	podJSON, err := json.Marshal(pod)
+	require.NoError(t, err)
+	decoded, err := jsonpatch.DecodePatch([]byte(fmt.Sprintf("[%s]", strings.Join(patch, ","))))
+	require.NoError(t, err)
+	patchedJSON, err := decoded.Apply(podJSON)
+	require.NoError(t, err)
+	patched := &corev1.Pod{}
+	require.NoError(t, json.Unmarshal(patchedJSON, patched))
+	return patched
+}
+
+func Test_PlainPodRequestingDevices(t *testing.T) {
+	assert := require.New(t)
+	fakeClient := devicesfake.NewSimpleClientset(node1dev1, node1dev2)
+	mutator := NewPodMutator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), fakeclients.VirtualMachineCache{}, nil, defaultNamespace)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpdk", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "sidecar",
+				},
+				{
+					// devices which are only requested are limited as well
+					Name: "dpdk",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{"fake.com/device1": resource.MustParse("1")},
+					},
+				},
+				{
+					Name: "spdk",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: resource.MustParse("1Gi"),
+							"fake.com/device2":    resource.MustParse("1"),
+						},
+						Requests: corev1.ResourceList{"fake.com/device1": resource.MustParse("1")},
+					},
+					SecurityContext: &corev1.SecurityContext{
+						Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "IPC_LOCK"}},
+					},
+				},
+			},
+		},
+	}
+
+	patch, err := mutator.Create(nil, pod)
+	assert.NoError(err)
+	patched := applyPodPatch(t, pod, patch)
+
+	assert.Nil(patched.Spec.Containers[0].SecurityContext, "expected containers requesting no devices to be unchanged")
+	assert.Equal([]corev1.Capability{"IPC_LOCK", "SYS_RESOURCE"}, patched.Spec.Containers[1].SecurityContext.Capabilities.Add)
+	assert.Equal(resource.MustParse("1"), patched.Spec.Containers[1].Resources.Limits["fake.com/device1"])
+	assert.Equal([]corev1.Capability{"NET_ADMIN", "IPC_LOCK", "SYS_RESOURCE"}, patched.Spec.Containers[2].SecurityContext.Capabilities.Add)
+	assert.Equal(resource.MustParse("1"), patched.Spec.Containers[2].Resources.Limits["fake.com/device1"])
+	assert.Equal(resource.MustParse("1Gi"), patched.Spec.Containers[2].Resources.Limits[corev1.ResourceMemory])
+
+	pod.Spec.Containers = pod.Spec.Containers[:1]
+	patch, err = mutator.Create(nil, pod)
+	assert.NoError(err)
+	assert.Empty(patch, "expected pods requesting no devices to be unchanged")
+}
+
+// newPodMutationConfig returns the configmap configuring the pod mutation with config
+func newPodMutationConfig(config string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: PodMutationConfigName, Namespace: defaultNamespace},
+		Data:       map[string]string{podMutationConfigKey: config},
+	}
+}
+
+func Test_VirtLauncherPodWithSidecars(t *testing.T) {
+	fakeClient := devicesfake.NewSimpleClientset(node1dev1, node1dev2)
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: kubevirtv1.VirtualMachineSpec{
+			Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+				Spec: kubevirtv1.VirtualMachineInstanceSpec{
+					Domain: kubevirtv1.DomainSpec{
+						Devices: kubevirtv1.Devices{
+							HostDevices: []kubevirtv1.HostDevice{
+								{Name: node1dev1.Name, DeviceName: node1dev1.Status.ResourceName},
+								{Name: node1dev2.Name, DeviceName: node1dev1.Status.ResourceName},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "virt-launcher-demo-abcde",
+			Namespace: "default",
+			Labels: map[string]string{
+				"kubevirt.io": "virt-launcher",
+				VMLabel:       vm.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					// hook sidecars are listed before the compute container
+					Name: "hook-sidecar-0",
+				},
+				{
+					Name: computeContainerName,
+					Resources: corev1.ResourceRequirements{
+						Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+					},
+				},
+				{
+					Name:            "guest-console-log",
+					SecurityContext: &corev1.SecurityContext{},
+				},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		name                 string
+		configMaps           []runtime.Object
+		expectedCapabilities []corev1.Capability
+		expectedMemory       string
+	}{
+		{
+			name:                 "default mutation",
+			expectedCapabilities: []corev1.Capability{"SYS_RESOURCE"},
+			expectedMemory:       "2Gi",
+		},
+		{
+			name: "configured mutation",
+			configMaps: []runtime.Object{newPodMutationConfig(`
+- resourceName: fake.com/device1
+  capabilities: ["SYS_RESOURCE", "IPC_LOCK"]
+  resources:
+    memory: 512Mi
+`)},
+			expectedCapabilities: []corev1.Capability{"SYS_RESOURCE", "IPC_LOCK"},
+			expectedMemory:       "3Gi",
+		},
+		{
+			name:                 "invalid configuration",
+			configMaps:           []runtime.Object{newPodMutationConfig(`- capabilities: ["IPC_LOCK"]`)},
+			expectedCapabilities: []corev1.Capability{"SYS_RESOURCE"},
+			expectedMemory:       "2Gi",
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			k8sclient := k8sfake.NewSimpleClientset(v.configMaps...)
+			mutator := NewPodMutator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), fakeclients.VirtualMachineCache{vm},
+				fakeclients.ConfigMapCache(k8sclient.CoreV1().ConfigMaps), defaultNamespace)
+
+			patch, err := mutator.Create(nil, pod)
+			assert.NoError(err)
+			patched := applyPodPatch(t, pod, patch)
+
+			assert.Nil(patched.Spec.Containers[0].SecurityContext, "expected sidecars to be unchanged")
+			assert.Equal(pod.Spec.Containers[2], patched.Spec.Containers[2], "expected sidecars to be unchanged")
+			compute := patched.Spec.Containers[1]
+			assert.Equal(v.expectedCapabilities, compute.SecurityContext.Capabilities.Add)
+			assert.True(resource.MustParse(v.expectedMemory).Equal(compute.Resources.Requests[corev1.ResourceMemory]))
+			assert.True(resource.MustParse(v.expectedMemory).Equal(compute.Resources.Limits[corev1.ResourceMemory]))
+		})
+	}
+}
+
+func Test_PlainPodDeviceMutationConfig(t *testing.T) {
+	assert := require.New(t)
+	fakeClient := devicesfake.NewSimpleClientset(node1dev1, node1dev2)
+	k8sclient := k8sfake.NewSimpleClientset(newPodMutationConfig(`[{"resourceName": "fake.com/device1", "capabilities": ["IPC_LOCK"], "resources": {"memory": "1Gi"}}]`))
+	mutator := NewPodMutator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), fakeclients.VirtualMachineCache{},
+		fakeclients.ConfigMapCache(k8sclient.CoreV1().ConfigMaps), defaultNamespace)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpdk", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "dpdk",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{"fake.com/device1": resource.MustParse("2")},
+					},
+				},
+				{
+					Name: "spdk",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{"fake.com/device2": resource.MustParse("1")},
+					},
+				},
+			},
+		},
+	}
+
+	patch, err := mutator.Create(nil, pod)
+	assert.NoError(err)
+	patched := applyPodPatch(t, pod, patch)
+
+	assert.Equal([]corev1.Capability{"IPC_LOCK"}, patched.Spec.Containers[0].SecurityContext.Capabilities.Add)
+	assert.True(resource.MustParse("2Gi").Equal(patched.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory]))
+	assert.NotContains(patched.Spec.Containers[0].Resources.Limits, corev1.ResourceMemory, "expected no memory limit to be added")
+	assert.Equal(userspaceDriverCapabilities, patched.Spec.Containers[1].SecurityContext.Capabilities.Add, "expected the defaults for devices without configuration")
+	assert.Empty(patched.Spec.Containers[1].Resources.Requests)
+}
@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// PodMutationConfigName is the ConfigMap in the namespace of the webhook which configures the mutation of
+	// pods using PCI devices. Its config key holds a list of DeviceMutations in YAML or JSON
+	PodMutationConfigName = "pcidevices-pod-mutation"
+	podMutationConfigKey  = "config"
+)
+
+// DeviceMutation configures how containers using the PCI devices of ResourceName are mutated
+type DeviceMutation struct {
+	ResourceName string `json:"resourceName"`
+	// Capabilities replace the default capabilities added to containers using the devices
+	Capabilities []corev1.Capability `json:"capabilities,omitempty"`
+	// Resources are added for each device to the requests of containers using the devices, and to their
+	// limits if the container is limited, e.g. memory locked for DMA
+	Resources corev1.ResourceList `json:"resources,omitempty"`
+}
+
+// podMutationConfig holds the DeviceMutations by resourceName
+type podMutationConfig map[string]DeviceMutation
+
+// loadPodMutationConfig reads the DeviceMutations from the PodMutationConfigName ConfigMap. Pods are mutated
+// with the defaults if the ConfigMap is missing or invalid, as the webhook must not block pods
+func loadPodMutationConfig(configMapCache ctlcorev1.ConfigMapCache, namespace string) podMutationConfig {
+	config := podMutationConfig{}
+	if configMapCache == nil {
+		return config
+	}
+	cm, err := configMapCache.Get(namespace, PodMutationConfigName)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logrus.Errorf("error looking up configmap %s/%s: %v", namespace, PodMutationConfigName, err)
+		}
+		return config
+	}
+
+	mutations, err := parseDeviceMutations(cm.Data[podMutationConfigKey])
+	if err != nil {
+		logrus.Errorf("ignoring invalid configmap %s/%s: %v", namespace, PodMutationConfigName, err)
+		return config
+	}
+	for _, v := range mutations {
+		config[v.ResourceName] = v
+	}
+	return config
+}
+
+func parseDeviceMutations(data string) ([]DeviceMutation, error) {
+	var mutations []DeviceMutation
+	if data == "" {
+		return nil, nil
+	}
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewBufferString(data), len(data)).Decode(&mutations); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", podMutationConfigKey, err)
+	}
+	for _, v := range mutations {
+		if v.ResourceName == "" {
+			return nil, fmt.Errorf("resourceName of a device mutation is required")
+		}
+	}
+	return mutations, nil
+}
+
+// merge returns the capabilities and resources of a container using devices, which holds the number of
+// devices by resourceName. Devices without DeviceMutation get defaultCapabilities
+func (c podMutationConfig) merge(devices map[string]int64, defaultCapabilities []corev1.Capability) ([]corev1.Capability, corev1.ResourceList) {
+	resourceNames := make([]string, 0, len(devices))
+	for name := range devices {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+
+	var capabilities []corev1.Capability
+	resources := corev1.ResourceList{}
+	for _, name := range resourceNames {
+		mutation, ok := c[name]
+		if !ok {
+			capabilities = appendCapabilities(capabilities, defaultCapabilities)
+			continue
+		}
+		capabilities = appendCapabilities(capabilities, mutation.Capabilities)
+		for resourceName, quantity := range mutation.Resources {
+			total := resources[resourceName]
+			for i := int64(0); i < devices[name]; i++ {
+				total.Add(quantity)
+			}
+			resources[resourceName] = total
+		}
+	}
+	return capabilities, resources
+}
+
+func appendCapabilities(capabilities []corev1.Capability, add []corev1.Capability) []corev1.Capability {
+	for _, v := range add {
+		if !containsCapability(capabilities, v) {
+			capabilities = append(capabilities, v)
+		}
+	}
+	return capabilities
+}
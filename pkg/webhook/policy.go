@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	kubevirtctl "github.com/harvester/harvester/pkg/generated/controllers/kubevirt.io/v1"
+	werror "github.com/harvester/harvester/pkg/webhook/error"
+	"github.com/harvester/harvester/pkg/webhook/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+
+	devicesv1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+const systemMastersGroup = "system:masters"
+
+// requester is who a request for PCIDevices is evaluated for
+type requester struct {
+	user   string
+	groups []string
+	// namespace is where the devices are used, and is empty for PCIDeviceClaims. vmName is the VM whose spec
+	// is admitted, whose host devices are submitted again by the request, and is empty for other requests
+	namespace string
+	vmName    string
+}
+
+func newRequester(request *types.Request, namespace, vmName string) requester {
+	return requester{
+		user:      request.UserInfo.Username,
+		groups:    request.UserInfo.Groups,
+		namespace: namespace,
+		vmName:    vmName,
+	}
+}
+
+func (r requester) String() string {
+	if r.namespace != "" {
+		return fmt.Sprintf("user %s in namespace %s", r.user, r.namespace)
+	}
+	return fmt.Sprintf("user %s", r.user)
+}
+
+// policyChecker admits requests for PCIDevices against PCIDevicePolicies
+type policyChecker struct {
+	policyCache  v1beta1.PCIDevicePolicyCache
+	claimCache   v1beta1.PCIDeviceClaimCache
+	deviceCache  v1beta1.PCIDeviceCache
+	vmCache      kubevirtctl.VirtualMachineCache
+	hotplugCache v1beta1.PCIDeviceHotplugCache
+	// namespace of pcidevices, whose service accounts are trusted
+	namespace string
+}
+
+func newPolicyChecker(policyCache v1beta1.PCIDevicePolicyCache, claimCache v1beta1.PCIDeviceClaimCache,
+	deviceCache v1beta1.PCIDeviceCache, vmCache kubevirtctl.VirtualMachineCache, hotplugCache v1beta1.PCIDeviceHotplugCache,
+	namespace string) *policyChecker {
+	return &policyChecker{
+		policyCache:  policyCache,
+		claimCache:   claimCache,
+		deviceCache:  deviceCache,
+		vmCache:      vmCache,
+		hotplugCache: hotplugCache,
+		namespace:    namespace,
+	}
+}
+
+// newClientsPolicyChecker returns a policyChecker using the caches of clients
+func newClientsPolicyChecker(clients *Clients, options Options) *policyChecker {
+	return newPolicyChecker(clients.PCIFactory.Devices().V1beta1().PCIDevicePolicy().Cache(),
+		clients.PCIFactory.Devices().V1beta1().PCIDeviceClaim().Cache(),
+		clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(),
+		clients.KubevirtFactory.Kubevirt().V1().VirtualMachine().Cache(),
+		clients.PCIFactory.Devices().V1beta1().PCIDeviceHotplug().Cache(),
+		options.Namespace)
+}
+
+// trusted checks if the requester bypasses policies. Service accounts in the namespace of pcidevices
+// create claims on behalf of users, such as the claims for devices sharing an iommu group
+func (p *policyChecker) trusted(req requester) bool {
+	for _, group := range req.groups {
+		if group == systemMastersGroup || group == "system:serviceaccounts:"+p.namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// check admits the devices for the requester. Each device must be permitted by a policy with a subject
+// matching the requester, and which has not reached its cap for that subject. Policies only apply once
+// the first one is created, so clusters without policies are not affected
+func (p *policyChecker) check(req requester, devices []*devicesv1beta1.PCIDevice) error {
+	if len(devices) == 0 || p.trusted(req) {
+		return nil
+	}
+
+	policies, err := p.policyCache.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing pcidevicepolicies: %v", err)
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+
+	// added counts the devices of this request against each policy subject
+	added := make(map[string]int)
+	for _, pd := range devices {
+		if err := p.admitDevice(req, pd, policies, added); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *policyChecker) admitDevice(req requester, pd *devicesv1beta1.PCIDevice, policies []*devicesv1beta1.PCIDevicePolicy, added map[string]int) error {
+	var exceeded []string
+	for _, policy := range policies {
+		if !policy.Permits(pd) {
+			continue
+		}
+		for _, subject := range policy.Spec.Subjects {
+			if !subject.Matches(req.user, req.groups, req.namespace) {
+				continue
+			}
+			if policy.Spec.MaxDevices == nil {
+				return nil
+			}
+
+			held, counted, err := p.heldDevices(req, policy, subject)
+			if err != nil {
+				return err
+			}
+			if !counted {
+				return nil
+			}
+
+			key := fmt.Sprintf("%s/%s/%s", policy.Name, subject.Kind, subject.Name)
+			if held+added[key] < int(*policy.Spec.MaxDevices) {
+				added[key]++
+				return nil
+			}
+			exceeded = append(exceeded, fmt.Sprintf("%s allows %d devices for %s %s", policy.Name, *policy.Spec.MaxDevices, strings.ToLower(subject.Kind), subject.Name))
+		}
+	}
+
+	if len(exceeded) > 0 {
+		return werror.NewBadRequest(fmt.Sprintf("%s exceeds the device caps of pcidevicepolicies for pcidevice %s: %s", req, pd.Name, strings.Join(exceeded, "; ")))
+	}
+	return werror.NewBadRequest(fmt.Sprintf("%s is not permitted to use pcidevice %s (%s) by any pcidevicepolicy", req, pd.Name, pd.Status.ResourceName))
+}
+
+// heldDevices counts the devices permitted by policy which the subject already holds. Users and groups
+// are capped when claiming devices, groups by the claims of all their members, and namespaces when devices are added to their VMs, either in the spec
+// of a VM or by hot-plugging them, so counted is false if the subject is not capped for the request
+func (p *policyChecker) heldDevices(req requester, policy *devicesv1beta1.PCIDevicePolicy, subject devicesv1beta1.PolicySubject) (int, bool, error) {
+	var held int
+	switch {
+	case subject.Kind == devicesv1beta1.PolicySubjectNamespace && req.namespace != "":
+		vms, err := p.vmCache.List(req.namespace, labels.Everything())
+		if err != nil {
+			return 0, false, fmt.Errorf("error listing vms in namespace %s: %v", req.namespace, err)
+		}
+		// host devices are allocated by resourceName, and counted by it
+		for _, vm := range vms {
+			if vm.Name == req.vmName {
+				continue
+			}
+			for _, hostDevice := range vm.Spec.Template.Spec.Domain.Devices.HostDevices {
+				devices, err := p.deviceCache.GetByIndex(PCIDeviceByResourceName, hostDevice.DeviceName)
+				if err != nil {
+					return 0, false, fmt.Errorf("error listing pcidevices by resourceName %s: %v", hostDevice.DeviceName, err)
+				}
+				if len(devices) > 0 && policy.Permits(devices[0]) {
+					held++
+				}
+			}
+		}
+		hotplugged, err := p.hotpluggedDevices(req.namespace, policy)
+		if err != nil {
+			return 0, false, err
+		}
+		held += hotplugged
+	case subject.Kind != devicesv1beta1.PolicySubjectNamespace && req.namespace == "":
+		pdcs, err := p.claimCache.List(labels.Everything())
+		if err != nil {
+			return 0, false, fmt.Errorf("error listing pcideviceclaims: %v", err)
+		}
+		for _, pdc := range pdcs {
+			if !claimedBy(pdc, subject) {
+				continue
+			}
+			name := claimedDeviceName(pdc)
+			pd, err := p.deviceCache.Get(name)
+			if err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return 0, false, fmt.Errorf("error looking up pcidevice %s from cache: %v", name, err)
+			}
+			if policy.Permits(pd) {
+				held++
+			}
+		}
+	default:
+		return 0, false, nil
+	}
+	return held, true, nil
+}
+
+// hotpluggedDevices counts the devices permitted by policy which are hot-plugged into the VMIs of namespace.
+// Hot-plugged devices are not part of the VM spec, and count until their hotplug is deleted, unless it failed
+func (p *policyChecker) hotpluggedDevices(namespace string, policy *devicesv1beta1.PCIDevicePolicy) (int, error) {
+	hps, err := p.hotplugCache.List(labels.Everything())
+	if err != nil {
+		return 0, fmt.Errorf("error listing pcidevicehotplugs: %v", err)
+	}
+	var held int
+	for _, hp := range hps {
+		if hp.Spec.VMINamespace != namespace || hp.Status.Phase == devicesv1beta1.PCIDeviceHotplugFailed {
+			continue
+		}
+		pd, err := p.deviceCache.Get(hp.Spec.DeviceName)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, fmt.Errorf("error looking up pcidevice %s from cache: %v", hp.Spec.DeviceName, err)
+		}
+		if policy.Permits(pd) {
+			held++
+		}
+	}
+	return held, nil
+}
+
+// claimedBy checks if pdc counts against the cap of subject, which is a user or group. Claims count for
+// their userName, and for the groups their requester was a member of when creating them
+func claimedBy(pdc *devicesv1beta1.PCIDeviceClaim, subject devicesv1beta1.PolicySubject) bool {
+	return subject.Matches(pdc.Spec.UserName, requesterGroups(pdc), "")
+}
+
+// requesterGroups returns the groups recorded in the requester-groups annotation of pdc
+func requesterGroups(pdc *devicesv1beta1.PCIDeviceClaim) []string {
+	value := pdc.Annotations[devicesv1beta1.RequesterGroupsAnnotation]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// claimedDeviceName returns the name of the PCIDevice owning a claim, which matches the claim name
+// for claims created by the UI
+func claimedDeviceName(pdc *devicesv1beta1.PCIDeviceClaim) string {
+	if len(pdc.OwnerReferences) > 0 {
+		return pdc.OwnerReferences[0].Name
+	}
+	return pdc.Name
+}
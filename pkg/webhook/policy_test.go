@@ -0,0 +1,527 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/harvester/harvester/pkg/webhook/types"
+	"github.com/rancher/wrangler/pkg/webhook"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	devicesv1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func newPolicy(name string, maxDevices *int32, resourceNames []string, classIDs []string, subjects ...devicesv1beta1.PolicySubject) *devicesv1beta1.PCIDevicePolicy {
+	return &devicesv1beta1.PCIDevicePolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: devicesv1beta1.PCIDevicePolicySpec{
+			Subjects:      subjects,
+			ResourceNames: resourceNames,
+			ClassIDs:      classIDs,
+			MaxDevices:    maxDevices,
+		},
+	}
+}
+
+func newUserClaim(pd *devicesv1beta1.PCIDevice, userName string) *devicesv1beta1.PCIDeviceClaim {
+	return &devicesv1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pd.Name,
+		},
+		Spec: devicesv1beta1.PCIDeviceClaimSpec{
+			UserName: userName,
+			NodeName: pd.Status.NodeName,
+			Address:  pd.Status.Address,
+		},
+	}
+}
+
+// newGroupClaim claims pd for userName as a member of groups
+func newGroupClaim(pd *devicesv1beta1.PCIDevice, userName string, groups string) *devicesv1beta1.PCIDeviceClaim {
+	pdc := newUserClaim(pd, userName)
+	pdc.Annotations = map[string]string{devicesv1beta1.RequesterGroupsAnnotation: groups}
+	return pdc
+}
+
+func newVMWithDevices(namespace, name string, pds ...*devicesv1beta1.PCIDevice) *kubevirtv1.VirtualMachine {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: kubevirtv1.VirtualMachineSpec{
+			Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{},
+		},
+	}
+	for _, pd := range pds {
+		vm.Spec.Template.Spec.Domain.Devices.HostDevices = append(vm.Spec.Template.Spec.Domain.Devices.HostDevices, kubevirtv1.HostDevice{
+			Name:       pd.Name,
+			DeviceName: pd.Status.ResourceName,
+		})
+	}
+	return vm
+}
+
+// renameHostDevices names the host devices of vm, which still allocate devices by their resourceName
+func renameHostDevices(vm *kubevirtv1.VirtualMachine, name string) *kubevirtv1.VirtualMachine {
+	for i := range vm.Spec.Template.Spec.Domain.Devices.HostDevices {
+		vm.Spec.Template.Spec.Domain.Devices.HostDevices[i].Name = name
+	}
+	return vm
+}
+
+func newTestPolicyChecker(objs []runtime.Object, vms ...*kubevirtv1.VirtualMachine) *policyChecker {
+	fakeClient := fake.NewSimpleClientset(objs...)
+	return newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies),
+		fakeclients.PCIDeviceClaimsCache(fakeClient.DevicesV1beta1().PCIDeviceClaims),
+		fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices),
+		fakeclients.VirtualMachineCache(vms), fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace)
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func Test_PolicyCheck(t *testing.T) {
+	alice := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectUser, Name: "alice"}
+	developers := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectGroup, Name: "developers"}
+	projectA := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectNamespace, Name: "project-a"}
+
+	var testCases = []struct {
+		name        string
+		objs        []runtime.Object
+		vms         []*kubevirtv1.VirtualMachine
+		req         requester
+		devices     []*devicesv1beta1.PCIDevice
+		expectError bool
+	}{
+		{
+			name:    "no policies permit all devices",
+			objs:    []runtime.Object{node1dev1},
+			req:     requester{user: "alice"},
+			devices: []*devicesv1beta1.PCIDevice{node1dev1},
+		},
+		{
+			name:    "policy permits resourceName",
+			objs:    []runtime.Object{node1dev1, newPolicy("alice", nil, []string{"fake.com/device1"}, nil, alice)},
+			req:     requester{user: "alice"},
+			devices: []*devicesv1beta1.PCIDevice{node1dev1},
+		},
+		{
+			name:        "policy does not permit resourceName",
+			objs:        []runtime.Object{node1dev3, newPolicy("alice", nil, []string{"fake.com/device1"}, nil, alice)},
+			req:         requester{user: "alice"},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev3},
+			expectError: true,
+		},
+		{
+			name:    "policy permits class id prefix",
+			objs:    []runtime.Object{node1dev3, newPolicy("gpus", nil, nil, []string{"03"}, developers)},
+			req:     requester{user: "bob", groups: []string{"developers"}},
+			devices: []*devicesv1beta1.PCIDevice{node1dev3},
+		},
+		{
+			name:        "policy of other subjects does not apply",
+			objs:        []runtime.Object{node1dev1, newPolicy("alice", nil, nil, nil, alice)},
+			req:         requester{user: "bob"},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev1},
+			expectError: true,
+		},
+		{
+			name: "user reached cap",
+			objs: []runtime.Object{node1dev1, node1dev2, newUserClaim(node1dev1, "alice"),
+				newPolicy("alice", int32Ptr(1), nil, []string{"02"}, alice)},
+			req:         requester{user: "alice"},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev2},
+			expectError: true,
+		},
+		{
+			name: "devices not permitted by policy do not count towards cap",
+			objs: []runtime.Object{node1dev1, node1dev3, newUserClaim(node1dev3, "alice"),
+				newPolicy("alice", int32Ptr(1), nil, []string{"02"}, alice),
+				newPolicy("gpus", nil, nil, []string{"03"}, alice)},
+			req:     requester{user: "alice"},
+			devices: []*devicesv1beta1.PCIDevice{node1dev1},
+		},
+		{
+			name: "group cap counts the claims of all members",
+			objs: []runtime.Object{node1dev1, node1dev2, newGroupClaim(node1dev1, "alice", "admins,developers"),
+				newPolicy("developers", int32Ptr(1), nil, nil, developers)},
+			req:         requester{user: "bob", groups: []string{"developers"}},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev2},
+			expectError: true,
+		},
+		{
+			name: "claims requested outside the group do not count towards group cap",
+			objs: []runtime.Object{node1dev1, node1dev2, newUserClaim(node1dev1, "alice"), newGroupClaim(node1dev3, "bob", "admins"),
+				newPolicy("developers", int32Ptr(1), nil, nil, developers)},
+			req:     requester{user: "bob", groups: []string{"developers"}},
+			devices: []*devicesv1beta1.PCIDevice{node1dev2},
+		},
+		{
+			name: "namespace reached cap",
+			objs: []runtime.Object{node1dev1, node1dev2, newPolicy("project-a", int32Ptr(1), nil, nil, projectA)},
+			vms: []*kubevirtv1.VirtualMachine{
+				newVMWithDevices("project-a", "vm1", node1dev1),
+			},
+			req:         requester{user: "alice", namespace: "project-a", vmName: "vm2"},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev2},
+			expectError: true,
+		},
+		{
+			name: "namespace cap excludes the updated vm",
+			objs: []runtime.Object{node1dev1, node1dev2, newPolicy("project-a", int32Ptr(2), nil, nil, projectA)},
+			vms: []*kubevirtv1.VirtualMachine{
+				newVMWithDevices("project-a", "vm1", node1dev1),
+				newVMWithDevices("other", "vm2", node1dev2),
+			},
+			req:     requester{user: "alice", namespace: "project-a", vmName: "vm1"},
+			devices: []*devicesv1beta1.PCIDevice{node1dev1, node1dev2},
+		},
+		{
+			name: "namespace cap counts host devices by resourceName",
+			objs: []runtime.Object{node1dev1, node1dev2, newPolicy("project-a", int32Ptr(1), nil, nil, projectA)},
+			vms: []*kubevirtv1.VirtualMachine{
+				renameHostDevices(newVMWithDevices("project-a", "vm1", node1dev1), "foo"),
+			},
+			req:         requester{user: "alice", namespace: "project-a", vmName: "vm2"},
+			devices:     []*devicesv1beta1.PCIDevice{node1dev2},
+			expectError: true,
+		},
+		{
+			name:    "trusted service accounts bypass policies",
+			objs:    []runtime.Object{node1dev1, newPolicy("alice", nil, nil, nil, alice)},
+			req:     requester{user: "system:serviceaccount:harvester-system:pcidevices", groups: []string{"system:serviceaccounts:harvester-system"}},
+			devices: []*devicesv1beta1.PCIDevice{node1dev1},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			policy := newTestPolicyChecker(v.objs, v.vms...)
+			err := policy.check(v.req, v.devices)
+			if v.expectError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func Test_VMValidatorChecksPolicyByResourceName(t *testing.T) {
+	alice := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectUser, Name: "alice"}
+	objs := []runtime.Object{node1dev1, node1dev3, newPolicy("alice", nil, []string{"fake.com/device1"}, nil, alice)}
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		name        string
+		vm          *kubevirtv1.VirtualMachine
+		expectError bool
+	}{
+		{
+			name: "permitted device",
+			vm:   newVMWithDevices("default", "vm1", node1dev1),
+		},
+		{
+			name:        "restricted resourceName under another name",
+			vm:          renameHostDevices(newVMWithDevices("default", "vm1", node1dev3), "foo"),
+			expectError: true,
+		},
+		{
+			name:        "permitted name with restricted resourceName",
+			vm:          renameHostDevices(newVMWithDevices("default", "vm1", node1dev3), node1dev1.Name),
+			expectError: true,
+		},
+		{
+			name: "host devices not served by pcidevices",
+			vm: &kubevirtv1.VirtualMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "default"},
+				Spec: kubevirtv1.VirtualMachineSpec{
+					Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+						Spec: kubevirtv1.VirtualMachineInstanceSpec{
+							Domain: kubevirtv1.DomainSpec{
+								Devices: kubevirtv1.Devices{
+									HostDevices: []kubevirtv1.HostDevice{{Name: "usb", DeviceName: "kubevirt.io/usb"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			fakeClient := fake.NewSimpleClientset(objs...)
+			validator := NewPCIVMValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs))
+			err := validator.Create(request, v.vm)
+			if v.expectError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			// updates are only checked if they change the host devices
+			assert.NoError(validator.Update(request, v.vm, v.vm.DeepCopy()))
+			err = validator.Update(request, newVMWithDevices("default", "vm1"), v.vm)
+			if v.expectError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func Test_ClaimMutatorRecordsRequester(t *testing.T) {
+	assert := require.New(t)
+	mutator := NewPCIDeviceClaimMutator(newTestPolicyChecker(nil))
+
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+	patchOps, err := mutator.Create(request, newUserClaim(node1dev1, "admin"))
+	assert.NoError(err)
+	assert.Equal(types.PatchOps{
+		`{"op": "add", "path": "/metadata/annotations", "value": {"devices.harvesterhci.io/requested-by":"alice","devices.harvesterhci.io/requester-groups":""}}`,
+		`{"op": "add", "path": "/spec/userName", "value": "alice"}`,
+	}, patchOps)
+
+	patchOps, err = mutator.Create(request, newUserClaim(node1dev1, "alice"))
+	assert.NoError(err)
+	assert.Equal(types.PatchOps{
+		`{"op": "add", "path": "/metadata/annotations", "value": {"devices.harvesterhci.io/requested-by":"alice","devices.harvesterhci.io/requester-groups":""}}`,
+	}, patchOps, "expected no userName patch for claims of the requester")
+
+	pdc := newGroupClaim(node1dev1, "alice", "admins")
+	pdc.Annotations[devicesv1beta1.RequestedByAnnotation] = "mallory"
+	request.UserInfo.Groups = []string{"developers", "system:authenticated"}
+	patchOps, err = mutator.Create(request, pdc)
+	assert.NoError(err)
+	assert.Equal(types.PatchOps{
+		`{"op": "add", "path": "/metadata/annotations/devices.harvesterhci.io~1requested-by", "value": "alice"}`,
+		`{"op": "add", "path": "/metadata/annotations/devices.harvesterhci.io~1requester-groups", "value": "developers,system:authenticated"}`,
+	}, patchOps, "expected the requester to replace the requester annotations chosen by the user")
+}
+
+func Test_ClaimValidator(t *testing.T) {
+	assert := require.New(t)
+	alice := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectUser, Name: "alice"}
+	objs := []runtime.Object{node1dev1, node1dev3, newPolicy("alice", nil, []string{"fake.com/device1"}, nil, alice)}
+	fakeClient := fake.NewSimpleClientset(objs...)
+	validator := NewPCIDeviceClaimValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs))
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+
+	assert.NoError(validator.Create(request, newUserClaim(node1dev1, "alice")))
+	assert.Error(validator.Create(request, newUserClaim(node1dev3, "alice")), "expected device not permitted for the user to be refused")
+	missing := newUserClaim(node1dev1, "alice")
+	missing.Name = "missing"
+	assert.Error(validator.Create(request, missing), "expected claims of unknown devices to be refused")
+}
+
+func Test_ClaimValidatorRefusesOwnerChanges(t *testing.T) {
+	assert := require.New(t)
+	objs := []runtime.Object{node1dev1}
+	fakeClient := fake.NewSimpleClientset(objs...)
+	validator := NewPCIDeviceClaimValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs))
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+
+	pdc := newGroupClaim(node1dev1, "alice", "developers")
+	pdc.Annotations[devicesv1beta1.RequestedByAnnotation] = "alice"
+
+	updated := pdc.DeepCopy()
+	updated.Spec.UserName = "bob"
+	assert.Error(validator.Update(request, pdc, updated), "expected the userName to be immutable")
+
+	updated = pdc.DeepCopy()
+	updated.Annotations[devicesv1beta1.RequestedByAnnotation] = "bob"
+	assert.Error(validator.Update(request, pdc, updated), "expected the requester to be immutable")
+
+	updated = pdc.DeepCopy()
+	delete(updated.Annotations, devicesv1beta1.RequestedByAnnotation)
+	assert.Error(validator.Update(request, pdc, updated), "expected the requester to not be removed")
+
+	updated = pdc.DeepCopy()
+	updated.Annotations[devicesv1beta1.RequesterGroupsAnnotation] = "admins"
+	assert.Error(validator.Update(request, pdc, updated), "expected the requester groups to be immutable")
+
+	updated = pdc.DeepCopy()
+	updated.Labels = map[string]string{"team": "ml"}
+	assert.NoError(validator.Update(request, pdc, updated))
+}
+
+func Test_HotplugMutator(t *testing.T) {
+	assert := require.New(t)
+	projectA := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectNamespace, Name: "project-a"}
+	objs := []runtime.Object{node1dev1, node1dev3, newPolicy("project-a", nil, []string{"fake.com/device1"}, nil, projectA)}
+	fakeClient := fake.NewSimpleClientset(objs...)
+	mutator := NewPCIDeviceHotplugMutator(newTestPolicyChecker(objs))
+	validator := NewPCIDeviceHotplugValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs))
+
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+	newHotplug := func(pd *devicesv1beta1.PCIDevice) *devicesv1beta1.PCIDeviceHotplug {
+		return &devicesv1beta1.PCIDeviceHotplug{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "notebook-" + pd.Name,
+			},
+			Spec: devicesv1beta1.PCIDeviceHotplugSpec{
+				DeviceName:   pd.Name,
+				VMINamespace: "project-a",
+				VMIName:      "notebook",
+			},
+		}
+	}
+
+	patchOps, err := mutator.Create(request, newHotplug(node1dev1))
+	assert.NoError(err)
+	assert.Equal(types.PatchOps{
+		`{"op": "add", "path": "/metadata/annotations", "value": {"devices.harvesterhci.io/requested-by":"alice"}}`,
+	}, patchOps)
+
+	assert.NoError(validator.Create(request, newHotplug(node1dev1)))
+	assert.Error(validator.Create(request, newHotplug(node1dev3)), "expected device not permitted for the namespace of the vmi to be refused")
+
+	hp := newHotplug(node1dev1)
+	hp.Spec.DeviceName = "missing"
+	assert.Error(validator.Create(request, hp), "expected hotplug of unknown device to be refused")
+
+	moved := newHotplug(node1dev1)
+	moved.Spec.VMIName = "other"
+	assert.Error(validator.Update(request, newHotplug(node1dev1), moved), "expected spec changes to be refused")
+}
+
+func Test_HotplugValidatorCountsHeldDevices(t *testing.T) {
+	assert := require.New(t)
+	projectA := devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectNamespace, Name: "project-a"}
+	policy := newPolicy("project-a", int32Ptr(2), []string{"fake.com/device1", "fake.com/device2"}, nil, projectA)
+	attached := &devicesv1beta1.PCIDeviceHotplug{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "notebook-node1dev2",
+		},
+		Spec: devicesv1beta1.PCIDeviceHotplugSpec{
+			DeviceName:   node1dev2.Name,
+			VMINamespace: "project-a",
+			VMIName:      "notebook",
+		},
+		Status: devicesv1beta1.PCIDeviceHotplugStatus{
+			Phase: devicesv1beta1.PCIDeviceHotplugAttached,
+		},
+	}
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			},
+		},
+	}
+	hp := &devicesv1beta1.PCIDeviceHotplug{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "notebook-node1dev1",
+		},
+		Spec: devicesv1beta1.PCIDeviceHotplugSpec{
+			DeviceName:   node1dev1.Name,
+			VMINamespace: "project-a",
+			VMIName:      "notebook",
+		},
+	}
+	// the vm of the hotplug holds a device in its spec, and another one is hot-plugged
+	vm := newVMWithDevices("project-a", "notebook", node1dev1)
+
+	objs := []runtime.Object{node1dev1, node1dev2, policy, attached}
+	fakeClient := fake.NewSimpleClientset(objs...)
+	validator := NewPCIDeviceHotplugValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs, vm))
+	assert.Error(validator.Create(request, hp), "expected the devices in the spec of the vm and hot-plugged devices to count against the cap")
+
+	failed := attached.DeepCopy()
+	failed.Status.Phase = devicesv1beta1.PCIDeviceHotplugFailed
+	objs = []runtime.Object{node1dev1, node1dev2, policy, failed}
+	validator = NewPCIDeviceHotplugValidator(fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices), newTestPolicyChecker(objs, vm))
+	assert.NoError(validator.Create(request, hp), "expected failed hotplugs not to count against the cap")
+}
+
+func Test_PCIDevicePolicyValidator(t *testing.T) {
+	validator := NewPCIDevicePolicyValidator()
+	var testCases = []struct {
+		name        string
+		policy      *devicesv1beta1.PCIDevicePolicy
+		expectError bool
+	}{
+		{
+			name:   "valid policy",
+			policy: newPolicy("gpus", int32Ptr(1), nil, []string{"03"}, devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectGroup, Name: "gpu-users"}),
+		},
+		{
+			name:        "no subjects",
+			policy:      newPolicy("gpus", nil, nil, nil),
+			expectError: true,
+		},
+		{
+			name:        "unknown subject kind",
+			policy:      newPolicy("gpus", nil, nil, nil, devicesv1beta1.PolicySubject{Kind: "ServiceAccount", Name: "alice"}),
+			expectError: true,
+		},
+		{
+			name:        "subject without name",
+			policy:      newPolicy("gpus", nil, nil, nil, devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectUser}),
+			expectError: true,
+		},
+		{
+			name:        "negative cap",
+			policy:      newPolicy("gpus", int32Ptr(-1), nil, nil, devicesv1beta1.PolicySubject{Kind: devicesv1beta1.PolicySubjectUser, Name: "alice"}),
+			expectError: true,
+		},
+	}
+
+	for _, v := range testCases {
+		t.Run(v.name, func(t *testing.T) {
+			assert := require.New(t)
+			err := validator.Create(nil, v.policy)
+			if v.expectError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(err, validator.Update(nil, v.policy, v.policy))
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"time"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/harvester/harvester/pkg/webhook/types"
@@ -14,38 +14,109 @@ import (
 	v1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 )
 
+const (
+	defaultNamespace   = "harvester-system"
+	defaultServiceName = "pcidevices-webhook"
+	defaultPort        = 8443
+	// servicePort is the port of the webhook service of the manifest, which targets the webhook port of the
+	// container, so the port the webhook listens on can change without changing the service
+	servicePort = 8443
+)
+
 var (
 	certName = "pcidevices-webhook-tls"
 	caName   = "pcidevices-webhook-ca"
-	port     = int32(8443)
 
 	mutationPath        = "/v1/webhook/mutation"
-	failPolicyIgnore    = v1.Ignore
+	validationPath      = "/v1/webhook/validation"
+	readyPath           = "/v1/webhook/ready"
 	sideEffectClassNone = v1.SideEffectClassNone
-	namespace           = "harvester-system"
 	threadiness         = 5
 	MutatorName         = "pcidevices-mutator"
+	ValidatorName       = "pcidevices-validator"
 )
 
-// AdmissionWebhookServer serves the mutating webhook for pcidevices
+// Options configure the webhook server, and the webhook configurations the API server calls it with
+type Options struct {
+	// Namespace of the webhook service, which holds the certificates of the webhook
+	Namespace string
+	// ServiceName is the service the API server calls the webhook with
+	ServiceName string
+	// Port the webhook listens on, which the service targets
+	Port int
+	// FailurePolicy of the mutating webhooks, Ignore or Fail
+	FailurePolicy string
+	// ValidationFailurePolicy of the validating webhook, Ignore or Fail. It enforces PCIDevicePolicies,
+	// so requests are refused while the webhook is down by default
+	ValidationFailurePolicy string
+}
+
+// DefaultOptions serve the webhook in the namespace of Harvester
+func DefaultOptions() Options {
+	return Options{
+		Namespace:               defaultNamespace,
+		ServiceName:             defaultServiceName,
+		Port:                    defaultPort,
+		FailurePolicy:           string(v1.Ignore),
+		ValidationFailurePolicy: string(v1.Fail),
+	}
+}
+
+// Validate checks the options are complete
+func (o Options) Validate() error {
+	if o.Namespace == "" || o.ServiceName == "" {
+		return fmt.Errorf("namespace and service name of the webhook are required")
+	}
+	if o.Port <= 0 || o.Port > 65535 {
+		return fmt.Errorf("invalid webhook port %d", o.Port)
+	}
+	for _, policy := range []string{o.FailurePolicy, o.ValidationFailurePolicy} {
+		if policy != string(v1.Ignore) && policy != string(v1.Fail) {
+			return fmt.Errorf("invalid webhook failure policy %s, expected %s or %s", policy, v1.Ignore, v1.Fail)
+		}
+	}
+	return nil
+}
+
+// AdmissionWebhookServer serves the mutating and validating webhooks for pcidevices
 type AdmissionWebhookServer struct {
 	context    context.Context
 	restConfig *rest.Config
+	options    Options
+
+	// serving is closed once the server listens and the caches of the clients are synced
+	serving chan struct{}
+	// ready is closed once the webhook configurations are applied
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 // New helps initialise a new AdmissionWebhookServer
-func New(ctx context.Context, restConfig *rest.Config) *AdmissionWebhookServer {
+func New(ctx context.Context, restConfig *rest.Config, options Options) *AdmissionWebhookServer {
 	return &AdmissionWebhookServer{
 		context:    ctx,
 		restConfig: restConfig,
+		options:    options,
+		serving:    make(chan struct{}),
+		ready:      make(chan struct{}),
 	}
 }
 
+// Ready is closed once the webhook serves requests and the API server is configured to call it
+func (s *AdmissionWebhookServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
 // ListenAndServe starts the http listener and handlers
 func (s *AdmissionWebhookServer) ListenAndServe() error {
+	if err := s.options.Validate(); err != nil {
+		return err
+	}
+
 	clients, err := NewClient(s.context, s.restConfig, threadiness)
 	if err != nil {
 		return err
@@ -53,68 +124,70 @@ func (s *AdmissionWebhookServer) ListenAndServe() error {
 
 	RegisterIndexers(clients)
 
-	mutationHandler, mutationResources, err := Mutation(clients)
+	mutationHandler, mutationResources, err := Mutation(clients, s.options)
+	if err != nil {
+		return err
+	}
+
+	validationHandler, validationResources, err := Validation(clients, s.options)
 	if err != nil {
 		return err
 	}
 
 	router := mux.NewRouter()
 	router.Handle(mutationPath, mutationHandler)
-	if err := s.listenAndServe(clients, router, mutationResources); err != nil {
+	router.Handle(validationPath, validationHandler)
+	router.HandleFunc(readyPath, s.serveReady)
+	if err := s.listenAndServe(clients, router, validationResources, mutationResources); err != nil {
 		return err
 	}
 
 	if err := clients.Start(s.context); err != nil {
 		return err
 	}
+	close(s.serving)
 	return nil
 }
 
-func (s *AdmissionWebhookServer) listenAndServe(clients *Clients, handler http.Handler, mutationResources []types.Resource) error {
+// serveReady reports if the webhook configurations are applied, for readiness probes
+func (s *AdmissionWebhookServer) serveReady(rw http.ResponseWriter, _ *http.Request) {
+	select {
+	case <-s.ready:
+		rw.WriteHeader(http.StatusOK)
+	default:
+		http.Error(rw, "webhook configuration not applied yet", http.StatusServiceUnavailable)
+	}
+}
+
+func (s *AdmissionWebhookServer) listenAndServe(clients *Clients, handler http.Handler, validationResources []types.Resource, mutationResources []types.Resource) error {
 	apply := clients.Apply.WithDynamicLookup()
 	clients.Core.Secret().OnChange(s.context, "secrets", func(key string, secret *corev1.Secret) (*corev1.Secret, error) {
-		if secret == nil || secret.Name != caName || secret.Namespace != namespace || len(secret.Data[corev1.TLSCertKey]) == 0 {
+		if secret == nil || secret.Name != caName || secret.Namespace != s.options.Namespace || len(secret.Data[corev1.TLSCertKey]) == 0 {
 			return nil, nil
 		}
-		logrus.Info("Sleeping for 15 seconds then applying webhook config")
-		// Sleep here to make sure server is listening and all caches are primed
-		time.Sleep(15 * time.Second)
-
-		logrus.Debugf("Building mutation rules...")
-		mutationRules := s.buildRules(mutationResources)
-
-		mutatingWebhookConfiguration := &v1.MutatingWebhookConfiguration{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: MutatorName,
-			},
-			Webhooks: []v1.MutatingWebhook{
-				{
-					Name: "pcidevices.harvesterhci.io",
-					ClientConfig: v1.WebhookClientConfig{
-						Service: &v1.ServiceReference{
-							Namespace: namespace,
-							Name:      "pcidevices-webhook",
-							Path:      &mutationPath,
-							Port:      &port,
-						},
-						CABundle: secret.Data[corev1.TLSCertKey],
-					},
-					Rules:                   mutationRules,
-					FailurePolicy:           &failPolicyIgnore,
-					SideEffects:             &sideEffectClassNone,
-					AdmissionReviewVersions: []string{"v1", "v1beta1"},
-				},
-			},
+		// the API server is only pointed to the webhook once it listens and all caches are primed
+		select {
+		case <-s.serving:
+		case <-s.context.Done():
+			return secret, s.context.Err()
 		}
 
-		return secret, apply.WithOwner(secret).ApplyObjects(mutatingWebhookConfiguration)
+		objs := s.webhookConfigurations(secret.Data[corev1.TLSCertKey], mutationResources, validationResources)
+		if err := apply.WithOwner(secret).ApplyObjects(objs...); err != nil {
+			return secret, err
+		}
+		s.readyOnce.Do(func() {
+			logrus.Info("webhook configurations applied")
+			close(s.ready)
+		})
+		return secret, nil
 	})
 
-	tlsName := fmt.Sprintf("pcidevices-webhook.%s.svc", namespace)
+	tlsName := fmt.Sprintf("%s.%s.svc", s.options.ServiceName, s.options.Namespace)
 
-	return server.ListenAndServe(s.context, int(port), 0, handler, &server.ListenOpts{
+	return server.ListenAndServe(s.context, s.options.Port, 0, handler, &server.ListenOpts{
 		Secrets:       clients.Core.Secret(),
-		CertNamespace: namespace,
+		CertNamespace: s.options.Namespace,
 		CertName:      certName,
 		CAName:        caName,
 		TLSListenerConfig: dynamiclistener.Config{
@@ -126,6 +199,104 @@ func (s *AdmissionWebhookServer) listenAndServe(clients *Clients, handler http.H
 	})
 }
 
+// webhookConfigurations returns the webhook configurations pointing the API server to the webhook service
+func (s *AdmissionWebhookServer) webhookConfigurations(caBundle []byte, mutationResources, validationResources []types.Resource) []runtime.Object {
+	logrus.Debugf("Building mutation rules...")
+	podResources, otherResources := splitPodResources(mutationResources)
+	logrus.Debugf("Building validation rules...")
+	validationRules := s.buildRules(validationResources)
+
+	port := int32(servicePort)
+	failurePolicy := v1.FailurePolicyType(s.options.FailurePolicy)
+	validationFailurePolicy := v1.FailurePolicyType(s.options.ValidationFailurePolicy)
+	clientConfig := func(path *string) v1.WebhookClientConfig {
+		return v1.WebhookClientConfig{
+			Service: &v1.ServiceReference{
+				Namespace: s.options.Namespace,
+				Name:      s.options.ServiceName,
+				Path:      path,
+				Port:      &port,
+			},
+			CABundle: caBundle,
+		}
+	}
+	mutatingWebhookConfiguration := &v1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: MutatorName,
+		},
+		Webhooks: []v1.MutatingWebhook{
+			{
+				Name:                    "pcidevices.harvesterhci.io",
+				ClientConfig:            clientConfig(&mutationPath),
+				Rules:                   s.buildRules(otherResources),
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffectClassNone,
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			},
+			{
+				// the webhook intercepts all pods, so the pods of the webhook itself and of the
+				// control plane are excluded, otherwise they could not be recreated while the webhook
+				// is down with the Fail policy
+				Name:                    "pods.pcidevices.harvesterhci.io",
+				ClientConfig:            clientConfig(&mutationPath),
+				Rules:                   s.buildRules(podResources),
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffectClassNone,
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+				NamespaceSelector:       s.podNamespaceSelector(),
+			},
+		},
+	}
+	objs := []runtime.Object{mutatingWebhookConfiguration}
+
+	if len(validationRules) > 0 {
+		validatingWebhookConfiguration := &v1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ValidatorName,
+			},
+			Webhooks: []v1.ValidatingWebhook{
+				{
+					Name:                    "validator.pcidevices.harvesterhci.io",
+					ClientConfig:            clientConfig(&validationPath),
+					Rules:                   validationRules,
+					FailurePolicy:           &validationFailurePolicy,
+					SideEffects:             &sideEffectClassNone,
+					AdmissionReviewVersions: []string{"v1", "v1beta1"},
+				},
+			},
+		}
+		objs = append(objs, validatingWebhookConfiguration)
+	}
+
+	return objs
+}
+
+// podNamespaceSelector excludes the namespace of the webhook and kube-system from the pod webhook
+func (s *AdmissionWebhookServer) podNamespaceSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      corev1.LabelMetadataName,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{s.options.Namespace, metav1.NamespaceSystem},
+			},
+		},
+	}
+}
+
+// splitPodResources separates the pod resources, which the webhook intercepts in all namespaces
+func splitPodResources(resources []types.Resource) ([]types.Resource, []types.Resource) {
+	var pods, others []types.Resource
+	for _, rsc := range resources {
+		if rsc.APIGroup == corev1.GroupName && len(rsc.Names) == 1 && rsc.Names[0] == string(corev1.ResourcePods) {
+			pods = append(pods, rsc)
+		} else {
+			others = append(others, rsc)
+		}
+	}
+	return pods, others
+}
+
 func (s *AdmissionWebhookServer) buildRules(resources []types.Resource) []v1.RuleWithOperations {
 	rules := []v1.RuleWithOperations{}
 	for _, rsc := range resources {
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/webhook/types"
+	"github.com/stretchr/testify/require"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func Test_OptionsValidate(t *testing.T) {
+	assert := require.New(t)
+	assert.NoError(DefaultOptions().Validate())
+
+	options := DefaultOptions()
+	options.Namespace = "pcidevices-system"
+	options.FailurePolicy = "Fail"
+	assert.NoError(options.Validate())
+
+	options.FailurePolicy = "Retry"
+	assert.Error(options.Validate(), "expected unknown failure policies to be refused")
+
+	options = DefaultOptions()
+	options.ValidationFailurePolicy = "Retry"
+	assert.Error(options.Validate(), "expected unknown validation failure policies to be refused")
+
+	options = DefaultOptions()
+	options.Port = 0
+	assert.Error(options.Validate(), "expected invalid ports to be refused")
+
+	options = DefaultOptions()
+	options.ServiceName = ""
+	assert.Error(options.Validate(), "expected the service name to be required")
+}
+
+func Test_ServeReady(t *testing.T) {
+	assert := require.New(t)
+	s := New(context.TODO(), nil, DefaultOptions())
+
+	rec := httptest.NewRecorder()
+	s.serveReady(rec, httptest.NewRequest(http.MethodGet, readyPath, nil))
+	assert.Equal(http.StatusServiceUnavailable, rec.Code, "expected the webhook to not be ready before its configuration is applied")
+
+	s.readyOnce.Do(func() { close(s.ready) })
+	rec = httptest.NewRecorder()
+	s.serveReady(rec, httptest.NewRequest(http.MethodGet, readyPath, nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	select {
+	case <-s.Ready():
+	default:
+		assert.Fail("expected Ready to be closed")
+	}
+}
+
+func Test_WebhookConfigurationsExcludeNamespacesFromPods(t *testing.T) {
+	assert := require.New(t)
+	options := DefaultOptions()
+	options.FailurePolicy = string(admissionregv1.Fail)
+	options.ValidationFailurePolicy = string(admissionregv1.Ignore)
+	options.Port = 9443
+	s := New(context.TODO(), nil, options)
+
+	mutationResources := []types.Resource{
+		NewPodMutator(nil, nil, nil, options.Namespace).Resource(),
+		NewPCIDeviceClaimMutator(nil).Resource(),
+	}
+	validationResources := []types.Resource{NewPCIDevicePolicyValidator().Resource()}
+	objs := s.webhookConfigurations([]byte("ca"), mutationResources, validationResources)
+	assert.Len(objs, 2)
+
+	mutating, ok := objs[0].(*admissionregv1.MutatingWebhookConfiguration)
+	assert.True(ok, "expected a mutating webhook configuration")
+	assert.Len(mutating.Webhooks, 2)
+
+	others, pods := mutating.Webhooks[0], mutating.Webhooks[1]
+	assert.Nil(others.NamespaceSelector, "expected cluster scoped resources to not be filtered by namespace")
+	assert.Len(others.Rules, 1)
+	assert.Equal([]string{"pcideviceclaims"}, others.Rules[0].Resources)
+
+	assert.Len(pods.Rules, 1)
+	assert.Equal([]string{"pods"}, pods.Rules[0].Resources)
+	assert.Equal(admissionregv1.Fail, *pods.FailurePolicy)
+	assert.NotNil(pods.NamespaceSelector, "expected the pod webhook to exclude namespaces")
+	selector, err := metav1.LabelSelectorAsSelector(pods.NamespaceSelector)
+	assert.NoError(err)
+	for _, namespace := range []string{options.Namespace, metav1.NamespaceSystem} {
+		assert.False(selector.Matches(namespaceLabels(namespace)), "expected pods in %s to not be mutated", namespace)
+	}
+	assert.True(selector.Matches(namespaceLabels("default")), "expected pods in other namespaces to be mutated")
+
+	validating, ok := objs[1].(*admissionregv1.ValidatingWebhookConfiguration)
+	assert.True(ok, "expected a validating webhook configuration")
+	assert.Equal(validationPath, *validating.Webhooks[0].ClientConfig.Service.Path)
+	assert.Equal(admissionregv1.Ignore, *validating.Webhooks[0].FailurePolicy)
+	assert.Equal(int32(servicePort), *validating.Webhooks[0].ClientConfig.Service.Port)
+	assert.Equal(int32(servicePort), *mutating.Webhooks[0].ClientConfig.Service.Port,
+		"expected the webhooks to call the port of the service, whatever port the webhook listens on")
+}
+
+func namespaceLabels(namespace string) labels.Set {
+	return labels.Set{corev1.LabelMetadataName: namespace}
+}
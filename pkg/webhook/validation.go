@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	"net/http"
+
+	"github.com/harvester/harvester/pkg/webhook/types"
+	"github.com/rancher/wrangler/pkg/webhook"
+)
+
+func Validation(clients *Clients, options Options) (http.Handler, []types.Resource, error) {
+	var resources []types.Resource
+	policy := newClientsPolicyChecker(clients, options)
+	validators := []types.Validator{
+		NewPCIDevicePolicyValidator(),
+		NewPCIDeviceClaimValidator(clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(), policy),
+		NewPCIDeviceHotplugValidator(clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(), policy),
+		NewPCIVMValidator(clients.PCIFactory.Devices().V1beta1().PCIDevice().Cache(), policy),
+	}
+
+	router := webhook.NewRouter()
+	for _, v := range validators {
+		addHandler(router, types.AdmissionTypeValidation, types.NewValidatorAdapter(v))
+		resources = append(resources, v.Resource())
+	}
+
+	return router, resources, nil
+}
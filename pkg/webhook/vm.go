@@ -3,7 +3,10 @@ package webhook
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
+	werror "github.com/harvester/harvester/pkg/webhook/error"
 	"github.com/harvester/harvester/pkg/webhook/types"
 	"github.com/sirupsen/logrus"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
@@ -19,13 +22,15 @@ import (
 
 const (
 	defaultHostDevBasePath = "/spec/template/spec/domain/devices/hostDevices/-"
+	evictionStrategyPath   = "/spec/template/spec/evictionStrategy"
 )
 
-func NewPCIVMMutator(deviceCache v1beta1.PCIDeviceCache, pciClaimCache v1beta1.PCIDeviceClaimCache, pciClaimClient v1beta1.PCIDeviceClaimClient) types.Mutator {
+func NewPCIVMMutator(deviceCache v1beta1.PCIDeviceCache, pciClaimCache v1beta1.PCIDeviceClaimCache, pciClaimClient v1beta1.PCIDeviceClaimClient, policy *policyChecker) types.Mutator {
 	return &vmPCIMutator{
 		deviceCache:    deviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         policy,
 	}
 }
 
@@ -36,13 +41,15 @@ type vmPCIMutator struct {
 	deviceCache    v1beta1.PCIDeviceCache
 	pciClaimCache  v1beta1.PCIDeviceClaimCache
 	pciClaimClient v1beta1.PCIDeviceClaimClient
+	policy         *policyChecker
 }
 
 // pciDeviceWithOwners is used to track the owner of a device along with owner.
-// this is used to create additional pcidevice claims with the same username
+// this is used to create additional pcidevice claims with the same username and requester groups
 type pciDeviceWithOwners struct {
 	device *devicesv1beta1.PCIDevice
 	owner  string
+	groups string
 }
 
 // Mutator is applied on create/update requests as pcidevices can be added during these two operations
@@ -67,27 +74,181 @@ func (vm *vmPCIMutator) Create(request *types.Request, newObj runtime.Object) (t
 		return nil, nil
 	}
 
-	return vm.generatePatch(vmObj)
+	patchOps, err := vm.generatePatch(newRequester(request, vmObj.Namespace, vmObj.Name), vmObj)
+	if err != nil {
+		return nil, err
+	}
+	passthroughOps, err := vm.passthroughPatch(vmObj)
+	if err != nil {
+		return nil, err
+	}
+	return append(patchOps, passthroughOps...), nil
 }
 
 func (vm *vmPCIMutator) Update(request *types.Request, oldObj runtime.Object, newObj runtime.Object) (types.PatchOps, error) {
 	vmObj := newObj.(*kubevirtv1.VirtualMachine)
-	oldVMObj := newObj.(*kubevirtv1.VirtualMachine)
+	oldVMObj := oldObj.(*kubevirtv1.VirtualMachine)
 
-	if len(vmObj.Spec.Template.Spec.Domain.Devices.HostDevices) == 0 {
+	if reflect.DeepEqual(oldVMObj.Spec.Template.Spec.Domain.Devices.HostDevices, vmObj.Spec.Template.Spec.Domain.Devices.HostDevices) {
+		// no changes to host device, ignore request
 		return nil, nil
 	}
 
+	// the passthrough label and eviction strategy are reverted once the last device is removed from the VM
+	passthroughOps, err := vm.passthroughPatch(vmObj)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vmObj.Spec.Template.Spec.Domain.Devices.HostDevices) == 0 {
+		return passthroughOps, nil
+	}
+
+	patchOps, err := vm.generatePatch(newRequester(request, vmObj.Namespace, vmObj.Name), vmObj)
+	if err != nil {
+		return nil, err
+	}
+	return append(patchOps, passthroughOps...), nil
+}
+
+func NewPCIVMValidator(deviceCache v1beta1.PCIDeviceCache, policy *policyChecker) types.Validator {
+	return &vmPCIValidator{
+		deviceCache: deviceCache,
+		policy:      policy,
+	}
+}
+
+// vmPCIValidator admits the host devices of VMs against PCIDevicePolicies, including the devices added by
+// the mutator for iommu groups
+type vmPCIValidator struct {
+	types.DefaultValidator
+	deviceCache v1beta1.PCIDeviceCache
+	policy      *policyChecker
+}
+
+func (v *vmPCIValidator) Resource() types.Resource {
+	return types.Resource{
+		Names:      []string{"virtualmachines"},
+		Scope:      admissionregv1.NamespacedScope,
+		APIGroup:   kubevirtv1.SchemeGroupVersion.Group,
+		APIVersion: kubevirtv1.SchemeGroupVersion.Version,
+		ObjectType: &kubevirtv1.VirtualMachine{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+			admissionregv1.Update,
+		},
+	}
+}
+
+func (v *vmPCIValidator) Create(request *types.Request, newObj runtime.Object) error {
+	return v.checkHostDevices(request, newObj.(*kubevirtv1.VirtualMachine))
+}
+
+func (v *vmPCIValidator) Update(request *types.Request, oldObj runtime.Object, newObj runtime.Object) error {
+	vmObj := newObj.(*kubevirtv1.VirtualMachine)
+	oldVMObj := oldObj.(*kubevirtv1.VirtualMachine)
 	if reflect.DeepEqual(oldVMObj.Spec.Template.Spec.Domain.Devices.HostDevices, vmObj.Spec.Template.Spec.Domain.Devices.HostDevices) {
-		// no changes to host device, ignore request
-		return nil, nil
+		return nil
+	}
+	return v.checkHostDevices(request, vmObj)
+}
+
+func (v *vmPCIValidator) checkHostDevices(request *types.Request, vmObj *kubevirtv1.VirtualMachine) error {
+	if len(vmObj.Spec.Template.Spec.Domain.Devices.HostDevices) == 0 {
+		return nil
+	}
+	devices, err := resolveHostDevices(v.deviceCache, vmObj)
+	if err != nil {
+		return err
+	}
+	return v.policy.check(newRequester(request, vmObj.Namespace, vmObj.Name), devices)
+}
+
+// passthroughPatch labels VMs using PCIDevices, and their VMIs through the template, and sets their eviction
+// strategy to External, as VMs with passthrough devices cannot be live-migrated. KubeVirt keeps the disruption
+// budget of the VMI, so a drain of the node is blocked until the VM is shut down instead of migrating or
+// killing it. The changes are reverted once the VM no longer uses PCIDevices
+func (vm *vmPCIMutator) passthroughPatch(vmObj *kubevirtv1.VirtualMachine) (types.PatchOps, error) {
+	passthrough, err := vm.usesPCIDevices(vmObj)
+	if err != nil {
+		return nil, err
 	}
 
-	return vm.generatePatch(vmObj)
+	var patchOps types.PatchOps
+	templateMeta := vmObj.Spec.Template.ObjectMeta
+	strategy := vmObj.Spec.Template.Spec.EvictionStrategy
+	original, recorded := vmObj.Annotations[devicesv1beta1.OriginalEvictionStrategyAnnotation]
+	if passthrough {
+		if vmObj.Labels[devicesv1beta1.PassthroughVMLabel] != "true" {
+			patchOps = append(patchOps, mapEntryPatch("/metadata/labels", vmObj.Labels, devicesv1beta1.PassthroughVMLabel, "true")...)
+		}
+		if templateMeta.Labels[devicesv1beta1.PassthroughVMLabel] != "true" {
+			if reflect.DeepEqual(templateMeta, metav1.ObjectMeta{}) {
+				// the template may have no metadata at all
+				patchOps = append(patchOps, fmt.Sprintf(`{"op": "add", "path": "/spec/template/metadata", "value": {"labels": {"%s": "true"}}}`, devicesv1beta1.PassthroughVMLabel))
+			} else {
+				patchOps = append(patchOps, mapEntryPatch("/spec/template/metadata/labels", templateMeta.Labels, devicesv1beta1.PassthroughVMLabel, "true")...)
+			}
+		}
+		// None is kept as the choice of the user to have the VM killed by drains
+		if strategy == nil || *strategy == kubevirtv1.EvictionStrategyLiveMigrate {
+			value := ""
+			if strategy != nil {
+				value = string(*strategy)
+			}
+			patchOps = append(patchOps, mapEntryPatch("/metadata/annotations", vmObj.Annotations, devicesv1beta1.OriginalEvictionStrategyAnnotation, value)...)
+			patchOps = append(patchOps, fmt.Sprintf(`{"op": "add", "path": "%s", "value": "%s"}`, evictionStrategyPath, kubevirtv1.EvictionStrategyExternal))
+		}
+		return patchOps, nil
+	}
+
+	if _, ok := vmObj.Labels[devicesv1beta1.PassthroughVMLabel]; ok {
+		patchOps = append(patchOps, removePatch("/metadata/labels", devicesv1beta1.PassthroughVMLabel))
+	}
+	if _, ok := templateMeta.Labels[devicesv1beta1.PassthroughVMLabel]; ok {
+		patchOps = append(patchOps, removePatch("/spec/template/metadata/labels", devicesv1beta1.PassthroughVMLabel))
+	}
+	if recorded {
+		patchOps = append(patchOps, removePatch("/metadata/annotations", devicesv1beta1.OriginalEvictionStrategyAnnotation))
+		// a strategy changed by the user since is kept
+		if strategy != nil && *strategy == kubevirtv1.EvictionStrategyExternal {
+			if original == "" {
+				patchOps = append(patchOps, fmt.Sprintf(`{"op": "remove", "path": "%s"}`, evictionStrategyPath))
+			} else {
+				patchOps = append(patchOps, fmt.Sprintf(`{"op": "add", "path": "%s", "value": "%s"}`, evictionStrategyPath, original))
+			}
+		}
+	}
+	return patchOps, nil
+}
+
+// usesPCIDevices checks if any host device of the VM is a PCIDevice
+func (vm *vmPCIMutator) usesPCIDevices(vmObj *kubevirtv1.VirtualMachine) (bool, error) {
+	devices, err := resolveHostDevices(vm.deviceCache, vmObj)
+	return len(devices) > 0, err
+}
+
+// mapEntryPatch sets key to value in the string map at path, which holds m. The map is added if it is missing
+func mapEntryPatch(path string, m map[string]string, key, value string) types.PatchOps {
+	if m == nil {
+		return types.PatchOps{fmt.Sprintf(`{"op": "add", "path": "%s", "value": {"%s": "%s"}}`, path, key, value)}
+	}
+	return types.PatchOps{fmt.Sprintf(`{"op": "add", "path": "%s/%s", "value": "%s"}`, path, escapePathKey(key), value)}
+}
+
+func removePatch(path, key string) string {
+	return fmt.Sprintf(`{"op": "remove", "path": "%s/%s"}`, path, escapePathKey(key))
 }
 
-// generatePatch is a common method used by create and update calls to generate a patch operation for VM
-func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.PatchOps, error) {
+// escapePathKey escapes key for use in a JSON pointer
+func escapePathKey(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// generatePatch is a common method used by create and update calls to generate a patch operation for VM.
+// The VM is admitted by the validating webhook, but claims for the devices added for iommu groups are
+// created here, so the devices of the VM are admitted against PCIDevicePolicies for req before
+func (vm *vmPCIMutator) generatePatch(req requester, vmObj *kubevirtv1.VirtualMachine) (types.PatchOps, error) {
 	var pciDevicesInVM []string
 	var possiblePCIDeviceRequirement []pciDeviceWithOwners
 	for _, v := range vmObj.Spec.Template.Spec.Domain.Devices.HostDevices {
@@ -96,7 +257,7 @@ func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.P
 		pciDeviceObj, err := vm.deviceCache.Get(v.Name)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
-				return nil, nil // pre 1.1.2 UI changes the device name did not match pcidevice. This avoids breaking
+				continue // pre 1.1.2 UI changes the device name did not match pcidevice. This avoids breaking
 			}
 			return nil, fmt.Errorf("error looking up pcidevice %s from cache: %v", v.Name, err)
 		}
@@ -104,7 +265,7 @@ func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.P
 		pciDeviceClaimObj, err := vm.pciClaimCache.Get(v.Name)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
-				return nil, nil
+				continue
 			}
 			return nil, fmt.Errorf("error looking up pcidevice %s from cache: %v", v.Name, err)
 		}
@@ -119,6 +280,7 @@ func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.P
 			possiblePCIDeviceRequirement = append(possiblePCIDeviceRequirement, pciDeviceWithOwners{
 				device: v,
 				owner:  pciDeviceClaimObj.Spec.UserName,
+				groups: pciDeviceClaimObj.Annotations[devicesv1beta1.RequesterGroupsAnnotation],
 			})
 		}
 
@@ -129,8 +291,24 @@ func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.P
 		return nil, nil // no further action needed as all devices are already present
 	}
 
+	devices, err := resolveHostDevices(vm.deviceCache, vmObj)
+	if err != nil {
+		return nil, err
+	}
+	// devices in the same iommu group are listed once for each device of the group in the VM
+	seen := make(map[string]bool)
+	for _, v := range devicesNeeded {
+		if !seen[v.device.Name] {
+			seen[v.device.Name] = true
+			devices = append(devices, v.device)
+		}
+	}
+	if err := vm.policy.check(req, devices); err != nil {
+		return nil, err
+	}
+
 	for _, v := range devicesNeeded {
-		if err := vm.findAndCreateClaim(v.device, v.owner); err != nil {
+		if err := vm.findAndCreateClaim(v, req.user); err != nil {
 			return nil, fmt.Errorf("error during findAndCreateClaim: %v", err)
 		}
 	}
@@ -141,6 +319,53 @@ func (vm *vmPCIMutator) generatePatch(vmObj *kubevirtv1.VirtualMachine) (types.P
 	return patch, err
 }
 
+// resolveHostDevices returns the PCIDevices of the host devices of a VM, which are admitted against
+// PCIDevicePolicies. KubeVirt allocates host devices by their resourceName, so they are resolved by it, and
+// the name only selects the device among the devices of the resourceName. Host devices whose name is a
+// PCIDevice of another resourceName are refused, host devices of resourceNames not served by pcidevices,
+// like USB devices, are no PCIDevices
+func resolveHostDevices(deviceCache v1beta1.PCIDeviceCache, vmObj *kubevirtv1.VirtualMachine) ([]*devicesv1beta1.PCIDevice, error) {
+	var devices []*devicesv1beta1.PCIDevice
+	for _, v := range vmObj.Spec.Template.Spec.Domain.Devices.HostDevices {
+		pd, err := resolveHostDevice(deviceCache, v)
+		if err != nil {
+			return nil, err
+		}
+		if pd != nil {
+			devices = append(devices, pd)
+		}
+	}
+	return devices, nil
+}
+
+// resolveHostDevice returns the PCIDevice named like the host device if it has the resourceName of the host
+// device, or else the first PCIDevice of the resourceName. nil is returned if no PCIDevice has the resourceName
+func resolveHostDevice(deviceCache v1beta1.PCIDeviceCache, hostDevice kubevirtv1.HostDevice) (*devicesv1beta1.PCIDevice, error) {
+	named, err := deviceCache.Get(hostDevice.Name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("error looking up pcidevice %s from cache: %v", hostDevice.Name, err)
+	}
+	if named != nil && err == nil {
+		if named.Status.ResourceName != hostDevice.DeviceName {
+			return nil, werror.NewBadRequest(fmt.Sprintf("host device %s uses deviceName %s, but pcidevice %s has resourceName %s",
+				hostDevice.Name, hostDevice.DeviceName, named.Name, named.Status.ResourceName))
+		}
+		return named, nil
+	}
+
+	candidates, err := deviceCache.GetByIndex(PCIDeviceByResourceName, hostDevice.DeviceName)
+	if err != nil {
+		return nil, fmt.Errorf("error listing pcidevices by resourceName %s: %v", hostDevice.DeviceName, err)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates[0], nil
+}
+
 // generate patch for host devices in VM spec
 func generatePatchFromDevices(devicesNeeded []pciDeviceWithOwners) (types.PatchOps, error) {
 	var patchOps types.PatchOps
@@ -180,11 +405,17 @@ func identifyAdditionalPCIDevices(pciDevicesInVM []string, possiblePCIDeviceRequ
 	return additionalDevicesNeeded
 }
 
-func (vm *vmPCIMutator) findAndCreateClaim(dev *devicesv1beta1.PCIDevice, owner string) error {
-	_, err := vm.pciClaimCache.Get(dev.Name)
+// findAndCreateClaim claims the device for its owner and their groups, unless it is already claimed.
+// requestedBy is the user whose VM required the claim
+func (vm *vmPCIMutator) findAndCreateClaim(dev pciDeviceWithOwners, requestedBy string) error {
+	_, err := vm.pciClaimCache.Get(dev.device.Name)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			newClaim := generatePCIDeviceClaim(dev, owner)
+			newClaim := generatePCIDeviceClaim(dev.device, dev.owner)
+			newClaim.Annotations = map[string]string{devicesv1beta1.RequestedByAnnotation: requestedBy}
+			if dev.groups != "" {
+				newClaim.Annotations[devicesv1beta1.RequesterGroupsAnnotation] = dev.groups
+			}
 			_, createErr := vm.pciClaimClient.Create(newClaim)
 			return createErr
 		} else {
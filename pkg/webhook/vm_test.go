@@ -1,9 +1,17 @@
 package webhook
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/harvester/harvester/pkg/webhook/types"
+	"github.com/rancher/wrangler/pkg/webhook"
 	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 
@@ -229,9 +237,10 @@ func Test_VMWithNoDevices(t *testing.T) {
 		deviceCache:    pciDeviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace),
 	}
 
-	patchOps, err := vmPCIMutator.generatePatch(vmWithoutDevice)
+	patchOps, err := vmPCIMutator.generatePatch(requester{}, vmWithoutDevice)
 	assert.NoError(err, "expect no error while creation of patch")
 	assert.Len(patchOps, 0, "expected no patch operation to be generated")
 }
@@ -247,9 +256,10 @@ func Test_VMWithoutIommuDevices(t *testing.T) {
 		deviceCache:    pciDeviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace),
 	}
 
-	patchOps, err := vmPCIMutator.generatePatch(vmWithoutIommuDevice)
+	patchOps, err := vmPCIMutator.generatePatch(requester{}, vmWithoutIommuDevice)
 	assert.NoError(err, "expect no error while creation of patch")
 	assert.Len(patchOps, 0, "expected no patch operation to be generated")
 }
@@ -265,9 +275,10 @@ func Test_VMWithIommuDevices(t *testing.T) {
 		deviceCache:    pciDeviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace),
 	}
 
-	patchOps, err := vmPCIMutator.generatePatch(vmWithIommuDevice)
+	patchOps, err := vmPCIMutator.generatePatch(requester{}, vmWithIommuDevice)
 	assert.NoError(err, "expect no error while creation of patch")
 	assert.Len(patchOps, 1, "expected patch operation to be generated")
 	newPCIDeviceClaimObj, err := vmPCIMutator.pciClaimCache.Get(node1dev2.Name)
@@ -286,9 +297,10 @@ func Test_VMWithAllIommuDevices(t *testing.T) {
 		deviceCache:    pciDeviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace),
 	}
 
-	patchOps, err := vmPCIMutator.generatePatch(vmWithAllIommuDevice)
+	patchOps, err := vmPCIMutator.generatePatch(requester{}, vmWithAllIommuDevice)
 	assert.NoError(err, "expect no error while creation of patch")
 	assert.Len(patchOps, 0, "expected no patch operation to be generated")
 }
@@ -303,9 +315,105 @@ func Test_VMWithoutValidDeviceName(t *testing.T) {
 		deviceCache:    pciDeviceCache,
 		pciClaimCache:  pciClaimCache,
 		pciClaimClient: pciClaimClient,
+		policy:         newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace),
 	}
 
-	patchOps, err := vmPCIMutator.generatePatch(vmWithoutValidDeviceName)
+	patchOps, err := vmPCIMutator.generatePatch(requester{}, vmWithoutValidDeviceName)
 	assert.NoError(err, "expect no error while creation of patch")
 	assert.Len(patchOps, 0, "expected no patch operation to be generated")
 }
+
+// applyVMPatch applies patch to vm, and returns the patched vm
+func applyVMPatch(t *testing.T, vm *kubevirtv1.VirtualMachine, patch types.PatchOps) *kubevirtv1.VirtualMachine {
+	vmJSON, err := json.Marshal(vm)
+	require.NoError(t, err)
+	decoded, err := jsonpatch.DecodePatch([]byte(fmt.Sprintf("[%s]", strings.Join(patch, ","))))
+	require.NoError(t, err)
+	patchedJSON, err := decoded.Apply(vmJSON)
+	require.NoError(t, err)
+	patched := &kubevirtv1.VirtualMachine{}
+	require.NoError(t, json.Unmarshal(patchedJSON, patched))
+	return patched
+}
+
+func Test_VMPassthroughEvictionStrategy(t *testing.T) {
+	assert := require.New(t)
+	fakeClient := fake.NewSimpleClientset(node1dev1, node1dev2, node1dev1Claim, node1dev2Claim)
+	pciDeviceCache := fakeclients.PCIDevicesCache(fakeClient.DevicesV1beta1().PCIDevices)
+	pciClaimCache := fakeclients.PCIDeviceClaimsCache(fakeClient.DevicesV1beta1().PCIDeviceClaims)
+	mutator := NewPCIVMMutator(pciDeviceCache, pciClaimCache, fakeclients.PCIDeviceClaimsClient(fakeClient.DevicesV1beta1().PCIDeviceClaims),
+		newPolicyChecker(fakeclients.PCIDevicePoliciesCache(fakeClient.DevicesV1beta1().PCIDevicePolicies), pciClaimCache, pciDeviceCache, fakeclients.VirtualMachineCache{}, fakeclients.PCIDeviceHotplugsCache(fakeClient.DevicesV1beta1().PCIDeviceHotplugs), defaultNamespace))
+	request := &types.Request{
+		Request: &webhook.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "admin"},
+			},
+		},
+	}
+
+	var tests = []struct {
+		name     string
+		strategy *kubevirtv1.EvictionStrategy
+		expected kubevirtv1.EvictionStrategy
+	}{
+		{
+			name:     "cluster eviction strategy",
+			expected: kubevirtv1.EvictionStrategyExternal,
+		},
+		{
+			name:     "live migration",
+			strategy: evictionStrategy(kubevirtv1.EvictionStrategyLiveMigrate),
+			expected: kubevirtv1.EvictionStrategyExternal,
+		},
+		{
+			name:     "external eviction",
+			strategy: evictionStrategy(kubevirtv1.EvictionStrategyExternal),
+			expected: kubevirtv1.EvictionStrategyExternal,
+		},
+		{
+			name:     "no eviction",
+			strategy: evictionStrategy(kubevirtv1.EvictionStrategyNone),
+			expected: kubevirtv1.EvictionStrategyNone,
+		},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			vm := vmWithAllIommuDevice.DeepCopy()
+			vm.Spec.Template.Spec.EvictionStrategy = v.strategy
+			patchOps, err := mutator.Create(request, vm)
+			assert.NoError(err)
+			created := applyVMPatch(t, vm, patchOps)
+			assert.Equal("true", created.Labels[devicesv1beta1.PassthroughVMLabel])
+			assert.Equal("true", created.Spec.Template.ObjectMeta.Labels[devicesv1beta1.PassthroughVMLabel])
+			assert.Equal(v.expected, *created.Spec.Template.Spec.EvictionStrategy)
+
+			// updates of a passthrough VM keep it unchanged
+			patchOps, err = mutator.Update(request, created, created.DeepCopy())
+			assert.NoError(err)
+			assert.Empty(patchOps)
+
+			// updates not changing the host devices are not mutated
+			unlabeled := created.DeepCopy()
+			delete(unlabeled.Labels, devicesv1beta1.PassthroughVMLabel)
+			patchOps, err = mutator.Update(request, created, unlabeled)
+			assert.NoError(err)
+			assert.Empty(patchOps)
+
+			// removing the devices restores the eviction strategy
+			updated := created.DeepCopy()
+			updated.Spec.Template.Spec.Domain.Devices.HostDevices = nil
+			patchOps, err = mutator.Update(request, created, updated)
+			assert.NoError(err)
+			updated = applyVMPatch(t, updated, patchOps)
+			assert.NotContains(updated.Labels, devicesv1beta1.PassthroughVMLabel)
+			assert.NotContains(updated.Spec.Template.ObjectMeta.Labels, devicesv1beta1.PassthroughVMLabel)
+			assert.NotContains(updated.Annotations, devicesv1beta1.OriginalEvictionStrategyAnnotation)
+			assert.Equal(v.strategy, updated.Spec.Template.Spec.EvictionStrategy)
+		})
+	}
+}
+
+func evictionStrategy(strategy kubevirtv1.EvictionStrategy) *kubevirtv1.EvictionStrategy {
+	return &strategy
+}
@@ -94,7 +94,7 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 
 	// start webhook //
-	w := webhook.New(ctx, cfg)
+	w := webhook.New(ctx, cfg, webhook.DefaultOptions())
 	err = w.ListenAndServe()
 	Expect(err).NotTo(HaveOccurred())
 